@@ -0,0 +1,20 @@
+-- Migration 004: Record where each file version's content lives in blob storage
+-- Legacy versions are stored under their own id; TUS uploads use the s3store object key.
+-- Existing rows are filled in by `valvx-api backfill-storage`, which checks MinIO.
+
+BEGIN;
+
+ALTER TABLE public.arca_file_version
+    ADD COLUMN storage_bucket text,
+    ADD COLUMN storage_key text,
+    ADD COLUMN storage_etag text,
+    ADD COLUMN content_hash text,
+    ADD COLUMN upload_id text;
+
+CREATE UNIQUE INDEX idx_arca_file_version_upload ON public.arca_file_version(upload_id);
+CREATE INDEX idx_arca_file_version_storage_key ON public.arca_file_version(storage_key);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 4;
+
+COMMIT;
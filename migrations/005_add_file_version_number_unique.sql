@@ -0,0 +1,26 @@
+-- Migration 005: Enforce sequential, unique version numbers per file
+-- Uploads may now append versions to an existing arca_file (TUS metadata "fileId").
+
+BEGIN;
+
+-- Files that already have duplicate numbers are renumbered 1..n in upload
+-- order so the index can be created.
+UPDATE public.arca_file_version fv
+SET number = r.rn
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY file_id ORDER BY number, created_at, id) AS rn
+    FROM public.arca_file_version
+    WHERE file_id IN (
+        SELECT file_id FROM public.arca_file_version
+        GROUP BY file_id, number
+        HAVING COUNT(*) > 1
+    )
+) r
+WHERE fv.id = r.id AND fv.number <> r.rn;
+
+CREATE UNIQUE INDEX uq_arca_file_version_file_number ON public.arca_file_version(file_id, number);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 5;
+
+COMMIT;
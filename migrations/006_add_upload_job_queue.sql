@@ -0,0 +1,28 @@
+-- Migration 006: Durable post-upload processing queue (outbox)
+-- One row per completed TUS upload; workers turn it into arca_file rows.
+-- status: pending -> processing -> done, or dead after max attempts
+
+BEGIN;
+
+CREATE TABLE public.arca_upload_job (
+    id uuid NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    upload_id text NOT NULL UNIQUE,
+    status text DEFAULT 'pending' NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    payload jsonb NOT NULL,
+    last_error text,
+    run_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    locked_at timestamp without time zone,
+    file_version_id uuid,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_arca_upload_job_file_version FOREIGN KEY (file_version_id) REFERENCES public.arca_file_version(id)
+);
+
+CREATE INDEX idx_arca_upload_job_status_run_at ON public.arca_upload_job(status, run_at);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 6;
+
+COMMIT;
@@ -0,0 +1,22 @@
+-- Migration 007: Soft delete for files and folders
+-- Deleting moves items to the project trash by setting deleted_at; everything
+-- removed in one operation shares the same timestamp so it can be restored
+-- together. The API purges rows and blobs once the retention period has passed.
+
+BEGIN;
+
+ALTER TABLE public.arca_folder
+    ADD COLUMN deleted_at timestamp without time zone,
+    ADD COLUMN deleted_by uuid REFERENCES public.iam_profile(id);
+
+ALTER TABLE public.arca_file
+    ADD COLUMN deleted_at timestamp without time zone,
+    ADD COLUMN deleted_by uuid REFERENCES public.iam_profile(id);
+
+CREATE INDEX idx_arca_folder_deleted_at ON public.arca_folder(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_arca_file_deleted_at ON public.arca_file(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Update migration version
+UPDATE public.migration_version SET version = 7;
+
+COMMIT;
@@ -0,0 +1,28 @@
+-- Migration 008: Content-addressed blobs shared between file versions
+-- Each distinct SHA-256 is stored once; arca_blob counts the versions using it
+-- so the object is only deleted when the last reference goes away.
+-- Existing versions are hashed by `valvx-api backfill-hashes`.
+
+BEGIN;
+
+CREATE TABLE public.arca_blob (
+    content_hash text NOT NULL,
+    storage_bucket text NOT NULL,
+    storage_key text NOT NULL,
+    storage_etag text,
+    size bigint NOT NULL,
+    ref_count integer NOT NULL DEFAULT 0,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (content_hash)
+);
+
+ALTER TABLE public.arca_file_version
+    ADD CONSTRAINT fk_arca_file_version_blob FOREIGN KEY (content_hash) REFERENCES public.arca_blob(content_hash);
+
+CREATE INDEX idx_arca_file_version_content_hash ON public.arca_file_version(content_hash);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 8;
+
+COMMIT;
@@ -0,0 +1,27 @@
+-- Migration 009: Storage quotas per project and tenant
+-- storage_quota is a byte limit; NULL falls back to the API's configured
+-- default (VALVX_API_PROJECT_STORAGE_QUOTA / VALVX_API_TENANT_STORAGE_QUOTA).
+-- Uploads reserve their declared length at creation so concurrent uploads
+-- cannot overshoot a quota; the reservation is released when the file
+-- version is recorded, or ignored once it is older than a day.
+
+BEGIN;
+
+ALTER TABLE public.core_project ADD COLUMN storage_quota bigint;
+ALTER TABLE public.core_tenant ADD COLUMN storage_quota bigint;
+
+CREATE TABLE public.arca_storage_reservation (
+    id uuid NOT NULL,
+    project_id uuid NOT NULL,
+    size bigint NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_arca_storage_reservation_project FOREIGN KEY (project_id) REFERENCES public.core_project(id)
+);
+
+CREATE INDEX idx_arca_storage_reservation_project ON public.arca_storage_reservation(project_id, created_at);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 9;
+
+COMMIT;
@@ -0,0 +1,34 @@
+-- Migration 010: IFC STEP header metadata per file version
+-- Filled in by the upload worker after an .ifc version is stored; existing
+-- versions are parsed by `valvx-api backfill-ifc`. A file that cannot be
+-- parsed still gets a row, with the reason in error.
+
+BEGIN;
+
+CREATE TABLE public.arca_ifc_header (
+    file_version_id uuid NOT NULL,
+    schema text,
+    schemas text[] NOT NULL DEFAULT '{}',
+    description text[] NOT NULL DEFAULT '{}',
+    implementation_level text,
+    view_definition text,
+    file_name text,
+    time_stamp text,
+    authors text[] NOT NULL DEFAULT '{}',
+    organizations text[] NOT NULL DEFAULT '{}',
+    preprocessor_version text,
+    originating_system text,
+    file_authorization text,
+    error text,
+    parsed_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (file_version_id),
+    CONSTRAINT fk_arca_ifc_header_version FOREIGN KEY (file_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_arca_ifc_header_schema ON public.arca_ifc_header(schema);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 10;
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Migration 011: IFC element index per file version
+-- One row per IfcRoot instance (GlobalId, entity type, Name and containing
+-- storey), rebuilt by the upload worker and `valvx-api backfill-ifc`.
+-- GlobalIds are not unique in badly exported models, so rows are keyed on
+-- the STEP instance id.
+
+BEGIN;
+
+CREATE TABLE public.arca_ifc_element (
+    file_version_id uuid NOT NULL,
+    step_id bigint NOT NULL,
+    global_id text NOT NULL,
+    type text NOT NULL,
+    name text,
+    storey_global_id text,
+    storey_name text,
+    PRIMARY KEY (file_version_id, step_id),
+    CONSTRAINT fk_arca_ifc_element_version FOREIGN KEY (file_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_arca_ifc_element_global_id ON public.arca_ifc_element(file_version_id, global_id);
+CREATE INDEX idx_arca_ifc_element_type ON public.arca_ifc_element(file_version_id, type);
+CREATE INDEX idx_arca_ifc_element_storey ON public.arca_ifc_element(file_version_id, storey_name);
+
+ALTER TABLE public.arca_ifc_header
+    ADD COLUMN element_count integer,
+    ADD COLUMN indexed_at timestamp without time zone;
+
+-- Update migration version
+UPDATE public.migration_version SET version = 11;
+
+COMMIT;
@@ -0,0 +1,34 @@
+-- Migration 012: IFC property sets, placements and version comparisons
+-- Elements gain their property sets and resolved placement so two versions
+-- of a file can be compared by GlobalId. index_version records what the
+-- indexer stored; `valvx-api backfill-ifc` reprocesses older rows.
+-- arca_ifc_diff caches comparisons; rows are dropped when either version is
+-- re-indexed.
+
+BEGIN;
+
+ALTER TABLE public.arca_ifc_element
+    ADD COLUMN psets jsonb,
+    ADD COLUMN placement jsonb;
+
+ALTER TABLE public.arca_ifc_header
+    ADD COLUMN index_version integer NOT NULL DEFAULT 1;
+
+CREATE TABLE public.arca_ifc_diff (
+    from_version_id uuid NOT NULL,
+    to_version_id uuid NOT NULL,
+    result jsonb NOT NULL,
+    computed_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (from_version_id, to_version_id),
+    CONSTRAINT fk_arca_ifc_diff_from FOREIGN KEY (from_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE,
+    CONSTRAINT fk_arca_ifc_diff_to FOREIGN KEY (to_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_arca_ifc_diff_to ON public.arca_ifc_diff(to_version_id);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 12;
+
+COMMIT;
@@ -0,0 +1,22 @@
+-- Migration 013: IFC base quantities and spatial structure tree
+-- Elements gain their IfcElementQuantity values. The spatial tree of each
+-- indexed version is computed once by the indexer and served as stored.
+
+BEGIN;
+
+ALTER TABLE public.arca_ifc_element
+    ADD COLUMN quantities jsonb;
+
+CREATE TABLE public.arca_ifc_spatial_tree (
+    file_version_id uuid NOT NULL,
+    tree jsonb NOT NULL,
+    computed_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (file_version_id),
+    CONSTRAINT fk_arca_ifc_spatial_tree_version FOREIGN KEY (file_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE
+);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 13;
+
+COMMIT;
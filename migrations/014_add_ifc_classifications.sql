@@ -0,0 +1,13 @@
+-- Migration 014: IFC classification references per element
+-- Used to group quantity takeoffs by classification system (CoClass,
+-- Uniclass, ...). Filled in by the indexer at index version 4.
+
+BEGIN;
+
+ALTER TABLE public.arca_ifc_element
+    ADD COLUMN classifications jsonb;
+
+-- Update migration version
+UPDATE public.migration_version SET version = 14;
+
+COMMIT;
@@ -0,0 +1,52 @@
+-- Migration 015: IFC validation rules
+-- Project-level model checks run against every uploaded IFC version. Each
+-- rule with failures opens a BCF topic listing the offending GlobalIds;
+-- arca_ifc_rule_result records the outcome per version so re-running a
+-- check does not open the same topic twice.
+
+BEGIN;
+
+CREATE TABLE public.arca_ifc_rule (
+    id uuid NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    project_id uuid NOT NULL,
+    name text NOT NULL,
+    kind text NOT NULL,
+    types text[] DEFAULT '{}' NOT NULL,
+    pset text,
+    properties text[] DEFAULT '{}' NOT NULL,
+    pattern text,
+    priority text DEFAULT 'Normal' NOT NULL,
+    enabled boolean DEFAULT true NOT NULL,
+    creator_id uuid NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_arca_ifc_rule_project FOREIGN KEY (project_id)
+        REFERENCES public.core_project(id),
+    CONSTRAINT fk_arca_ifc_rule_creator FOREIGN KEY (creator_id)
+        REFERENCES public.iam_profile(id)
+);
+
+CREATE INDEX idx_arca_ifc_rule_project ON public.arca_ifc_rule(project_id);
+
+CREATE TABLE public.arca_ifc_rule_result (
+    file_version_id uuid NOT NULL,
+    rule_id uuid NOT NULL,
+    failures integer NOT NULL,
+    topic_id uuid,
+    checked_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (file_version_id, rule_id),
+    CONSTRAINT fk_arca_ifc_rule_result_version FOREIGN KEY (file_version_id)
+        REFERENCES public.arca_file_version(id) ON DELETE CASCADE,
+    CONSTRAINT fk_arca_ifc_rule_result_rule FOREIGN KEY (rule_id)
+        REFERENCES public.arca_ifc_rule(id) ON DELETE CASCADE,
+    CONSTRAINT fk_arca_ifc_rule_result_topic FOREIGN KEY (topic_id)
+        REFERENCES public.collab_topic(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_arca_ifc_rule_result_rule ON public.arca_ifc_rule_result(rule_id);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 15;
+
+COMMIT;
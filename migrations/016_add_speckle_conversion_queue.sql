@@ -0,0 +1,20 @@
+-- Migration 016: Speckle conversion queue
+-- arca_speckle_mapping rows are now created for each uploaded IFC version
+-- and driven by the conversion worker: pending -> processing -> ready|error.
+-- speckle_upload_id is the server's file upload being polled; until it
+-- completes speckle_model_id holds the model name the file was sent to.
+
+BEGIN;
+
+ALTER TABLE public.arca_speckle_mapping
+    ADD COLUMN speckle_upload_id text,
+    ADD COLUMN attempts integer NOT NULL DEFAULT 0,
+    ADD COLUMN run_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    ADD COLUMN submitted_at timestamp without time zone;
+
+CREATE INDEX idx_speckle_mapping_run_at ON public.arca_speckle_mapping(status, run_at);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 16;
+
+COMMIT;
@@ -0,0 +1,34 @@
+-- Migration 017: Lossless BCF import
+-- Imported topics, viewpoints and comments keep the GUIDs from the BCF file
+-- and are matched on them when the same file is imported again. GUIDs are
+-- unique within a project (topics) or topic (viewpoints, comments) so the
+-- same file can be imported into several projects.
+-- Authors and assignees that are not members of the project are kept as
+-- text next to the profile the row falls back to.
+
+BEGIN;
+
+ALTER TABLE public.collab_topic
+    DROP CONSTRAINT collab_topic_guid_key,
+    ADD CONSTRAINT uq_collab_topic_project_guid UNIQUE (project_id, guid),
+    ADD COLUMN creation_author text,
+    ADD COLUMN assigned_to_email text;
+
+ALTER TABLE public.collab_viewpoint
+    DROP CONSTRAINT collab_viewpoint_guid_key,
+    ADD CONSTRAINT uq_collab_viewpoint_topic_guid UNIQUE (topic_id, guid);
+
+ALTER TABLE public.collab_comment
+    ADD COLUMN guid text,
+    ADD COLUMN author text;
+
+UPDATE public.collab_comment SET guid = id::text;
+
+ALTER TABLE public.collab_comment
+    ALTER COLUMN guid SET NOT NULL,
+    ADD CONSTRAINT uq_collab_comment_topic_guid UNIQUE (topic_id, guid);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 17;
+
+COMMIT;
@@ -0,0 +1,50 @@
+-- Migration 018: BCF 3.0 topic content
+-- Documents are files shipped inside a BCF file (documents.xml) and belong
+-- to the project; topics refer to them, or to external URLs, through
+-- document references. Modified authors follow the creation author rule of
+-- migration 017: a profile when the author is a project member, otherwise
+-- the text from the file.
+
+BEGIN;
+
+ALTER TABLE public.collab_topic
+    ADD COLUMN reference_links text[],
+    ADD COLUMN modified_author text;
+
+ALTER TABLE public.collab_comment
+    ADD COLUMN modified_by uuid REFERENCES public.iam_profile(id),
+    ADD COLUMN modified_author text;
+
+ALTER TABLE public.collab_viewpoint
+    ADD COLUMN camera_aspect_ratio double precision;
+
+CREATE TABLE public.collab_document (
+    id uuid NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    guid text NOT NULL,
+    project_id uuid NOT NULL,
+    filename text NOT NULL,
+    description text,
+    data bytea NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT uq_collab_document_project_guid UNIQUE (project_id, guid),
+    CONSTRAINT fk_collab_document_project FOREIGN KEY (project_id) REFERENCES public.core_project(id)
+);
+
+-- A reference points at a document of the project by GUID or at a URL
+CREATE TABLE public.collab_document_reference (
+    id uuid NOT NULL,
+    guid text NOT NULL,
+    topic_id uuid NOT NULL,
+    document_guid text,
+    url text,
+    description text,
+    PRIMARY KEY (id),
+    CONSTRAINT uq_collab_document_reference_topic_guid UNIQUE (topic_id, guid),
+    CONSTRAINT fk_collab_document_reference_topic FOREIGN KEY (topic_id) REFERENCES public.collab_topic(id) ON DELETE CASCADE
+);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 18;
+
+COMMIT;
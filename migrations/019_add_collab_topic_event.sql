@@ -0,0 +1,25 @@
+-- Migration 019: topic history
+-- Each change to a topic field is one row, typed as in the events of the
+-- BCF API; the rows written by one update share created_at and author, and
+-- are listed together as one event.
+
+BEGIN;
+
+CREATE TABLE public.collab_topic_event (
+    id uuid NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    topic_id uuid NOT NULL,
+    author_id uuid,
+    type text NOT NULL,
+    value text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_collab_topic_event_topic FOREIGN KEY (topic_id) REFERENCES public.collab_topic(id) ON DELETE CASCADE,
+    CONSTRAINT fk_collab_topic_event_author FOREIGN KEY (author_id) REFERENCES public.iam_profile(id)
+);
+
+CREATE INDEX idx_collab_topic_event_topic ON public.collab_topic_event(topic_id, created_at);
+
+-- Update migration version
+UPDATE public.migration_version SET version = 19;
+
+COMMIT;
@@ -0,0 +1,74 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// --- Shared blobs ---
+//
+// File versions with a content_hash point at an arca_blob row instead of
+// owning their object. ref_count is the number of versions using the blob;
+// the object is deleted only when it drops to zero. Versions without a hash
+// (legacy uploads not yet backfilled) still own their object outright.
+
+// AcquireBlob adds a reference to the blob with the given hash, registering
+// key as its object if the content has not been seen before. It returns the
+// key and ETag versions with this content should point at; when that key is
+// not the one passed in, the caller's object is a duplicate and can be
+// deleted once tx commits.
+func AcquireBlob(ctx context.Context, tx *sql.Tx, hash, bucket, key, etag string, size int64) (string, string, error) {
+	var sharedKey string
+	var sharedETag sql.NullString
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO arca_blob (content_hash, storage_bucket, storage_key, storage_etag, size, ref_count, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, 1, now(), now())
+		ON CONFLICT (content_hash) DO UPDATE
+		SET ref_count = arca_blob.ref_count + 1, updated_at = now()
+		RETURNING storage_key, storage_etag`,
+		hash, bucket, key, etag, size).Scan(&sharedKey, &sharedETag)
+	if err != nil {
+		return "", "", fmt.Errorf("acquire blob %s: %w", hash, err)
+	}
+	return sharedKey, sharedETag.String, nil
+}
+
+// retainBlob adds a reference to an existing blob.
+func retainBlob(ctx context.Context, tx *sql.Tx, hash string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE arca_blob SET ref_count = ref_count + 1, updated_at = now()
+		WHERE content_hash = $1`, hash)
+	if err != nil {
+		return fmt.Errorf("retain blob %s: %w", hash, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("retain blob %s: not registered", hash)
+	}
+	return nil
+}
+
+// releaseBlob drops a reference to a blob. When the last reference goes, the
+// row is removed and its storage key is returned for deletion after commit.
+func releaseBlob(ctx context.Context, tx *sql.Tx, hash string) (string, bool, error) {
+	var refs int
+	var key string
+	err := tx.QueryRowContext(ctx, `
+		UPDATE arca_blob SET ref_count = ref_count - 1, updated_at = now()
+		WHERE content_hash = $1
+		RETURNING ref_count, storage_key`, hash).Scan(&refs, &key)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("release blob %s: %w", hash, err)
+	}
+	if refs > 0 {
+		return "", false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM arca_blob WHERE content_hash = $1`, hash); err != nil {
+		return "", false, fmt.Errorf("delete blob %s: %w", hash, err)
+	}
+	return key, true, nil
+}
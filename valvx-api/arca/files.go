@@ -0,0 +1,456 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// maxBulkFiles bounds how many files one bulk move or copy may touch.
+const maxBulkFiles = 1000
+
+// --- Files ---
+
+// FolderProjectID returns the project a folder belongs to. Handlers use it to
+// authorize the destination of a cross-project move or copy.
+func (s *Service) FolderProjectID(ctx context.Context, folderID string) (string, error) {
+	var projectID string
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT project_id FROM arca_folder WHERE id = $1 AND deleted_at IS NULL`, folderID).Scan(&projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: folder %s", ErrNotFound, folderID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("get folder project: %w", err)
+	}
+	return projectID, nil
+}
+
+// UpdateFile renames a file and/or moves it to another folder. A move drops
+// every link the file has to folders in projectID and links it into the
+// destination instead.
+func (s *Service) UpdateFile(ctx context.Context, projectID, fileID string, req UpdateFileRequest) (*File, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := getFile(ctx, tx, projectID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	name, ext := file.Name, file.Ext
+	if req.Name != nil {
+		if name, err = cleanName(*req.Name); err != nil {
+			return nil, err
+		}
+	}
+	if req.Ext != nil {
+		if ext, err = cleanExt(*req.Ext); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_file SET name = $2, ext = $3, updated_at = $4 WHERE id = $1`,
+		fileID, name, ext, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("update file: %w", err)
+	}
+
+	folderID := file.FolderID
+	if req.FolderID != nil {
+		folder, err := targetFolder(ctx, tx, projectID, *req.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTransferQuota(ctx, tx, projectID, folder.ProjectID, []string{fileID}, s.Config.Quotas, false); err != nil {
+			return nil, err
+		}
+		if err := moveFiles(ctx, tx, projectID, []string{fileID}, folder.ID); err != nil {
+			return nil, err
+		}
+		folderID = folder.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	files, err := filesIn(ctx, s.DB, folderID, []string{fileID})
+	if err != nil {
+		return nil, err
+	}
+	return &files[0], nil
+}
+
+// MoveFiles moves a batch of files from projectID into one folder.
+func (s *Service) MoveFiles(ctx context.Context, projectID string, req BulkFilesRequest) ([]File, error) {
+	fileIDs, err := cleanFileIDs(req.FileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkFilesInProject(ctx, tx, projectID, fileIDs); err != nil {
+		return nil, err
+	}
+	folder, err := targetFolder(ctx, tx, projectID, req.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransferQuota(ctx, tx, projectID, folder.ProjectID, fileIDs, s.Config.Quotas, false); err != nil {
+		return nil, err
+	}
+	if err := moveFiles(ctx, tx, projectID, fileIDs, folder.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return filesIn(ctx, s.DB, folder.ID, fileIDs)
+}
+
+// CopyFile copies one file, with all its versions, into a folder.
+func (s *Service) CopyFile(ctx context.Context, projectID, fileID string, req CopyFileRequest) (*File, error) {
+	var name, ext *string
+	if req.Name != nil {
+		n, err := cleanName(*req.Name)
+		if err != nil {
+			return nil, err
+		}
+		name = &n
+	}
+	if req.Ext != nil {
+		e, err := cleanExt(*req.Ext)
+		if err != nil {
+			return nil, err
+		}
+		ext = &e
+	}
+
+	files, err := s.copyFiles(ctx, projectID, []string{fileID}, req.FolderID, name, ext)
+	if err != nil {
+		return nil, err
+	}
+	return &files[0], nil
+}
+
+// CopyFiles copies a batch of files, with all their versions, into one folder.
+func (s *Service) CopyFiles(ctx context.Context, projectID string, req BulkFilesRequest) ([]File, error) {
+	fileIDs, err := cleanFileIDs(req.FileIDs)
+	if err != nil {
+		return nil, err
+	}
+	return s.copyFiles(ctx, projectID, fileIDs, req.FolderID, nil, nil)
+}
+
+// copyFiles creates new arca_file rows with duplicated version rows. Hashed
+// versions share their blob with the original; the content of unhashed ones
+// is copied server-side to a key equal to the new version id. Those copies
+// can take minutes for large files, so they are made before the transaction
+// opens, and deleted again if the batch ends up rolled back.
+func (s *Service) copyFiles(ctx context.Context, projectID string, fileIDs []string, folderID string, name, ext *string) ([]File, error) {
+	if s.Blobs == nil {
+		return nil, errors.New("blob storage is not configured")
+	}
+	if err := checkFilesInProject(ctx, s.DB, projectID, fileIDs); err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	committed := false
+	defer func() {
+		if !committed {
+			s.deleteBlobs(context.WithoutCancel(ctx), copied)
+		}
+	}()
+
+	type version struct {
+		id, newID, key, bucket, etag string
+		hash                         sql.NullString
+	}
+	versions := make(map[string][]version, len(fileIDs))
+	for _, fileID := range fileIDs {
+		rows, err := s.DB.QueryContext(ctx, `
+			SELECT id, COALESCE(storage_key, id::text), COALESCE(storage_bucket, ''),
+			       COALESCE(storage_etag, ''), content_hash
+			FROM arca_file_version
+			WHERE file_id = $1 ORDER BY number`, fileID)
+		if err != nil {
+			return nil, fmt.Errorf("query versions: %w", err)
+		}
+		for rows.Next() {
+			var v version
+			if err := rows.Scan(&v.id, &v.key, &v.bucket, &v.etag, &v.hash); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan version: %w", err)
+			}
+			versions[fileID] = append(versions[fileID], v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("query versions: %w", err)
+		}
+
+		for i := range versions[fileID] {
+			v := &versions[fileID][i]
+			v.newID = uuid.New().String()
+			if v.hash.Valid {
+				continue
+			}
+			srcBucket := v.bucket
+			if srcBucket == "" {
+				srcBucket = s.Blobs.Bucket
+			}
+			etag, err := s.Blobs.Copy(ctx, srcBucket, v.key, v.newID)
+			if err != nil {
+				return nil, err
+			}
+			copied = append(copied, v.newID)
+			v.bucket, v.key, v.etag = s.Blobs.Bucket, v.newID, etag
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkFilesInProject(ctx, tx, projectID, fileIDs); err != nil {
+		return nil, err
+	}
+	folder, err := targetFolder(ctx, tx, projectID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransferQuota(ctx, tx, projectID, folder.ProjectID, fileIDs, s.Config.Quotas, true); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	newIDs := make([]string, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		newID := uuid.New().String()
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO arca_file (id, created_at, updated_at, name, ext)
+			SELECT $2, $3, $3, COALESCE($4, name), COALESCE($5, ext)
+			FROM arca_file WHERE id = $1`,
+			fileID, newID, now, name, ext)
+		if err != nil {
+			return nil, fmt.Errorf("copy file %s: %w", fileID, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO arca_folder_file (folder_id, file_id) VALUES ($1, $2)`, folder.ID, newID)
+		if err != nil {
+			return nil, fmt.Errorf("link file copy: %w", err)
+		}
+
+		for _, v := range versions[fileID] {
+			if v.hash.Valid {
+				if err := retainBlob(ctx, tx, v.hash.String); err != nil {
+					return nil, err
+				}
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO arca_file_version (id, created_at, updated_at, number, size, file_id, creator_id,
+				    storage_bucket, storage_key, storage_etag, content_hash)
+				SELECT $2, created_at, $3, number, size, $4, creator_id, NULLIF($5, ''), $6, NULLIF($7, ''), content_hash
+				FROM arca_file_version WHERE id = $1`,
+				v.id, v.newID, now, newID, v.bucket, v.key, v.etag)
+			if err != nil {
+				return nil, fmt.Errorf("copy version %s: %w", v.id, err)
+			}
+		}
+		newIDs = append(newIDs, newID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	committed = true
+	return filesIn(ctx, s.DB, folder.ID, newIDs)
+}
+
+// --- File helpers ---
+
+// getFile returns fileID as linked from a folder in projectID. Files linked
+// from several folders are reported with the first one.
+func getFile(ctx context.Context, q queryer, projectID, fileID string) (*File, error) {
+	var f File
+	err := q.QueryRowContext(ctx, `
+		SELECT f.id, f.name, COALESCE(f.ext, ''), fo.id, fo.project_id, f.created_at, f.updated_at
+		FROM arca_file f
+		JOIN arca_folder_file ff ON ff.file_id = f.id
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		WHERE f.id = $1 AND fo.project_id = $2 AND f.deleted_at IS NULL AND fo.deleted_at IS NULL
+		ORDER BY fo.id
+		LIMIT 1`, fileID, projectID,
+	).Scan(&f.ID, &f.Name, &f.Ext, &f.FolderID, &f.ProjectID, &f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: file %s", ErrNotFound, fileID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get file: %w", err)
+	}
+	return &f, nil
+}
+
+// filesIn returns fileIDs as linked from folderID, in the order given.
+func filesIn(ctx context.Context, q queryer, folderID string, fileIDs []string) ([]File, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT f.id, f.name, COALESCE(f.ext, ''), fo.id, fo.project_id, f.created_at, f.updated_at
+		FROM arca_file f
+		JOIN arca_folder_file ff ON ff.file_id = f.id
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		WHERE fo.id = $1 AND f.id = ANY($2) AND f.deleted_at IS NULL`, folderID, pq.Array(fileIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query files: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[string]File, len(fileIDs))
+	for rows.Next() {
+		var f File
+		if err := rows.Scan(&f.ID, &f.Name, &f.Ext, &f.FolderID, &f.ProjectID, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan file: %w", err)
+		}
+		byID[f.ID] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query files: %w", err)
+	}
+
+	files := make([]File, 0, len(fileIDs))
+	for _, id := range fileIDs {
+		f, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: file %s", ErrNotFound, id)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// checkFilesInProject fails with ErrNotFound unless every file is linked from
+// at least one folder in projectID.
+func checkFilesInProject(ctx context.Context, q queryer, projectID string, fileIDs []string) error {
+	var found int
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT ff.file_id) FROM arca_folder_file ff
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		JOIN arca_file f ON f.id = ff.file_id
+		WHERE fo.project_id = $1 AND ff.file_id = ANY($2)
+		  AND fo.deleted_at IS NULL AND f.deleted_at IS NULL`,
+		projectID, pq.Array(fileIDs)).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("check files: %w", err)
+	}
+	if found != len(fileIDs) {
+		return fmt.Errorf("%w: %d of %d files are not in this project", ErrNotFound, len(fileIDs)-found, len(fileIDs))
+	}
+	return nil
+}
+
+// targetFolder loads the destination folder of a move or copy. It may live in
+// another project, but only one belonging to the same tenant as projectID.
+func targetFolder(ctx context.Context, q queryer, projectID, folderID string) (*Folder, error) {
+	if folderID == "" {
+		return nil, fmt.Errorf("%w: folderId is required", ErrInvalid)
+	}
+
+	var f Folder
+	var sameTenant bool
+	err := q.QueryRowContext(ctx, `
+		SELECT fo.id, fo.name, fo.parent_id, fo.project_id, fo.creator_id, fo.created_at, fo.updated_at,
+		       dst.tenant_id IS NOT DISTINCT FROM src.tenant_id
+		FROM arca_folder fo
+		JOIN core_project dst ON dst.id = fo.project_id
+		JOIN core_project src ON src.id = $2
+		WHERE fo.id = $1 AND fo.deleted_at IS NULL`, folderID, projectID,
+	).Scan(&f.ID, &f.Name, &f.ParentID, &f.ProjectID, &f.CreatorID, &f.CreatedAt, &f.UpdatedAt, &sameTenant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: folder %s", ErrNotFound, folderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get target folder: %w", err)
+	}
+	if !sameTenant {
+		return nil, fmt.Errorf("%w: files can only be moved or copied within one tenant", ErrInvalid)
+	}
+	return &f, nil
+}
+
+// moveFiles replaces the links fileIDs have to folders in projectID with a
+// link to folderID.
+func moveFiles(ctx context.Context, tx *sql.Tx, projectID string, fileIDs []string, folderID string) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM arca_folder_file ff
+		USING arca_folder fo
+		WHERE fo.id = ff.folder_id AND fo.project_id = $1 AND ff.file_id = ANY($2)`,
+		projectID, pq.Array(fileIDs))
+	if err != nil {
+		return fmt.Errorf("unlink files: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO arca_folder_file (folder_id, file_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING`, folderID, pq.Array(fileIDs))
+	if err != nil {
+		return fmt.Errorf("link files: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_file SET updated_at = $2 WHERE id = ANY($1)`, pq.Array(fileIDs), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("touch files: %w", err)
+	}
+	return nil
+}
+
+func cleanExt(ext string) (string, error) {
+	ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+	if strings.ContainsAny(ext, `/\.`) {
+		return "", fmt.Errorf("%w: invalid extension", ErrInvalid)
+	}
+	if len(ext) > 32 {
+		return "", fmt.Errorf("%w: extension is too long", ErrInvalid)
+	}
+	return ext, nil
+}
+
+// cleanFileIDs validates a bulk request's file list and drops duplicates.
+func cleanFileIDs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: fileIds is required", ErrInvalid)
+	}
+	if len(ids) > maxBulkFiles {
+		return nil, fmt.Errorf("%w: at most %d files per request", ErrInvalid, maxBulkFiles)
+	}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid file id %q", ErrInvalid, id)
+		}
+		if !slices.Contains(out, id) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,364 @@
+// Package arca implements the file and folder management API (arca_ tables).
+//
+// Read-only browsing lives in the main package; this package owns every
+// mutation of arca_folder, arca_file and their links.
+package arca
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+// Handler holds the arca HTTP handler dependencies.
+type Handler struct {
+	Service      *Service
+	SessionStore *auth.SessionStore
+}
+
+// NewHandler creates a new arca handler.
+func NewHandler(svc *Service, sessionStore *auth.SessionStore) *Handler {
+	return &Handler{Service: svc, SessionStore: sessionStore}
+}
+
+// RegisterRoutes registers folder and file management routes on the given mux.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/projects/{projectId}/folders", h.CreateFolder)
+	mux.HandleFunc("PATCH /api/projects/{projectId}/folders/{folderId}", h.UpdateFolder)
+	mux.HandleFunc("DELETE /api/projects/{projectId}/folders/{folderId}", h.DeleteFolder)
+	mux.HandleFunc("POST /api/projects/{projectId}/folders/{folderId}/restore", h.RestoreFolder)
+
+	mux.HandleFunc("PATCH /api/projects/{projectId}/files/{fileId}", h.UpdateFile)
+	mux.HandleFunc("POST /api/projects/{projectId}/files/{fileId}/copy", h.CopyFile)
+	mux.HandleFunc("POST /api/projects/{projectId}/files/move", h.MoveFiles)
+	mux.HandleFunc("POST /api/projects/{projectId}/files/copy", h.CopyFiles)
+	mux.HandleFunc("DELETE /api/projects/{projectId}/files/{fileId}", h.DeleteFile)
+	mux.HandleFunc("POST /api/projects/{projectId}/files/{fileId}/restore", h.RestoreFile)
+
+	mux.HandleFunc("GET /api/projects/{projectId}/trash", h.ListTrash)
+	mux.HandleFunc("GET /api/projects/{projectId}/storage", h.GetStorageUsage)
+}
+
+// requireWriter resolves the caller's profile in the path project and writes
+// an error response if the caller may not modify it.
+func (h *Handler) requireWriter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return h.requireWriterIn(w, r, r.PathValue("projectId"))
+}
+
+// requireWriterIn is requireWriter for an explicit project.
+func (h *Handler) requireWriterIn(w http.ResponseWriter, r *http.Request, projectID string) (string, bool) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	profileID, err := h.SessionStore.GetWritableProfileForProject(r.Context(), accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return profileID, true
+}
+
+// CreateFolder creates a folder, optionally below a parent folder.
+func (h *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder, err := h.Service.CreateFolder(r.Context(), r.PathValue("projectId"), profileID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, folder)
+}
+
+// UpdateFolder renames a folder and/or moves it to a new parent.
+func (h *Handler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	var req UpdateFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder, err := h.Service.UpdateFolder(r.Context(), r.PathValue("projectId"), r.PathValue("folderId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, folder)
+}
+
+// DeleteFolder moves a folder to the trash. Pass ?recursive=true to delete a
+// non-empty folder together with its contents; otherwise non-empty folders
+// are refused.
+func (h *Handler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	err := h.Service.DeleteFolder(r.Context(), r.PathValue("projectId"), r.PathValue("folderId"), profileID, recursive)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireMember checks that the caller has a profile in the path project.
+func (h *Handler) requireMember(w http.ResponseWriter, r *http.Request) bool {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	_, err := h.SessionStore.GetProfileForProject(r.Context(), accountID, r.PathValue("projectId"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// requireTargetWriter checks that the caller may also write to the project
+// of the destination folder when it differs from the path project.
+func (h *Handler) requireTargetWriter(w http.ResponseWriter, r *http.Request, folderID string) bool {
+	if folderID == "" {
+		return true // the service rejects the request
+	}
+	projectID, err := h.Service.FolderProjectID(r.Context(), folderID)
+	if err != nil {
+		writeError(w, err)
+		return false
+	}
+	if projectID == r.PathValue("projectId") {
+		return true
+	}
+	_, ok := h.requireWriterIn(w, r, projectID)
+	return ok
+}
+
+// UpdateFile renames a file and/or moves it to another folder, possibly in
+// another project of the same tenant.
+func (h *Handler) UpdateFile(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	var req UpdateFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.FolderID != nil && !h.requireTargetWriter(w, r, *req.FolderID) {
+		return
+	}
+
+	file, err := h.Service.UpdateFile(r.Context(), r.PathValue("projectId"), r.PathValue("fileId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, file)
+}
+
+// CopyFile copies a file and all its versions into a folder.
+func (h *Handler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	var req CopyFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.requireTargetWriter(w, r, req.FolderID) {
+		return
+	}
+
+	file, err := h.Service.CopyFile(r.Context(), r.PathValue("projectId"), r.PathValue("fileId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, file)
+}
+
+// MoveFiles moves many files into one folder in a single transaction.
+func (h *Handler) MoveFiles(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	var req BulkFilesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.requireTargetWriter(w, r, req.FolderID) {
+		return
+	}
+
+	files, err := h.Service.MoveFiles(r.Context(), r.PathValue("projectId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+// CopyFiles copies many files into one folder in a single transaction.
+func (h *Handler) CopyFiles(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	var req BulkFilesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.requireTargetWriter(w, r, req.FolderID) {
+		return
+	}
+
+	files, err := h.Service.CopyFiles(r.Context(), r.PathValue("projectId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, files)
+}
+
+// DeleteFile moves a file to the trash.
+func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteFile(r.Context(), r.PathValue("projectId"), r.PathValue("fileId"), profileID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTrash lists the project's deleted files and folders.
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	items, err := h.Service.ListTrash(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, items)
+}
+
+// RestoreFolder restores a folder and everything deleted along with it.
+func (h *Handler) RestoreFolder(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	folder, err := h.Service.RestoreFolder(r.Context(), r.PathValue("projectId"), r.PathValue("folderId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, folder)
+}
+
+// RestoreFile restores a file from the trash.
+func (h *Handler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+
+	file, err := h.Service.RestoreFile(r.Context(), r.PathValue("projectId"), r.PathValue("fileId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, file)
+}
+
+// GetStorageUsage reports the project's and tenant's storage use and quotas.
+func (h *Handler) GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMember(w, r) {
+		return
+	}
+
+	usage, err := h.Service.StorageUsage(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// --- Helpers ---
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError maps service errors to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
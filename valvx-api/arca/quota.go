@@ -0,0 +1,172 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// reservationTTL is how long an upload's reserved bytes count against a
+// quota. Uploads abandoned half-way stop blocking space after this.
+const reservationTTL = 24 * time.Hour
+
+// Quotas are the default byte limits for projects and tenants without their
+// own storage_quota. A limit of zero or less means unlimited.
+type Quotas struct {
+	Project int64
+	Tenant  int64
+}
+
+// --- Storage quotas ---
+
+// StorageUsage returns the project's and its tenant's usage and limits.
+func (s *Service) StorageUsage(ctx context.Context, projectID string) (*StorageUsage, error) {
+	return storageUsage(ctx, s.DB, projectID, s.Config.Quotas)
+}
+
+// ReserveStorage checks that size more bytes fit within the project and
+// tenant quotas and reserves them until ReleaseStorage is called in the
+// transaction that records the upload. It returns a *QuotaError when either
+// quota would be exceeded.
+func ReserveStorage(ctx context.Context, db *sql.DB, projectID string, size int64, defaults Quotas) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkQuota(ctx, tx, projectID, size, defaults, true); err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO arca_storage_reservation (id, project_id, size, created_at)
+		VALUES ($1, $2, $3, now())`, id, projectID, size)
+	if err != nil {
+		return "", fmt.Errorf("reserve storage: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	return id, nil
+}
+
+// ReleaseStorage drops a reservation once its bytes are counted as a stored
+// file version. An empty id is a no-op.
+func ReleaseStorage(ctx context.Context, tx *sql.Tx, reservationID string) error {
+	if reservationID == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM arca_storage_reservation WHERE id = $1`, reservationID)
+	if err != nil {
+		return fmt.Errorf("release storage: %w", err)
+	}
+	return nil
+}
+
+// checkQuota checks that size more bytes fit within the project's quota and,
+// when tenant is set, its tenant's. It takes the tenant's quota lock for the
+// rest of tx, so the bytes must be recorded before tx commits.
+func checkQuota(ctx context.Context, tx *sql.Tx, projectID string, size int64, defaults Quotas, tenant bool) error {
+	// All projects of a tenant share its quota, so serialize per tenant
+	_, err := tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(hashtext('arca_quota:' || tenant_id::text))
+		FROM core_project WHERE id = $1`, projectID)
+	if err != nil {
+		return fmt.Errorf("lock quota: %w", err)
+	}
+
+	usage, err := storageUsage(ctx, tx, projectID, defaults)
+	if err != nil {
+		return err
+	}
+	if r := usage.Project.RemainingBytes; r != nil && size > *r {
+		return &QuotaError{Scope: "project", Remaining: *r}
+	}
+	if r := usage.Tenant.RemainingBytes; tenant && r != nil && size > *r {
+		return &QuotaError{Scope: "tenant", Remaining: *r}
+	}
+	return nil
+}
+
+// checkTransferQuota checks that the versions of fileIDs fit in the project
+// they are moved or copied to. Moves stay within one tenant and only count
+// against another project; copies count against the tenant as well.
+func checkTransferQuota(ctx context.Context, tx *sql.Tx, projectID, targetProjectID string, fileIDs []string, defaults Quotas, copying bool) error {
+	if !copying && targetProjectID == projectID {
+		return nil
+	}
+	var size int64
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(size), 0) FROM arca_file_version WHERE file_id = ANY($1)`,
+		pq.Array(fileIDs)).Scan(&size)
+	if err != nil {
+		return fmt.Errorf("query transfer size: %w", err)
+	}
+	return checkQuota(ctx, tx, targetProjectID, size, defaults, copying)
+}
+
+func storageUsage(ctx context.Context, q queryer, projectID string, defaults Quotas) (*StorageUsage, error) {
+	var u StorageUsage
+	var projectQuota, tenantQuota int64
+	err := q.QueryRowContext(ctx, `
+		SELECT p.id, t.id, COALESCE(p.storage_quota, $2), COALESCE(t.storage_quota, $3)
+		FROM core_project p
+		JOIN core_tenant t ON t.id = p.tenant_id
+		WHERE p.id = $1`, projectID, defaults.Project, defaults.Tenant,
+	).Scan(&u.Project.ID, &u.Tenant.ID, &projectQuota, &tenantQuota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: project %s", ErrNotFound, projectID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get project: %w", err)
+	}
+
+	tenantProjects, err := stringColumn(ctx, q,
+		`SELECT id FROM core_project WHERE tenant_id = $1`, u.Tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("query tenant projects: %w", err)
+	}
+
+	if err := scopeUsage(ctx, q, &u.Project, []string{projectID}, projectQuota); err != nil {
+		return nil, err
+	}
+	if err := scopeUsage(ctx, q, &u.Tenant, tenantProjects, tenantQuota); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// scopeUsage fills in used and reserved bytes for a set of projects.
+func scopeUsage(ctx context.Context, q queryer, scope *UsageScope, projectIDs []string, quota int64) error {
+	err := q.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(fv.size), 0) FROM arca_file_version fv
+			 WHERE fv.file_id IN (
+				SELECT ff.file_id FROM arca_folder_file ff
+				JOIN arca_folder fo ON fo.id = ff.folder_id
+				WHERE fo.project_id = ANY($1)
+			 )),
+			(SELECT COALESCE(SUM(size), 0) FROM arca_storage_reservation
+			 WHERE project_id = ANY($1) AND created_at > now() - $2::interval)`,
+		pq.Array(projectIDs), fmt.Sprintf("%d seconds", int(reservationTTL.Seconds())),
+	).Scan(&scope.UsedBytes, &scope.ReservedBytes)
+	if err != nil {
+		return fmt.Errorf("query usage: %w", err)
+	}
+
+	if quota > 0 {
+		remaining := max(quota-scope.UsedBytes-scope.ReservedBytes, 0)
+		scope.QuotaBytes = &quota
+		scope.RemainingBytes = &remaining
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
+)
+
+// Config holds arca service settings.
+type Config struct {
+	// TrashRetention is how long deleted items stay restorable.
+	TrashRetention time.Duration
+	// PurgeInterval is how often the purger looks for expired trash.
+	PurgeInterval time.Duration
+	// Quotas are the default storage limits.
+	Quotas Quotas
+}
+
+// Service implements folder and file management for the arca_ tables.
+type Service struct {
+	DB     *sql.DB
+	Blobs  *blobstor.Store
+	Config Config
+}
+
+// NewService creates a new arca service.
+func NewService(db *sql.DB, blobs *blobstor.Store, cfg Config) *Service {
+	return &Service{DB: db, Blobs: blobs, Config: cfg}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// --- Folders ---
+
+func (s *Service) GetFolder(ctx context.Context, projectID, folderID string) (*Folder, error) {
+	return getFolder(ctx, s.DB, projectID, folderID)
+}
+
+func getFolder(ctx context.Context, q queryer, projectID, folderID string) (*Folder, error) {
+	var f Folder
+	err := q.QueryRowContext(ctx, `
+		SELECT id, name, parent_id, project_id, creator_id, created_at, updated_at
+		FROM arca_folder
+		WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL`, folderID, projectID,
+	).Scan(&f.ID, &f.Name, &f.ParentID, &f.ProjectID, &f.CreatorID, &f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: folder %s", ErrNotFound, folderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get folder: %w", err)
+	}
+	return &f, nil
+}
+
+func (s *Service) CreateFolder(ctx context.Context, projectID, creatorID string, req CreateFolderRequest) (*Folder, error) {
+	name, err := cleanName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if req.ParentID != nil {
+		if _, err := getFolder(ctx, tx, projectID, *req.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	if err := lockSiblings(ctx, tx, projectID, req.ParentID); err != nil {
+		return nil, err
+	}
+	if err := checkSiblingName(ctx, tx, projectID, req.ParentID, name, ""); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO arca_folder (id, created_at, updated_at, name, project_id, creator_id, parent_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, now, now, name, projectID, creatorID, req.ParentID)
+	if err != nil {
+		return nil, fmt.Errorf("insert folder: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return s.GetFolder(ctx, projectID, id)
+}
+
+// UpdateFolder renames and/or moves a folder. Moving a folder below itself
+// or one of its descendants is rejected.
+func (s *Service) UpdateFolder(ctx context.Context, projectID, folderID string, req UpdateFolderRequest) (*Folder, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	folder, err := getFolder(ctx, tx, projectID, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := folder.Name
+	if req.Name != nil {
+		if name, err = cleanName(*req.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	parentID := folder.ParentID
+	if req.ParentID.Set {
+		parentID = req.ParentID.Value
+		if parentID != nil {
+			if _, err := getFolder(ctx, tx, projectID, *parentID); err != nil {
+				return nil, err
+			}
+			cycle, err := isDescendant(ctx, tx, *parentID, folderID)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				return nil, fmt.Errorf("%w: cannot move a folder into itself or its subfolders", ErrInvalid)
+			}
+		}
+	}
+
+	if err := lockSiblings(ctx, tx, projectID, parentID); err != nil {
+		return nil, err
+	}
+	if err := checkSiblingName(ctx, tx, projectID, parentID, name, folderID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE arca_folder SET name = $2, parent_id = $3, updated_at = $4
+		WHERE id = $1`, folderID, name, parentID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("update folder: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return s.GetFolder(ctx, projectID, folderID)
+}
+
+// DeleteFolder moves a folder to the project trash. Without recursive it
+// refuses a folder that still has subfolders or files. With recursive the
+// live subtree goes too, along with files not also linked from a live folder
+// outside it. Everything is stamped with the same deleted_at so that
+// RestoreFolder can bring it back as one unit.
+func (s *Service) DeleteFolder(ctx context.Context, projectID, folderID, deletedBy string, recursive bool) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := getFolder(ctx, tx, projectID, folderID); err != nil {
+		return err
+	}
+
+	folderIDs, err := subtreeFolderIDs(ctx, tx, folderID)
+	if err != nil {
+		return err
+	}
+	fileIDs, err := filesOnlyIn(ctx, tx, folderIDs)
+	if err != nil {
+		return err
+	}
+
+	if !recursive && (len(folderIDs) > 1 || len(fileIDs) > 0) {
+		return fmt.Errorf("%w: folder is not empty", ErrConflict)
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_folder SET deleted_at = $2, deleted_by = $3 WHERE id = ANY($1)`,
+		pq.Array(folderIDs), now, deletedBy)
+	if err != nil {
+		return fmt.Errorf("trash folders: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_file SET deleted_at = $2, deleted_by = $3 WHERE id = ANY($1)`,
+		pq.Array(fileIDs), now, deletedBy)
+	if err != nil {
+		return fmt.Errorf("trash files: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// --- Helpers ---
+
+func cleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%w: name may not contain slashes", ErrInvalid)
+	}
+	if len(name) > 255 {
+		return "", fmt.Errorf("%w: name is too long", ErrInvalid)
+	}
+	return name, nil
+}
+
+// lockSiblings serializes changes to the children of one parent so that two
+// concurrent requests cannot both pass the unique-name check.
+func lockSiblings(ctx context.Context, tx *sql.Tx, projectID string, parentID *string) error {
+	parent := "root"
+	if parentID != nil {
+		parent = *parentID
+	}
+	_, err := tx.ExecContext(ctx,
+		`SELECT pg_advisory_xact_lock(hashtext($1))`, "arca_folder:"+projectID+":"+parent)
+	if err != nil {
+		return fmt.Errorf("lock siblings: %w", err)
+	}
+	return nil
+}
+
+// checkSiblingName fails with ErrConflict if another folder under the same
+// parent already has name (case-insensitive).
+func checkSiblingName(ctx context.Context, q queryer, projectID string, parentID *string, name, excludeID string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM arca_folder
+			WHERE project_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+			  AND lower(name) = lower($3) AND id::text <> $4 AND deleted_at IS NULL
+		)`, projectID, parentID, name, excludeID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check sibling names: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("%w: a folder named %q already exists here", ErrConflict, name)
+	}
+	return nil
+}
+
+// isDescendant reports whether folderID is ancestorID or lies below it,
+// walking parent_id upwards from folderID.
+func isDescendant(ctx context.Context, q queryer, folderID, ancestorID string) (bool, error) {
+	var found bool
+	err := q.QueryRowContext(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM arca_folder WHERE id = $1
+			UNION
+			SELECT f.id, f.parent_id FROM arca_folder f
+			JOIN ancestors a ON f.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`,
+		folderID, ancestorID).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("check ancestry: %w", err)
+	}
+	return found, nil
+}
+
+// subtreeFolderIDs returns folderID and all live folders below it.
+func subtreeFolderIDs(ctx context.Context, q queryer, folderID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM arca_folder WHERE id = $1
+			UNION
+			SELECT f.id FROM arca_folder f
+			JOIN subtree s ON f.parent_id = s.id
+			WHERE f.deleted_at IS NULL
+		)
+		SELECT id FROM subtree`, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("query subtree: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan subtree: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// filesOnlyIn returns the live files linked from folderIDs that have no link
+// to any live folder outside that set.
+func filesOnlyIn(ctx context.Context, q queryer, folderIDs []string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT DISTINCT ff.file_id FROM arca_folder_file ff
+		JOIN arca_file f ON f.id = ff.file_id
+		WHERE ff.folder_id = ANY($1) AND f.deleted_at IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM arca_folder_file other
+			JOIN arca_folder fo ON fo.id = other.folder_id
+			WHERE other.file_id = ff.file_id AND NOT (other.folder_id = ANY($1))
+			  AND fo.deleted_at IS NULL
+		  )`, pq.Array(folderIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query files: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan file: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// deleteFiles removes files and all their versions and returns the storage
+// keys that are no longer referenced: objects owned by unhashed versions and
+// shared blobs whose last reference went. Versions still linked from BCF
+// topics or tickets make the whole delete fail with ErrConflict.
+func deleteFiles(ctx context.Context, tx *sql.Tx, fileIDs []string) ([]string, error) {
+	if len(fileIDs) == 0 {
+		return nil, nil
+	}
+
+	var referenced bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM arca_file_version fv
+			WHERE fv.file_id = ANY($1) AND (
+				EXISTS (SELECT 1 FROM collab_topic_file ctf WHERE ctf.file_version_id = fv.id)
+				OR EXISTS (SELECT 1 FROM opus_ticket ot WHERE ot.file_version_id = fv.id)
+			)
+		)`, pq.Array(fileIDs)).Scan(&referenced)
+	if err != nil {
+		return nil, fmt.Errorf("check references: %w", err)
+	}
+	if referenced {
+		return nil, fmt.Errorf("%w: some file versions are referenced by BCF topics or tickets", ErrConflict)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT COALESCE(storage_key, id::text), content_hash FROM arca_file_version WHERE file_id = ANY($1)`,
+		pq.Array(fileIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query storage keys: %w", err)
+	}
+	var keys, hashes []string
+	for rows.Next() {
+		var key string
+		var hash sql.NullString
+		if err := rows.Scan(&key, &hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan storage key: %w", err)
+		}
+		if hash.Valid {
+			hashes = append(hashes, hash.String)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	stmts := []string{
+		`DELETE FROM arca_speckle_mapping WHERE file_version_id IN (SELECT id FROM arca_file_version WHERE file_id = ANY($1))`,
+		`UPDATE arca_upload_job SET file_version_id = NULL WHERE file_version_id IN (SELECT id FROM arca_file_version WHERE file_id = ANY($1))`,
+		`DELETE FROM arca_file_version WHERE file_id = ANY($1)`,
+		`DELETE FROM arca_folder_file WHERE file_id = ANY($1)`,
+		`DELETE FROM arca_file WHERE id = ANY($1)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, pq.Array(fileIDs)); err != nil {
+			return nil, fmt.Errorf("delete files: %w", err)
+		}
+	}
+
+	for _, hash := range hashes {
+		key, gone, err := releaseBlob(ctx, tx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if gone {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// deleteBlobs removes objects after their rows are gone. Failures only leave
+// an orphaned object behind, so they are logged rather than returned.
+func (s *Service) deleteBlobs(ctx context.Context, keys []string) {
+	if s.Blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := s.Blobs.Delete(ctx, key); err != nil {
+			log.Printf("Warning: could not delete blob %s: %v", key, err)
+		}
+	}
+}
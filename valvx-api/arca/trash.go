@@ -0,0 +1,393 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// purgeBatchSize is how many files the purger deletes per transaction.
+const purgeBatchSize = 100
+
+// --- Trash ---
+
+// DeleteFile moves a file to the project trash, removing it from every
+// folder it is linked into.
+func (s *Service) DeleteFile(ctx context.Context, projectID, fileID, deletedBy string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := getFile(ctx, tx, projectID, fileID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_file SET deleted_at = $2, deleted_by = $3 WHERE id = $1`,
+		fileID, time.Now().UTC(), deletedBy)
+	if err != nil {
+		return fmt.Errorf("trash file: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// ListTrash returns the items deleted in a project, newest first.
+func (s *Service) ListTrash(ctx context.Context, projectID string) ([]TrashItem, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT 'folder', fo.id, fo.name, '', fo.parent_id, fo.deleted_at, fo.deleted_by, p.name
+		FROM arca_folder fo
+		LEFT JOIN iam_profile p ON p.id = fo.deleted_by
+		WHERE fo.project_id = $1 AND fo.deleted_at IS NOT NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM arca_folder parent
+			WHERE parent.id = fo.parent_id AND parent.deleted_at = fo.deleted_at
+		  )
+		UNION ALL
+		SELECT 'file', f.id, f.name, COALESCE(f.ext, ''), link.folder_id, f.deleted_at, f.deleted_by, p.name
+		FROM arca_file f
+		JOIN LATERAL (
+			SELECT ff.folder_id FROM arca_folder_file ff
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			WHERE ff.file_id = f.id AND fo.project_id = $1
+			ORDER BY ff.folder_id
+			LIMIT 1
+		) link ON true
+		LEFT JOIN iam_profile p ON p.id = f.deleted_by
+		WHERE f.deleted_at IS NOT NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM arca_folder_file ff
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			WHERE ff.file_id = f.id AND fo.deleted_at = f.deleted_at
+		  )
+		ORDER BY 6 DESC`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("query trash: %w", err)
+	}
+	defer rows.Close()
+
+	items := []TrashItem{}
+	for rows.Next() {
+		var it TrashItem
+		if err := rows.Scan(&it.Type, &it.ID, &it.Name, &it.Ext, &it.ParentID,
+			&it.DeletedAt, &it.DeletedBy, &it.DeletedByName); err != nil {
+			return nil, fmt.Errorf("scan trash item: %w", err)
+		}
+		it.PurgeAt = it.DeletedAt.Add(s.Config.TrashRetention)
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// RestoreFolder brings a trashed folder back together with everything that
+// was deleted along with it. The parent must not be in the trash, and no
+// live sibling may have taken the folder's name in the meantime.
+func (s *Service) RestoreFolder(ctx context.Context, projectID, folderID string) (*Folder, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	var parentID *string
+	var deletedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT name, parent_id, deleted_at FROM arca_folder
+		WHERE id = $1 AND project_id = $2 AND deleted_at IS NOT NULL`, folderID, projectID,
+	).Scan(&name, &parentID, &deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: folder %s is not in the trash", ErrNotFound, folderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get trashed folder: %w", err)
+	}
+
+	if parentID != nil {
+		if _, err := getFolder(ctx, tx, projectID, *parentID); errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: the parent folder is in the trash; restore it first", ErrConflict)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if err := lockSiblings(ctx, tx, projectID, parentID); err != nil {
+		return nil, err
+	}
+	if err := checkSiblingName(ctx, tx, projectID, parentID, name, folderID); err != nil {
+		return nil, err
+	}
+
+	// Only the part of the subtree that went to the trash in the same delete
+	folderIDs, err := stringColumn(ctx, tx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM arca_folder WHERE id = $1
+			UNION
+			SELECT f.id FROM arca_folder f
+			JOIN subtree s ON f.parent_id = s.id
+			WHERE f.deleted_at = $2
+		)
+		SELECT id FROM subtree`, folderID, deletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("query subtree: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_folder SET deleted_at = NULL, deleted_by = NULL WHERE id = ANY($1)`,
+		pq.Array(folderIDs))
+	if err != nil {
+		return nil, fmt.Errorf("restore folders: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE arca_file SET deleted_at = NULL, deleted_by = NULL
+		WHERE deleted_at = $2
+		  AND id IN (SELECT file_id FROM arca_folder_file WHERE folder_id = ANY($1))`,
+		pq.Array(folderIDs), deletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("restore files: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return s.GetFolder(ctx, projectID, folderID)
+}
+
+// RestoreFile brings a trashed file back into the live folders it is linked
+// from. A file whose folders are all in the trash is restored with them.
+func (s *Service) RestoreFile(ctx context.Context, projectID, fileID string) (*File, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var liveFolderID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT (
+			SELECT fo.id FROM arca_folder_file ff
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			WHERE ff.file_id = f.id AND fo.project_id = $2 AND fo.deleted_at IS NULL
+			ORDER BY fo.id
+			LIMIT 1
+		)
+		FROM arca_file f
+		WHERE f.id = $1 AND f.deleted_at IS NOT NULL
+		  AND EXISTS (
+			SELECT 1 FROM arca_folder_file ff
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			WHERE ff.file_id = f.id AND fo.project_id = $2
+		  )`, fileID, projectID).Scan(&liveFolderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: file %s is not in the trash", ErrNotFound, fileID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get trashed file: %w", err)
+	}
+	if !liveFolderID.Valid {
+		return nil, fmt.Errorf("%w: the file's folder is in the trash; restore it first", ErrConflict)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE arca_file SET deleted_at = NULL, deleted_by = NULL WHERE id = $1`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("restore file: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	files, err := filesIn(ctx, s.DB, liveFolderID.String, []string{fileID})
+	if err != nil {
+		return nil, err
+	}
+	return &files[0], nil
+}
+
+// --- Purger ---
+
+// RunPurger purges expired trash every Config.PurgeInterval until ctx ends.
+func (s *Service) RunPurger(ctx context.Context) {
+	interval := s.Config.PurgeInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Purge(ctx); err != nil {
+			log.Printf("Trash purge error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge permanently removes files and folders that have been in the trash
+// longer than Config.TrashRetention, including their blobs. Files with a
+// version still referenced by a BCF topic or ticket are kept, and so are the
+// folders they sit in. Expired storage reservations are dropped as well.
+func (s *Service) Purge(ctx context.Context) error {
+	if s.Config.TrashRetention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().UTC().Add(-s.Config.TrashRetention)
+
+	_, err := s.DB.ExecContext(ctx,
+		`DELETE FROM arca_storage_reservation WHERE created_at < now() - $1::interval`,
+		fmt.Sprintf("%d seconds", int(reservationTTL.Seconds())))
+	if err != nil {
+		return fmt.Errorf("delete expired reservations: %w", err)
+	}
+
+	var kept int
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM arca_file f
+		WHERE f.deleted_at < $1 AND EXISTS (
+			SELECT 1 FROM arca_file_version fv
+			WHERE fv.file_id = f.id AND (
+				EXISTS (SELECT 1 FROM collab_topic_file ctf WHERE ctf.file_version_id = fv.id)
+				OR EXISTS (SELECT 1 FROM opus_ticket ot WHERE ot.file_version_id = fv.id)
+			)
+		)`, cutoff).Scan(&kept)
+	if err != nil {
+		return fmt.Errorf("count referenced files: %w", err)
+	}
+	if kept > 0 {
+		log.Printf("Trash purge: keeping %d expired files still referenced by topics or tickets", kept)
+	}
+
+	purgedFiles := 0
+	for {
+		n, err := s.purgeFiles(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		purgedFiles += n
+	}
+
+	purgedFolders, err := s.purgeFolders(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if purgedFiles > 0 || purgedFolders > 0 {
+		log.Printf("Trash purge: removed %d files and %d folders", purgedFiles, purgedFolders)
+	}
+	return nil
+}
+
+// purgeFiles deletes one batch of expired, unreferenced files.
+func (s *Service) purgeFiles(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileIDs, err := stringColumn(ctx, tx, `
+		SELECT f.id FROM arca_file f
+		WHERE f.deleted_at < $1 AND NOT EXISTS (
+			SELECT 1 FROM arca_file_version fv
+			WHERE fv.file_id = f.id AND (
+				EXISTS (SELECT 1 FROM collab_topic_file ctf WHERE ctf.file_version_id = fv.id)
+				OR EXISTS (SELECT 1 FROM opus_ticket ot WHERE ot.file_version_id = fv.id)
+			)
+		)
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("query expired files: %w", err)
+	}
+	if len(fileIDs) == 0 {
+		return 0, nil
+	}
+
+	keys, err := deleteFiles(ctx, tx, fileIDs)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+
+	s.deleteBlobs(ctx, keys)
+	return len(fileIDs), nil
+}
+
+// purgeFolders deletes expired folders bottom-up. A folder goes once it has
+// no subfolders and no trashed files left; links to live files (linked from
+// other folders too) are dropped with it.
+func (s *Service) purgeFolders(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM arca_folder_file ff
+		USING arca_folder fo, arca_file f
+		WHERE fo.id = ff.folder_id AND f.id = ff.file_id
+		  AND fo.deleted_at < $1 AND f.deleted_at IS NULL`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("unlink live files: %w", err)
+	}
+
+	total := 0
+	for {
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM arca_folder fo
+			WHERE fo.deleted_at < $1
+			  AND NOT EXISTS (SELECT 1 FROM arca_folder child WHERE child.parent_id = fo.id)
+			  AND NOT EXISTS (SELECT 1 FROM arca_folder_file ff WHERE ff.folder_id = fo.id)`, cutoff)
+		if err != nil {
+			return 0, fmt.Errorf("delete folders: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			break
+		}
+		total += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return total, nil
+}
+
+// stringColumn runs a query returning one text-compatible column.
+func stringColumn(ctx context.Context, q queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,141 @@
+package arca
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a folder or file does not exist in the project.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned for duplicate sibling names and non-empty deletes.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned for requests that can never succeed, e.g. cycles.
+	ErrInvalid = errors.New("invalid request")
+	// ErrQuotaExceeded is wrapped by QuotaError.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Folder is a node in a project's arca_folder hierarchy.
+type Folder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *string   `json:"parentId"`
+	ProjectID string    `json:"projectId"`
+	CreatorID string    `json:"creatorId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateFolderRequest is the request body for creating a folder.
+// A nil ParentID creates a top-level folder.
+type CreateFolderRequest struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parentId,omitempty"`
+}
+
+// UpdateFolderRequest is the request body for renaming and/or moving a folder.
+// ParentID distinguishes "not sent" from an explicit null (move to top level).
+type UpdateFolderRequest struct {
+	Name     *string        `json:"name,omitempty"`
+	ParentID OptionalString `json:"parentId"`
+}
+
+// OptionalString is a JSON string field that records whether it was present.
+type OptionalString struct {
+	Set   bool
+	Value *string
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if bytes.Equal(data, []byte("null")) {
+		o.Value = nil
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// File is an arca_file as seen from one folder it is linked into.
+type File struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Ext       string    `json:"ext"`
+	FolderID  string    `json:"folderId"`
+	ProjectID string    `json:"projectId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UpdateFileRequest is the request body for renaming and/or moving a file.
+// FolderID may name a folder in another project of the same tenant.
+type UpdateFileRequest struct {
+	Name     *string `json:"name,omitempty"`
+	Ext      *string `json:"ext,omitempty"`
+	FolderID *string `json:"folderId,omitempty"`
+}
+
+// CopyFileRequest is the request body for copying one file. Name and Ext
+// default to those of the source file.
+type CopyFileRequest struct {
+	FolderID string  `json:"folderId"`
+	Name     *string `json:"name,omitempty"`
+	Ext      *string `json:"ext,omitempty"`
+}
+
+// BulkFilesRequest is the request body for moving or copying many files into
+// one folder. The whole batch succeeds or fails together.
+type BulkFilesRequest struct {
+	FileIDs  []string `json:"fileIds"`
+	FolderID string   `json:"folderId"`
+}
+
+// TrashItem is a file or folder in a project's trash. Only the item that was
+// deleted is listed; its contents come back with it on restore.
+type TrashItem struct {
+	Type          string    `json:"type"` // "folder" or "file"
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Ext           string    `json:"ext,omitempty"`
+	ParentID      *string   `json:"parentId"`
+	DeletedAt     time.Time `json:"deletedAt"`
+	DeletedBy     *string   `json:"deletedBy"`
+	DeletedByName *string   `json:"deletedByName"`
+	PurgeAt       time.Time `json:"purgeAt"`
+}
+
+// StorageUsage reports bytes used against the quotas of a project and its
+// tenant. Every stored version counts at its full size, including files in
+// the trash, until it is purged.
+type StorageUsage struct {
+	Project UsageScope `json:"project"`
+	Tenant  UsageScope `json:"tenant"`
+}
+
+// UsageScope is the usage of one project or tenant. QuotaBytes and
+// RemainingBytes are null when no limit applies.
+type UsageScope struct {
+	ID             string `json:"id"`
+	UsedBytes      int64  `json:"usedBytes"`
+	ReservedBytes  int64  `json:"reservedBytes"`
+	QuotaBytes     *int64 `json:"quotaBytes"`
+	RemainingBytes *int64 `json:"remainingBytes"`
+}
+
+// QuotaError is returned when an upload, copy or move would exceed a storage
+// quota.
+type QuotaError struct {
+	Scope     string // "project" or "tenant"
+	Remaining int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s storage quota exceeded: %d bytes remaining", e.Scope, e.Remaining)
+}
+
+// Unwrap makes errors.Is(err, ErrQuotaExceeded) work.
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
+)
+
+// archiveEntry is one file version to be written into a folder archive.
+type archiveEntry struct {
+	dirs     []string
+	name     string
+	ext      string
+	key      string
+	modified time.Time
+}
+
+// handleFolderArchive streams a ZIP of a folder subtree, one entry per file,
+// with the folder hierarchy preserved as paths below the folder's own name.
+// Objects are copied from blob storage one at a time, so memory use does not
+// depend on the size of the folder.
+//
+// Query parameters:
+//   - ext: comma-separated extensions to include, e.g. ifc,pdf
+//   - versions: comma-separated file version IDs to use instead of the latest
+//     version of their files
+func handleFolderArchive(w http.ResponseWriter, r *http.Request, db *sql.DB, sessions *auth.SessionStore, store *blobstor.Store) {
+	projectID := r.PathValue("projectId")
+	folderID := r.PathValue("folderId")
+	if !requireProjectMember(w, r, sessions, projectID) {
+		return
+	}
+	if store == nil {
+		http.Error(w, "blob storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	exts, versionIDs := []string{}, []string{}
+	if v := q.Get("ext"); v != "" {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), ".")); e != "" {
+				exts = append(exts, e)
+			}
+		}
+	}
+	if v := q.Get("versions"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				versionIDs = append(versionIDs, id)
+			}
+		}
+	}
+
+	var folderName string
+	err := db.QueryRowContext(r.Context(),
+		`SELECT name FROM arca_folder WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL`,
+		folderID, projectID).Scan(&folderName)
+	if err == sql.ErrNoRows {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		WITH RECURSIVE tree AS (
+			SELECT id, ARRAY[]::text[] AS dirs, ARRAY[id] AS path
+			FROM arca_folder
+			WHERE id = $1
+			UNION ALL
+			SELECT f.id, t.dirs || f.name::text, t.path || f.id
+			FROM arca_folder f
+			JOIN tree t ON f.parent_id = t.id
+			WHERE NOT f.id = ANY(t.path) AND f.deleted_at IS NULL
+		)
+		SELECT t.dirs, fi.name, COALESCE(fi.ext, ''), COALESCE(v.storage_key, v.id::text), v.created_at
+		FROM tree t
+		JOIN arca_folder_file ff ON ff.folder_id = t.id
+		JOIN arca_file fi ON fi.id = ff.file_id AND fi.deleted_at IS NULL
+		JOIN LATERAL (
+			SELECT id, storage_key, created_at
+			FROM arca_file_version
+			WHERE file_id = fi.id
+			ORDER BY id::text = ANY($2) DESC, number DESC
+			LIMIT 1
+		) v ON true
+		WHERE cardinality($3::text[]) = 0 OR lower(COALESCE(fi.ext, '')) = ANY($3)
+		ORDER BY t.dirs, lower(fi.name), fi.id`,
+		folderID, pq.Array(versionIDs), pq.Array(exts))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var entries []archiveEntry
+	for rows.Next() {
+		var e archiveEntry
+		if err := rows.Scan(pq.Array(&e.dirs), &e.name, &e.ext, &e.key, &e.modified); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Large archives outlive the server's WriteTimeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": folderName + ".zip"}))
+
+	zw := zip.NewWriter(w)
+	used := make(map[string]bool)
+	for _, e := range entries {
+		name := archivePath(folderName, e, used)
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: e.modified,
+		})
+		if err == nil {
+			err = copyObject(r, store, e.key, fw)
+		}
+		if err != nil {
+			// Headers are gone; abort so the client sees a failed download
+			// rather than a truncated archive that looks complete.
+			log.Printf("Archive of folder %s failed at %s: %v", folderID, name, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Archive of folder %s failed: %v", folderID, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func copyObject(r *http.Request, store *blobstor.Store, key string, dst io.Writer) error {
+	body, err := store.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(dst, body)
+	return err
+}
+
+// archivePath builds the entry path for e and disambiguates files that share
+// a name in the same folder by appending " (2)", " (3)", ...
+func archivePath(root string, e archiveEntry, used map[string]bool) string {
+	parts := []string{cleanArchiveName(root)}
+	for _, d := range e.dirs {
+		parts = append(parts, cleanArchiveName(d))
+	}
+	dir := path.Join(parts...)
+
+	base := cleanArchiveName(e.name)
+	suffix := ""
+	if e.ext != "" {
+		suffix = "." + cleanArchiveName(e.ext)
+	}
+
+	name := path.Join(dir, base+suffix)
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = path.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, suffix))
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// cleanArchiveName makes a folder or file name safe as one ZIP path segment.
+func cleanArchiveName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
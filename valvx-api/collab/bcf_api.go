@@ -0,0 +1,1056 @@
+package collab
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+// The buildingSMART BCF API 3.0 (https://github.com/buildingSMART/BCF-API),
+// so that BCF tools can sync topics with a project. Topics, comments and
+// viewpoints are addressed by their BCF GUIDs and users by email; the
+// session token is accepted as a bearer token.
+
+// Actions offered to members who may write; readers get none.
+var (
+	apiProjectActions = []string{"createTopic"}
+	apiTopicActions   = []string{"update", "createComment", "createViewpoint"}
+	apiCommentActions = []string{"update"}
+)
+
+func (h *Handler) registerAPIRoutes(mux *http.ServeMux) {
+	const project = "/bcf/3.0/projects/{projectId}"
+	const topic = project + "/topics/{topicGuid}"
+	const viewpoint = topic + "/viewpoints/{viewpointGuid}"
+
+	mux.HandleFunc("GET /foundation/versions", h.APIVersions)
+
+	mux.HandleFunc("GET /bcf/3.0/projects", h.APIListProjects)
+	mux.HandleFunc("GET "+project, h.APIGetProject)
+	mux.HandleFunc("GET "+project+"/extensions", h.APIGetExtensions)
+
+	mux.HandleFunc("GET "+project+"/topics", h.APIListTopics)
+	mux.HandleFunc("POST "+project+"/topics", h.APICreateTopic)
+	mux.HandleFunc("GET "+topic, h.APIGetTopic)
+	mux.HandleFunc("PUT "+topic, h.APIUpdateTopic)
+	mux.HandleFunc("DELETE "+topic, h.APIDeleteTopic)
+	mux.HandleFunc("GET "+topic+"/events", h.APIListTopicEvents)
+
+	mux.HandleFunc("GET "+topic+"/comments", h.APIListComments)
+	mux.HandleFunc("POST "+topic+"/comments", h.APICreateComment)
+	mux.HandleFunc("GET "+topic+"/comments/{commentGuid}", h.APIGetComment)
+	mux.HandleFunc("PUT "+topic+"/comments/{commentGuid}", h.APIUpdateComment)
+	mux.HandleFunc("DELETE "+topic+"/comments/{commentGuid}", h.APIDeleteComment)
+
+	mux.HandleFunc("GET "+topic+"/viewpoints", h.APIListViewpoints)
+	mux.HandleFunc("POST "+topic+"/viewpoints", h.APICreateViewpoint)
+	mux.HandleFunc("GET "+viewpoint, h.APIGetViewpoint)
+	mux.HandleFunc("DELETE "+viewpoint, h.APIDeleteViewpoint)
+	mux.HandleFunc("GET "+viewpoint+"/selection", h.APIGetSelection)
+	mux.HandleFunc("GET "+viewpoint+"/coloring", h.APIGetColoring)
+	mux.HandleFunc("GET "+viewpoint+"/visibility", h.APIGetVisibility)
+	mux.HandleFunc("GET "+viewpoint+"/snapshot", h.APIGetSnapshot)
+}
+
+// --- JSON ---
+
+type apiVersion struct {
+	APIID           string `json:"api_id"`
+	VersionID       string `json:"version_id"`
+	DetailedVersion string `json:"detailed_version"`
+}
+
+type apiProject struct {
+	ProjectID     string            `json:"project_id"`
+	Name          string            `json:"name"`
+	Authorization *apiAuthorization `json:"authorization,omitempty"`
+}
+
+type apiAuthorization struct {
+	ProjectActions []string `json:"project_actions,omitempty"`
+	TopicActions   []string `json:"topic_actions,omitempty"`
+	CommentActions []string `json:"comment_actions,omitempty"`
+}
+
+type apiExtensions struct {
+	TopicType      []string  `json:"topic_type"`
+	TopicStatus    []string  `json:"topic_status"`
+	TopicLabel     []string  `json:"topic_label"`
+	Priority       []string  `json:"priority"`
+	Users          []apiUser `json:"users"`
+	Stage          []string  `json:"stage"`
+	ProjectActions []string  `json:"project_actions"`
+	TopicActions   []string  `json:"topic_actions"`
+	CommentActions []string  `json:"comment_actions"`
+}
+
+type apiUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type apiTopic struct {
+	GUID           string            `json:"guid"`
+	TopicType      string            `json:"topic_type,omitempty"`
+	TopicStatus    string            `json:"topic_status,omitempty"`
+	ReferenceLinks []string          `json:"reference_links,omitempty"`
+	Title          string            `json:"title"`
+	Priority       string            `json:"priority,omitempty"`
+	Labels         []string          `json:"labels,omitempty"`
+	CreationDate   string            `json:"creation_date"`
+	CreationAuthor string            `json:"creation_author"`
+	ModifiedDate   string            `json:"modified_date,omitempty"`
+	ModifiedAuthor string            `json:"modified_author,omitempty"`
+	AssignedTo     string            `json:"assigned_to,omitempty"`
+	Stage          string            `json:"stage,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	DueDate        string            `json:"due_date,omitempty"`
+	Authorization  *apiAuthorization `json:"authorization,omitempty"`
+}
+
+type apiEvent struct {
+	TopicGUID string           `json:"topic_guid"`
+	Date      string           `json:"date"`
+	Author    string           `json:"author"`
+	Actions   []apiEventAction `json:"actions"`
+}
+
+type apiEventAction struct {
+	Type  string  `json:"type"`
+	Value *string `json:"value"`
+}
+
+type apiComment struct {
+	GUID           string            `json:"guid"`
+	Date           string            `json:"date"`
+	Author         string            `json:"author"`
+	Comment        string            `json:"comment"`
+	TopicGUID      string            `json:"topic_guid"`
+	ViewpointGUID  string            `json:"viewpoint_guid,omitempty"`
+	ModifiedDate   string            `json:"modified_date,omitempty"`
+	ModifiedAuthor string            `json:"modified_author,omitempty"`
+	Authorization  *apiAuthorization `json:"authorization,omitempty"`
+}
+
+// apiViewpoint carries components and snapshot data only when posted; they
+// are read back through the sub-resources.
+type apiViewpoint struct {
+	GUID              string                `json:"guid"`
+	OrthogonalCamera  *apiOrthogonalCamera  `json:"orthogonal_camera,omitempty"`
+	PerspectiveCamera *apiPerspectiveCamera `json:"perspective_camera,omitempty"`
+	Lines             []apiLine             `json:"lines,omitempty"`
+	ClippingPlanes    []ClippingPlane       `json:"clipping_planes,omitempty"`
+	Snapshot          *apiSnapshot          `json:"snapshot,omitempty"`
+	Components        *apiComponents        `json:"components,omitempty"`
+}
+
+type apiOrthogonalCamera struct {
+	CameraViewPoint  Vector3  `json:"camera_view_point"`
+	CameraDirection  Vector3  `json:"camera_direction"`
+	CameraUpVector   Vector3  `json:"camera_up_vector"`
+	ViewToWorldScale float64  `json:"view_to_world_scale"`
+	AspectRatio      *float64 `json:"aspect_ratio,omitempty"`
+}
+
+type apiPerspectiveCamera struct {
+	CameraViewPoint Vector3  `json:"camera_view_point"`
+	CameraDirection Vector3  `json:"camera_direction"`
+	CameraUpVector  Vector3  `json:"camera_up_vector"`
+	FieldOfView     float64  `json:"field_of_view"`
+	AspectRatio     *float64 `json:"aspect_ratio,omitempty"`
+}
+
+type apiLine struct {
+	StartPoint Vector3 `json:"start_point"`
+	EndPoint   Vector3 `json:"end_point"`
+}
+
+type apiSnapshot struct {
+	SnapshotType string `json:"snapshot_type"`
+	SnapshotData string `json:"snapshot_data,omitempty"`
+}
+
+type apiComponents struct {
+	Selection  []apiComponent `json:"selection,omitempty"`
+	Coloring   []apiColoring  `json:"coloring,omitempty"`
+	Visibility *apiVisibility `json:"visibility,omitempty"`
+}
+
+type apiComponent struct {
+	IfcGuid           string `json:"ifc_guid,omitempty"`
+	OriginatingSystem string `json:"originating_system,omitempty"`
+	AuthoringToolID   string `json:"authoring_tool_id,omitempty"`
+}
+
+type apiColoring struct {
+	Color      string         `json:"color"`
+	Components []apiComponent `json:"components"`
+}
+
+type apiVisibility struct {
+	DefaultVisibility bool           `json:"default_visibility"`
+	Exceptions        []apiComponent `json:"exceptions,omitempty"`
+}
+
+// --- Projects ---
+
+// APIVersions lists the API versions served, for client discovery.
+func (h *Handler) APIVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]apiVersion{
+		"versions": {{
+			APIID:           "bcf",
+			VersionID:       "3.0",
+			DetailedVersion: "https://github.com/buildingSMART/BCF-API/tree/release_3_0",
+		}},
+	})
+}
+
+// APIListProjects lists the caller's projects.
+func (h *Handler) APIListProjects(w http.ResponseWriter, r *http.Request) {
+	projects, ok := h.apiProjects(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+// APIGetProject returns one of the caller's projects.
+func (h *Handler) APIGetProject(w http.ResponseWriter, r *http.Request) {
+	projects, ok := h.apiProjects(w, r)
+	if !ok {
+		return
+	}
+	for _, p := range projects {
+		if p.ProjectID == r.PathValue("projectId") {
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+	}
+	http.Error(w, "project not found", http.StatusNotFound)
+}
+
+func (h *Handler) apiProjects(w http.ResponseWriter, r *http.Request) ([]apiProject, bool) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	projects, err := h.Service.ListProjects(r.Context(), accountID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	out := make([]apiProject, len(projects))
+	for i, p := range projects {
+		out[i] = apiProject{ProjectID: p.ID, Name: p.Name, Authorization: &apiAuthorization{}}
+		if p.Writable {
+			out[i].Authorization.ProjectActions = apiProjectActions
+		}
+	}
+	return out, true
+}
+
+// APIGetExtensions lists the values topics of the project may use: the
+// defaults plus any in use, and the members as users.
+func (h *Handler) APIGetExtensions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	projectID := r.PathValue("projectId")
+
+	topics, err := h.Service.ListTopics(r.Context(), projectID, TopicFilters{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members, err := h.Service.ListMembers(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ext := extensionsFor(topics)
+	out := apiExtensions{
+		TopicType:      ext.TopicTypes,
+		TopicStatus:    ext.TopicStatuses,
+		TopicLabel:     []string{},
+		Priority:       ext.Priorities,
+		Users:          []apiUser{},
+		Stage:          []string{},
+		ProjectActions: apiProjectActions,
+		TopicActions:   apiTopicActions,
+		CommentActions: apiCommentActions,
+	}
+	if ext.TopicLabels != nil {
+		out.TopicLabel = ext.TopicLabels.TopicLabel
+	}
+	if ext.Stages != nil {
+		out.Stage = ext.Stages.Stage
+	}
+	for _, m := range members {
+		out.Users = append(out.Users, apiUser{ID: m.Email, Name: m.Name})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// --- Topics ---
+
+// APIListTopics lists the topics of a project, with OData query options.
+func (h *Handler) APIListTopics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	query, err := parseODataQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topics, err := h.Service.ListTopics(r.Context(), r.PathValue("projectId"), TopicFilters{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authz := h.apiAuthorization(r, &apiAuthorization{TopicActions: apiTopicActions})
+	out := make([]apiTopic, len(topics))
+	for i, t := range topics {
+		out[i] = topicToAPI(t, authz)
+	}
+	writeJSON(w, http.StatusOK, applyOData(query, out))
+}
+
+// APICreateTopic creates a topic. A GUID may be given by the client.
+func (h *Handler) APICreateTopic(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+	projectID := r.PathValue("projectId")
+
+	var in apiTopic
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Title) == "" {
+		http.Error(w, "invalid request body: a title is required", http.StatusBadRequest)
+		return
+	}
+	if in.GUID != "" {
+		if _, err := h.Service.GetTopicByGUID(r.Context(), projectID, in.GUID); err == nil {
+			http.Error(w, "a topic with this guid exists", http.StatusConflict)
+			return
+		}
+	}
+
+	req, ok := h.topicRequest(w, r, in, false)
+	if !ok {
+		return
+	}
+	topic, err := h.Service.CreateTopic(r.Context(), projectID, profileID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, topicToAPI(*topic, h.apiAuthorization(r, &apiAuthorization{TopicActions: apiTopicActions})))
+}
+
+// APIGetTopic returns a topic.
+func (h *Handler) APIGetTopic(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, topicToAPI(*topic, h.apiAuthorization(r, &apiAuthorization{TopicActions: apiTopicActions})))
+}
+
+// APIUpdateTopic replaces the fields of a topic; fields left out are
+// cleared, except the status, which is kept.
+func (h *Handler) APIUpdateTopic(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	var in apiTopic
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Title) == "" {
+		http.Error(w, "invalid request body: a title is required", http.StatusBadRequest)
+		return
+	}
+	req, ok := h.topicRequest(w, r, in, true)
+	if !ok {
+		return
+	}
+
+	updated, err := h.Service.UpdateTopic(r.Context(), topic.ID, profileID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, topicToAPI(*updated, h.apiAuthorization(r, &apiAuthorization{TopicActions: apiTopicActions})))
+}
+
+// APIDeleteTopic deletes a topic with its comments and viewpoints.
+func (h *Handler) APIDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteTopic(r.Context(), topic.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListTopicEvents lists the changes made to a topic, with OData query
+// options.
+func (h *Handler) APIListTopicEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	query, err := parseODataQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := h.Service.ListTopicEvents(r.Context(), topic.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]apiEvent, len(events))
+	for i, e := range events {
+		out[i] = apiEvent{
+			TopicGUID: e.TopicGUID,
+			Date:      formatBCFDate(e.Date),
+			Author:    derefStr(e.Author),
+			Actions:   make([]apiEventAction, len(e.Actions)),
+		}
+		for j, a := range e.Actions {
+			out[i].Actions[j] = apiEventAction(a)
+		}
+	}
+	writeJSON(w, http.StatusOK, applyOData(query, out))
+}
+
+// topicByGUID looks up the path topic and writes a 404 if there is none.
+func (h *Handler) topicByGUID(w http.ResponseWriter, r *http.Request) (*Topic, bool) {
+	topic, err := h.Service.GetTopicByGUID(r.Context(), r.PathValue("projectId"), r.PathValue("topicGuid"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "topic not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return topic, true
+}
+
+// topicRequest maps a posted topic. With replace set, fields left out are
+// cleared rather than kept.
+func (h *Handler) topicRequest(w http.ResponseWriter, r *http.Request, in apiTopic, replace bool) (CreateTopicRequest, bool) {
+	field := optionalStr
+	if replace {
+		field = func(s string) *string { return &s }
+	}
+	req := CreateTopicRequest{
+		GUID:           optionalStr(in.GUID),
+		Title:          strings.TrimSpace(in.Title),
+		Description:    field(in.Description),
+		Priority:       field(in.Priority),
+		TopicType:      field(in.TopicType),
+		TopicStatus:    optionalStr(in.TopicStatus),
+		Stage:          field(in.Stage),
+		DueDate:        dueDateFromBCF(in.DueDate),
+		Labels:         in.Labels,
+		ReferenceLinks: in.ReferenceLinks,
+	}
+	if replace {
+		if req.DueDate == nil {
+			req.DueDate = field("")
+		}
+		if req.Labels == nil {
+			req.Labels = []string{}
+		}
+		if req.ReferenceLinks == nil {
+			req.ReferenceLinks = []string{}
+		}
+		req.AssignedTo = field("")
+	}
+
+	if in.AssignedTo != "" {
+		profileID, err := h.Service.profileByEmail(r.Context(), r.PathValue("projectId"), in.AssignedTo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return req, false
+		}
+		if profileID == nil {
+			http.Error(w, "assigned_to is not a member of the project", http.StatusBadRequest)
+			return req, false
+		}
+		req.AssignedTo = profileID
+	}
+	return req, true
+}
+
+func topicToAPI(t Topic, authorization *apiAuthorization) apiTopic {
+	x := topicToXML(t)
+	out := apiTopic{
+		GUID:           x.GUID,
+		TopicType:      x.TopicType,
+		TopicStatus:    x.TopicStatus,
+		ReferenceLinks: x.ReferenceLink,
+		Title:          x.Title,
+		Priority:       x.Priority,
+		Labels:         x.Labels,
+		CreationDate:   x.CreationDate,
+		CreationAuthor: x.CreationAuthor,
+		AssignedTo:     x.AssignedTo,
+		Stage:          x.Stage,
+		Description:    x.Description,
+		DueDate:        x.DueDate,
+		Authorization:  authorization,
+	}
+	if t.UpdatedAt.After(t.CreatedAt) {
+		out.ModifiedDate = x.ModifiedDate
+		out.ModifiedAuthor = x.ModifiedAuthor
+	}
+	return out
+}
+
+// apiAuthorization returns the actions the caller may take when the client
+// asked for them with includeAuthorization=true, or nil.
+func (h *Handler) apiAuthorization(r *http.Request, actions *apiAuthorization) *apiAuthorization {
+	if r.URL.Query().Get("includeAuthorization") != "true" {
+		return nil
+	}
+	accountID := auth.AccountIDFromContext(r.Context())
+	if _, err := h.SessionStore.GetWritableProfileForProject(r.Context(), accountID, r.PathValue("projectId")); err != nil {
+		return &apiAuthorization{}
+	}
+	return actions
+}
+
+// --- Comments ---
+
+// APIListComments lists the comments of a topic, with OData query options.
+func (h *Handler) APIListComments(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	query, err := parseODataQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	authz := h.apiAuthorization(r, &apiAuthorization{CommentActions: apiCommentActions})
+	out := make([]apiComment, len(topic.Comments))
+	for i, c := range topic.Comments {
+		out[i] = commentToAPI(c, topic.GUID, authz)
+	}
+	writeJSON(w, http.StatusOK, applyOData(query, out))
+}
+
+// APICreateComment adds a comment to a topic. A GUID may be given by the
+// client.
+func (h *Handler) APICreateComment(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	var in apiComment
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Comment) == "" {
+		http.Error(w, "invalid request body: a comment is required", http.StatusBadRequest)
+		return
+	}
+	if in.GUID != "" && findComment(topic.Comments, in.GUID) != nil {
+		http.Error(w, "a comment with this guid exists", http.StatusConflict)
+		return
+	}
+	req, ok := commentRequest(w, in, topic)
+	if !ok {
+		return
+	}
+
+	created, err := h.Service.CreateComment(r.Context(), topic.ID, profileID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeComment(w, r, topic, created.GUID, http.StatusCreated)
+}
+
+// APIGetComment returns a comment.
+func (h *Handler) APIGetComment(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+	comment := findComment(topic.Comments, r.PathValue("commentGuid"))
+	if comment == nil {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, commentToAPI(*comment, topic.GUID, h.apiAuthorization(r, &apiAuthorization{CommentActions: apiCommentActions})))
+}
+
+// APIUpdateComment replaces the text and viewpoint of a comment.
+func (h *Handler) APIUpdateComment(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := h.requireWriter(w, r)
+	if !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+	comment := findComment(topic.Comments, r.PathValue("commentGuid"))
+	if comment == nil {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	}
+
+	var in apiComment
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Comment) == "" {
+		http.Error(w, "invalid request body: a comment is required", http.StatusBadRequest)
+		return
+	}
+	req, ok := commentRequest(w, in, topic)
+	if !ok {
+		return
+	}
+
+	updated, err := h.Service.UpdateComment(r.Context(), comment.ID, profileID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, commentToAPI(*updated, topic.GUID, h.apiAuthorization(r, &apiAuthorization{CommentActions: apiCommentActions})))
+}
+
+// APIDeleteComment removes a comment.
+func (h *Handler) APIDeleteComment(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+	comment := findComment(topic.Comments, r.PathValue("commentGuid"))
+	if comment == nil {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Service.DeleteComment(r.Context(), comment.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeComment reads a comment back with its author and viewpoint GUID.
+func (h *Handler) writeComment(w http.ResponseWriter, r *http.Request, topic *Topic, guid string, status int) {
+	comments, err := h.Service.ListComments(r.Context(), topic.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comment := findComment(comments, guid)
+	if comment == nil {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, status, commentToAPI(*comment, topic.GUID, h.apiAuthorization(r, &apiAuthorization{CommentActions: apiCommentActions})))
+}
+
+// commentRequest maps a posted comment, resolving its viewpoint GUID in the
+// topic.
+func commentRequest(w http.ResponseWriter, in apiComment, topic *Topic) (CreateCommentRequest, bool) {
+	req := CreateCommentRequest{GUID: optionalStr(in.GUID), Body: in.Comment}
+	if in.ViewpointGUID != "" {
+		vp := findViewpoint(topic.Viewpoints, in.ViewpointGUID)
+		if vp == nil {
+			http.Error(w, "viewpoint_guid is not a viewpoint of the topic", http.StatusBadRequest)
+			return req, false
+		}
+		req.ViewpointID = &vp.ID
+	}
+	return req, true
+}
+
+func findComment(comments []Comment, guid string) *Comment {
+	for i := range comments {
+		if strings.EqualFold(comments[i].GUID, guid) {
+			return &comments[i]
+		}
+	}
+	return nil
+}
+
+func commentToAPI(c Comment, topicGUID string, authorization *apiAuthorization) apiComment {
+	x := commentsToXML([]Comment{c})[0]
+	out := apiComment{
+		GUID:           x.GUID,
+		Date:           x.Date,
+		Author:         x.Author,
+		Comment:        x.Comment,
+		TopicGUID:      topicGUID,
+		ModifiedDate:   x.ModifiedDate,
+		ModifiedAuthor: x.ModifiedAuthor,
+		Authorization:  authorization,
+	}
+	if x.Viewpoint != nil {
+		out.ViewpointGUID = x.Viewpoint.GUID
+	}
+	return out
+}
+
+// --- Viewpoints ---
+
+// APIListViewpoints lists the viewpoints of a topic.
+func (h *Handler) APIListViewpoints(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	out := make([]apiViewpoint, len(topic.Viewpoints))
+	for i, vp := range topic.Viewpoints {
+		out[i] = viewpointToAPI(vp)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APICreateViewpoint adds a viewpoint, with its components and snapshot,
+// to a topic. A GUID may be given by the client.
+func (h *Handler) APICreateViewpoint(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return
+	}
+
+	var in apiViewpoint
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if in.GUID != "" && findViewpoint(topic.Viewpoints, in.GUID) != nil {
+		http.Error(w, "a viewpoint with this guid exists", http.StatusConflict)
+		return
+	}
+	req, err := viewpointRequest(in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vp, err := h.Service.CreateViewpoint(r.Context(), topic.ID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, viewpointToAPI(*vp))
+}
+
+// APIGetViewpoint returns the camera, lines and clipping planes of a
+// viewpoint.
+func (h *Handler) APIGetViewpoint(w http.ResponseWriter, r *http.Request) {
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, viewpointToAPI(*vp))
+}
+
+// APIDeleteViewpoint removes a viewpoint.
+func (h *Handler) APIDeleteViewpoint(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireWriter(w, r); !ok {
+		return
+	}
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteViewpoint(r.Context(), vp.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIGetSelection returns the selected components of a viewpoint.
+func (h *Handler) APIGetSelection(w http.ResponseWriter, r *http.Request) {
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	c := componentsToAPI(vp.Components)
+	selection := c.Selection
+	if selection == nil {
+		selection = []apiComponent{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"selection": selection})
+}
+
+// APIGetColoring returns the colored components of a viewpoint.
+func (h *Handler) APIGetColoring(w http.ResponseWriter, r *http.Request) {
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	c := componentsToAPI(vp.Components)
+	coloring := c.Coloring
+	if coloring == nil {
+		coloring = []apiColoring{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"coloring": coloring})
+}
+
+// APIGetVisibility returns the component visibility of a viewpoint; a
+// viewpoint without one shows everything.
+func (h *Handler) APIGetVisibility(w http.ResponseWriter, r *http.Request) {
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	c := componentsToAPI(vp.Components)
+	visibility := c.Visibility
+	if visibility == nil {
+		visibility = &apiVisibility{DefaultVisibility: true}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"visibility": visibility})
+}
+
+// APIGetSnapshot returns the snapshot image of a viewpoint.
+func (h *Handler) APIGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	vp, ok := h.viewpointByGUID(w, r)
+	if !ok {
+		return
+	}
+	data, contentType, err := h.Service.GetSnapshot(r.Context(), vp.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// viewpointByGUID checks membership and looks up the path viewpoint,
+// writing an error response if either fails.
+func (h *Handler) viewpointByGUID(w http.ResponseWriter, r *http.Request) (*Viewpoint, bool) {
+	if _, ok := h.requireMember(w, r); !ok {
+		return nil, false
+	}
+	topic, ok := h.topicByGUID(w, r)
+	if !ok {
+		return nil, false
+	}
+	vp := findViewpoint(topic.Viewpoints, r.PathValue("viewpointGuid"))
+	if vp == nil {
+		http.Error(w, "viewpoint not found", http.StatusNotFound)
+		return nil, false
+	}
+	return vp, true
+}
+
+func findViewpoint(viewpoints []Viewpoint, guid string) *Viewpoint {
+	for i := range viewpoints {
+		if strings.EqualFold(viewpoints[i].GUID, guid) {
+			return &viewpoints[i]
+		}
+	}
+	return nil
+}
+
+func viewpointToAPI(v Viewpoint) apiViewpoint {
+	out := apiViewpoint{GUID: v.GUID}
+	if v.CameraType == "orthogonal" {
+		out.OrthogonalCamera = &apiOrthogonalCamera{
+			CameraViewPoint: v.CameraPosition,
+			CameraDirection: v.CameraDirection,
+			CameraUpVector:  v.CameraUp,
+			AspectRatio:     v.AspectRatio,
+		}
+		if v.ViewWorldScale != nil {
+			out.OrthogonalCamera.ViewToWorldScale = *v.ViewWorldScale
+		}
+	} else {
+		out.PerspectiveCamera = &apiPerspectiveCamera{
+			CameraViewPoint: v.CameraPosition,
+			CameraDirection: v.CameraDirection,
+			CameraUpVector:  v.CameraUp,
+			FieldOfView:     60,
+			AspectRatio:     v.AspectRatio,
+		}
+		if v.FieldOfView != nil {
+			out.PerspectiveCamera.FieldOfView = *v.FieldOfView
+		}
+	}
+
+	if v.Lines != nil {
+		var lines []Line
+		if json.Unmarshal(*v.Lines, &lines) == nil {
+			for _, l := range lines {
+				out.Lines = append(out.Lines, apiLine{StartPoint: l.Start, EndPoint: l.End})
+			}
+		}
+	}
+	if v.ClippingPlanes != nil {
+		json.Unmarshal(*v.ClippingPlanes, &out.ClippingPlanes)
+	}
+	if v.SnapshotBase64 != nil {
+		out.Snapshot = &apiSnapshot{SnapshotType: "png"}
+		if dataURLImageType(*v.SnapshotBase64) == "jpeg" {
+			out.Snapshot.SnapshotType = "jpg"
+		}
+	}
+	return out
+}
+
+// viewpointRequest maps a posted viewpoint, which needs a camera.
+func viewpointRequest(in apiViewpoint) (CreateViewpointRequest, error) {
+	req := CreateViewpointRequest{GUID: optionalStr(in.GUID)}
+	switch {
+	case in.PerspectiveCamera != nil:
+		c := in.PerspectiveCamera
+		req.CameraType = "perspective"
+		req.CameraPosition, req.CameraDirection, req.CameraUp = c.CameraViewPoint, c.CameraDirection, c.CameraUpVector
+		req.FieldOfView = &c.FieldOfView
+		req.AspectRatio = c.AspectRatio
+	case in.OrthogonalCamera != nil:
+		c := in.OrthogonalCamera
+		req.CameraType = "orthogonal"
+		req.CameraPosition, req.CameraDirection, req.CameraUp = c.CameraViewPoint, c.CameraDirection, c.CameraUpVector
+		req.ViewWorldScale = &c.ViewToWorldScale
+		req.AspectRatio = c.AspectRatio
+	default:
+		return req, errors.New("a perspective_camera or orthogonal_camera is required")
+	}
+
+	if len(in.Lines) > 0 {
+		lines := make([]Line, len(in.Lines))
+		for i, l := range in.Lines {
+			lines[i] = Line{Start: l.StartPoint, End: l.EndPoint}
+		}
+		req.Lines = rawJSON(lines)
+	}
+	if len(in.ClippingPlanes) > 0 {
+		req.ClippingPlanes = rawJSON(in.ClippingPlanes)
+	}
+	if in.Components != nil {
+		req.Components = rawJSON(componentsFromAPI(in.Components))
+	}
+
+	if s := in.Snapshot; s != nil && s.SnapshotData != "" {
+		imageType := "png"
+		switch s.SnapshotType {
+		case "png":
+		case "jpg":
+			imageType = "jpeg"
+		default:
+			return req, errors.New("snapshot_type must be png or jpg")
+		}
+		dataURL := "data:image/" + imageType + ";base64," + s.SnapshotData
+		if decodeBase64DataURL(dataURL) == nil {
+			return req, errors.New("snapshot_data is not valid base64")
+		}
+		req.SnapshotBase64 = &dataURL
+	}
+	return req, nil
+}
+
+func componentsToAPI(raw *json.RawMessage) apiComponents {
+	var out apiComponents
+	if raw == nil {
+		return out
+	}
+	var c Components
+	if json.Unmarshal(*raw, &c) != nil {
+		return out
+	}
+	out.Selection = componentListToAPI(c.Selection)
+	for _, col := range c.Coloring {
+		out.Coloring = append(out.Coloring, apiColoring{Color: col.Color, Components: componentListToAPI(col.Components)})
+	}
+	if c.Visibility != nil {
+		out.Visibility = &apiVisibility{
+			DefaultVisibility: c.Visibility.DefaultVisibility,
+			Exceptions:        componentListToAPI(c.Visibility.Exceptions),
+		}
+	}
+	return out
+}
+
+func componentsFromAPI(in *apiComponents) Components {
+	var c Components
+	c.Selection = componentListFromAPI(in.Selection)
+	for _, col := range in.Coloring {
+		c.Coloring = append(c.Coloring, ComponentColoring{Color: col.Color, Components: componentListFromAPI(col.Components)})
+	}
+	if in.Visibility != nil {
+		c.Visibility = &ComponentVisibility{
+			DefaultVisibility: in.Visibility.DefaultVisibility,
+			Exceptions:        componentListFromAPI(in.Visibility.Exceptions),
+		}
+	}
+	return c
+}
+
+func componentListToAPI(list []Component) []apiComponent {
+	var out []apiComponent
+	for _, c := range list {
+		out = append(out, apiComponent{IfcGuid: c.IfcGuid, OriginatingSystem: c.OriginatingSystem, AuthoringToolID: c.AuthoringToolID})
+	}
+	return out
+}
+
+func componentListFromAPI(list []apiComponent) []Component {
+	var out []Component
+	for _, c := range list {
+		out = append(out, Component{IfcGuid: c.IfcGuid, OriginatingSystem: c.OriginatingSystem, AuthoringToolID: c.AuthoringToolID})
+	}
+	return out
+}
@@ -4,18 +4,44 @@ import (
 	"archive/zip"
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
+	"path"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
+)
+
+// BCF versions written by ExportBCFZip. ParseBCFZip also reads BCF 2.0,
+// which shares the 2.1 layout.
+const (
+	BCFVersion21 = "2.1"
+	BCFVersion30 = "3.0"
 )
 
+// Archive is the content of a BCF file.
+type Archive struct {
+	// Version is the BCF version; empty means BCFVersion21.
+	Version string
+	Topics  []Topic
+	// Documents are the project documents topics refer to by GUID.
+	Documents []Document
+}
+
+// defaultAspectRatio is written for viewpoints saved without one, which
+// BCF 3.0 requires: the shape of a typical viewer.
+const defaultAspectRatio = 16.0 / 9.0
+
 // BCF 2.1 XML structures for export/import
 
 type bcfVersion struct {
 	XMLName   xml.Name `xml:"Version"`
 	VersionID string   `xml:"VersionId,attr"`
-	XMLNS     string   `xml:"xmlns,attr"`
+	XMLNS     string   `xml:"xmlns,attr,omitempty"`
 }
 
 type bcfProject struct {
@@ -31,54 +57,88 @@ type bcfProjectInfo struct {
 }
 
 type bcfMarkup struct {
-	XMLName    xml.Name       `xml:"Markup"`
-	XMLNS      string         `xml:"xmlns,attr"`
-	Topic      bcfTopicXML    `xml:"Topic"`
-	Comment    []bcfCommentXML `xml:"Comment"`
+	XMLName    xml.Name          `xml:"Markup"`
+	XMLNS      string            `xml:"xmlns,attr"`
+	Header     *bcfHeader        `xml:"Header,omitempty"`
+	Topic      bcfTopicXML       `xml:"Topic"`
+	Comment    []bcfCommentXML   `xml:"Comment"`
 	Viewpoints []bcfViewpointRef `xml:"Viewpoints"`
 }
 
+type bcfHeader struct {
+	File []bcfFileXML `xml:"File"`
+}
+
+type bcfFileXML struct {
+	IfcProject string `xml:"IfcProject,attr,omitempty"`
+	IsExternal bool   `xml:"IsExternal,attr"`
+	Filename   string `xml:"Filename,omitempty"`
+	Date       string `xml:"Date,omitempty"`
+	Reference  string `xml:"Reference,omitempty"`
+}
+
 type bcfTopicXML struct {
-	XMLName        xml.Name `xml:"Topic"`
-	GUID           string   `xml:"Guid,attr"`
-	TopicType      string   `xml:"TopicType,attr,omitempty"`
-	TopicStatus    string   `xml:"TopicStatus,attr,omitempty"`
-	Title          string   `xml:"Title"`
-	Description    string   `xml:"Description,omitempty"`
-	Priority       string   `xml:"Priority,omitempty"`
-	CreationDate   string   `xml:"CreationDate"`
-	CreationAuthor string   `xml:"CreationAuthor,omitempty"`
-	ModifiedDate   string   `xml:"ModifiedDate,omitempty"`
-	DueDate        string   `xml:"DueDate,omitempty"`
-	AssignedTo     string   `xml:"AssignedTo,omitempty"`
-	Stage          string   `xml:"Stage,omitempty"`
-	Labels         []string `xml:"Labels,omitempty"`
+	XMLName           xml.Name            `xml:"Topic"`
+	GUID              string              `xml:"Guid,attr"`
+	TopicType         string              `xml:"TopicType,attr,omitempty"`
+	TopicStatus       string              `xml:"TopicStatus,attr,omitempty"`
+	ReferenceLink     []string            `xml:"ReferenceLink,omitempty"`
+	Title             string              `xml:"Title"`
+	Priority          string              `xml:"Priority,omitempty"`
+	Labels            []string            `xml:"Labels,omitempty"`
+	CreationDate      string              `xml:"CreationDate"`
+	CreationAuthor    string              `xml:"CreationAuthor"`
+	ModifiedDate      string              `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor    string              `xml:"ModifiedAuthor,omitempty"`
+	DueDate           string              `xml:"DueDate,omitempty"`
+	AssignedTo        string              `xml:"AssignedTo,omitempty"`
+	Stage             string              `xml:"Stage,omitempty"`
+	Description       string              `xml:"Description,omitempty"`
+	DocumentReference []bcfDocumentRefXML `xml:"DocumentReference,omitempty"`
+}
+
+// bcfDocumentRefXML is a document reference of either version: BCF 2.1
+// names a path or URL in ReferencedDocument, BCF 3.0 a document GUID or Url.
+type bcfDocumentRefXML struct {
+	GUID               string `xml:"Guid,attr,omitempty"`
+	IsExternal         bool   `xml:"isExternal,attr,omitempty"`
+	ReferencedDocument string `xml:"ReferencedDocument,omitempty"`
+	DocumentGUID       string `xml:"DocumentGuid,omitempty"`
+	URL                string `xml:"Url,omitempty"`
+	Description        string `xml:"Description,omitempty"`
 }
 
 type bcfCommentXML struct {
-	XMLName      xml.Name `xml:"Comment"`
-	GUID         string   `xml:"Guid,attr"`
-	Date         string   `xml:"Date"`
-	Author       string   `xml:"Author,omitempty"`
-	Comment      string   `xml:"Comment"`
-	ViewpointGUID string  `xml:"Viewpoint>Guid,omitempty"`
+	XMLName        xml.Name          `xml:"Comment"`
+	GUID           string            `xml:"Guid,attr"`
+	Date           string            `xml:"Date"`
+	Author         string            `xml:"Author"`
+	Comment        string            `xml:"Comment"`
+	Viewpoint      *bcfCommentTarget `xml:"Viewpoint,omitempty"`
+	ModifiedDate   string            `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor string            `xml:"ModifiedAuthor,omitempty"`
+}
+
+type bcfCommentTarget struct {
+	GUID string `xml:"Guid,attr"`
 }
 
 type bcfViewpointRef struct {
-	XMLName  xml.Name `xml:"Viewpoints"`
-	GUID     string   `xml:"Guid,attr"`
-	Viewpoint string  `xml:"Viewpoint"`
-	Snapshot  string  `xml:"Snapshot,omitempty"`
+	XMLName   xml.Name `xml:"Viewpoints"`
+	GUID      string   `xml:"Guid,attr"`
+	Viewpoint string   `xml:"Viewpoint"`
+	Snapshot  string   `xml:"Snapshot,omitempty"`
 }
 
 type bcfVisInfo struct {
-	XMLName         xml.Name             `xml:"VisualizationInfo"`
-	XMLNS           string               `xml:"xmlns,attr"`
-	GUID            string               `xml:"Guid,attr"`
-	PerspectiveCamera *bcfPerspective    `xml:"PerspectiveCamera,omitempty"`
+	XMLName           xml.Name           `xml:"VisualizationInfo"`
+	XMLNS             string             `xml:"xmlns,attr,omitempty"`
+	GUID              string             `xml:"Guid,attr"`
+	Components        *bcfComponentsXML  `xml:"Components,omitempty"`
 	OrthogonalCamera  *bcfOrthogonal     `xml:"OrthogonalCamera,omitempty"`
+	PerspectiveCamera *bcfPerspective    `xml:"PerspectiveCamera,omitempty"`
+	Lines             *bcfLines          `xml:"Lines,omitempty"`
 	ClippingPlanes    *bcfClippingPlanes `xml:"ClippingPlanes,omitempty"`
-	Components        *bcfComponentsXML  `xml:"Components,omitempty"`
 }
 
 type bcfPerspective struct {
@@ -86,6 +146,7 @@ type bcfPerspective struct {
 	CameraDirection bcfPoint `xml:"CameraDirection"`
 	CameraUpVector  bcfPoint `xml:"CameraUpVector"`
 	FieldOfView     float64  `xml:"FieldOfView"`
+	AspectRatio     *float64 `xml:"AspectRatio,omitempty"` // BCF 3.0
 }
 
 type bcfOrthogonal struct {
@@ -93,6 +154,7 @@ type bcfOrthogonal struct {
 	CameraDirection  bcfPoint `xml:"CameraDirection"`
 	CameraUpVector   bcfPoint `xml:"CameraUpVector"`
 	ViewToWorldScale float64  `xml:"ViewToWorldScale"`
+	AspectRatio      *float64 `xml:"AspectRatio,omitempty"` // BCF 3.0
 }
 
 type bcfPoint struct {
@@ -110,137 +172,364 @@ type bcfClippingPlaneXML struct {
 	Direction bcfPoint `xml:"Direction"`
 }
 
+type bcfLines struct {
+	Line []bcfLineXML `xml:"Line"`
+}
+
+type bcfLineXML struct {
+	StartPoint bcfPoint `xml:"StartPoint"`
+	EndPoint   bcfPoint `xml:"EndPoint"`
+}
+
 type bcfComponentsXML struct {
-	Selection  *bcfComponentSelection  `xml:"Selection,omitempty"`
+	Selection  *bcfComponentList       `xml:"Selection,omitempty"`
 	Visibility *bcfComponentVisibility `xml:"Visibility,omitempty"`
+	Coloring   *bcfComponentColoring   `xml:"Coloring,omitempty"`
 }
 
-type bcfComponentSelection struct {
+type bcfComponentList struct {
 	Component []bcfComponentXML `xml:"Component"`
 }
 
 type bcfComponentVisibility struct {
 	DefaultVisibility bool              `xml:"DefaultVisibility,attr"`
-	Exceptions        []bcfComponentXML `xml:"Exceptions>Component,omitempty"`
+	Exceptions        *bcfComponentList `xml:"Exceptions,omitempty"`
+}
+
+type bcfComponentColoring struct {
+	Color []bcfColorXML `xml:"Color"`
+}
+
+type bcfColorXML struct {
+	Color     string            `xml:"Color,attr"`
+	Component []bcfComponentXML `xml:"Component"`
 }
 
 type bcfComponentXML struct {
-	IfcGuid            string `xml:"IfcGuid,attr"`
-	OriginatingSystem  string `xml:"OriginatingSystem,attr,omitempty"`
-	AuthoringToolId    string `xml:"AuthoringToolId,attr,omitempty"`
+	IfcGuid           string `xml:"IfcGuid,attr,omitempty"`
+	OriginatingSystem string `xml:"OriginatingSystem,omitempty"`
+	AuthoringToolId   string `xml:"AuthoringToolId,omitempty"`
+}
+
+// bcfViewpointFile names the files of one viewpoint in its topic folder.
+type bcfViewpointFile struct {
+	GUID      string
+	Viewpoint string
+	Snapshot  string
 }
 
-// ExportBCFZip creates a BCF 2.1 compliant ZIP file from topics.
-func ExportBCFZip(topics []Topic) ([]byte, error) {
+// ExportBCFZip creates a BCF ZIP file in the archive's version.
+func ExportBCFZip(a *Archive) ([]byte, error) {
+	v3 := a.Version == BCFVersion30
+	if !v3 && a.Version != "" && a.Version != BCFVersion21 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, a.Version)
+	}
+
 	buf := new(bytes.Buffer)
 	w := zip.NewWriter(buf)
 
 	// Write bcf.version
-	versionData, _ := xml.MarshalIndent(bcfVersion{
-		VersionID: "2.1",
-		XMLNS:     "http://www.buildingsmart-tech.org/bcf/version/2.1",
-	}, "", "  ")
-	writeZipFile(w, "bcf.version", []byte(xml.Header+string(versionData)))
+	if v3 {
+		writeXMLFile(w, "bcf.version", bcfVersion{VersionID: BCFVersion30})
+		writeXMLFile(w, "extensions.xml", extensionsFor(a.Topics))
+	} else {
+		writeXMLFile(w, "bcf.version", bcfVersion{
+			VersionID: BCFVersion21,
+			XMLNS:     "http://www.buildingsmart-tech.org/bcf/version/2.1",
+		})
+	}
+
+	documents := make(map[string]Document, len(a.Documents))
+	for _, d := range a.Documents {
+		documents[d.GUID] = d
+		if v3 {
+			writeZipFile(w, documentPath3(d.GUID), d.Data)
+		} else {
+			writeZipFile(w, documentPath21(d), d.Data)
+		}
+	}
+	if v3 && len(a.Documents) > 0 {
+		writeXMLFile(w, "documents.xml", documentInfoFor(a.Documents))
+	}
 
-	for _, topic := range topics {
+	for _, topic := range a.Topics {
 		prefix := topic.GUID + "/"
+		vps := writeViewpoints(w, prefix, topic.Viewpoints, v3)
 
-		// Build markup
-		markup := bcfMarkup{
-			XMLNS: "http://www.buildingsmart-tech.org/bcf/markup/2.1",
-			Topic: bcfTopicXML{
-				GUID:         topic.GUID,
-				TopicType:    derefStr(topic.TopicType),
-				TopicStatus:  topic.TopicStatus,
-				Title:        topic.Title,
-				Description:  derefStr(topic.Description),
-				Priority:     derefStr(topic.Priority),
-				CreationDate: topic.CreatedAt.Format(time.RFC3339),
-				Labels:       topic.Labels,
-			},
-		}
-
-		// Comments
-		for _, c := range topic.Comments {
-			cGUID := c.ID // Use ID as GUID for now
-			markup.Comment = append(markup.Comment, bcfCommentXML{
-				GUID:    cGUID,
-				Date:    c.CreatedAt.Format(time.RFC3339),
-				Author:  derefStr(c.AuthorName),
-				Comment: c.Body,
-			})
+		// Write markup.bcf
+		if v3 {
+			writeXMLFile(w, prefix+"markup.bcf", markup3For(topic, vps))
+		} else {
+			writeXMLFile(w, prefix+"markup.bcf", markupFor(topic, vps, documents))
 		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close zip: %w", err)
+	}
+	return buf.Bytes(), nil
+}
 
-		// Viewpoints
-		for i, vp := range topic.Viewpoints {
-			var vpFileName, snapFileName string
-			if i == 0 {
-				vpFileName = "viewpoint.bcfv"
-				snapFileName = "snapshot.png"
-			} else {
-				vpFileName = vp.GUID + ".bcfv"
-				snapFileName = vp.GUID + ".png"
+// markupFor builds the BCF 2.1 markup of a topic. Documents are referred to
+// by their path in the file.
+func markupFor(topic Topic, vps []bcfViewpointFile, documents map[string]Document) bcfMarkup {
+	markup := bcfMarkup{
+		XMLNS:   "http://www.buildingsmart-tech.org/bcf/markup/2.1",
+		Topic:   topicToXML(topic),
+		Comment: commentsToXML(topic.Comments),
+	}
+	if len(topic.Files) > 0 {
+		markup.Header = &bcfHeader{File: filesToXML(topic.Files)}
+	}
+
+	for _, ref := range topic.DocumentReferences {
+		x := bcfDocumentRefXML{GUID: ref.GUID, Description: derefStr(ref.Description)}
+		switch {
+		case ref.URL != nil:
+			x.IsExternal = true
+			x.ReferencedDocument = *ref.URL
+		case ref.DocumentGUID != nil:
+			d, ok := documents[*ref.DocumentGUID]
+			if !ok {
+				continue
 			}
+			x.ReferencedDocument = "../" + documentPath21(d)
+		}
+		markup.Topic.DocumentReference = append(markup.Topic.DocumentReference, x)
+	}
 
-			markup.Viewpoints = append(markup.Viewpoints, bcfViewpointRef{
-				GUID:      vp.GUID,
-				Viewpoint: vpFileName,
-				Snapshot:  snapFileName,
-			})
+	for _, vp := range vps {
+		markup.Viewpoints = append(markup.Viewpoints, bcfViewpointRef{
+			GUID:      vp.GUID,
+			Viewpoint: vp.Viewpoint,
+			Snapshot:  vp.Snapshot,
+		})
+	}
+	return markup
+}
+
+// topicToXML maps the topic fields BCF 2.1 and 3.0 share.
+func topicToXML(topic Topic) bcfTopicXML {
+	return bcfTopicXML{
+		GUID:           topic.GUID,
+		TopicType:      derefStr(topic.TopicType),
+		TopicStatus:    topic.TopicStatus,
+		ReferenceLink:  topic.ReferenceLinks,
+		Title:          topic.Title,
+		Priority:       derefStr(topic.Priority),
+		Labels:         topic.Labels,
+		CreationDate:   formatBCFDate(topic.CreatedAt),
+		CreationAuthor: derefStr(topic.CreationAuthor),
+		ModifiedDate:   formatBCFDate(topic.UpdatedAt),
+		ModifiedAuthor: derefStr(topic.ModifiedAuthor),
+		DueDate:        dueDateToBCF(topic.DueDate),
+		AssignedTo:     derefStr(topic.AssignedToEmail),
+		Stage:          derefStr(topic.Stage),
+		Description:    derefStr(topic.Description),
+	}
+}
+
+func topicFromXML(t bcfTopicXML) Topic {
+	topic := Topic{
+		GUID:            t.GUID,
+		Title:           t.Title,
+		TopicStatus:     t.TopicStatus,
+		Description:     optionalStr(t.Description),
+		Priority:        optionalStr(t.Priority),
+		TopicType:       optionalStr(t.TopicType),
+		Stage:           optionalStr(t.Stage),
+		Labels:          t.Labels,
+		ReferenceLinks:  t.ReferenceLink,
+		CreationAuthor:  optionalStr(t.CreationAuthor),
+		ModifiedAuthor:  optionalStr(t.ModifiedAuthor),
+		AssignedToEmail: optionalStr(t.AssignedTo),
+		DueDate:         dueDateFromBCF(t.DueDate),
+		CreatedAt:       parseBCFDate(t.CreationDate),
+	}
+	topic.UpdatedAt = topic.CreatedAt
+	if modified := parseBCFDate(t.ModifiedDate); !modified.IsZero() {
+		topic.UpdatedAt = modified
+	}
+	return topic
+}
+
+func commentsToXML(comments []Comment) []bcfCommentXML {
+	var out []bcfCommentXML
+	for _, c := range comments {
+		comment := bcfCommentXML{
+			GUID:    c.GUID,
+			Date:    formatBCFDate(c.CreatedAt),
+			Author:  derefStr(c.Author),
+			Comment: c.Body,
+		}
+		if c.ViewpointGUID != nil {
+			comment.Viewpoint = &bcfCommentTarget{GUID: *c.ViewpointGUID}
+		}
+		if c.UpdatedAt.After(c.CreatedAt) {
+			comment.ModifiedDate = formatBCFDate(c.UpdatedAt)
+			comment.ModifiedAuthor = derefStr(c.ModifiedAuthor)
+		}
+		out = append(out, comment)
+	}
+	return out
+}
+
+func commentsFromXML(comments []bcfCommentXML) []Comment {
+	var out []Comment
+	for _, c := range comments {
+		comment := Comment{
+			GUID:           c.GUID,
+			Body:           c.Comment,
+			Author:         optionalStr(c.Author),
+			ModifiedAuthor: optionalStr(c.ModifiedAuthor),
+			CreatedAt:      parseBCFDate(c.Date),
+		}
+		comment.UpdatedAt = comment.CreatedAt
+		if modified := parseBCFDate(c.ModifiedDate); !modified.IsZero() {
+			comment.UpdatedAt = modified
+		}
+		if c.Viewpoint != nil && c.Viewpoint.GUID != "" {
+			comment.ViewpointGUID = &c.Viewpoint.GUID
+		}
+		out = append(out, comment)
+	}
+	return out
+}
+
+// filesToXML lists a topic's model files for the markup header. The
+// reference is the file version id, which import links back to.
+func filesToXML(files []TopicFile) []bcfFileXML {
+	var out []bcfFileXML
+	for _, f := range files {
+		x := bcfFileXML{
+			IfcProject: derefStr(f.IfcProject),
+			IsExternal: true,
+			Filename:   f.Filename,
+			Reference:  f.FileVersionID,
+		}
+		if f.Date != nil {
+			x.Date = formatBCFDate(*f.Date)
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+func filesFromXML(files []bcfFileXML) []TopicFile {
+	var out []TopicFile
+	for _, f := range files {
+		if f.Reference == "" {
+			continue
+		}
+		tf := TopicFile{FileVersionID: f.Reference, Filename: f.Filename, IfcProject: optionalStr(f.IfcProject)}
+		if date := parseBCFDate(f.Date); !date.IsZero() {
+			tf.Date = &date
+		}
+		out = append(out, tf)
+	}
+	return out
+}
+
+// writeViewpoints writes the .bcfv and snapshot files of a topic's
+// viewpoints and returns their names.
+func writeViewpoints(w *zip.Writer, prefix string, viewpoints []Viewpoint, v3 bool) []bcfViewpointFile {
+	var files []bcfViewpointFile
+	for i, vp := range viewpoints {
+		snapExt := ".png"
+		if vp.SnapshotBase64 != nil {
+			snapExt = snapshotExt(*vp.SnapshotBase64)
+		}
+		var vpFileName, snapFileName string
+		if i == 0 {
+			vpFileName = "viewpoint.bcfv"
+			snapFileName = "snapshot" + snapExt
+		} else {
+			vpFileName = vp.GUID + ".bcfv"
+			snapFileName = vp.GUID + snapExt
+		}
 
-			// Write viewpoint .bcfv file
-			visInfo := bcfVisInfo{
-				XMLNS: "http://www.buildingsmart-tech.org/bcf/viewpoint/2.1",
-				GUID:  vp.GUID,
+		// Write viewpoint .bcfv file
+		visInfo := bcfVisInfo{GUID: vp.GUID}
+		var aspect *float64
+		if v3 {
+			aspect = vp.AspectRatio
+			if aspect == nil {
+				r := defaultAspectRatio
+				aspect = &r
 			}
+		} else {
+			visInfo.XMLNS = "http://www.buildingsmart-tech.org/bcf/viewpoint/2.1"
+		}
 
-			if vp.CameraType == "perspective" {
-				fov := 60.0
-				if vp.FieldOfView != nil {
-					fov = *vp.FieldOfView
-				}
-				visInfo.PerspectiveCamera = &bcfPerspective{
-					CameraViewPoint: bcfPoint{vp.CameraPosition.X, vp.CameraPosition.Y, vp.CameraPosition.Z},
-					CameraDirection: bcfPoint{vp.CameraDirection.X, vp.CameraDirection.Y, vp.CameraDirection.Z},
-					CameraUpVector:  bcfPoint{vp.CameraUp.X, vp.CameraUp.Y, vp.CameraUp.Z},
-					FieldOfView:     fov,
-				}
-			} else {
-				scale := 1.0
-				if vp.ViewWorldScale != nil {
-					scale = *vp.ViewWorldScale
-				}
-				visInfo.OrthogonalCamera = &bcfOrthogonal{
-					CameraViewPoint:  bcfPoint{vp.CameraPosition.X, vp.CameraPosition.Y, vp.CameraPosition.Z},
-					CameraDirection:  bcfPoint{vp.CameraDirection.X, vp.CameraDirection.Y, vp.CameraDirection.Z},
-					CameraUpVector:   bcfPoint{vp.CameraUp.X, vp.CameraUp.Y, vp.CameraUp.Z},
-					ViewToWorldScale: scale,
-				}
+		if vp.CameraType == "perspective" {
+			fov := 60.0
+			if vp.FieldOfView != nil {
+				fov = *vp.FieldOfView
+			}
+			visInfo.PerspectiveCamera = &bcfPerspective{
+				CameraViewPoint: pointToXML(vp.CameraPosition),
+				CameraDirection: pointToXML(vp.CameraDirection),
+				CameraUpVector:  pointToXML(vp.CameraUp),
+				FieldOfView:     fov,
+				AspectRatio:     aspect,
+			}
+		} else {
+			scale := 1.0
+			if vp.ViewWorldScale != nil {
+				scale = *vp.ViewWorldScale
+			}
+			visInfo.OrthogonalCamera = &bcfOrthogonal{
+				CameraViewPoint:  pointToXML(vp.CameraPosition),
+				CameraDirection:  pointToXML(vp.CameraDirection),
+				CameraUpVector:   pointToXML(vp.CameraUp),
+				ViewToWorldScale: scale,
+				AspectRatio:      aspect,
 			}
+		}
 
-			vpData, _ := xml.MarshalIndent(visInfo, "", "  ")
-			writeZipFile(w, prefix+vpFileName, []byte(xml.Header+string(vpData)))
+		visInfo.Components = componentsToXML(vp.Components)
+		visInfo.ClippingPlanes = clippingPlanesToXML(vp.ClippingPlanes)
+		visInfo.Lines = linesToXML(vp.Lines)
+		writeXMLFile(w, prefix+vpFileName, visInfo)
 
-			// Write snapshot if available
-			if vp.SnapshotBase64 != nil {
-				snapData := decodeBase64DataURL(*vp.SnapshotBase64)
-				if len(snapData) > 0 {
-					writeZipFile(w, prefix+snapFileName, snapData)
-				}
-			}
+		// Write snapshot if available
+		var snapData []byte
+		if vp.SnapshotBase64 != nil {
+			snapData = decodeBase64DataURL(*vp.SnapshotBase64)
+		}
+		if len(snapData) > 0 {
+			writeZipFile(w, prefix+snapFileName, snapData)
+		} else {
+			snapFileName = ""
 		}
 
-		// Write markup.bcf
-		markupData, _ := xml.MarshalIndent(markup, "", "  ")
-		writeZipFile(w, prefix+"markup.bcf", []byte(xml.Header+string(markupData)))
+		files = append(files, bcfViewpointFile{GUID: vp.GUID, Viewpoint: vpFileName, Snapshot: snapFileName})
 	}
+	return files
+}
 
-	w.Close()
-	return buf.Bytes(), nil
+// snapshotExt returns the file extension for a snapshot data URL. BCF
+// snapshots are PNG or JPEG.
+func snapshotExt(dataURL string) string {
+	switch dataURLImageType(dataURL) {
+	case "jpeg", "jpg":
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// snapshotImageType returns the image subtype of a snapshot file name.
+func snapshotImageType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	}
+	return "png"
 }
 
-// ParseBCFZip parses a BCF 2.1 ZIP file and returns topics.
-func ParseBCFZip(data []byte) ([]Topic, error) {
+// ParseBCFZip parses a BCF 2.0, 2.1 or 3.0 ZIP file. The version is read
+// from bcf.version; files without one are taken to be BCF 2.1.
+func ParseBCFZip(data []byte) (*Archive, error) {
 	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
 		return nil, fmt.Errorf("open zip: %w", err)
@@ -248,124 +537,361 @@ func ParseBCFZip(data []byte) ([]Topic, error) {
 
 	// Index files by path
 	files := make(map[string]*zip.File)
+	var markups []string
 	for _, f := range r.File {
 		files[f.Name] = f
+		if strings.HasSuffix(f.Name, "/markup.bcf") {
+			markups = append(markups, f.Name)
+		}
+	}
+	sort.Strings(markups)
+
+	a := &Archive{Version: BCFVersion21}
+	if f, ok := files["bcf.version"]; ok {
+		var version bcfVersion
+		if err := readXMLFile(f, &version); err != nil {
+			return nil, fmt.Errorf("read bcf.version: %w", err)
+		}
+		a.Version = strings.TrimSpace(version.VersionID)
+	}
+	switch a.Version {
+	case "2.0", BCFVersion21, BCFVersion30:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, a.Version)
 	}
+	v3 := a.Version == BCFVersion30
 
-	var topics []Topic
+	if v3 {
+		a.Documents = readDocuments(files)
+	}
 
-	// Find all markup.bcf files
-	for path, f := range files {
-		if !strings.HasSuffix(path, "/markup.bcf") {
-			continue
+	for _, p := range markups {
+		topicDir := strings.TrimSuffix(p, "markup.bcf")
+
+		var topic Topic
+		var vps []bcfViewpointFile
+		if v3 {
+			var markup bcfMarkup3
+			if err := readXMLFile(files[p], &markup); err != nil {
+				continue
+			}
+			topic, vps = markup.topic()
+		} else {
+			var markup bcfMarkup
+			if err := readXMLFile(files[p], &markup); err != nil {
+				continue
+			}
+			var docs []Document
+			topic, vps, docs = markup.topic(topicDir, files)
+			a.Documents = append(a.Documents, docs...)
 		}
 
-		topicDir := strings.TrimSuffix(path, "markup.bcf")
+		topic.Viewpoints = readViewpoints(files, topicDir, vps)
+		a.Topics = append(a.Topics, topic)
+	}
+
+	return a, nil
+}
+
+// topic maps a BCF 2.1 markup. Documents the topic refers to by path are
+// returned too, under the GUID of their reference.
+func (m *bcfMarkup) topic(dir string, files map[string]*zip.File) (Topic, []bcfViewpointFile, []Document) {
+	topic := topicFromXML(m.Topic)
+	topic.Comments = commentsFromXML(m.Comment)
+	if m.Header != nil {
+		topic.Files = filesFromXML(m.Header.File)
+	}
 
-		rc, err := f.Open()
-		if err != nil {
+	var docs []Document
+	for _, x := range m.Topic.DocumentReference {
+		if x.ReferencedDocument == "" {
 			continue
 		}
-
-		var markup bcfMarkup
-		if err := xml.NewDecoder(rc).Decode(&markup); err != nil {
-			rc.Close()
+		ref := DocumentReference{GUID: x.GUID, Description: optionalStr(x.Description)}
+		if ref.GUID == "" {
+			ref.GUID = stableGUID(topic.GUID + "/" + x.ReferencedDocument)
+		}
+		p := path.Join(dir, x.ReferencedDocument)
+		f, ok := files[p]
+		if x.IsExternal || !ok {
+			ref.URL = &x.ReferencedDocument
+		} else if data, err := readZipFile(f); err == nil {
+			docs = append(docs, Document{GUID: ref.GUID, Filename: path.Base(p), Data: data})
+			ref.DocumentGUID = &ref.GUID
+		} else {
 			continue
 		}
-		rc.Close()
+		topic.DocumentReferences = append(topic.DocumentReferences, ref)
+	}
 
-		topic := Topic{
-			GUID:        markup.Topic.GUID,
-			Title:       markup.Topic.Title,
-			TopicStatus: markup.Topic.TopicStatus,
+	var vps []bcfViewpointFile
+	for _, ref := range m.Viewpoints {
+		vps = append(vps, bcfViewpointFile{GUID: ref.GUID, Viewpoint: ref.Viewpoint, Snapshot: ref.Snapshot})
+	}
+	return topic, vps, docs
+}
+
+// readViewpoints loads the viewpoint files of a topic.
+func readViewpoints(files map[string]*zip.File, topicDir string, refs []bcfViewpointFile) []Viewpoint {
+	var viewpoints []Viewpoint
+	for _, vpRef := range refs {
+		vpFile, ok := files[topicDir+vpRef.Viewpoint]
+		if !ok {
+			continue
 		}
 
-		if markup.Topic.Description != "" {
-			topic.Description = &markup.Topic.Description
+		var visInfo bcfVisInfo
+		if err := readXMLFile(vpFile, &visInfo); err != nil {
+			continue
 		}
-		if markup.Topic.Priority != "" {
-			topic.Priority = &markup.Topic.Priority
+
+		vp := Viewpoint{
+			GUID: visInfo.GUID,
 		}
-		if markup.Topic.TopicType != "" {
-			topic.TopicType = &markup.Topic.TopicType
+		if vp.GUID == "" {
+			vp.GUID = vpRef.GUID
 		}
 
-		// Parse viewpoints
-		for _, vpRef := range markup.Viewpoints {
-			vpPath := topicDir + vpRef.Viewpoint
-			vpFile, ok := files[vpPath]
-			if !ok {
-				continue
-			}
+		if visInfo.PerspectiveCamera != nil {
+			cam := visInfo.PerspectiveCamera
+			vp.CameraType = "perspective"
+			vp.CameraPosition = pointFromXML(cam.CameraViewPoint)
+			vp.CameraDirection = pointFromXML(cam.CameraDirection)
+			vp.CameraUp = pointFromXML(cam.CameraUpVector)
+			vp.FieldOfView = &cam.FieldOfView
+			vp.AspectRatio = cam.AspectRatio
+		} else if visInfo.OrthogonalCamera != nil {
+			cam := visInfo.OrthogonalCamera
+			vp.CameraType = "orthogonal"
+			vp.CameraPosition = pointFromXML(cam.CameraViewPoint)
+			vp.CameraDirection = pointFromXML(cam.CameraDirection)
+			vp.CameraUp = pointFromXML(cam.CameraUpVector)
+			vp.ViewWorldScale = &cam.ViewToWorldScale
+			vp.AspectRatio = cam.AspectRatio
+		}
 
-			vpRC, err := vpFile.Open()
-			if err != nil {
-				continue
-			}
+		vp.Components = componentsFromXML(visInfo.Components)
+		vp.ClippingPlanes = clippingPlanesFromXML(visInfo.ClippingPlanes)
+		vp.Lines = linesFromXML(visInfo.Lines)
 
-			var visInfo bcfVisInfo
-			if err := xml.NewDecoder(vpRC).Decode(&visInfo); err != nil {
-				vpRC.Close()
-				continue
+		// Load snapshot
+		if vpRef.Snapshot != "" {
+			if snapFile, ok := files[topicDir+vpRef.Snapshot]; ok {
+				if snapData, err := readZipFile(snapFile); err == nil {
+					encoded := "data:image/" + snapshotImageType(vpRef.Snapshot) + ";base64," + base64.StdEncoding.EncodeToString(snapData)
+					vp.SnapshotBase64 = &encoded
+				}
 			}
-			vpRC.Close()
+		}
 
-			vp := Viewpoint{
-				GUID: visInfo.GUID,
-			}
+		viewpoints = append(viewpoints, vp)
+	}
+	return viewpoints
+}
 
-			if visInfo.PerspectiveCamera != nil {
-				cam := visInfo.PerspectiveCamera
-				vp.CameraType = "perspective"
-				vp.CameraPosition = Vector3{cam.CameraViewPoint.X, cam.CameraViewPoint.Y, cam.CameraViewPoint.Z}
-				vp.CameraDirection = Vector3{cam.CameraDirection.X, cam.CameraDirection.Y, cam.CameraDirection.Z}
-				vp.CameraUp = Vector3{cam.CameraUpVector.X, cam.CameraUpVector.Y, cam.CameraUpVector.Z}
-				vp.FieldOfView = &cam.FieldOfView
-			} else if visInfo.OrthogonalCamera != nil {
-				cam := visInfo.OrthogonalCamera
-				vp.CameraType = "orthogonal"
-				vp.CameraPosition = Vector3{cam.CameraViewPoint.X, cam.CameraViewPoint.Y, cam.CameraViewPoint.Z}
-				vp.CameraDirection = Vector3{cam.CameraDirection.X, cam.CameraDirection.Y, cam.CameraDirection.Z}
-				vp.CameraUp = Vector3{cam.CameraUpVector.X, cam.CameraUpVector.Y, cam.CameraUpVector.Z}
-				vp.ViewWorldScale = &cam.ViewToWorldScale
-			}
+// --- Viewpoint contents ---
 
-			// Load snapshot
-			if vpRef.Snapshot != "" {
-				snapPath := topicDir + vpRef.Snapshot
-				if snapFile, ok := files[snapPath]; ok {
-					if snapRC, err := snapFile.Open(); err == nil {
-						snapBuf := new(bytes.Buffer)
-						snapBuf.ReadFrom(snapRC)
-						snapRC.Close()
-						encoded := "data:image/png;base64," + base64.StdEncoding.EncodeToString(snapBuf.Bytes())
-						vp.SnapshotBase64 = &encoded
-					}
-				}
-			}
+// componentsToXML maps stored Components JSON to the BCF element. BCF
+// requires a Visibility element, so components without one are shown by
+// default.
+func componentsToXML(raw *json.RawMessage) *bcfComponentsXML {
+	var c Components
+	if raw == nil || json.Unmarshal(*raw, &c) != nil {
+		return nil
+	}
+	if len(c.Selection) == 0 && c.Visibility == nil && len(c.Coloring) == 0 {
+		return nil
+	}
 
-			topic.Viewpoints = append(topic.Viewpoints, vp)
+	out := &bcfComponentsXML{Visibility: &bcfComponentVisibility{DefaultVisibility: true}}
+	if len(c.Selection) > 0 {
+		out.Selection = &bcfComponentList{Component: componentListToXML(c.Selection)}
+	}
+	if c.Visibility != nil {
+		out.Visibility.DefaultVisibility = c.Visibility.DefaultVisibility
+		if len(c.Visibility.Exceptions) > 0 {
+			out.Visibility.Exceptions = &bcfComponentList{Component: componentListToXML(c.Visibility.Exceptions)}
 		}
+	}
+	if len(c.Coloring) > 0 {
+		out.Coloring = &bcfComponentColoring{}
+		for _, col := range c.Coloring {
+			out.Coloring.Color = append(out.Coloring.Color, bcfColorXML{
+				Color:     col.Color,
+				Component: componentListToXML(col.Components),
+			})
+		}
+	}
+	return out
+}
 
-		// Parse comments
-		for _, c := range markup.Comment {
-			comment := Comment{
-				Body: c.Comment,
-			}
-			if c.Author != "" {
-				comment.AuthorName = &c.Author
+func componentsFromXML(x *bcfComponentsXML) *json.RawMessage {
+	if x == nil {
+		return nil
+	}
+	var c Components
+	if x.Selection != nil {
+		c.Selection = componentListFromXML(x.Selection.Component)
+	}
+	if x.Visibility != nil {
+		var exceptions []Component
+		if x.Visibility.Exceptions != nil {
+			exceptions = componentListFromXML(x.Visibility.Exceptions.Component)
+		}
+		// A visibility that shows everything carries no information
+		if !x.Visibility.DefaultVisibility || len(exceptions) > 0 {
+			c.Visibility = &ComponentVisibility{
+				DefaultVisibility: x.Visibility.DefaultVisibility,
+				Exceptions:        exceptions,
 			}
-			topic.Comments = append(topic.Comments, comment)
 		}
+	}
+	if x.Coloring != nil {
+		for _, col := range x.Coloring.Color {
+			c.Coloring = append(c.Coloring, ComponentColoring{
+				Color:      col.Color,
+				Components: componentListFromXML(col.Component),
+			})
+		}
+	}
+	if len(c.Selection) == 0 && c.Visibility == nil && len(c.Coloring) == 0 {
+		return nil
+	}
+	return rawJSON(c)
+}
+
+func componentListToXML(list []Component) []bcfComponentXML {
+	out := make([]bcfComponentXML, len(list))
+	for i, c := range list {
+		out[i] = bcfComponentXML{IfcGuid: c.IfcGuid, OriginatingSystem: c.OriginatingSystem, AuthoringToolId: c.AuthoringToolID}
+	}
+	return out
+}
+
+func componentListFromXML(list []bcfComponentXML) []Component {
+	out := make([]Component, len(list))
+	for i, c := range list {
+		out[i] = Component{IfcGuid: c.IfcGuid, OriginatingSystem: c.OriginatingSystem, AuthoringToolID: c.AuthoringToolId}
+	}
+	return out
+}
 
-		topics = append(topics, topic)
+func clippingPlanesToXML(raw *json.RawMessage) *bcfClippingPlanes {
+	var planes []ClippingPlane
+	if raw == nil || json.Unmarshal(*raw, &planes) != nil || len(planes) == 0 {
+		return nil
 	}
+	out := &bcfClippingPlanes{}
+	for _, p := range planes {
+		out.ClippingPlane = append(out.ClippingPlane, bcfClippingPlaneXML{
+			Location:  pointToXML(p.Location),
+			Direction: pointToXML(p.Direction),
+		})
+	}
+	return out
+}
 
-	return topics, nil
+func clippingPlanesFromXML(x *bcfClippingPlanes) *json.RawMessage {
+	if x == nil || len(x.ClippingPlane) == 0 {
+		return nil
+	}
+	planes := make([]ClippingPlane, len(x.ClippingPlane))
+	for i, p := range x.ClippingPlane {
+		planes[i] = ClippingPlane{Location: pointFromXML(p.Location), Direction: pointFromXML(p.Direction)}
+	}
+	return rawJSON(planes)
+}
+
+func linesToXML(raw *json.RawMessage) *bcfLines {
+	var lines []Line
+	if raw == nil || json.Unmarshal(*raw, &lines) != nil || len(lines) == 0 {
+		return nil
+	}
+	out := &bcfLines{}
+	for _, l := range lines {
+		out.Line = append(out.Line, bcfLineXML{StartPoint: pointToXML(l.Start), EndPoint: pointToXML(l.End)})
+	}
+	return out
+}
+
+func linesFromXML(x *bcfLines) *json.RawMessage {
+	if x == nil || len(x.Line) == 0 {
+		return nil
+	}
+	lines := make([]Line, len(x.Line))
+	for i, l := range x.Line {
+		lines[i] = Line{Start: pointFromXML(l.StartPoint), End: pointFromXML(l.EndPoint)}
+	}
+	return rawJSON(lines)
+}
+
+func pointToXML(v Vector3) bcfPoint   { return bcfPoint{v.X, v.Y, v.Z} }
+func pointFromXML(p bcfPoint) Vector3 { return Vector3{p.X, p.Y, p.Z} }
+
+func rawJSON(v interface{}) *json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	raw := json.RawMessage(data)
+	return &raw
 }
 
 // --- Helpers ---
 
+// bcfDateLayouts are the xs:dateTime forms found in BCF files; many tools
+// leave out the time zone, which is then taken as UTC.
+var bcfDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+// parseBCFDate parses a BCF date. It returns the zero time when the date is
+// missing or unreadable, which import treats as unknown.
+func parseBCFDate(s string) time.Time {
+	for _, layout := range bcfDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func formatBCFDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// dueDateToBCF turns a due_date column value into a BCF date.
+func dueDateToBCF(due *string) string {
+	if due == nil {
+		return ""
+	}
+	for _, layout := range bcfDateLayouts {
+		if t, err := time.Parse(layout, *due); err == nil {
+			return formatBCFDate(t)
+		}
+	}
+	return ""
+}
+
+// dueDateFromBCF turns a BCF due date into a value for the due_date column.
+func dueDateFromBCF(s string) *string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	for _, layout := range bcfDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			due := t.Format("2006-01-02")
+			return &due
+		}
+	}
+	return nil
+}
+
+func optionalStr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
 func writeZipFile(w *zip.Writer, name string, data []byte) {
 	f, err := w.Create(name)
 	if err != nil {
@@ -374,6 +900,35 @@ func writeZipFile(w *zip.Writer, name string, data []byte) {
 	f.Write(data)
 }
 
+func writeXMLFile(w *zip.Writer, name string, v interface{}) {
+	data, _ := xml.MarshalIndent(v, "", "  ")
+	writeZipFile(w, name, []byte(xml.Header+string(data)))
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func readXMLFile(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// stableGUID derives a GUID from a name, for BCF items that have none, so
+// that importing the same file again finds them.
+func stableGUID(name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
+
 func derefStr(s *string) string {
 	if s == nil {
 		return ""
@@ -0,0 +1,240 @@
+package collab
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func bcfTestTopic() Topic {
+	str := func(s string) *string { return &s }
+	num := func(f float64) *float64 { return &f }
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	modified := created.Add(2 * time.Hour)
+
+	return Topic{
+		GUID:            "5a5d8a1c-8b5f-4c2c-9a55-8e4b1f2f6a01",
+		Title:           "Beam clashes with duct",
+		Description:     str("Move the duct below the beam"),
+		Priority:        str("High"),
+		TopicType:       str("Clash"),
+		TopicStatus:     "Open",
+		Stage:           str("Design"),
+		AssignedToEmail: str("bo@valvx.se"),
+		DueDate:         str("2024-04-01"),
+		Labels:          []string{"Structural", "HVAC"},
+		ReferenceLinks:  []string{"https://valvx.se/issues/12"},
+		CreationAuthor:  str("anna@valvx.se"),
+		ModifiedAuthor:  str("bo@valvx.se"),
+		CreatedAt:       created,
+		UpdatedAt:       modified,
+		Files:           []TopicFile{{FileVersionID: "fv-1", Filename: "A-40-V-001.ifc", Date: &created, IfcProject: str("0YvctVUKr0kugbFTf53O9L")}},
+		Comments: []Comment{
+			{GUID: "c1", Body: "Seen on site", Author: str("anna@valvx.se"), ViewpointGUID: str("vp-1"), CreatedAt: created, UpdatedAt: created},
+			{GUID: "c2", Body: "Agreed, edited", Author: str("bo@valvx.se"), ModifiedAuthor: str("bo@valvx.se"), CreatedAt: created, UpdatedAt: modified},
+		},
+		Viewpoints: []Viewpoint{
+			{
+				GUID:            "vp-1",
+				CameraType:      "perspective",
+				CameraPosition:  Vector3{10, 20, 30},
+				CameraDirection: Vector3{-1, 0, 0},
+				CameraUp:        Vector3{0, 0, 1},
+				FieldOfView:     num(45),
+				AspectRatio:     num(1.5),
+				SnapshotBase64:  str("data:image/png;base64," + encodeBase64([]byte("\x89PNG fake"))),
+				Components: rawJSON(Components{
+					Selection:  []Component{{IfcGuid: "2O2Fr$t4X7Zf8NOew3FLOH", OriginatingSystem: "Revit"}},
+					Visibility: &ComponentVisibility{DefaultVisibility: false, Exceptions: []Component{{IfcGuid: "1kTvXnbbzCWw8lcMd1dR4o"}}},
+					Coloring:   []ComponentColoring{{Color: "FF0000", Components: []Component{{IfcGuid: "2O2Fr$t4X7Zf8NOew3FLOH"}}}},
+				}),
+				ClippingPlanes: rawJSON([]ClippingPlane{{Location: Vector3{0, 0, 3}, Direction: Vector3{0, 0, 1}}}),
+				Lines:          rawJSON([]Line{{Start: Vector3{0, 0, 0}, End: Vector3{5, 0, 0}}}),
+			},
+			{
+				GUID:            "vp-2",
+				CameraType:      "orthogonal",
+				CameraPosition:  Vector3{0, 0, 100},
+				CameraDirection: Vector3{0, 0, -1},
+				CameraUp:        Vector3{0, 1, 0},
+				ViewWorldScale:  num(25),
+				SnapshotBase64:  str("data:image/jpeg;base64," + encodeBase64([]byte("\xff\xd8 fake"))),
+			},
+		},
+	}
+}
+
+func TestBCFZipRoundTrip(t *testing.T) {
+	for _, version := range []string{BCFVersion21, BCFVersion30} {
+		t.Run(version, func(t *testing.T) {
+			want := bcfTestTopic()
+			data, err := ExportBCFZip(&Archive{Version: version, Topics: []Topic{want}})
+			if err != nil {
+				t.Fatalf("ExportBCFZip: %v", err)
+			}
+			a, err := ParseBCFZip(data)
+			if err != nil {
+				t.Fatalf("ParseBCFZip: %v", err)
+			}
+			if a.Version != version {
+				t.Errorf("Version = %q, want %q", a.Version, version)
+			}
+			if len(a.Topics) != 1 {
+				t.Fatalf("got %d topics, want 1", len(a.Topics))
+			}
+			got := a.Topics[0]
+
+			gotViewpoints, wantViewpoints := got.Viewpoints, want.Viewpoints
+			got.Viewpoints, want.Viewpoints = nil, nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("topic =\n%+v\nwant\n%+v", got, want)
+			}
+
+			if len(gotViewpoints) != len(wantViewpoints) {
+				t.Fatalf("got %d viewpoints, want %d", len(gotViewpoints), len(wantViewpoints))
+			}
+			for i, w := range wantViewpoints {
+				g := gotViewpoints[i]
+				switch {
+				case version == BCFVersion21:
+					// BCF 2.1 cameras have no aspect ratio
+					w.AspectRatio = nil
+				case w.AspectRatio == nil:
+					// BCF 3.0 requires one
+					r := defaultAspectRatio
+					w.AspectRatio = &r
+				}
+				for _, f := range []struct {
+					name      string
+					got, want *json.RawMessage
+				}{
+					{"components", g.Components, w.Components},
+					{"clipping planes", g.ClippingPlanes, w.ClippingPlanes},
+					{"lines", g.Lines, w.Lines},
+				} {
+					if !jsonEqual(t, f.got, f.want) {
+						t.Errorf("viewpoint %s %s = %s, want %s", w.GUID, f.name, rawText(f.got), rawText(f.want))
+					}
+				}
+				g.Components, g.ClippingPlanes, g.Lines = nil, nil, nil
+				w.Components, w.ClippingPlanes, w.Lines = nil, nil, nil
+				if !reflect.DeepEqual(g, w) {
+					t.Errorf("viewpoint =\n%+v\nwant\n%+v", g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestBCFZipSnapshotNames(t *testing.T) {
+	data, err := ExportBCFZip(&Archive{Topics: []Topic{bcfTestTopic()}})
+	if err != nil {
+		t.Fatalf("ExportBCFZip: %v", err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, f := range r.File {
+		names[f.Name] = true
+	}
+	prefix := bcfTestTopic().GUID + "/"
+	for _, name := range []string{"bcf.version", prefix + "markup.bcf", prefix + "viewpoint.bcfv", prefix + "snapshot.png", prefix + "vp-2.bcfv", prefix + "vp-2.jpg"} {
+		if !names[name] {
+			t.Errorf("%s missing from %v", name, names)
+		}
+	}
+}
+
+func TestParseBCFZipMissingDates(t *testing.T) {
+	data := bcfTestZip(t, map[string]string{
+		"bcf.version": `<Version VersionId="2.1"><DetailedVersion>2.1</DetailedVersion></Version>`,
+		"t1/markup.bcf": `<Markup>
+  <Header><File IsExternal="true"><Filename>a.ifc</Filename><Date>not a date</Date><Reference>fv-1</Reference></File></Header>
+  <Topic Guid="t1" TopicStatus="Open"><Title>Undated</Title></Topic>
+  <Comment Guid="c1"><Comment>No date either</Comment></Comment>
+</Markup>`,
+		"t2/markup.bcf": `<Markup>
+  <Topic Guid="t2" TopicStatus="Open"><Title>Local time</Title><CreationDate>2024-03-01T10:00:00</CreationDate></Topic>
+</Markup>`,
+	})
+	a, err := ParseBCFZip(data)
+	if err != nil {
+		t.Fatalf("ParseBCFZip: %v", err)
+	}
+	if len(a.Topics) != 2 {
+		t.Fatalf("got %d topics, want 2", len(a.Topics))
+	}
+
+	undated := a.Topics[0]
+	if !undated.CreatedAt.IsZero() || !undated.UpdatedAt.IsZero() {
+		t.Errorf("topic dates = %v, %v; want zero", undated.CreatedAt, undated.UpdatedAt)
+	}
+	if len(undated.Comments) != 1 || !undated.Comments[0].CreatedAt.IsZero() || !undated.Comments[0].UpdatedAt.IsZero() {
+		t.Errorf("comments = %+v, want one with zero dates", undated.Comments)
+	}
+	if len(undated.Files) != 1 || undated.Files[0].Date != nil {
+		t.Errorf("files = %+v, want one without a date", undated.Files)
+	}
+
+	// Dates without a zone are UTC, and an unmodified topic was last
+	// updated when it was created
+	want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	if local := a.Topics[1]; !local.CreatedAt.Equal(want) || !local.UpdatedAt.Equal(want) {
+		t.Errorf("topic dates = %v, %v; want %v", local.CreatedAt, local.UpdatedAt, want)
+	}
+}
+
+func TestBCFZipUnsupportedVersion(t *testing.T) {
+	if _, err := ExportBCFZip(&Archive{Version: "1.0"}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("ExportBCFZip error = %v, want ErrUnsupportedVersion", err)
+	}
+	data := bcfTestZip(t, map[string]string{"bcf.version": `<Version VersionId="4.0"/>`})
+	if _, err := ParseBCFZip(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("ParseBCFZip error = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func bcfTestZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func jsonEqual(t *testing.T, a, b *json.RawMessage) bool {
+	t.Helper()
+	if a == nil || b == nil {
+		return a == b
+	}
+	var x, y interface{}
+	if err := json.Unmarshal(*a, &x); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(*b, &y); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func rawText(raw *json.RawMessage) string {
+	if raw == nil {
+		return "null"
+	}
+	return string(*raw)
+}
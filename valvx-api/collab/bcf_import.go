@@ -0,0 +1,355 @@
+package collab
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ImportBCF imports the topics of a BCF 2.0, 2.1 or 3.0 ZIP file into a
+// project and returns how many were imported. Topics, viewpoints, comments
+// and documents are matched on their GUIDs, so importing the same file
+// again updates rather than duplicates them; rows changed locally after the
+// file was written are left alone. Authors and assignees are mapped to
+// project members by email and otherwise kept as text, with the importer
+// standing in as the author.
+func (s *Service) ImportBCF(ctx context.Context, projectID, importerID string, file io.Reader) (int, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, fmt.Errorf("read file: %w", err)
+	}
+
+	archive, err := ParseBCFZip(data)
+	if err != nil {
+		return 0, fmt.Errorf("parse BCF: %w", err)
+	}
+
+	im := &bcfImport{s: s, projectID: projectID, importerID: importerID, profiles: map[string]*string{}}
+	if err := im.documents(ctx, archive.Documents); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, t := range archive.Topics {
+		if err := im.topic(ctx, t); err != nil {
+			return count, fmt.Errorf("import topic %s: %w", t.GUID, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// bcfImport holds the state of one import.
+type bcfImport struct {
+	s          *Service
+	projectID  string
+	importerID string
+	profiles   map[string]*string // email -> profile id, nil when not a member
+}
+
+// topic upserts one topic with its viewpoints, comments and references.
+func (im *bcfImport) topic(ctx context.Context, t Topic) error {
+	if t.GUID == "" {
+		t.GUID = uuid.New().String()
+	}
+	if t.TopicStatus == "" {
+		t.TopicStatus = "Open"
+	}
+
+	creatorID, creationAuthor, err := im.author(ctx, t.CreationAuthor)
+	if err != nil {
+		return err
+	}
+	assignedTo, assignedToEmail, err := im.person(ctx, t.AssignedToEmail)
+	if err != nil {
+		return err
+	}
+	modifiedBy, modifiedAuthor, err := im.person(ctx, t.ModifiedAuthor)
+	if err != nil {
+		return err
+	}
+
+	tx, err := im.s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var topicID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO collab_topic (id, guid, title, description, priority, topic_type,
+		    topic_status, stage, assigned_to, assigned_to_email, due_date, labels,
+		    reference_links, project_id, creator_id, creation_author, modified_by,
+		    modified_author, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+		    COALESCE($19::timestamp, $21), COALESCE($20::timestamp, $19, $21))
+		ON CONFLICT (project_id, guid) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			priority = EXCLUDED.priority,
+			topic_type = EXCLUDED.topic_type,
+			topic_status = EXCLUDED.topic_status,
+			stage = EXCLUDED.stage,
+			assigned_to = EXCLUDED.assigned_to,
+			assigned_to_email = EXCLUDED.assigned_to_email,
+			due_date = EXCLUDED.due_date,
+			labels = EXCLUDED.labels,
+			reference_links = EXCLUDED.reference_links,
+			creator_id = EXCLUDED.creator_id,
+			creation_author = EXCLUDED.creation_author,
+			modified_by = EXCLUDED.modified_by,
+			modified_author = EXCLUDED.modified_author,
+			created_at = COALESCE($19::timestamp, collab_topic.created_at),
+			updated_at = EXCLUDED.updated_at
+		WHERE $20::timestamp IS NOT NULL AND collab_topic.updated_at <= EXCLUDED.updated_at
+		RETURNING id`,
+		uuid.New().String(), t.GUID, t.Title, t.Description, t.Priority, t.TopicType,
+		t.TopicStatus, t.Stage, assignedTo, assignedToEmail, t.DueDate, pq.Array(t.Labels),
+		pq.Array(t.ReferenceLinks), im.projectID, creatorID, creationAuthor, modifiedBy,
+		modifiedAuthor, knownTime(t.CreatedAt), knownTime(t.UpdatedAt), now,
+	).Scan(&topicID)
+	if err == sql.ErrNoRows {
+		// Changed here since the file was written, or the file does not say
+		// when it changed; keep our version
+		err = tx.QueryRowContext(ctx,
+			"SELECT id FROM collab_topic WHERE project_id = $1 AND guid = $2",
+			im.projectID, t.GUID,
+		).Scan(&topicID)
+	}
+	if err != nil {
+		return fmt.Errorf("upsert topic: %w", err)
+	}
+
+	viewpoints, err := im.viewpoints(ctx, tx, topicID, t)
+	if err != nil {
+		return err
+	}
+	if err := im.comments(ctx, tx, topicID, t.Comments, viewpoints); err != nil {
+		return err
+	}
+	if err := im.references(ctx, tx, topicID, t); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// viewpoints inserts the viewpoints of t that are not there yet and returns
+// the ids of all viewpoints of the topic by GUID. BCF viewpoints do not
+// change once written, so existing ones are kept as they are.
+func (im *bcfImport) viewpoints(ctx context.Context, tx *sql.Tx, topicID string, t Topic) (map[string]string, error) {
+	for i, vp := range t.Viewpoints {
+		if vp.GUID == "" {
+			vp.GUID = uuid.New().String()
+		}
+		var snapshotData []byte
+		snapshotType := "png"
+		if vp.SnapshotBase64 != nil {
+			snapshotData = decodeBase64DataURL(*vp.SnapshotBase64)
+			snapshotType = dataURLImageType(*vp.SnapshotBase64)
+		}
+		// Viewpoints are listed by creation time; keep the file's order
+		base := t.CreatedAt
+		if base.IsZero() {
+			base = time.Now().UTC()
+		}
+		createdAt := base.Add(time.Duration(i) * time.Microsecond)
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO collab_viewpoint (id, guid, topic_id, camera_type,
+			    camera_position_x, camera_position_y, camera_position_z,
+			    camera_direction_x, camera_direction_y, camera_direction_z,
+			    camera_up_x, camera_up_y, camera_up_z,
+			    camera_fov, camera_view_world_scale, camera_aspect_ratio,
+			    snapshot_data, snapshot_type, components, clipping_planes, lines, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+			ON CONFLICT (topic_id, guid) DO NOTHING`,
+			uuid.New().String(), vp.GUID, topicID, vp.CameraType,
+			vp.CameraPosition.X, vp.CameraPosition.Y, vp.CameraPosition.Z,
+			vp.CameraDirection.X, vp.CameraDirection.Y, vp.CameraDirection.Z,
+			vp.CameraUp.X, vp.CameraUp.Y, vp.CameraUp.Z,
+			vp.FieldOfView, vp.ViewWorldScale, vp.AspectRatio,
+			snapshotData, snapshotType, jsonOrNull(vp.Components), jsonOrNull(vp.ClippingPlanes), jsonOrNull(vp.Lines), createdAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert viewpoint: %w", err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT guid, id FROM collab_viewpoint WHERE topic_id = $1", topicID)
+	if err != nil {
+		return nil, fmt.Errorf("query viewpoints: %w", err)
+	}
+	defer rows.Close()
+
+	ids := map[string]string{}
+	for rows.Next() {
+		var guid, id string
+		if err := rows.Scan(&guid, &id); err != nil {
+			return nil, fmt.Errorf("scan viewpoint: %w", err)
+		}
+		ids[guid] = id
+	}
+	return ids, rows.Err()
+}
+
+// comments upserts the comments of a topic, linking them to their
+// viewpoints.
+func (im *bcfImport) comments(ctx context.Context, tx *sql.Tx, topicID string, comments []Comment, viewpoints map[string]string) error {
+	for _, c := range comments {
+		if c.GUID == "" {
+			c.GUID = uuid.New().String()
+		}
+		authorID, author, err := im.author(ctx, c.Author)
+		if err != nil {
+			return err
+		}
+		modifiedBy, modifiedAuthor, err := im.person(ctx, c.ModifiedAuthor)
+		if err != nil {
+			return err
+		}
+		var viewpointID *string
+		if c.ViewpointGUID != nil {
+			if id, ok := viewpoints[*c.ViewpointGUID]; ok {
+				viewpointID = &id
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO collab_comment (id, guid, body, viewpoint_id, topic_id, author_id, author,
+			    modified_by, modified_author, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::timestamp, $12), COALESCE($11::timestamp, $10, $12))
+			ON CONFLICT (topic_id, guid) DO UPDATE SET
+				body = EXCLUDED.body,
+				viewpoint_id = EXCLUDED.viewpoint_id,
+				author_id = EXCLUDED.author_id,
+				author = EXCLUDED.author,
+				modified_by = EXCLUDED.modified_by,
+				modified_author = EXCLUDED.modified_author,
+				created_at = COALESCE($10::timestamp, collab_comment.created_at),
+				updated_at = EXCLUDED.updated_at
+			WHERE $11::timestamp IS NOT NULL AND collab_comment.updated_at <= EXCLUDED.updated_at`,
+			uuid.New().String(), c.GUID, c.Body, viewpointID, topicID, authorID, author,
+			modifiedBy, modifiedAuthor, knownTime(c.CreatedAt), knownTime(c.UpdatedAt), time.Now().UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("upsert comment: %w", err)
+		}
+	}
+	return nil
+}
+
+// references upserts the document references of a topic and links the
+// model files of its header that are versions in this project.
+func (im *bcfImport) references(ctx context.Context, tx *sql.Tx, topicID string, t Topic) error {
+	for _, ref := range t.DocumentReferences {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO collab_document_reference (id, guid, topic_id, document_guid, url, description)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (topic_id, guid) DO UPDATE SET
+				document_guid = EXCLUDED.document_guid,
+				url = EXCLUDED.url,
+				description = EXCLUDED.description`,
+			uuid.New().String(), ref.GUID, topicID, ref.DocumentGUID, ref.URL, ref.Description,
+		)
+		if err != nil {
+			return fmt.Errorf("upsert document reference: %w", err)
+		}
+	}
+
+	for _, f := range t.Files {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO collab_topic_file (topic_id, file_version_id)
+			SELECT $1, fv.id
+			FROM arca_file_version fv
+			JOIN arca_folder_file ff ON ff.file_id = fv.file_id
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			WHERE fv.id::text = $2 AND fo.project_id = $3
+			LIMIT 1
+			ON CONFLICT DO NOTHING`,
+			topicID, f.FileVersionID, im.projectID,
+		)
+		if err != nil {
+			return fmt.Errorf("link file: %w", err)
+		}
+	}
+	return nil
+}
+
+// documents upserts the documents shipped in the file.
+func (im *bcfImport) documents(ctx context.Context, docs []Document) error {
+	for _, d := range docs {
+		_, err := im.s.DB.ExecContext(ctx, `
+			INSERT INTO collab_document (id, guid, project_id, filename, description, data)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (project_id, guid) DO UPDATE SET
+				filename = EXCLUDED.filename,
+				description = EXCLUDED.description,
+				data = EXCLUDED.data`,
+			uuid.New().String(), d.GUID, im.projectID, d.Filename, d.Description, d.Data,
+		)
+		if err != nil {
+			return fmt.Errorf("upsert document %s: %w", d.GUID, err)
+		}
+	}
+	return nil
+}
+
+// author resolves a BCF author to the profile to record and, when the
+// author is not a project member, the text to keep alongside the importer.
+func (im *bcfImport) author(ctx context.Context, email *string) (string, *string, error) {
+	id, text, err := im.person(ctx, email)
+	if err != nil {
+		return "", nil, err
+	}
+	if id == nil {
+		return im.importerID, text, nil
+	}
+	return *id, nil, nil
+}
+
+// person resolves an email from a BCF file to a project profile, or returns
+// it as text when it belongs to no member.
+func (im *bcfImport) person(ctx context.Context, email *string) (*string, *string, error) {
+	if email == nil {
+		return nil, nil, nil
+	}
+	id, err := im.profile(ctx, *email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == nil {
+		return nil, email, nil
+	}
+	return id, nil, nil
+}
+
+// profile looks up the project profile of the account owning an email
+// address, or returns nil when it is not a member of the project.
+func (im *bcfImport) profile(ctx context.Context, email string) (*string, error) {
+	key := strings.ToLower(strings.TrimSpace(email))
+	if id, ok := im.profiles[key]; ok {
+		return id, nil
+	}
+	id, err := im.s.profileByEmail(ctx, im.projectID, key)
+	if err != nil {
+		return nil, err
+	}
+	im.profiles[key] = id
+	return id, nil
+}
+
+// knownTime returns t for a timestamp parameter, or nil when the file gave
+// no date, so that the existing row's date is kept.
+func knownTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,349 @@
+package collab
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"sort"
+)
+
+// BCF 3.0 XML structures. Viewpoint files and comments keep the 2.1 shape;
+// the markup nests comments and viewpoints in the topic and wraps repeated
+// elements, and the file gains extensions.xml and documents.xml.
+
+type bcfMarkup3 struct {
+	XMLName xml.Name    `xml:"Markup"`
+	Header  *bcfHeader3 `xml:"Header,omitempty"`
+	Topic   bcfTopic3   `xml:"Topic"`
+}
+
+type bcfHeader3 struct {
+	Files []bcfFileXML `xml:"Files>File"`
+}
+
+type bcfTopic3 struct {
+	GUID               string                 `xml:"Guid,attr"`
+	TopicType          string                 `xml:"TopicType,attr"`
+	TopicStatus        string                 `xml:"TopicStatus,attr"`
+	ReferenceLinks     *bcfReferenceLinks     `xml:"ReferenceLinks,omitempty"`
+	Title              string                 `xml:"Title"`
+	Priority           string                 `xml:"Priority,omitempty"`
+	Labels             *bcfLabels             `xml:"Labels,omitempty"`
+	CreationDate       string                 `xml:"CreationDate"`
+	CreationAuthor     string                 `xml:"CreationAuthor"`
+	ModifiedDate       string                 `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor     string                 `xml:"ModifiedAuthor,omitempty"`
+	DueDate            string                 `xml:"DueDate,omitempty"`
+	AssignedTo         string                 `xml:"AssignedTo,omitempty"`
+	Stage              string                 `xml:"Stage,omitempty"`
+	Description        string                 `xml:"Description,omitempty"`
+	DocumentReferences *bcfDocumentReferences `xml:"DocumentReferences,omitempty"`
+	Comments           *bcfComments           `xml:"Comments,omitempty"`
+	Viewpoints         *bcfViewpoints3        `xml:"Viewpoints,omitempty"`
+}
+
+// Lists that may be empty are pointers: encoding/xml writes the parent of
+// an "a>b" path even for an empty slice, and BCF 3.0 wrappers may not be
+// empty.
+
+type bcfReferenceLinks struct {
+	ReferenceLink []string `xml:"ReferenceLink"`
+}
+
+type bcfLabels struct {
+	Label []string `xml:"Label"`
+}
+
+type bcfDocumentReferences struct {
+	DocumentReference []bcfDocumentRefXML `xml:"DocumentReference"`
+}
+
+type bcfComments struct {
+	Comment []bcfCommentXML `xml:"Comment"`
+}
+
+type bcfViewpoints3 struct {
+	ViewPoint []bcfViewpointRef3 `xml:"ViewPoint"`
+}
+
+type bcfViewpointRef3 struct {
+	GUID      string `xml:"Guid,attr"`
+	Viewpoint string `xml:"Viewpoint,omitempty"`
+	Snapshot  string `xml:"Snapshot,omitempty"`
+}
+
+// bcfExtensions always lists the default types, statuses and priorities;
+// the other lists are left out when empty.
+type bcfExtensions struct {
+	XMLName       xml.Name        `xml:"Extensions"`
+	TopicTypes    []string        `xml:"TopicTypes>TopicType"`
+	TopicStatuses []string        `xml:"TopicStatuses>TopicStatus"`
+	Priorities    []string        `xml:"Priorities>Priority"`
+	TopicLabels   *bcfTopicLabels `xml:"TopicLabels,omitempty"`
+	Users         *bcfUsers       `xml:"Users,omitempty"`
+	Stages        *bcfStages      `xml:"Stages,omitempty"`
+}
+
+type bcfTopicLabels struct {
+	TopicLabel []string `xml:"TopicLabel"`
+}
+
+type bcfUsers struct {
+	User []string `xml:"User"`
+}
+
+type bcfStages struct {
+	Stage []string `xml:"Stage"`
+}
+
+type bcfDocumentInfo struct {
+	XMLName   xml.Name         `xml:"DocumentInfo"`
+	Documents []bcfDocumentXML `xml:"Documents>Document"`
+}
+
+type bcfDocumentXML struct {
+	GUID        string `xml:"Guid,attr"`
+	Filename    string `xml:"Filename"`
+	Description string `xml:"Description,omitempty"`
+}
+
+// The values the web app offers for topics; extensions.xml lists these
+// along with any others the exported topics use.
+var (
+	topicTypes    = []string{"Issue", "Request", "Comment", "Clash"}
+	topicStatuses = []string{"Open", "InProgress", "Closed", "ReOpened"}
+	priorities    = []string{"Critical", "Major", "Normal", "Minor"}
+)
+
+// markup3For builds the BCF 3.0 markup of a topic.
+func markup3For(topic Topic, vps []bcfViewpointFile) bcfMarkup3 {
+	t := topicToXML(topic)
+	if t.TopicType == "" {
+		// Required in BCF 3.0
+		t.TopicType = topicTypes[0]
+	}
+	markup := bcfMarkup3{Topic: bcfTopic3{
+		GUID:           t.GUID,
+		TopicType:      t.TopicType,
+		TopicStatus:    t.TopicStatus,
+		Title:          t.Title,
+		Priority:       t.Priority,
+		CreationDate:   t.CreationDate,
+		CreationAuthor: t.CreationAuthor,
+		ModifiedDate:   t.ModifiedDate,
+		ModifiedAuthor: t.ModifiedAuthor,
+		DueDate:        t.DueDate,
+		AssignedTo:     t.AssignedTo,
+		Stage:          t.Stage,
+		Description:    t.Description,
+	}}
+	if len(topic.Files) > 0 {
+		markup.Header = &bcfHeader3{Files: filesToXML(topic.Files)}
+	}
+	if len(t.ReferenceLink) > 0 {
+		markup.Topic.ReferenceLinks = &bcfReferenceLinks{ReferenceLink: t.ReferenceLink}
+	}
+	if len(t.Labels) > 0 {
+		markup.Topic.Labels = &bcfLabels{Label: t.Labels}
+	}
+
+	if len(topic.DocumentReferences) > 0 {
+		refs := &bcfDocumentReferences{}
+		for _, ref := range topic.DocumentReferences {
+			refs.DocumentReference = append(refs.DocumentReference, bcfDocumentRefXML{
+				GUID:         ref.GUID,
+				DocumentGUID: derefStr(ref.DocumentGUID),
+				URL:          derefStr(ref.URL),
+				Description:  derefStr(ref.Description),
+			})
+		}
+		markup.Topic.DocumentReferences = refs
+	}
+	if len(topic.Comments) > 0 {
+		markup.Topic.Comments = &bcfComments{Comment: commentsToXML(topic.Comments)}
+	}
+	if len(vps) > 0 {
+		markup.Topic.Viewpoints = &bcfViewpoints3{}
+		for _, vp := range vps {
+			markup.Topic.Viewpoints.ViewPoint = append(markup.Topic.Viewpoints.ViewPoint, bcfViewpointRef3(vp))
+		}
+	}
+	return markup
+}
+
+// topic maps a BCF 3.0 markup.
+func (m *bcfMarkup3) topic() (Topic, []bcfViewpointFile) {
+	t := m.Topic
+	x := bcfTopicXML{
+		GUID:           t.GUID,
+		TopicType:      t.TopicType,
+		TopicStatus:    t.TopicStatus,
+		Title:          t.Title,
+		Priority:       t.Priority,
+		CreationDate:   t.CreationDate,
+		CreationAuthor: t.CreationAuthor,
+		ModifiedDate:   t.ModifiedDate,
+		ModifiedAuthor: t.ModifiedAuthor,
+		DueDate:        t.DueDate,
+		AssignedTo:     t.AssignedTo,
+		Stage:          t.Stage,
+		Description:    t.Description,
+	}
+	if t.ReferenceLinks != nil {
+		x.ReferenceLink = t.ReferenceLinks.ReferenceLink
+	}
+	if t.Labels != nil {
+		x.Labels = t.Labels.Label
+	}
+	topic := topicFromXML(x)
+	if t.Comments != nil {
+		topic.Comments = commentsFromXML(t.Comments.Comment)
+	}
+	if m.Header != nil {
+		topic.Files = filesFromXML(m.Header.Files)
+	}
+
+	var refs []bcfDocumentRefXML
+	if t.DocumentReferences != nil {
+		refs = t.DocumentReferences.DocumentReference
+	}
+	for _, x := range refs {
+		if x.DocumentGUID == "" && x.URL == "" {
+			continue
+		}
+		ref := DocumentReference{
+			GUID:         x.GUID,
+			DocumentGUID: optionalStr(x.DocumentGUID),
+			URL:          optionalStr(x.URL),
+			Description:  optionalStr(x.Description),
+		}
+		if ref.GUID == "" {
+			ref.GUID = stableGUID(topic.GUID + "/" + x.DocumentGUID + x.URL)
+		}
+		topic.DocumentReferences = append(topic.DocumentReferences, ref)
+	}
+
+	var vps []bcfViewpointFile
+	if t.Viewpoints != nil {
+		for _, ref := range t.Viewpoints.ViewPoint {
+			vps = append(vps, bcfViewpointFile(ref))
+		}
+	}
+	return topic, vps
+}
+
+// extensionsFor lists the values a set of topics may use.
+func extensionsFor(topics []Topic) bcfExtensions {
+	ext := bcfExtensions{
+		TopicTypes:    append([]string(nil), topicTypes...),
+		TopicStatuses: append([]string(nil), topicStatuses...),
+		Priorities:    append([]string(nil), priorities...),
+	}
+	labels := map[string]bool{}
+	users := map[string]bool{}
+	stages := map[string]bool{}
+	for _, t := range topics {
+		ext.TopicTypes = appendMissing(ext.TopicTypes, derefStr(t.TopicType))
+		ext.TopicStatuses = appendMissing(ext.TopicStatuses, t.TopicStatus)
+		ext.Priorities = appendMissing(ext.Priorities, derefStr(t.Priority))
+		for _, l := range t.Labels {
+			labels[l] = true
+		}
+		users[derefStr(t.CreationAuthor)] = true
+		users[derefStr(t.ModifiedAuthor)] = true
+		users[derefStr(t.AssignedToEmail)] = true
+		for _, c := range t.Comments {
+			users[derefStr(c.Author)] = true
+			users[derefStr(c.ModifiedAuthor)] = true
+		}
+		stages[derefStr(t.Stage)] = true
+	}
+	if l := sortedKeys(labels); len(l) > 0 {
+		ext.TopicLabels = &bcfTopicLabels{TopicLabel: l}
+	}
+	if u := sortedKeys(users); len(u) > 0 {
+		ext.Users = &bcfUsers{User: u}
+	}
+	if st := sortedKeys(stages); len(st) > 0 {
+		ext.Stages = &bcfStages{Stage: st}
+	}
+	return ext
+}
+
+func appendMissing(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+	for _, e := range list {
+		if e == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// sortedKeys returns the non-empty keys of set in order.
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func documentInfoFor(documents []Document) bcfDocumentInfo {
+	var info bcfDocumentInfo
+	for _, d := range documents {
+		info.Documents = append(info.Documents, bcfDocumentXML{
+			GUID:        d.GUID,
+			Filename:    d.Filename,
+			Description: derefStr(d.Description),
+		})
+	}
+	return info
+}
+
+// readDocuments reads documents.xml and the documents it lists.
+func readDocuments(files map[string]*zip.File) []Document {
+	f, ok := files["documents.xml"]
+	if !ok {
+		return nil
+	}
+	var info bcfDocumentInfo
+	if err := readXMLFile(f, &info); err != nil {
+		return nil
+	}
+
+	var docs []Document
+	for _, x := range info.Documents {
+		df, ok := files[documentPath3(x.GUID)]
+		if !ok {
+			continue
+		}
+		data, err := readZipFile(df)
+		if err != nil {
+			continue
+		}
+		docs = append(docs, Document{
+			GUID:        x.GUID,
+			Filename:    x.Filename,
+			Description: optionalStr(x.Description),
+			Data:        data,
+		})
+	}
+	return docs
+}
+
+// documentPath3 is where BCF 3.0 keeps a document: named by its GUID, with
+// the file name in documents.xml.
+func documentPath3(guid string) string {
+	return "Documents/" + guid
+}
+
+// documentPath21 is where a BCF 2.1 export puts a document. 2.1 has no
+// document list, so the name is kept in the path.
+func documentPath21(d Document) string {
+	return "Documents/" + d.GUID + "/" + d.Filename
+}
@@ -1,11 +1,15 @@
 // Package collab implements the BCF (BIM Collaboration Format) API endpoints.
 //
 // Provides CRUD operations for BCF topics, comments, and viewpoints,
-// plus BCF 2.1 export/import functionality.
+// plus BCF 2.1 and 3.0 export/import functionality and the buildingSMART
+// BCF API 3.0 for external BCF tools.
 package collab
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/nsssthlm/valvx-api/internal/auth"
@@ -40,8 +44,39 @@ func (h *Handler) getProfileID(r *http.Request) string {
 	return profileID
 }
 
+// requireMember resolves the caller's profile in the path project and writes
+// an error response if the caller is not a member.
+func (h *Handler) requireMember(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return h.requireProfile(w, r, h.SessionStore.GetProfileForProject)
+}
+
+// requireWriter is requireMember for callers that may add content; pending
+// invitations are refused.
+func (h *Handler) requireWriter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return h.requireProfile(w, r, h.SessionStore.GetWritableProfileForProject)
+}
+
+func (h *Handler) requireProfile(w http.ResponseWriter, r *http.Request, lookup func(ctx context.Context, accountID, projectID string) (string, error)) (string, bool) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	profileID, err := lookup(r.Context(), accountID, r.PathValue("projectId"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return profileID, true
+}
+
 // RegisterRoutes registers BCF API routes on the given mux.
-// All routes are under /api/projects/{projectId}/bcf/
+// The web app's routes are under /api/projects/{projectId}/bcf/; the
+// buildingSMART BCF API for other tools is under /bcf/3.0/.
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/projects/{projectId}/bcf/topics", h.ListTopics)
 	mux.HandleFunc("POST /api/projects/{projectId}/bcf/topics", h.CreateTopic)
@@ -58,6 +93,8 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 
 	mux.HandleFunc("GET /api/projects/{projectId}/bcf/export", h.ExportBCF)
 	mux.HandleFunc("POST /api/projects/{projectId}/bcf/import", h.ImportBCF)
+
+	h.registerAPIRoutes(mux)
 }
 
 // ListTopics returns all BCF topics for a project.
@@ -131,7 +168,13 @@ func (h *Handler) UpdateTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic, err := h.Service.UpdateTopic(r.Context(), topicID, req)
+	modifiedBy := h.getProfileID(r)
+	if modifiedBy == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	topic, err := h.Service.UpdateTopic(r.Context(), topicID, modifiedBy, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -236,11 +279,18 @@ func (h *Handler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// ExportBCF generates a BCF 2.1 ZIP file for all topics in the project.
+// ExportBCF generates a BCF ZIP file for all topics in the project, BCF 2.1
+// unless the version query parameter asks for 3.0.
 func (h *Handler) ExportBCF(w http.ResponseWriter, r *http.Request) {
 	projectID := r.PathValue("projectId")
 
-	data, err := h.Service.ExportBCF(r.Context(), projectID)
+	version := r.URL.Query().Get("version")
+	if version != "" && version != BCFVersion21 && version != BCFVersion30 {
+		http.Error(w, "version must be 2.1 or 3.0", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.Service.ExportBCF(r.Context(), projectID, version)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -251,7 +301,8 @@ func (h *Handler) ExportBCF(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// ImportBCF imports topics from a BCF 2.1 ZIP file.
+// ImportBCF imports topics from a BCF ZIP file; the version is read from
+// the file.
 func (h *Handler) ImportBCF(w http.ResponseWriter, r *http.Request) {
 	projectID := r.PathValue("projectId")
 
@@ -274,6 +325,10 @@ func (h *Handler) ImportBCF(w http.ResponseWriter, r *http.Request) {
 	}
 
 	count, err := h.Service.ImportBCF(r.Context(), projectID, importerID, file)
+	if errors.Is(err, ErrUnsupportedVersion) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,562 @@
+package collab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OData query options of the BCF API. Lists are filtered, ordered and paged
+// on the JSON form of their items, so a $filter names the same fields the
+// client reads, e.g.
+//
+//	$filter=topic_status eq 'Open' and labels/any(l: l eq 'Structural')
+//	$orderby=creation_date desc&$top=50&$skip=100
+//
+// Supported are eq, ne, gt, ge, lt, le, and, or, not, parentheses, the
+// functions contains, startswith, endswith, tolower and toupper, and any/all
+// on lists. Strings that are both dates compare as dates.
+
+type odataQuery struct {
+	filter  odataExpr // nil keeps every item
+	orderBy []odataOrder
+	top     int // -1 for no limit
+	skip    int
+}
+
+type odataOrder struct {
+	path []string
+	desc bool
+}
+
+// odataExpr evaluates an expression against one item.
+type odataExpr func(env odataEnv) interface{}
+
+// odataEnv holds the item's JSON fields and the variables of the enclosing
+// any/all lambdas.
+type odataEnv struct {
+	fields map[string]interface{}
+	vars   map[string]interface{}
+}
+
+// parseODataQuery reads $filter, $orderby, $top and $skip.
+func parseODataQuery(q url.Values) (*odataQuery, error) {
+	query := &odataQuery{top: -1}
+
+	if f := q.Get("$filter"); strings.TrimSpace(f) != "" {
+		p := &odataParser{}
+		if err := p.tokenize(f); err != nil {
+			return nil, fmt.Errorf("$filter: %w", err)
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, fmt.Errorf("$filter: %w", err)
+		}
+		if tok := p.peek(); tok.kind != odataEOF {
+			return nil, fmt.Errorf("$filter: unexpected %q", tok.text)
+		}
+		query.filter = expr
+	}
+
+	if o := q.Get("$orderby"); strings.TrimSpace(o) != "" {
+		for _, part := range strings.Split(o, ",") {
+			fields := strings.Fields(part)
+			if len(fields) == 0 || len(fields) > 2 {
+				return nil, fmt.Errorf("$orderby: invalid %q", part)
+			}
+			order := odataOrder{path: strings.Split(fields[0], "/")}
+			if len(fields) == 2 {
+				switch fields[1] {
+				case "asc":
+				case "desc":
+					order.desc = true
+				default:
+					return nil, fmt.Errorf("$orderby: invalid direction %q", fields[1])
+				}
+			}
+			query.orderBy = append(query.orderBy, order)
+		}
+	}
+
+	for _, opt := range []struct {
+		name string
+		dst  *int
+	}{{"$top", &query.top}, {"$skip", &query.skip}} {
+		v := q.Get(opt.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer", opt.name)
+		}
+		*opt.dst = n
+	}
+	return query, nil
+}
+
+// applyOData filters, orders and pages items by their JSON form.
+func applyOData[T any](q *odataQuery, items []T) []T {
+	type record struct {
+		item   T
+		fields map[string]interface{}
+	}
+	var records []record
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			continue
+		}
+		if q.filter != nil && q.filter(odataEnv{fields: fields}) != true {
+			continue
+		}
+		records = append(records, record{item, fields})
+	}
+
+	if len(q.orderBy) > 0 {
+		sort.SliceStable(records, func(i, j int) bool {
+			for _, o := range q.orderBy {
+				a := resolveODataPath(odataEnv{fields: records[i].fields}, o.path)
+				b := resolveODataPath(odataEnv{fields: records[j].fields}, o.path)
+				c := odataOrderCompare(a, b)
+				if c == 0 {
+					continue
+				}
+				if o.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if q.skip >= len(records) {
+		records = nil
+	} else {
+		records = records[q.skip:]
+	}
+	if q.top >= 0 && q.top < len(records) {
+		records = records[:q.top]
+	}
+
+	out := make([]T, len(records))
+	for i, r := range records {
+		out[i] = r.item
+	}
+	return out
+}
+
+// --- Parser ---
+
+const (
+	odataEOF = iota
+	odataIdent
+	odataString
+	odataLiteral // number, date or other unquoted value
+	odataPunct   // ( ) , : /
+)
+
+type odataToken struct {
+	kind int
+	text string
+}
+
+type odataParser struct {
+	tokens []odataToken
+	pos    int
+}
+
+func (p *odataParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("(),:/", c) >= 0:
+			p.tokens = append(p.tokens, odataToken{odataPunct, string(c)})
+			i++
+		case c == '\'':
+			// Quotes inside strings are doubled
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return fmt.Errorf("unterminated string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			p.tokens = append(p.tokens, odataToken{odataString, b.String()})
+		case isODataIdentChar(c) && !(c >= '0' && c <= '9'):
+			j := i
+			for j < len(s) && isODataIdentChar(s[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, odataToken{odataIdent, s[i:j]})
+			i = j
+		case c >= '0' && c <= '9' || c == '-':
+			j := i + 1
+			for j < len(s) && (isODataIdentChar(s[j]) || strings.IndexByte(":.+-", s[j]) >= 0) {
+				j++
+			}
+			p.tokens = append(p.tokens, odataToken{odataLiteral, s[i:j]})
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return nil
+}
+
+func isODataIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *odataParser) peek() odataToken {
+	if p.pos >= len(p.tokens) {
+		return odataToken{kind: odataEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *odataParser) next() odataToken {
+	tok := p.peek()
+	if tok.kind != odataEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given keyword or punctuation.
+func (p *odataParser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == odataIdent || tok.kind == odataPunct) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *odataParser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *odataParser) parseOr() (odataExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env odataEnv) interface{} { return l(env) == true || right(env) == true }
+	}
+	return left, nil
+}
+
+func (p *odataParser) parseAnd() (odataExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env odataEnv) interface{} { return l(env) == true && right(env) == true }
+	}
+	return left, nil
+}
+
+func (p *odataParser) parseUnary() (odataExpr, error) {
+	if p.accept("not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env odataEnv) interface{} { return operand(env) != true }, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *odataParser) parseComparison() (odataExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != odataIdent {
+		return left, nil
+	}
+	var test func(c int, ok bool) bool
+	switch tok.text {
+	case "eq":
+		test = func(c int, ok bool) bool { return ok && c == 0 }
+	case "ne":
+		test = func(c int, ok bool) bool { return !ok || c != 0 }
+	case "gt":
+		test = func(c int, ok bool) bool { return ok && c > 0 }
+	case "ge":
+		test = func(c int, ok bool) bool { return ok && c >= 0 }
+	case "lt":
+		test = func(c int, ok bool) bool { return ok && c < 0 }
+	case "le":
+		test = func(c int, ok bool) bool { return ok && c <= 0 }
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(env odataEnv) interface{} { return test(odataCompare(left(env), right(env))) }, nil
+}
+
+func (p *odataParser) parseOperand() (odataExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case odataString:
+		return odataConst(tok.text), nil
+	case odataLiteral:
+		if f, err := strconv.ParseFloat(tok.text, 64); err == nil {
+			return odataConst(f), nil
+		}
+		return odataConst(tok.text), nil
+	case odataPunct:
+		if tok.text != "(" {
+			return nil, fmt.Errorf("unexpected %q", tok.text)
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case odataIdent:
+		switch tok.text {
+		case "null":
+			return odataConst(nil), nil
+		case "true":
+			return odataConst(true), nil
+		case "false":
+			return odataConst(false), nil
+		}
+		if p.accept("(") {
+			return p.parseFunction(tok.text)
+		}
+		return p.parsePath(tok.text)
+	}
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+func odataConst(v interface{}) odataExpr {
+	return func(odataEnv) interface{} { return v }
+}
+
+// parseFunction parses the arguments of a function call after its "(".
+func (p *odataParser) parseFunction(name string) (odataExpr, error) {
+	var args []odataExpr
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	str := func(e odataExpr, env odataEnv) (string, bool) {
+		s, ok := e(env).(string)
+		return s, ok
+	}
+	switch {
+	case (name == "contains" || name == "startswith" || name == "endswith") && len(args) == 2:
+		match := map[string]func(s, sub string) bool{
+			"contains":   strings.Contains,
+			"startswith": strings.HasPrefix,
+			"endswith":   strings.HasSuffix,
+		}[name]
+		return func(env odataEnv) interface{} {
+			s, ok1 := str(args[0], env)
+			sub, ok2 := str(args[1], env)
+			return ok1 && ok2 && match(s, sub)
+		}, nil
+	case (name == "tolower" || name == "toupper") && len(args) == 1:
+		convert := strings.ToLower
+		if name == "toupper" {
+			convert = strings.ToUpper
+		}
+		return func(env odataEnv) interface{} {
+			if s, ok := str(args[0], env); ok {
+				return convert(s)
+			}
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported function %s with %d arguments", name, len(args))
+}
+
+// parsePath parses a field path such as labels or a/type, which may end in
+// an any or all lambda.
+func (p *odataParser) parsePath(first string) (odataExpr, error) {
+	path := []string{first}
+	for p.accept("/") {
+		tok := p.next()
+		if tok.kind != odataIdent {
+			return nil, fmt.Errorf("expected a field name after /")
+		}
+		if (tok.text == "any" || tok.text == "all") && p.accept("(") {
+			return p.parseLambda(path, tok.text == "all")
+		}
+		path = append(path, tok.text)
+	}
+	return func(env odataEnv) interface{} { return resolveODataPath(env, path) }, nil
+}
+
+// parseLambda parses "x: expr)" after "any(" or "all(".
+func (p *odataParser) parseLambda(path []string, all bool) (odataExpr, error) {
+	list := func(env odataEnv) []interface{} {
+		l, _ := resolveODataPath(env, path).([]interface{})
+		return l
+	}
+	if p.accept(")") {
+		// any() asks for a non-empty list
+		return func(env odataEnv) interface{} { return len(list(env)) > 0 }, nil
+	}
+
+	v := p.next()
+	if v.kind != odataIdent {
+		return nil, fmt.Errorf("expected a lambda variable")
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return func(env odataEnv) interface{} {
+		for _, item := range list(env) {
+			vars := map[string]interface{}{v.text: item}
+			for k, val := range env.vars {
+				if k != v.text {
+					vars[k] = val
+				}
+			}
+			if (body(odataEnv{fields: env.fields, vars: vars}) == true) != all {
+				return !all
+			}
+		}
+		return all
+	}, nil
+}
+
+func resolveODataPath(env odataEnv, path []string) interface{} {
+	var v interface{}
+	if lv, ok := env.vars[path[0]]; ok {
+		v = lv
+	} else {
+		v = env.fields[path[0]]
+	}
+	for _, name := range path[1:] {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+// odataCompare orders two values of the same kind; ok is false when they
+// cannot be compared. Two nulls are equal.
+func odataCompare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, a == nil && b == nil
+	}
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x == y {
+			return 0, true
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		if tx, ok := parseODataDate(x); ok {
+			if ty, ok := parseODataDate(y); ok {
+				return tx.Compare(ty), true
+			}
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// odataOrderCompare is odataCompare for $orderby, with nulls first.
+func odataOrderCompare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := odataCompare(a, b)
+	return c
+}
+
+func parseODataDate(s string) (time.Time, bool) {
+	for _, layout := range bcfDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
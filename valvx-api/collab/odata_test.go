@@ -0,0 +1,136 @@
+package collab
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type odataItem struct {
+	Guid         string   `json:"guid"`
+	Title        string   `json:"title"`
+	Status       string   `json:"topic_status"`
+	Priority     *string  `json:"priority"`
+	Index        float64  `json:"index"`
+	Labels       []string `json:"labels"`
+	CreationDate string   `json:"creation_date"`
+	Closed       bool     `json:"closed"`
+	Author       struct {
+		Name string `json:"name"`
+	} `json:"author"`
+}
+
+func odataItems() []odataItem {
+	high := "High"
+	items := []odataItem{
+		{Guid: "a", Title: "Crack in slab", Status: "Open", Priority: &high, Index: 3, Labels: []string{"Structural"}, CreationDate: "2024-03-01T10:00:00Z"},
+		{Guid: "b", Title: "Door 'D12' clash", Status: "Closed", Index: 1, Labels: []string{"Architecture", "Clash"}, CreationDate: "2024-03-01T11:00:00+02:00", Closed: true},
+		{Guid: "c", Title: "Missing duct", Status: "Open", Index: 2, CreationDate: "2024-02-28T09:00:00Z"},
+	}
+	items[0].Author.Name = "anna@valvx.se"
+	items[1].Author.Name = "bo@valvx.se"
+	return items
+}
+
+func odataGuids(items []odataItem) []string {
+	guids := []string{}
+	for _, it := range items {
+		guids = append(guids, it.Guid)
+	}
+	return guids
+}
+
+func TestApplyODataFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{"topic_status eq 'Open'", []string{"a", "c"}},
+		{"topic_status ne 'Open'", []string{"b"}},
+		{"index gt 1", []string{"a", "c"}},
+		{"index ge 2 and index le 2", []string{"c"}},
+		{"index lt 2 or topic_status eq 'Open'", []string{"a", "b", "c"}},
+		{"not (topic_status eq 'Open')", []string{"b"}},
+		{"closed eq true", []string{"b"}},
+		{"priority eq null", []string{"b", "c"}},
+		{"priority ne null", []string{"a"}},
+		{"author/name eq 'bo@valvx.se'", []string{"b"}},
+		{"title eq 'Door ''D12'' clash'", []string{"b"}},
+		{"contains(tolower(title), 'crack')", []string{"a"}},
+		{"startswith(title, 'Missing')", []string{"c"}},
+		{"endswith(toupper(title), 'CLASH')", []string{"b"}},
+		{"labels/any(l: l eq 'Clash')", []string{"b"}},
+		{"labels/any()", []string{"a", "b"}},
+		{"labels/all(l: l eq 'Structural')", []string{"a", "c"}},
+		// Dates compare as instants, not as text
+		{"creation_date lt '2024-03-01T10:00:00Z'", []string{"b", "c"}},
+		{"creation_date gt 2024-03-01", []string{"a", "b"}},
+		// Values of different kinds never match
+		{"index eq '3'", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q, err := parseODataQuery(url.Values{"$filter": {tt.filter}})
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := odataGuids(applyOData(q, odataItems())); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyODataOrderAndPaging(t *testing.T) {
+	tests := []struct {
+		query url.Values
+		want  []string
+	}{
+		{url.Values{"$orderby": {"index"}}, []string{"b", "c", "a"}},
+		{url.Values{"$orderby": {"index desc"}}, []string{"a", "c", "b"}},
+		{url.Values{"$orderby": {"creation_date asc"}}, []string{"c", "b", "a"}},
+		{url.Values{"$orderby": {"topic_status, index desc"}}, []string{"b", "a", "c"}},
+		{url.Values{"$orderby": {"author/name desc"}}, []string{"b", "a", "c"}},
+		// Nulls sort first
+		{url.Values{"$orderby": {"priority"}}, []string{"b", "c", "a"}},
+		{url.Values{"$orderby": {"index"}, "$top": {"2"}}, []string{"b", "c"}},
+		{url.Values{"$orderby": {"index"}, "$skip": {"1"}, "$top": {"1"}}, []string{"c"}},
+		{url.Values{"$skip": {"5"}}, []string{}},
+		{url.Values{"$top": {"0"}}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query.Encode(), func(t *testing.T) {
+			q, err := parseODataQuery(tt.query)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := odataGuids(applyOData(q, odataItems())); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseODataQueryErrors(t *testing.T) {
+	for _, q := range []url.Values{
+		{"$filter": {"title eq 'open"}},
+		{"$filter": {"title eq"}},
+		{"$filter": {"(index eq 1"}},
+		{"$filter": {"index eq 1)"}},
+		{"$filter": {"title eq 'a' 'b'"}},
+		{"$filter": {"index eq 1 & index eq 2"}},
+		{"$filter": {"substring(title, 1)"}},
+		{"$filter": {"contains(title)"}},
+		{"$filter": {"labels/any(l l eq 'x')"}},
+		{"$filter": {"author/ eq 'x'"}},
+		{"$orderby": {"index sideways"}},
+		{"$orderby": {"index,"}},
+		{"$top": {"-1"}},
+		{"$skip": {"ten"}},
+	} {
+		if _, err := parseODataQuery(q); err == nil {
+			t.Errorf("parseODataQuery(%v) succeeded", q)
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,9 +27,16 @@ func (s *Service) ListTopics(ctx context.Context, projectID string, filters Topi
 		SELECT t.id, t.guid, t.title, t.description, t.priority, t.topic_type,
 		       t.topic_status, t.stage, t.assigned_to, t.due_date, t.labels,
 		       t.project_id, t.creator_id, t.modified_by, t.created_at, t.updated_at,
-		       p.name as creator_name
+		       p.name as creator_name, COALESCE(t.creation_author, ci.email),
+		       COALESCE(ai.email, t.assigned_to_email), t.reference_links,
+		       COALESCE(t.modified_author, mi.email)
 		FROM collab_topic t
 		LEFT JOIN iam_profile p ON p.id = t.creator_id
+		LEFT JOIN iam_ident ci ON ci.id = p.ident_id
+		LEFT JOIN iam_profile ap ON ap.id = t.assigned_to
+		LEFT JOIN iam_ident ai ON ai.id = ap.ident_id
+		LEFT JOIN iam_profile mp ON mp.id = t.modified_by
+		LEFT JOIN iam_ident mi ON mi.id = mp.ident_id
 		WHERE t.project_id = $1`
 
 	args := []interface{}{projectID}
@@ -67,7 +74,8 @@ func (s *Service) ListTopics(ctx context.Context, projectID string, filters Topi
 			&t.ID, &t.GUID, &t.Title, &t.Description, &t.Priority, &t.TopicType,
 			&t.TopicStatus, &t.Stage, &t.AssignedTo, &t.DueDate, pq.Array(&labels),
 			&t.ProjectID, &t.CreatorID, &t.ModifiedBy, &t.CreatedAt, &t.UpdatedAt,
-			&t.CreatorName,
+			&t.CreatorName, &t.CreationAuthor, &t.AssignedToEmail, pq.Array(&t.ReferenceLinks),
+			&t.ModifiedAuthor,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan topic: %w", err)
@@ -95,15 +103,23 @@ func (s *Service) GetTopic(ctx context.Context, topicID string) (*Topic, error)
 		SELECT t.id, t.guid, t.title, t.description, t.priority, t.topic_type,
 		       t.topic_status, t.stage, t.assigned_to, t.due_date, t.labels,
 		       t.project_id, t.creator_id, t.modified_by, t.created_at, t.updated_at,
-		       p.name as creator_name
+		       p.name as creator_name, COALESCE(t.creation_author, ci.email),
+		       COALESCE(ai.email, t.assigned_to_email), t.reference_links,
+		       COALESCE(t.modified_author, mi.email)
 		FROM collab_topic t
 		LEFT JOIN iam_profile p ON p.id = t.creator_id
+		LEFT JOIN iam_ident ci ON ci.id = p.ident_id
+		LEFT JOIN iam_profile ap ON ap.id = t.assigned_to
+		LEFT JOIN iam_ident ai ON ai.id = ap.ident_id
+		LEFT JOIN iam_profile mp ON mp.id = t.modified_by
+		LEFT JOIN iam_ident mi ON mi.id = mp.ident_id
 		WHERE t.id = $1`, topicID,
 	).Scan(
 		&t.ID, &t.GUID, &t.Title, &t.Description, &t.Priority, &t.TopicType,
 		&t.TopicStatus, &t.Stage, &t.AssignedTo, &t.DueDate, pq.Array(&labels),
 		&t.ProjectID, &t.CreatorID, &t.ModifiedBy, &t.CreatedAt, &t.UpdatedAt,
-		&t.CreatorName,
+		&t.CreatorName, &t.CreationAuthor, &t.AssignedToEmail, pq.Array(&t.ReferenceLinks),
+		&t.ModifiedAuthor,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get topic: %w", err)
@@ -112,6 +128,11 @@ func (s *Service) GetTopic(ctx context.Context, topicID string) (*Topic, error)
 
 	t.Viewpoints, _ = s.listViewpoints(ctx, t.ID, 0)
 	t.Comments, _ = s.ListComments(ctx, t.ID)
+	t.DocumentReferences, _ = s.listDocumentReferences(ctx, t.ID)
+	t.Files, _ = s.listTopicFiles(ctx, t.ID)
+	for _, f := range t.Files {
+		t.FileVersionIDs = append(t.FileVersionIDs, f.FileVersionID)
+	}
 
 	return &t, nil
 }
@@ -119,16 +140,23 @@ func (s *Service) GetTopic(ctx context.Context, topicID string) (*Topic, error)
 func (s *Service) CreateTopic(ctx context.Context, projectID, creatorID string, req CreateTopicRequest) (*Topic, error) {
 	id := uuid.New().String()
 	guid := uuid.New().String()
+	if req.GUID != nil && *req.GUID != "" {
+		guid = *req.GUID
+	}
 	now := time.Now().UTC()
 
 	status := "Open"
+	if req.TopicStatus != nil && *req.TopicStatus != "" {
+		status = *req.TopicStatus
+	}
 
 	_, err := s.DB.ExecContext(ctx, `
 		INSERT INTO collab_topic (id, guid, title, description, priority, topic_type,
-		    topic_status, assigned_to, due_date, labels, project_id, creator_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		    topic_status, stage, assigned_to, due_date, labels, reference_links,
+		    project_id, creator_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
 		id, guid, req.Title, req.Description, req.Priority, req.TopicType,
-		status, req.AssignedTo, req.DueDate, pq.Array(req.Labels),
+		status, req.Stage, req.AssignedTo, req.DueDate, pq.Array(req.Labels), pq.Array(req.ReferenceLinks),
 		projectID, creatorID, now, now,
 	)
 	if err != nil {
@@ -157,28 +185,70 @@ func (s *Service) CreateTopic(ctx context.Context, projectID, creatorID string,
 	return s.GetTopic(ctx, id)
 }
 
-func (s *Service) UpdateTopic(ctx context.Context, topicID string, req CreateTopicRequest) (*Topic, error) {
+// UpdateTopic changes the fields set in req and records the changes as
+// topic events by modifiedBy.
+func (s *Service) UpdateTopic(ctx context.Context, topicID, modifiedBy string, req CreateTopicRequest) (*Topic, error) {
+	before, err := s.GetTopic(ctx, topicID)
+	if err != nil {
+		return nil, err
+	}
 	now := time.Now().UTC()
 
-	_, err := s.DB.ExecContext(ctx, `
+	// A NULL parameter keeps the column, an empty string clears it
+	_, err = s.DB.ExecContext(ctx, `
 		UPDATE collab_topic SET
 			title = COALESCE(NULLIF($2, ''), title),
-			description = COALESCE($3, description),
-			priority = COALESCE($4, priority),
-			topic_type = COALESCE($5, topic_type),
-			assigned_to = COALESCE($6, assigned_to),
-			due_date = COALESCE($7, due_date),
-			labels = COALESCE($8, labels),
-			updated_at = $9
+			description = CASE WHEN $3::text IS NULL THEN description ELSE NULLIF($3, '') END,
+			priority = CASE WHEN $4::text IS NULL THEN priority ELSE NULLIF($4, '') END,
+			topic_type = CASE WHEN $5::text IS NULL THEN topic_type ELSE NULLIF($5, '') END,
+			topic_status = COALESCE(NULLIF($6, ''), topic_status),
+			stage = CASE WHEN $7::text IS NULL THEN stage ELSE NULLIF($7, '') END,
+			assigned_to = CASE WHEN $8::text IS NULL THEN assigned_to ELSE NULLIF($8, '')::uuid END,
+			assigned_to_email = CASE WHEN $8::text IS NULL THEN assigned_to_email END,
+			due_date = CASE WHEN $9::text IS NULL THEN due_date ELSE NULLIF($9, '')::date END,
+			labels = COALESCE($10, labels),
+			reference_links = COALESCE($11, reference_links),
+			modified_by = $12,
+			modified_author = NULL,
+			updated_at = $13
 		WHERE id = $1`,
 		topicID, req.Title, req.Description, req.Priority, req.TopicType,
-		req.AssignedTo, req.DueDate, pq.Array(req.Labels), now,
+		req.TopicStatus, req.Stage, req.AssignedTo, req.DueDate, pq.Array(req.Labels),
+		pq.Array(req.ReferenceLinks), modifiedBy, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("update topic: %w", err)
 	}
 
-	return s.GetTopic(ctx, topicID)
+	after, err := s.GetTopic(ctx, topicID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range topicChanges(before, after) {
+		_, err := s.DB.ExecContext(ctx, `
+			INSERT INTO collab_topic_event (id, created_at, topic_id, author_id, type, value)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			uuid.New().String(), now, topicID, modifiedBy, a.Type, a.Value,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert topic event: %w", err)
+		}
+	}
+
+	return after, nil
+}
+
+// GetTopicByGUID returns the topic of a project with the given BCF GUID.
+func (s *Service) GetTopicByGUID(ctx context.Context, projectID, guid string) (*Topic, error) {
+	var id string
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT id FROM collab_topic WHERE project_id = $1 AND guid = $2",
+		projectID, guid,
+	).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("get topic: %w", err)
+	}
+	return s.GetTopic(ctx, id)
 }
 
 func (s *Service) DeleteTopic(ctx context.Context, topicID string) error {
@@ -186,14 +256,93 @@ func (s *Service) DeleteTopic(ctx context.Context, topicID string) error {
 	return err
 }
 
+// --- Topic events ---
+
+// ListTopicEvents returns the recorded changes to a topic, oldest first.
+func (s *Service) ListTopicEvents(ctx context.Context, topicID string) ([]TopicEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.guid, e.created_at, e.author_id, i.email, e.type, e.value
+		FROM collab_topic_event e
+		JOIN collab_topic t ON t.id = e.topic_id
+		LEFT JOIN iam_profile p ON p.id = e.author_id
+		LEFT JOIN iam_ident i ON i.id = p.ident_id
+		WHERE e.topic_id = $1
+		ORDER BY e.created_at ASC, e.type ASC`, topicID)
+	if err != nil {
+		return nil, fmt.Errorf("query topic events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []TopicEvent{}
+	for rows.Next() {
+		var e TopicEvent
+		var a TopicEventAction
+		if err := rows.Scan(&e.TopicGUID, &e.Date, &e.AuthorID, &e.Author, &a.Type, &a.Value); err != nil {
+			return nil, fmt.Errorf("scan topic event: %w", err)
+		}
+		// Rows written by one update make up one event
+		if n := len(events); n > 0 && events[n-1].Date.Equal(e.Date) && derefStr(events[n-1].AuthorID) == derefStr(e.AuthorID) {
+			events[n-1].Actions = append(events[n-1].Actions, a)
+			continue
+		}
+		e.Actions = []TopicEventAction{a}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// topicChanges lists the event actions that turn before into after.
+func topicChanges(before, after *Topic) []TopicEventAction {
+	var actions []TopicEventAction
+	changed := func(typ string, from, to *string) {
+		if derefStr(from) != derefStr(to) {
+			actions = append(actions, TopicEventAction{Type: typ, Value: to})
+		}
+	}
+	changed("title_updated", &before.Title, &after.Title)
+	changed("status_updated", &before.TopicStatus, &after.TopicStatus)
+	changed("type_updated", before.TopicType, after.TopicType)
+	changed("priority_updated", before.Priority, after.Priority)
+	changed("stage_updated", before.Stage, after.Stage)
+	changed("assigned_to_updated", before.AssignedToEmail, after.AssignedToEmail)
+	changed("description_updated", before.Description, after.Description)
+	changed("due_date_updated", optionalStr(dueDateToBCF(before.DueDate)), optionalStr(dueDateToBCF(after.DueDate)))
+
+	for _, l := range after.Labels {
+		if !containsStr(before.Labels, l) {
+			actions = append(actions, TopicEventAction{Type: "add_label", Value: &l})
+		}
+	}
+	for _, l := range before.Labels {
+		if !containsStr(after.Labels, l) {
+			actions = append(actions, TopicEventAction{Type: "remove_label", Value: &l})
+		}
+	}
+	return actions
+}
+
+func containsStr(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
 // --- Comments ---
 
 func (s *Service) ListComments(ctx context.Context, topicID string) ([]Comment, error) {
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT c.id, c.body, c.viewpoint_id, c.topic_id, c.author_id,
-		       c.created_at, c.updated_at, p.name as author_name
+		SELECT c.id, c.guid, c.body, c.viewpoint_id, v.guid, c.topic_id, c.author_id,
+		       c.created_at, c.updated_at, p.name as author_name, COALESCE(c.author, i.email),
+		       c.modified_by, COALESCE(c.modified_author, mi.email)
 		FROM collab_comment c
+		LEFT JOIN collab_viewpoint v ON v.id = c.viewpoint_id
 		LEFT JOIN iam_profile p ON p.id = c.author_id
+		LEFT JOIN iam_ident i ON i.id = p.ident_id
+		LEFT JOIN iam_profile mp ON mp.id = c.modified_by
+		LEFT JOIN iam_ident mi ON mi.id = mp.ident_id
 		WHERE c.topic_id = $1
 		ORDER BY c.created_at ASC`, topicID)
 	if err != nil {
@@ -204,8 +353,9 @@ func (s *Service) ListComments(ctx context.Context, topicID string) ([]Comment,
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.ID, &c.Body, &c.ViewpointID, &c.TopicID,
-			&c.AuthorID, &c.CreatedAt, &c.UpdatedAt, &c.AuthorName); err != nil {
+		if err := rows.Scan(&c.ID, &c.GUID, &c.Body, &c.ViewpointID, &c.ViewpointGUID, &c.TopicID,
+			&c.AuthorID, &c.CreatedAt, &c.UpdatedAt, &c.AuthorName, &c.Author,
+			&c.ModifiedBy, &c.ModifiedAuthor); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
@@ -219,12 +369,16 @@ func (s *Service) ListComments(ctx context.Context, topicID string) ([]Comment,
 
 func (s *Service) CreateComment(ctx context.Context, topicID, authorID string, req CreateCommentRequest) (*Comment, error) {
 	id := uuid.New().String()
+	guid := uuid.New().String()
+	if req.GUID != nil && *req.GUID != "" {
+		guid = *req.GUID
+	}
 	now := time.Now().UTC()
 
 	_, err := s.DB.ExecContext(ctx, `
-		INSERT INTO collab_comment (id, body, viewpoint_id, topic_id, author_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		id, req.Body, req.ViewpointID, topicID, authorID, now, now,
+		INSERT INTO collab_comment (id, guid, body, viewpoint_id, topic_id, author_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id, guid, req.Body, req.ViewpointID, topicID, authorID, now, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("insert comment: %w", err)
@@ -232,6 +386,7 @@ func (s *Service) CreateComment(ctx context.Context, topicID, authorID string, r
 
 	return &Comment{
 		ID:        id,
+		GUID:      guid,
 		Body:      req.Body,
 		TopicID:   topicID,
 		AuthorID:  authorID,
@@ -240,6 +395,36 @@ func (s *Service) CreateComment(ctx context.Context, topicID, authorID string, r
 	}, nil
 }
 
+// UpdateComment replaces the text and viewpoint of a comment.
+func (s *Service) UpdateComment(ctx context.Context, commentID, modifiedBy string, req CreateCommentRequest) (*Comment, error) {
+	var topicID string
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE collab_comment SET
+			body = $2,
+			viewpoint_id = $3,
+			modified_by = $4,
+			modified_author = NULL,
+			updated_at = $5
+		WHERE id = $1
+		RETURNING topic_id`,
+		commentID, req.Body, req.ViewpointID, modifiedBy, time.Now().UTC(),
+	).Scan(&topicID)
+	if err != nil {
+		return nil, fmt.Errorf("update comment: %w", err)
+	}
+
+	comments, err := s.ListComments(ctx, topicID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		if comments[i].ID == commentID {
+			return &comments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("update comment: %w", sql.ErrNoRows)
+}
+
 func (s *Service) DeleteComment(ctx context.Context, commentID string) error {
 	_, err := s.DB.ExecContext(ctx, "DELETE FROM collab_comment WHERE id = $1", commentID)
 	return err
@@ -253,8 +438,9 @@ func (s *Service) listViewpoints(ctx context.Context, topicID string, limit int)
 		       camera_position_x, camera_position_y, camera_position_z,
 		       camera_direction_x, camera_direction_y, camera_direction_z,
 		       camera_up_x, camera_up_y, camera_up_z,
-		       camera_fov, camera_view_world_scale,
-		       snapshot_data, components, clipping_planes, created_at
+		       camera_fov, camera_view_world_scale, camera_aspect_ratio,
+		       snapshot_data, COALESCE(snapshot_type, 'png'), components, clipping_planes,
+		       lines, created_at
 		FROM collab_viewpoint
 		WHERE topic_id = $1
 		ORDER BY created_at ASC`
@@ -273,14 +459,16 @@ func (s *Service) listViewpoints(ctx context.Context, topicID string, limit int)
 	for rows.Next() {
 		var v Viewpoint
 		var snapshotData []byte
+		var snapshotType string
 
 		err := rows.Scan(
 			&v.ID, &v.GUID, &v.TopicID, &v.CameraType,
 			&v.CameraPosition.X, &v.CameraPosition.Y, &v.CameraPosition.Z,
 			&v.CameraDirection.X, &v.CameraDirection.Y, &v.CameraDirection.Z,
 			&v.CameraUp.X, &v.CameraUp.Y, &v.CameraUp.Z,
-			&v.FieldOfView, &v.ViewWorldScale,
-			&snapshotData, &v.Components, &v.ClippingPlanes, &v.CreatedAt,
+			&v.FieldOfView, &v.ViewWorldScale, &v.AspectRatio,
+			&snapshotData, &snapshotType, &v.Components, &v.ClippingPlanes,
+			&v.Lines, &v.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -288,7 +476,7 @@ func (s *Service) listViewpoints(ctx context.Context, topicID string, limit int)
 
 		// Convert snapshot to base64 data URL if present
 		if len(snapshotData) > 0 {
-			encoded := "data:image/png;base64," + encodeBase64(snapshotData)
+			encoded := "data:image/" + snapshotType + ";base64," + encodeBase64(snapshotData)
 			v.SnapshotBase64 = &encoded
 		}
 
@@ -304,36 +492,46 @@ func (s *Service) listViewpoints(ctx context.Context, topicID string, limit int)
 func (s *Service) CreateViewpoint(ctx context.Context, topicID string, req CreateViewpointRequest) (*Viewpoint, error) {
 	id := uuid.New().String()
 	guid := uuid.New().String()
+	if req.GUID != nil && *req.GUID != "" {
+		guid = *req.GUID
+	}
 	now := time.Now().UTC()
 
 	// Decode base64 snapshot if provided
 	var snapshotData []byte
+	snapshotType := "png"
 	if req.SnapshotBase64 != nil {
 		snapshotData = decodeBase64DataURL(*req.SnapshotBase64)
+		snapshotType = dataURLImageType(*req.SnapshotBase64)
 	}
 
-	componentsJSON, _ := json.Marshal(req.Components)
-	clippingJSON, _ := json.Marshal(req.ClippingPlanes)
+	componentsJSON := jsonOrNull(req.Components)
+	clippingJSON := jsonOrNull(req.ClippingPlanes)
+	linesJSON := jsonOrNull(req.Lines)
 
 	_, err := s.DB.ExecContext(ctx, `
 		INSERT INTO collab_viewpoint (id, guid, topic_id, camera_type,
 		    camera_position_x, camera_position_y, camera_position_z,
 		    camera_direction_x, camera_direction_y, camera_direction_z,
 		    camera_up_x, camera_up_y, camera_up_z,
-		    camera_fov, camera_view_world_scale,
-		    snapshot_data, components, clipping_planes, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		    camera_fov, camera_view_world_scale, camera_aspect_ratio,
+		    snapshot_data, snapshot_type, components, clipping_planes, lines, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)`,
 		id, guid, topicID, req.CameraType,
 		req.CameraPosition.X, req.CameraPosition.Y, req.CameraPosition.Z,
 		req.CameraDirection.X, req.CameraDirection.Y, req.CameraDirection.Z,
 		req.CameraUp.X, req.CameraUp.Y, req.CameraUp.Z,
-		req.FieldOfView, req.ViewWorldScale,
-		snapshotData, componentsJSON, clippingJSON, now,
+		req.FieldOfView, req.ViewWorldScale, req.AspectRatio,
+		snapshotData, snapshotType, componentsJSON, clippingJSON, linesJSON, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("insert viewpoint: %w", err)
 	}
 
+	var snapshot *string
+	if len(snapshotData) > 0 {
+		snapshot = req.SnapshotBase64
+	}
 	return &Viewpoint{
 		ID:              id,
 		GUID:            guid,
@@ -344,10 +542,43 @@ func (s *Service) CreateViewpoint(ctx context.Context, topicID string, req Creat
 		CameraUp:        req.CameraUp,
 		FieldOfView:     req.FieldOfView,
 		ViewWorldScale:  req.ViewWorldScale,
+		AspectRatio:     req.AspectRatio,
+		SnapshotBase64:  snapshot,
+		Components:      req.Components,
+		ClippingPlanes:  req.ClippingPlanes,
+		Lines:           req.Lines,
 		CreatedAt:       now,
 	}, nil
 }
 
+// DeleteViewpoint removes a viewpoint; comments on it are kept.
+func (s *Service) DeleteViewpoint(ctx context.Context, viewpointID string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM collab_viewpoint WHERE id = $1", viewpointID)
+	return err
+}
+
+// dataURLImageType returns the image subtype of a data URL such as
+// "data:image/jpeg;base64,...", defaulting to png.
+func dataURLImageType(dataURL string) string {
+	rest, ok := strings.CutPrefix(dataURL, "data:image/")
+	if !ok {
+		return "png"
+	}
+	if i := strings.IndexAny(rest, ";,"); i > 0 {
+		return rest[:i]
+	}
+	return "png"
+}
+
+// jsonOrNull returns raw JSON for a jsonb column, or nil to store NULL when
+// the field was not given.
+func jsonOrNull(raw *json.RawMessage) []byte {
+	if raw == nil || len(*raw) == 0 || string(*raw) == "null" {
+		return nil
+	}
+	return *raw
+}
+
 func (s *Service) GetSnapshot(ctx context.Context, viewpointID string) ([]byte, string, error) {
 	var data []byte
 	var snapType string
@@ -364,68 +595,184 @@ func (s *Service) GetSnapshot(ctx context.Context, viewpointID string) ([]byte,
 	return data, "image/" + snapType, nil
 }
 
-// --- BCF Export/Import ---
+// --- Documents ---
 
-func (s *Service) ExportBCF(ctx context.Context, projectID string) ([]byte, error) {
-	topics, err := s.ListTopics(ctx, projectID, TopicFilters{})
+func (s *Service) listDocumentReferences(ctx context.Context, topicID string) ([]DocumentReference, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT guid, document_guid, url, description
+		FROM collab_document_reference
+		WHERE topic_id = $1
+		ORDER BY guid`, topicID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Fetch full data for each topic
-	var fullTopics []Topic
-	for _, t := range topics {
-		full, err := s.GetTopic(ctx, t.ID)
-		if err != nil {
-			continue
+	var refs []DocumentReference
+	for rows.Next() {
+		var ref DocumentReference
+		if err := rows.Scan(&ref.GUID, &ref.DocumentGUID, &ref.URL, &ref.Description); err != nil {
+			return nil, err
 		}
-		fullTopics = append(fullTopics, *full)
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// listDocuments returns the documents of a project with their contents.
+func (s *Service) listDocuments(ctx context.Context, projectID string) ([]Document, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT guid, filename, description, data
+		FROM collab_document
+		WHERE project_id = $1
+		ORDER BY created_at ASC`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("query documents: %w", err)
 	}
+	defer rows.Close()
 
-	return ExportBCFZip(fullTopics)
+	var docs []Document
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.GUID, &d.Filename, &d.Description, &d.Data); err != nil {
+			return nil, fmt.Errorf("scan document: %w", err)
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
 }
 
-func (s *Service) ImportBCF(ctx context.Context, projectID, importerID string, file io.Reader) (int, error) {
-	data, err := io.ReadAll(file)
+// listTopicFiles returns the file versions linked to a topic, with the
+// GlobalId of the IfcProject when the model has been indexed.
+func (s *Service) listTopicFiles(ctx context.Context, topicID string) ([]TopicFile, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT fv.id, f.name, COALESCE(f.ext, ''), fv.created_at,
+		       (SELECT e.global_id FROM arca_ifc_element e
+		        WHERE e.file_version_id = fv.id AND e.type = 'IFCPROJECT' LIMIT 1)
+		FROM collab_topic_file tf
+		JOIN arca_file_version fv ON fv.id = tf.file_version_id
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE tf.topic_id = $1
+		ORDER BY fv.created_at ASC`, topicID)
 	if err != nil {
-		return 0, fmt.Errorf("read file: %w", err)
+		return nil, err
 	}
+	defer rows.Close()
+
+	var files []TopicFile
+	for rows.Next() {
+		var f TopicFile
+		var ext string
+		var date time.Time
+		if err := rows.Scan(&f.FileVersionID, &f.Filename, &ext, &date, &f.IfcProject); err != nil {
+			return nil, err
+		}
+		if ext != "" {
+			f.Filename += "." + ext
+		}
+		f.Date = &date
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// --- Projects ---
 
-	topics, err := ParseBCFZip(data)
+// ListProjects returns the projects an account is an active member of.
+func (s *Service) ListProjects(ctx context.Context, accountID string) ([]Project, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT pr.id, pr.name, bool_or(p.project_accepted AND p.account_accepted)
+		FROM core_project pr
+		JOIN iam_profile p ON p.project_id = pr.id
+		JOIN iam_ident i ON i.id = p.ident_id
+		WHERE i.account_id = $1 AND p.active = true AND p.removed = false
+		GROUP BY pr.id, pr.name
+		ORDER BY pr.name`, accountID)
 	if err != nil {
-		return 0, fmt.Errorf("parse BCF: %w", err)
+		return nil, fmt.Errorf("query projects: %w", err)
 	}
+	defer rows.Close()
 
-	count := 0
-	for _, imported := range topics {
-		req := CreateTopicRequest{
-			Title:       imported.Title,
-			Description: imported.Description,
-			Priority:    imported.Priority,
-			TopicType:   imported.TopicType,
+	projects := []Project{}
+	for rows.Next() {
+		var p Project
+		if err := rows.Scan(&p.ID, &p.Name, &p.Writable); err != nil {
+			return nil, fmt.Errorf("scan project: %w", err)
 		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// ListMembers returns the active members of a project.
+func (s *Service) ListMembers(ctx context.Context, projectID string) ([]Member, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, i.email, p.name
+		FROM iam_profile p
+		JOIN iam_ident i ON i.id = p.ident_id
+		WHERE p.project_id = $1 AND p.active = true AND p.removed = false
+		ORDER BY i.email`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("query members: %w", err)
+	}
+	defer rows.Close()
 
-		if len(imported.Viewpoints) > 0 {
-			vp := imported.Viewpoints[0]
-			req.Viewpoint = &CreateViewpointRequest{
-				CameraType:      vp.CameraType,
-				CameraPosition:  vp.CameraPosition,
-				CameraDirection: vp.CameraDirection,
-				CameraUp:        vp.CameraUp,
-				FieldOfView:     vp.FieldOfView,
-				ViewWorldScale:  vp.ViewWorldScale,
-				SnapshotBase64:  vp.SnapshotBase64,
-				Components:      vp.Components,
-				ClippingPlanes:  vp.ClippingPlanes,
-			}
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.ProfileID, &m.Email, &m.Name); err != nil {
+			return nil, fmt.Errorf("scan member: %w", err)
 		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
 
-		_, err := s.CreateTopic(ctx, projectID, importerID, req)
+// profileByEmail looks up the project profile of the account owning an
+// email address, or returns nil when it is not a member of the project.
+func (s *Service) profileByEmail(ctx context.Context, projectID, email string) (*string, error) {
+	var id string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT p.id FROM iam_ident e
+		JOIN iam_ident i ON i.account_id = e.account_id
+		JOIN iam_profile p ON p.ident_id = i.id
+		WHERE lower(e.email) = $1 AND p.project_id = $2 AND p.removed = false
+		ORDER BY p.active DESC
+		LIMIT 1`,
+		strings.ToLower(strings.TrimSpace(email)), projectID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("look up %s: %w", email, err)
+	}
+	return &id, nil
+}
+
+// --- BCF Export/Import ---
+
+// ExportBCF writes the project's topics to a BCF file of the given version.
+func (s *Service) ExportBCF(ctx context.Context, projectID, version string) ([]byte, error) {
+	topics, err := s.ListTopics(ctx, projectID, TopicFilters{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch full data for each topic
+	var fullTopics []Topic
+	for _, t := range topics {
+		full, err := s.GetTopic(ctx, t.ID)
 		if err != nil {
 			continue
 		}
-		count++
+		fullTopics = append(fullTopics, *full)
+	}
+
+	documents, err := s.listDocuments(ctx, projectID)
+	if err != nil {
+		return nil, err
 	}
 
-	return count, nil
+	return ExportBCFZip(&Archive{Version: version, Topics: fullTopics, Documents: documents})
 }
@@ -2,44 +2,60 @@ package collab
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 )
 
+// ErrUnsupportedVersion is returned for BCF files of a version other than
+// 2.0, 2.1 or 3.0.
+var ErrUnsupportedVersion = errors.New("unsupported BCF version")
+
 // Topic represents a BCF topic (issue/request).
 type Topic struct {
-	ID             string      `json:"id"`
-	GUID           string      `json:"guid"`
-	Title          string      `json:"title"`
-	Description    *string     `json:"description,omitempty"`
-	Priority       *string     `json:"priority,omitempty"`
-	TopicType      *string     `json:"topicType,omitempty"`
-	TopicStatus    string      `json:"topicStatus"`
-	Stage          *string     `json:"stage,omitempty"`
-	AssignedTo     *string     `json:"assignedTo,omitempty"`
-	AssignedToName *string     `json:"assignedToName,omitempty"`
-	DueDate        *string     `json:"dueDate,omitempty"`
-	Labels         []string    `json:"labels,omitempty"`
-	ProjectID      string      `json:"projectId"`
-	CreatorID      string      `json:"creatorId"`
-	CreatorName    *string     `json:"creatorName,omitempty"`
-	ModifiedBy     *string     `json:"modifiedBy,omitempty"`
-	Viewpoints     []Viewpoint `json:"viewpoints,omitempty"`
-	Comments       []Comment   `json:"comments,omitempty"`
-	FileVersionIDs []string    `json:"fileVersionIds,omitempty"`
-	CreatedAt      time.Time   `json:"createdAt"`
-	UpdatedAt      time.Time   `json:"updatedAt"`
+	ID                 string              `json:"id"`
+	GUID               string              `json:"guid"`
+	Title              string              `json:"title"`
+	Description        *string             `json:"description,omitempty"`
+	Priority           *string             `json:"priority,omitempty"`
+	TopicType          *string             `json:"topicType,omitempty"`
+	TopicStatus        string              `json:"topicStatus"`
+	Stage              *string             `json:"stage,omitempty"`
+	AssignedTo         *string             `json:"assignedTo,omitempty"`
+	AssignedToName     *string             `json:"assignedToName,omitempty"`
+	AssignedToEmail    *string             `json:"assignedToEmail,omitempty"`
+	DueDate            *string             `json:"dueDate,omitempty"`
+	Labels             []string            `json:"labels,omitempty"`
+	ReferenceLinks     []string            `json:"referenceLinks,omitempty"`
+	ProjectID          string              `json:"projectId"`
+	CreatorID          string              `json:"creatorId"`
+	CreatorName        *string             `json:"creatorName,omitempty"`
+	CreationAuthor     *string             `json:"creationAuthor,omitempty"`
+	ModifiedBy         *string             `json:"modifiedBy,omitempty"`
+	ModifiedAuthor     *string             `json:"modifiedAuthor,omitempty"`
+	Viewpoints         []Viewpoint         `json:"viewpoints,omitempty"`
+	Comments           []Comment           `json:"comments,omitempty"`
+	DocumentReferences []DocumentReference `json:"documentReferences,omitempty"`
+	Files              []TopicFile         `json:"files,omitempty"`
+	FileVersionIDs     []string            `json:"fileVersionIds,omitempty"`
+	CreatedAt          time.Time           `json:"createdAt"`
+	UpdatedAt          time.Time           `json:"updatedAt"`
 }
 
 // Comment represents a BCF comment on a topic.
 type Comment struct {
-	ID          string    `json:"id"`
-	Body        string    `json:"body"`
-	ViewpointID *string   `json:"viewpointId,omitempty"`
-	TopicID     string    `json:"topicId"`
-	AuthorID    string    `json:"authorId"`
-	AuthorName  *string   `json:"authorName,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID             string    `json:"id"`
+	GUID           string    `json:"guid"`
+	Body           string    `json:"body"`
+	ViewpointID    *string   `json:"viewpointId,omitempty"`
+	ViewpointGUID  *string   `json:"viewpointGuid,omitempty"`
+	TopicID        string    `json:"topicId"`
+	AuthorID       string    `json:"authorId"`
+	AuthorName     *string   `json:"authorName,omitempty"`
+	Author         *string   `json:"author,omitempty"`
+	ModifiedBy     *string   `json:"modifiedBy,omitempty"`
+	ModifiedAuthor *string   `json:"modifiedAuthor,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
 // Viewpoint represents a BCF viewpoint (camera state + component visibility).
@@ -53,6 +69,7 @@ type Viewpoint struct {
 	CameraUp        Vector3          `json:"cameraUp"`
 	FieldOfView     *float64         `json:"fieldOfView,omitempty"`
 	ViewWorldScale  *float64         `json:"viewWorldScale,omitempty"`
+	AspectRatio     *float64         `json:"aspectRatio,omitempty"`
 	SnapshotBase64  *string          `json:"snapshotBase64,omitempty"`
 	Components      *json.RawMessage `json:"components,omitempty"`
 	ClippingPlanes  *json.RawMessage `json:"clippingPlanes,omitempty"`
@@ -60,6 +77,62 @@ type Viewpoint struct {
 	CreatedAt       time.Time        `json:"createdAt"`
 }
 
+// DocumentReference links a topic to a project document or an external URL.
+type DocumentReference struct {
+	GUID         string  `json:"guid"`
+	DocumentGUID *string `json:"documentGuid,omitempty"`
+	URL          *string `json:"url,omitempty"`
+	Description  *string `json:"description,omitempty"`
+}
+
+// Document is a file kept with a project's topics, as shipped in the
+// Documents folder of a BCF 3.0 file.
+type Document struct {
+	GUID        string  `json:"guid"`
+	Filename    string  `json:"filename"`
+	Description *string `json:"description,omitempty"`
+	Data        []byte  `json:"-"`
+}
+
+// TopicFile is a model file a topic applies to, listed in the header of the
+// BCF markup.
+type TopicFile struct {
+	FileVersionID string     `json:"fileVersionId"`
+	Filename      string     `json:"filename"`
+	Date          *time.Time `json:"date,omitempty"`
+	IfcProject    *string    `json:"ifcProject,omitempty"`
+}
+
+// TopicEvent is a change made to a topic by one user at one time.
+type TopicEvent struct {
+	TopicGUID string             `json:"topicGuid"`
+	Date      time.Time          `json:"date"`
+	AuthorID  *string            `json:"authorId,omitempty"`
+	Author    *string            `json:"author,omitempty"`
+	Actions   []TopicEventAction `json:"actions"`
+}
+
+// TopicEventAction is one changed field of a TopicEvent. Type is the BCF
+// API event type, such as "status_updated" or "add_label".
+type TopicEventAction struct {
+	Type  string  `json:"type"`
+	Value *string `json:"value,omitempty"`
+}
+
+// Project is a project as offered to BCF clients.
+type Project struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Writable bool   `json:"writable"`
+}
+
+// Member is an active member of a project.
+type Member struct {
+	ProfileID string `json:"profileId"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+}
+
 // Vector3 is a 3D coordinate.
 type Vector3 struct {
 	X float64 `json:"x"`
@@ -74,34 +147,83 @@ type TopicFilters struct {
 	AssignedTo string
 }
 
-// CreateTopicRequest is the request body for creating a topic.
+// CreateTopicRequest is the request body for creating a topic. When
+// updating, nil fields are left as they are and empty strings clear them.
 type CreateTopicRequest struct {
-	Title          string   `json:"title"`
-	Description    *string  `json:"description,omitempty"`
-	Priority       *string  `json:"priority,omitempty"`
-	TopicType      *string  `json:"topicType,omitempty"`
-	AssignedTo     *string  `json:"assignedTo,omitempty"`
-	DueDate        *string  `json:"dueDate,omitempty"`
-	Labels         []string `json:"labels,omitempty"`
-	FileVersionIDs []string `json:"fileVersionIds,omitempty"`
+	GUID           *string                 `json:"guid,omitempty"`
+	Title          string                  `json:"title"`
+	Description    *string                 `json:"description,omitempty"`
+	Priority       *string                 `json:"priority,omitempty"`
+	TopicType      *string                 `json:"topicType,omitempty"`
+	TopicStatus    *string                 `json:"topicStatus,omitempty"`
+	Stage          *string                 `json:"stage,omitempty"`
+	AssignedTo     *string                 `json:"assignedTo,omitempty"`
+	DueDate        *string                 `json:"dueDate,omitempty"`
+	Labels         []string                `json:"labels,omitempty"`
+	ReferenceLinks []string                `json:"referenceLinks,omitempty"`
+	FileVersionIDs []string                `json:"fileVersionIds,omitempty"`
 	Viewpoint      *CreateViewpointRequest `json:"viewpoint,omitempty"`
 }
 
 // CreateCommentRequest is the request body for creating a comment.
 type CreateCommentRequest struct {
+	GUID        *string `json:"guid,omitempty"`
 	Body        string  `json:"body"`
 	ViewpointID *string `json:"viewpointId,omitempty"`
 }
 
 // CreateViewpointRequest is the request body for creating a viewpoint.
 type CreateViewpointRequest struct {
+	GUID            *string          `json:"guid,omitempty"`
 	CameraType      string           `json:"cameraType"`
 	CameraPosition  Vector3          `json:"cameraPosition"`
 	CameraDirection Vector3          `json:"cameraDirection"`
 	CameraUp        Vector3          `json:"cameraUp"`
 	FieldOfView     *float64         `json:"fieldOfView,omitempty"`
 	ViewWorldScale  *float64         `json:"viewWorldScale,omitempty"`
+	AspectRatio     *float64         `json:"aspectRatio,omitempty"`
 	SnapshotBase64  *string          `json:"snapshotBase64,omitempty"`
 	Components      *json.RawMessage `json:"components,omitempty"`
 	ClippingPlanes  *json.RawMessage `json:"clippingPlanes,omitempty"`
+	Lines           *json.RawMessage `json:"lines,omitempty"`
+}
+
+// Components is the JSON stored in Viewpoint.Components, in the shape the
+// web viewer reads and writes.
+type Components struct {
+	Selection  []Component          `json:"selection,omitempty"`
+	Visibility *ComponentVisibility `json:"visibility,omitempty"`
+	Coloring   []ComponentColoring  `json:"coloring,omitempty"`
+}
+
+// Component identifies one IFC element by GlobalId.
+type Component struct {
+	IfcGuid           string `json:"ifcGuid"`
+	OriginatingSystem string `json:"originatingSystem,omitempty"`
+	AuthoringToolID   string `json:"authoringToolId,omitempty"`
+}
+
+// ComponentVisibility hides or shows everything except the exceptions.
+type ComponentVisibility struct {
+	DefaultVisibility bool        `json:"defaultVisibility"`
+	Exceptions        []Component `json:"exceptions"`
+}
+
+// ComponentColoring paints components in a color such as "FF0000".
+type ComponentColoring struct {
+	Color      string      `json:"color"`
+	Components []Component `json:"components"`
+}
+
+// ClippingPlane is one entry of Viewpoint.ClippingPlanes: the plane through
+// Location, clipping away the side Direction points to.
+type ClippingPlane struct {
+	Location  Vector3 `json:"location"`
+	Direction Vector3 `json:"direction"`
+}
+
+// Line is one entry of Viewpoint.Lines, a markup line in model coordinates.
+type Line struct {
+	Start Vector3 `json:"start"`
+	End   Vector3 `json:"end"`
 }
@@ -2,8 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
 )
 
 type Project struct {
@@ -18,13 +26,59 @@ type Folder struct {
 	ParentID *string `json:"parentId"`
 }
 
+// FolderNode is a folder in the nested tree returned by handleFolderTree.
+// Size and FileCount cover files directly in the folder; the Total fields
+// include every descendant, also those cut off by the depth limit.
+type FolderNode struct {
+	ID             string        `json:"id"`
+	Name           string        `json:"name"`
+	ParentID       *string       `json:"parentId"`
+	Depth          int           `json:"depth"`
+	FileCount      int64         `json:"fileCount"`
+	Size           int64         `json:"size"`
+	TotalFileCount int64         `json:"totalFileCount"`
+	TotalSize      int64         `json:"totalSize"`
+	Children       []*FolderNode `json:"children"`
+}
+
+type FileVersion struct {
+	ID          string  `json:"id"`
+	Number      int64   `json:"number"`
+	Size        int64   `json:"size"`
+	CreatorID   string  `json:"creatorId"`
+	CreatorName *string `json:"creatorName,omitempty"`
+	ContentHash *string `json:"contentHash"`
+	CreatedAt   string  `json:"createdAt"`
+}
+
 type File struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Ext       string `json:"ext"`
-	Size      int64  `json:"size"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	Ext             string  `json:"ext"`
+	Size            int64   `json:"size"`
+	LatestVersionID *string `json:"latestVersionId"`
+	VersionNumber   int64   `json:"versionNumber"`
+	ContentHash     *string `json:"contentHash"`
+	UploaderID      *string `json:"uploaderId,omitempty"`
+	UploaderName    *string `json:"uploaderName,omitempty"`
+	CreatedAt       string  `json:"createdAt"`
+	UpdatedAt       string  `json:"updatedAt"`
+	ModifiedAt      string  `json:"modifiedAt"`
+}
+
+// fileSorts maps the ?sort= values of handleListFiles to the SQL expression
+// ordered on and the type its cursor value is cast back to.
+var fileSorts = map[string]struct{ expr, typ string }{
+	"name": {"lower(f.name)", "text"},
+	"size": {"COALESCE(lv.size, 0)", "bigint"},
+	"date": {"COALESCE(lv.created_at, f.updated_at)", "timestamp"},
+}
+
+// fileCursor is the opaque keyset position passed back as ?cursor=.
+type fileCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
 }
 
 func handleListProjects(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -58,7 +112,7 @@ func handleListFolders(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	rows, err := db.QueryContext(r.Context(),
-		`SELECT id, name, parent_id FROM arca_folder WHERE project_id = $1 ORDER BY name`, projectID)
+		`SELECT id, name, parent_id FROM arca_folder WHERE project_id = $1 AND deleted_at IS NULL ORDER BY name`, projectID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -79,20 +133,210 @@ func handleListFolders(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	json.NewEncoder(w).Encode(folders)
 }
 
-func handleListFiles(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// handleFolderTree returns the project's folders as a nested tree with file
+// counts and byte sizes (latest version of each file). Optional ?depth=N
+// limits the returned levels; 0 returns only top-level folders.
+func handleFolderTree(w http.ResponseWriter, r *http.Request, db *sql.DB, sessions *auth.SessionStore) {
+	projectID := r.PathValue("projectId")
+	if projectID == "" {
+		http.Error(w, "missing projectId", http.StatusBadRequest)
+		return
+	}
+	if !requireProjectMember(w, r, sessions, projectID) {
+		return
+	}
+
+	maxDepth := -1
+	if v := r.URL.Query().Get("depth"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid depth", http.StatusBadRequest)
+			return
+		}
+		maxDepth = d
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		WITH RECURSIVE tree AS (
+			SELECT id, name, parent_id, 0 AS depth, ARRAY[id] AS path
+			FROM arca_folder
+			WHERE project_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
+			UNION ALL
+			SELECT f.id, f.name, f.parent_id, t.depth + 1, t.path || f.id
+			FROM arca_folder f
+			JOIN tree t ON f.parent_id = t.id
+			WHERE NOT f.id = ANY(t.path) AND f.deleted_at IS NULL
+		),
+		latest AS (
+			SELECT DISTINCT ON (fv.file_id) fv.file_id, fv.size
+			FROM arca_file_version fv
+			JOIN arca_folder_file ff ON ff.file_id = fv.file_id
+			JOIN tree t ON t.id = ff.folder_id
+			JOIN arca_file f ON f.id = fv.file_id AND f.deleted_at IS NULL
+			ORDER BY fv.file_id, fv.number DESC
+		),
+		direct AS (
+			SELECT ff.folder_id, COUNT(*) AS file_count, COALESCE(SUM(l.size), 0) AS size
+			FROM arca_folder_file ff
+			JOIN tree t ON t.id = ff.folder_id
+			JOIN arca_file f ON f.id = ff.file_id AND f.deleted_at IS NULL
+			LEFT JOIN latest l ON l.file_id = ff.file_id
+			GROUP BY ff.folder_id
+		)
+		SELECT t.id, t.name, t.parent_id, t.depth,
+		       COALESCE(d.file_count, 0), COALESCE(d.size, 0),
+		       COALESCE(agg.file_count, 0), COALESCE(agg.size, 0)
+		FROM tree t
+		LEFT JOIN direct d ON d.folder_id = t.id
+		LEFT JOIN LATERAL (
+			SELECT SUM(d2.file_count) AS file_count, SUM(d2.size) AS size
+			FROM tree t2
+			JOIN direct d2 ON d2.folder_id = t2.id
+			WHERE t.id = ANY(t2.path)
+		) agg ON true
+		WHERE $2 < 0 OR t.depth <= $2
+		ORDER BY t.depth, lower(t.name)`, projectID, maxDepth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	roots := []*FolderNode{}
+	byID := make(map[string]*FolderNode)
+	for rows.Next() {
+		n := &FolderNode{Children: []*FolderNode{}}
+		if err := rows.Scan(&n.ID, &n.Name, &n.ParentID, &n.Depth,
+			&n.FileCount, &n.Size, &n.TotalFileCount, &n.TotalSize); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		byID[n.ID] = n
+
+		// Rows are ordered by depth, so a parent is always seen first
+		if n.ParentID != nil {
+			if parent, ok := byID[*n.ParentID]; ok {
+				parent.Children = append(parent.Children, n)
+				continue
+			}
+		}
+		roots = append(roots, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
+// handleListFiles returns each file in a folder once, with its latest version.
+//
+// Query parameters:
+//   - sort: name (default), size or date (latest version upload time)
+//   - order: asc (default) or desc
+//   - ext: comma-separated extensions to include, e.g. ifc,pdf
+//   - limit: page size, default 200, max 1000
+//   - cursor: value from the X-Next-Cursor header of the previous page
+//
+// The body stays a plain array; X-Next-Cursor is set when more rows exist.
+func handleListFiles(w http.ResponseWriter, r *http.Request, db *sql.DB, sessions *auth.SessionStore) {
+	projectID := r.PathValue("projectId")
 	folderID := r.PathValue("folderId")
 	if folderID == "" {
 		http.Error(w, "missing folderId", http.StatusBadRequest)
 		return
 	}
+	if !requireProjectMember(w, r, sessions, projectID) {
+		return
+	}
 
-	rows, err := db.QueryContext(r.Context(), `
-		SELECT f.id, f.name, COALESCE(f.ext,''), COALESCE(fv.size, 0), f.created_at, f.updated_at
+	var exists bool
+	err := db.QueryRowContext(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM arca_folder WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL)`,
+		folderID, projectID).Scan(&exists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	sortKey := q.Get("sort")
+	if sortKey == "" {
+		sortKey = "name"
+	}
+	sortBy, ok := fileSorts[sortKey]
+	if !ok {
+		http.Error(w, "invalid sort", http.StatusBadRequest)
+		return
+	}
+	dir, cmp := "ASC", ">"
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		dir, cmp = "DESC", "<"
+	default:
+		http.Error(w, "invalid order", http.StatusBadRequest)
+		return
+	}
+	limit := 200
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	query := `
+		SELECT f.id, f.name, COALESCE(f.ext, ''), COALESCE(lv.size, 0), f.created_at, f.updated_at,
+		       COALESCE(lv.created_at, f.updated_at), lv.id, COALESCE(lv.number, 0), lv.content_hash, lv.creator_id, p.name,
+		       (` + sortBy.expr + `)::text
 		FROM arca_file f
 		JOIN arca_folder_file ff ON ff.file_id = f.id
-		LEFT JOIN arca_file_version fv ON fv.file_id = f.id
-		WHERE ff.folder_id = $1
-		ORDER BY f.name`, folderID)
+		LEFT JOIN LATERAL (
+			SELECT id, number, size, created_at, creator_id, content_hash
+			FROM arca_file_version
+			WHERE file_id = f.id
+			ORDER BY number DESC
+			LIMIT 1
+		) lv ON true
+		LEFT JOIN iam_profile p ON p.id = lv.creator_id
+		WHERE ff.folder_id = $1 AND f.deleted_at IS NULL`
+	args := []interface{}{folderID}
+
+	if v := q.Get("ext"); v != "" {
+		var exts []string
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), ".")); e != "" {
+				exts = append(exts, e)
+			}
+		}
+		args = append(args, pq.Array(exts))
+		query += fmt.Sprintf(" AND lower(COALESCE(f.ext, '')) = ANY($%d)", len(args))
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		var c fileCursor
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(raw, &c)
+		}
+		if err != nil || c.Sort != sortKey {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		args = append(args, c.Value, c.ID)
+		query += fmt.Sprintf(" AND (%s, f.id) %s ($%d::%s, $%d::uuid)",
+			sortBy.expr, cmp, len(args)-1, sortBy.typ, len(args))
+	}
+
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY %s %s, f.id %s LIMIT $%d", sortBy.expr, dir, dir, len(args))
+
+	rows, err := db.QueryContext(r.Context(), query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -100,13 +344,25 @@ func handleListFiles(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	defer rows.Close()
 
 	files := []File{}
+	var lastSortValue string
 	for rows.Next() {
 		var f File
-		if err := rows.Scan(&f.ID, &f.Name, &f.Ext, &f.Size, &f.CreatedAt, &f.UpdatedAt); err != nil {
+		var sortValue string
+		if err := rows.Scan(&f.ID, &f.Name, &f.Ext, &f.Size, &f.CreatedAt, &f.UpdatedAt,
+			&f.ModifiedAt, &f.LatestVersionID, &f.VersionNumber, &f.ContentHash, &f.UploaderID, &f.UploaderName,
+			&sortValue); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if len(files) == limit {
+			// The extra row only signals that another page exists
+			last := files[len(files)-1]
+			raw, _ := json.Marshal(fileCursor{Sort: sortKey, Value: lastSortValue, ID: last.ID})
+			w.Header().Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString(raw))
+			break
+		}
 		files = append(files, f)
+		lastSortValue = sortValue
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -131,3 +387,64 @@ func handleGetProject(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(p)
 }
+
+func handleListFileVersions(w http.ResponseWriter, r *http.Request, db *sql.DB, sessions *auth.SessionStore) {
+	fileID := r.PathValue("fileId")
+	if fileID == "" {
+		http.Error(w, "missing fileId", http.StatusBadRequest)
+		return
+	}
+	if !requireAccount(w, r) {
+		return
+	}
+
+	var projectID string
+	err := db.QueryRowContext(r.Context(), `
+		SELECT fo.project_id FROM arca_folder_file ff
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		JOIN arca_file f ON f.id = ff.file_id AND f.deleted_at IS NULL
+		WHERE ff.file_id = $1
+		LIMIT 1`, fileID).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requireProjectMember(w, r, sessions, projectID) {
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT fv.id, fv.number, fv.size, fv.creator_id, fv.created_at, p.name, fv.content_hash
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id AND f.deleted_at IS NULL
+		LEFT JOIN iam_profile p ON p.id = fv.creator_id
+		WHERE fv.file_id = $1
+		ORDER BY fv.number DESC`, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []FileVersion{}
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(&v.ID, &v.Number, &v.Size, &v.CreatorID, &v.CreatedAt, &v.CreatorName, &v.ContentHash); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		versions = append(versions, v)
+	}
+
+	if len(versions) == 0 {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
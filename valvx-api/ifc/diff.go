@@ -0,0 +1,274 @@
+package ifc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Changed attributes reported in AttributeChange.Attribute.
+const (
+	ChangeName      = "name"
+	ChangeType      = "type"
+	ChangeProperty  = "property"
+	ChangePlacement = "placement"
+)
+
+// VersionDiff compares two versions of a file by IFC GlobalId.
+type VersionDiff struct {
+	FromVersionID string          `json:"fromVersionId"`
+	ToVersionID   string          `json:"toVersionId"`
+	ComputedAt    time.Time       `json:"computedAt"`
+	Summary       DiffSummary     `json:"summary"`
+	Added         []ElementRef    `json:"added"`
+	Deleted       []ElementRef    `json:"deleted"`
+	Modified      []ElementChange `json:"modified"`
+}
+
+// DiffSummary counts elements per outcome.
+type DiffSummary struct {
+	Added     int `json:"added"`
+	Deleted   int `json:"deleted"`
+	Modified  int `json:"modified"`
+	Unchanged int `json:"unchanged"`
+}
+
+// ElementRef identifies an element in a diff.
+type ElementRef struct {
+	GlobalID   string  `json:"globalId"`
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	StoreyName *string `json:"storeyName"`
+}
+
+// ElementChange is an element present in both versions with differences.
+// The ref describes the element as it is in the newer version.
+type ElementChange struct {
+	ElementRef
+	Changes []AttributeChange `json:"changes"`
+}
+
+// AttributeChange is one changed attribute. Pset and Property are set for
+// property changes; From or To is null when the property was added or
+// removed.
+type AttributeChange struct {
+	Attribute string      `json:"attribute"`
+	Pset      string      `json:"pset,omitempty"`
+	Property  string      `json:"property,omitempty"`
+	From      interface{} `json:"from"`
+	To        interface{} `json:"to"`
+}
+
+// diffable reports whether elements of a type take part in a comparison.
+// Relationships and property definitions get new GlobalIds on most exports
+// and their effect already shows on the objects they relate.
+func diffable(typ string) bool {
+	if strings.HasPrefix(typ, "IFCREL") {
+		return false
+	}
+	switch typ {
+	case "IFCPROPERTYSET", "IFCELEMENTQUANTITY", "IFCPROPERTYSETTEMPLATE",
+		"IFCSIMPLEPROPERTYTEMPLATE", "IFCCOMPLEXPROPERTYTEMPLATE":
+		return false
+	}
+	return true
+}
+
+// CompareVersions returns the diff from one version of a file to another,
+// computing and storing it on first request.
+func (s *Service) CompareVersions(ctx context.Context, fileID, fromID, toID string) (*VersionDiff, error) {
+	if fromID == toID {
+		return nil, fmt.Errorf("%w: from and to are the same version", ErrInvalid)
+	}
+	for _, id := range []string{fromID, toID} {
+		var ok bool
+		err := s.DB.QueryRowContext(ctx,
+			`SELECT file_id = $2 FROM arca_file_version WHERE id = $1`, id, fileID).Scan(&ok)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: file version %s", ErrNotFound, id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get file version: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: version %s is not a version of file %s", ErrInvalid, id, fileID)
+		}
+		if err := s.requireIndexed(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	var raw []byte
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT result FROM arca_ifc_diff WHERE from_version_id = $1 AND to_version_id = $2`,
+		fromID, toID).Scan(&raw)
+	if err == nil {
+		var d VersionDiff
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, fmt.Errorf("decode stored diff: %w", err)
+		}
+		return &d, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("get stored diff: %w", err)
+	}
+
+	from, err := s.diffElements(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.diffElements(ctx, toID)
+	if err != nil {
+		return nil, err
+	}
+	d := diffElements(from, to)
+	d.FromVersionID = fromID
+	d.ToVersionID = toID
+	d.ComputedAt = time.Now().UTC()
+
+	raw, err = json.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("encode diff: %w", err)
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO arca_ifc_diff (from_version_id, to_version_id, result, computed_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (from_version_id, to_version_id) DO NOTHING`,
+		fromID, toID, raw, d.ComputedAt)
+	if err != nil {
+		return nil, fmt.Errorf("store diff: %w", err)
+	}
+	return d, nil
+}
+
+// diffElements loads the comparable elements of a version by GlobalId. When
+// a GlobalId is duplicated, the first instance in the file wins.
+func (s *Service) diffElements(ctx context.Context, fileVersionID string) (map[string]*Element, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT global_id, type, COALESCE(name, ''), storey_name, psets, placement
+		FROM arca_ifc_element
+		WHERE file_version_id = $1
+		ORDER BY step_id`, fileVersionID)
+	if err != nil {
+		return nil, fmt.Errorf("query elements: %w", err)
+	}
+	defer rows.Close()
+
+	elements := make(map[string]*Element)
+	for rows.Next() {
+		var el Element
+		var psets, placement []byte
+		if err := rows.Scan(&el.GlobalID, &el.Type, &el.Name, &el.StoreyName, &psets, &placement); err != nil {
+			return nil, fmt.Errorf("scan element: %w", err)
+		}
+		if !diffable(el.Type) || elements[el.GlobalID] != nil {
+			continue
+		}
+		if psets != nil {
+			if err := json.Unmarshal(psets, &el.Psets); err != nil {
+				return nil, fmt.Errorf("decode psets of %s: %w", el.GlobalID, err)
+			}
+		}
+		if placement != nil {
+			if err := json.Unmarshal(placement, &el.Placement); err != nil {
+				return nil, fmt.Errorf("decode placement of %s: %w", el.GlobalID, err)
+			}
+		}
+		elements[el.GlobalID] = &el
+	}
+	return elements, rows.Err()
+}
+
+func diffElements(from, to map[string]*Element) *VersionDiff {
+	d := &VersionDiff{Added: []ElementRef{}, Deleted: []ElementRef{}, Modified: []ElementChange{}}
+	for id, b := range to {
+		a, ok := from[id]
+		if !ok {
+			d.Added = append(d.Added, refOf(b))
+			continue
+		}
+		if changes := compareElement(a, b); len(changes) > 0 {
+			d.Modified = append(d.Modified, ElementChange{ElementRef: refOf(b), Changes: changes})
+		} else {
+			d.Summary.Unchanged++
+		}
+	}
+	for id, a := range from {
+		if _, ok := to[id]; !ok {
+			d.Deleted = append(d.Deleted, refOf(a))
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].GlobalID < d.Added[j].GlobalID })
+	sort.Slice(d.Deleted, func(i, j int) bool { return d.Deleted[i].GlobalID < d.Deleted[j].GlobalID })
+	sort.Slice(d.Modified, func(i, j int) bool { return d.Modified[i].GlobalID < d.Modified[j].GlobalID })
+	d.Summary.Added = len(d.Added)
+	d.Summary.Deleted = len(d.Deleted)
+	d.Summary.Modified = len(d.Modified)
+	return d
+}
+
+func refOf(el *Element) ElementRef {
+	return ElementRef{GlobalID: el.GlobalID, Type: el.Type, Name: el.Name, StoreyName: el.StoreyName}
+}
+
+func compareElement(a, b *Element) []AttributeChange {
+	var changes []AttributeChange
+	if a.Name != b.Name {
+		changes = append(changes, AttributeChange{Attribute: ChangeName, From: a.Name, To: b.Name})
+	}
+	if a.Type != b.Type {
+		changes = append(changes, AttributeChange{Attribute: ChangeType, From: a.Type, To: b.Type})
+	}
+
+	for _, pset := range unionKeys(a.Psets, b.Psets) {
+		pa, pb := a.Psets[pset], b.Psets[pset]
+		for _, prop := range unionKeys(pa, pb) {
+			va, okA := pa[prop]
+			vb, okB := pb[prop]
+			if okA == okB && valuesEqual(va, vb) {
+				continue
+			}
+			changes = append(changes, AttributeChange{
+				Attribute: ChangeProperty, Pset: pset, Property: prop, From: va, To: vb,
+			})
+		}
+	}
+
+	if !a.Placement.Equal(b.Placement) {
+		changes = append(changes, AttributeChange{Attribute: ChangePlacement, From: a.Placement, To: b.Placement})
+	}
+	return changes
+}
+
+// unionKeys returns the keys of both maps in sorted order.
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valuesEqual compares decoded property values, treating numbers that
+// differ only by export rounding as equal.
+func valuesEqual(a, b interface{}) bool {
+	fa, okA := a.(float64)
+	fb, okB := b.(float64)
+	if okA && okB {
+		return math.Abs(fa-fb) <= placementTolerance*max(1, math.Abs(fa), math.Abs(fb))
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,124 @@
+package ifc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffElements(t *testing.T) {
+	storey := "Plan 1"
+	at := func(x float64) *Placement {
+		return &Placement{Location: [3]float64{x, 0, 0}, XAxis: [3]float64{1, 0, 0}, ZAxis: [3]float64{0, 0, 1}}
+	}
+	from := map[string]*Element{
+		"kept": {GlobalID: "kept", Type: "IFCWALL", Name: "W1", Placement: at(1),
+			Psets: map[string]map[string]interface{}{"Pset_WallCommon": {"IsExternal": true, "FireRating": "EI60"}}},
+		"renamed": {GlobalID: "renamed", Type: "IFCDOOR", Name: "D1"},
+		"moved":   {GlobalID: "moved", Type: "IFCSLAB", Name: "S1", Placement: at(0)},
+		"rounded": {GlobalID: "rounded", Type: "IFCBEAM", Name: "B1", Placement: at(1000),
+			Psets: map[string]map[string]interface{}{"Dims": {"Length": 2.5}}},
+		"props": {GlobalID: "props", Type: "IFCWALL", Name: "W2",
+			Psets: map[string]map[string]interface{}{"Pset_WallCommon": {"IsExternal": false, "Removed": "x"}}},
+		"gone": {GlobalID: "gone", Type: "IFCCOLUMN", Name: "C1"},
+	}
+	to := map[string]*Element{
+		"kept": {GlobalID: "kept", Type: "IFCWALL", Name: "W1", Placement: at(1),
+			Psets: map[string]map[string]interface{}{"Pset_WallCommon": {"IsExternal": true, "FireRating": "EI60"}}},
+		"renamed": {GlobalID: "renamed", Type: "IFCDOOR", Name: "D1b", StoreyName: &storey},
+		"moved":   {GlobalID: "moved", Type: "IFCSLAB", Name: "S1", Placement: at(0.5)},
+		"rounded": {GlobalID: "rounded", Type: "IFCBEAM", Name: "B1", Placement: at(1000.0000001),
+			Psets: map[string]map[string]interface{}{"Dims": {"Length": 2.5000000001}}},
+		"props": {GlobalID: "props", Type: "IFCWALL", Name: "W2",
+			Psets: map[string]map[string]interface{}{
+				"Pset_WallCommon": {"IsExternal": true, "Added": nil},
+				"Pset_New":        {"Code": "A"},
+			}},
+		"new": {GlobalID: "new", Type: "IFCWINDOW", Name: "F1"},
+	}
+
+	d := diffElements(from, to)
+
+	wantSummary := DiffSummary{Added: 1, Deleted: 1, Modified: 3, Unchanged: 2}
+	if d.Summary != wantSummary {
+		t.Errorf("Summary = %+v, want %+v", d.Summary, wantSummary)
+	}
+	if want := []ElementRef{{GlobalID: "new", Type: "IFCWINDOW", Name: "F1"}}; !reflect.DeepEqual(d.Added, want) {
+		t.Errorf("Added = %+v", d.Added)
+	}
+	if want := []ElementRef{{GlobalID: "gone", Type: "IFCCOLUMN", Name: "C1"}}; !reflect.DeepEqual(d.Deleted, want) {
+		t.Errorf("Deleted = %+v", d.Deleted)
+	}
+
+	wantModified := []ElementChange{
+		{
+			ElementRef: ElementRef{GlobalID: "moved", Type: "IFCSLAB", Name: "S1"},
+			Changes:    []AttributeChange{{Attribute: ChangePlacement, From: at(0), To: at(0.5)}},
+		},
+		{
+			ElementRef: ElementRef{GlobalID: "props", Type: "IFCWALL", Name: "W2"},
+			Changes: []AttributeChange{
+				{Attribute: ChangeProperty, Pset: "Pset_New", Property: "Code", To: "A"},
+				{Attribute: ChangeProperty, Pset: "Pset_WallCommon", Property: "Added"},
+				{Attribute: ChangeProperty, Pset: "Pset_WallCommon", Property: "IsExternal", From: false, To: true},
+				{Attribute: ChangeProperty, Pset: "Pset_WallCommon", Property: "Removed", From: "x"},
+			},
+		},
+		{
+			// The ref describes the newer version
+			ElementRef: ElementRef{GlobalID: "renamed", Type: "IFCDOOR", Name: "D1b", StoreyName: &storey},
+			Changes:    []AttributeChange{{Attribute: ChangeName, From: "D1", To: "D1b"}},
+		},
+	}
+	if !reflect.DeepEqual(d.Modified, wantModified) {
+		t.Errorf("Modified =\n%+v\nwant\n%+v", d.Modified, wantModified)
+	}
+}
+
+func TestDiffElementsTypeChange(t *testing.T) {
+	from := map[string]*Element{"a": {GlobalID: "a", Type: "IFCWALL", Name: "x"}}
+	to := map[string]*Element{"a": {GlobalID: "a", Type: "IFCWALLSTANDARDCASE", Name: "x"}}
+
+	d := diffElements(from, to)
+	want := []AttributeChange{{Attribute: ChangeType, From: "IFCWALL", To: "IFCWALLSTANDARDCASE"}}
+	if len(d.Modified) != 1 || !reflect.DeepEqual(d.Modified[0].Changes, want) {
+		t.Errorf("Modified = %+v, want one type change", d.Modified)
+	}
+}
+
+func TestPlacementEqual(t *testing.T) {
+	p := &Placement{Location: [3]float64{12000, 0, 3}, XAxis: [3]float64{1, 0, 0}, ZAxis: [3]float64{0, 0, 1}}
+	tests := []struct {
+		name string
+		a, b *Placement
+		want bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", p, nil, false},
+		{"same", p, &Placement{Location: p.Location, XAxis: p.XAxis, ZAxis: p.ZAxis}, true},
+		{"rounding", p, &Placement{Location: [3]float64{12000.001, 0, 3}, XAxis: p.XAxis, ZAxis: p.ZAxis}, true},
+		{"moved", p, &Placement{Location: [3]float64{12000.1, 0, 3}, XAxis: p.XAxis, ZAxis: p.ZAxis}, false},
+		{"rotated", p, &Placement{Location: p.Location, XAxis: [3]float64{0, 1, 0}, ZAxis: p.ZAxis}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Equal(tt.b); got != tt.want {
+				t.Errorf("Equal = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffable(t *testing.T) {
+	for typ, want := range map[string]bool{
+		"IFCWALL":                   true,
+		"IFCBUILDINGSTOREY":         true,
+		"IFCRELAGGREGATES":          false,
+		"IFCRELDEFINESBYPROPERTIES": false,
+		"IFCPROPERTYSET":            false,
+		"IFCELEMENTQUANTITY":        false,
+	} {
+		if got := diffable(typ); got != want {
+			t.Errorf("diffable(%s) = %v, want %v", typ, got, want)
+		}
+	}
+}
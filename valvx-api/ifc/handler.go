@@ -0,0 +1,389 @@
+package ifc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+const (
+	defaultElementLimit = 1000
+	maxElementLimit     = 10000
+)
+
+// Handler holds the IFC HTTP handler dependencies.
+type Handler struct {
+	Service      *Service
+	SessionStore *auth.SessionStore
+}
+
+// NewHandler creates a new IFC handler.
+func NewHandler(svc *Service, sessionStore *auth.SessionStore) *Handler {
+	return &Handler{Service: svc, SessionStore: sessionStore}
+}
+
+// RegisterRoutes registers IFC model query routes on the given mux.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/files/{fileVersionId}/elements", h.ListElements)
+	mux.HandleFunc("GET /api/files/{fileVersionId}/elements/{globalId}", h.GetElement)
+	mux.HandleFunc("GET /api/files/{fileVersionId}/spatial-tree", h.GetSpatialTree)
+	mux.HandleFunc("GET /api/files/{fileVersionId}/quantities", h.GetQuantities)
+	mux.HandleFunc("GET /api/files/{fileId}/versions/compare", h.CompareVersions)
+	mux.HandleFunc("POST /api/files/{fileVersionId}/validate", h.Validate)
+	mux.HandleFunc("GET /api/files/{fileVersionId}/validation", h.GetValidation)
+
+	mux.HandleFunc("GET /api/projects/{projectId}/ifc/rules", h.ListRules)
+	mux.HandleFunc("POST /api/projects/{projectId}/ifc/rules", h.CreateRule)
+	mux.HandleFunc("PATCH /api/projects/{projectId}/ifc/rules/{ruleId}", h.UpdateRule)
+	mux.HandleFunc("DELETE /api/projects/{projectId}/ifc/rules/{ruleId}", h.DeleteRule)
+}
+
+// requireVersionMember checks that the caller is a member of the project the
+// path file version belongs to.
+func (h *Handler) requireVersionMember(w http.ResponseWriter, r *http.Request) bool {
+	return h.requireVersionMemberOf(w, r, r.PathValue("fileVersionId"))
+}
+
+// requireVersionMemberOf is requireVersionMember for an explicit version.
+func (h *Handler) requireVersionMemberOf(w http.ResponseWriter, r *http.Request, fileVersionID string) bool {
+	projectID, err := h.Service.VersionProjectID(r.Context(), fileVersionID)
+	if err != nil {
+		writeError(w, err)
+		return false
+	}
+	_, ok := h.requireProfile(w, r, projectID, false)
+	return ok
+}
+
+// requireProfile resolves the caller's profile in a project, requiring write
+// access when write is set.
+func (h *Handler) requireProfile(w http.ResponseWriter, r *http.Request, projectID string, write bool) (string, bool) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	lookup := h.SessionStore.GetProfileForProject
+	if write {
+		lookup = h.SessionStore.GetWritableProfileForProject
+	}
+	profileID, err := lookup(r.Context(), accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return profileID, true
+}
+
+// ListElements returns the indexed IfcRoot instances of a file version.
+//
+// Query parameters:
+//   - type: comma-separated entity names, e.g. IfcWall,IfcSlab (exact type,
+//     subtypes are not included)
+//   - storey: storey name or GlobalId
+//   - globalId: comma-separated GlobalIds
+//   - limit: page size (default 1000, max 10000)
+//   - cursor: value from the X-Next-Cursor header of the previous page
+func (h *Handler) ListElements(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVersionMember(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	f := ElementFilter{
+		Types:     splitList(q.Get("type")),
+		Storey:    strings.TrimSpace(q.Get("storey")),
+		GlobalIDs: splitList(q.Get("globalId")),
+		Limit:     defaultElementLimit,
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxElementLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		f.After = n
+	}
+
+	// One extra row tells whether another page exists
+	limit := f.Limit
+	f.Limit++
+	elements, err := h.Service.ListElements(r.Context(), r.PathValue("fileVersionId"), f)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(elements) > limit {
+		elements = elements[:limit]
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(elements[limit-1].StepID, 10))
+	}
+
+	writeJSON(w, http.StatusOK, elements)
+}
+
+// GetElement returns one element with its property sets, base quantities
+// and placement.
+func (h *Handler) GetElement(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVersionMember(w, r) {
+		return
+	}
+
+	el, err := h.Service.GetElement(r.Context(), r.PathValue("fileVersionId"), r.PathValue("globalId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, el)
+}
+
+// GetSpatialTree returns the IfcProject → IfcSite → IfcBuilding →
+// IfcBuildingStorey → element tree computed when the version was indexed.
+// The tree only changes when the version is re-indexed, so it carries an
+// ETag for conditional requests.
+func (h *Handler) GetSpatialTree(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVersionMember(w, r) {
+		return
+	}
+
+	fileVersionID := r.PathValue("fileVersionId")
+	tree, computedAt, err := h.Service.SpatialTree(r.Context(), fileVersionID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-%d"`, fileVersionID, computedAt.UnixNano())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(tree)
+}
+
+// GetQuantities returns a quantity takeoff of the version's base quantities.
+//
+// Query parameters:
+//   - groupBy: comma-separated dimensions among type, storey, classification
+//     and property (default type,storey)
+//   - classification: classification system to group by, e.g. CoClass
+//   - property: Pset.Property to group by
+//   - format: json (default) or csv; Accept: text/csv also selects CSV
+func (h *Handler) GetQuantities(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVersionMember(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	fileVersionID := r.PathValue("fileVersionId")
+	takeoff, err := h.Service.QuantityTakeoff(r.Context(), fileVersionID, TakeoffOptions{
+		GroupBy:        splitList(q.Get("groupBy")),
+		Classification: strings.TrimSpace(q.Get("classification")),
+		Property:       strings.TrimSpace(q.Get("property")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+	switch format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, takeoff)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": "quantities-" + fileVersionID + ".csv"}))
+		if err := takeoff.WriteCSV(w); err != nil {
+			log.Printf("Quantity takeoff CSV for %s failed: %v", fileVersionID, err)
+		}
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// CompareVersions returns the element diff between two versions of a file.
+// Query parameters from and to are file version IDs; the diff describes what
+// changed going from the first to the second.
+func (h *Handler) CompareVersions(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	if !h.requireVersionMemberOf(w, r, from) {
+		return
+	}
+
+	diff, err := h.Service.CompareVersions(r.Context(), r.PathValue("fileId"), from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// Validate runs the project's rules against a version now, raising topics
+// for rules that fail and have none yet, and returns the results.
+func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
+	fileVersionID := r.PathValue("fileVersionId")
+	projectID, err := h.Service.VersionProjectID(r.Context(), fileVersionID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	profileID, ok := h.requireProfile(w, r, projectID, true)
+	if !ok {
+		return
+	}
+
+	results, err := h.Service.ValidateVersion(r.Context(), fileVersionID, profileID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// GetValidation returns the last validation results of a version.
+func (h *Handler) GetValidation(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVersionMember(w, r) {
+		return
+	}
+
+	results, err := h.Service.ValidationResults(r.Context(), r.PathValue("fileVersionId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// ListRules returns the project's validation rules.
+func (h *Handler) ListRules(w http.ResponseWriter, r *http.Request) {
+	projectID := r.PathValue("projectId")
+	if _, ok := h.requireProfile(w, r, projectID, false); !ok {
+		return
+	}
+
+	rules, err := h.Service.ListRules(r.Context(), projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// CreateRule adds a validation rule to the project.
+func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	projectID := r.PathValue("projectId")
+	profileID, ok := h.requireProfile(w, r, projectID, true)
+	if !ok {
+		return
+	}
+
+	var req CreateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.Service.CreateRule(r.Context(), projectID, profileID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateRule changes a validation rule.
+func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	projectID := r.PathValue("projectId")
+	if _, ok := h.requireProfile(w, r, projectID, true); !ok {
+		return
+	}
+
+	var req UpdateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.Service.UpdateRule(r.Context(), projectID, r.PathValue("ruleId"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// DeleteRule removes a validation rule.
+func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	projectID := r.PathValue("projectId")
+	if _, ok := h.requireProfile(w, r, projectID, true); !ok {
+		return
+	}
+
+	if err := h.Service.DeleteRule(r.Context(), projectID, r.PathValue("ruleId")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func splitList(v string) []string {
+	out := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError maps service errors to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotIndexed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
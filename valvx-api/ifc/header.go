@@ -0,0 +1,66 @@
+package ifc
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Header is the HEADER section of a STEP file: FILE_DESCRIPTION, FILE_NAME
+// and FILE_SCHEMA.
+type Header struct {
+	Description         []string `json:"description"`
+	ImplementationLevel string   `json:"implementationLevel"`
+	ViewDefinition      string   `json:"viewDefinition"`
+
+	FileName            string   `json:"fileName"`
+	TimeStamp           string   `json:"timeStamp"`
+	Authors             []string `json:"authors"`
+	Organizations       []string `json:"organizations"`
+	PreprocessorVersion string   `json:"preprocessorVersion"`
+	OriginatingSystem   string   `json:"originatingSystem"`
+	Authorization       string   `json:"authorization"`
+
+	// Schemas lists FILE_SCHEMA identifiers, e.g. IFC2X3, IFC4 or IFC4X3_ADD2.
+	Schemas []string `json:"schemas"`
+}
+
+// Schema returns the first schema identifier in upper case, or "".
+func (h *Header) Schema() string {
+	if len(h.Schemas) == 0 {
+		return ""
+	}
+	return strings.ToUpper(h.Schemas[0])
+}
+
+var viewDefinition = regexp.MustCompile(`(?i)ViewDefinition\s*\[([^\]]*)\]`)
+
+func (h *Header) apply(typ string, args []Value) {
+	switch typ {
+	case "FILE_DESCRIPTION":
+		h.Description = Strings(arg(args, 0))
+		h.ImplementationLevel = String(arg(args, 1))
+		for _, d := range h.Description {
+			if m := viewDefinition.FindStringSubmatch(d); m != nil {
+				h.ViewDefinition = strings.TrimSpace(m[1])
+				break
+			}
+		}
+	case "FILE_NAME":
+		h.FileName = String(arg(args, 0))
+		h.TimeStamp = String(arg(args, 1))
+		h.Authors = Strings(arg(args, 2))
+		h.Organizations = Strings(arg(args, 3))
+		h.PreprocessorVersion = String(arg(args, 4))
+		h.OriginatingSystem = String(arg(args, 5))
+		h.Authorization = String(arg(args, 6))
+	case "FILE_SCHEMA":
+		h.Schemas = Strings(arg(args, 0))
+	}
+}
+
+// ReadHeader parses the HEADER section of a STEP file, reading no further
+// than its ENDSEC.
+func ReadHeader(src io.Reader) (*Header, error) {
+	return NewReader(src).Header()
+}
@@ -0,0 +1,268 @@
+package ifc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// insertBatchSize bounds the rows sent in one INSERT when storing an index.
+const insertBatchSize = 5000
+
+// saveElements replaces the element index of a version. Stored comparisons
+// involving the version were computed from the old index and are dropped.
+func saveElements(ctx context.Context, tx *sql.Tx, fileVersionID string, elements []Element) error {
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM arca_ifc_element WHERE file_version_id = $1`, fileVersionID); err != nil {
+		return fmt.Errorf("clear elements: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM arca_ifc_diff WHERE from_version_id = $1 OR to_version_id = $1`, fileVersionID); err != nil {
+		return fmt.Errorf("clear diffs: %w", err)
+	}
+
+	for start := 0; start < len(elements); start += insertBatchSize {
+		batch := elements[start:min(start+insertBatchSize, len(elements))]
+		stepIDs := make([]int64, len(batch))
+		globalIDs := make([]string, len(batch))
+		types := make([]string, len(batch))
+		names := make([]string, len(batch))
+		storeyIDs := make([]sql.NullString, len(batch))
+		storeyNames := make([]sql.NullString, len(batch))
+		psets := make([]sql.NullString, len(batch))
+		quantities := make([]sql.NullString, len(batch))
+		placements := make([]sql.NullString, len(batch))
+		classifications := make([]sql.NullString, len(batch))
+		for i, el := range batch {
+			stepIDs[i] = int64(el.StepID)
+			globalIDs[i] = el.GlobalID
+			types[i] = el.Type
+			names[i] = el.Name
+			if el.StoreyGlobalID != nil {
+				storeyIDs[i] = sql.NullString{String: *el.StoreyGlobalID, Valid: true}
+				storeyNames[i] = sql.NullString{String: *el.StoreyName, Valid: true}
+			}
+			var err error
+			if psets[i], err = jsonColumn(el.Psets, el.Psets == nil); err != nil {
+				return fmt.Errorf("encode psets of %s: %w", el.GlobalID, err)
+			}
+			if quantities[i], err = jsonColumn(el.Quantities, el.Quantities == nil); err != nil {
+				return fmt.Errorf("encode quantities of %s: %w", el.GlobalID, err)
+			}
+			if placements[i], err = jsonColumn(el.Placement, el.Placement == nil); err != nil {
+				return fmt.Errorf("encode placement of %s: %w", el.GlobalID, err)
+			}
+			if classifications[i], err = jsonColumn(el.Classifications, el.Classifications == nil); err != nil {
+				return fmt.Errorf("encode classifications of %s: %w", el.GlobalID, err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO arca_ifc_element (file_version_id, step_id, global_id, type, name,
+			    storey_global_id, storey_name, psets, quantities, placement, classifications)
+			SELECT $1, e.step_id, e.global_id, e.type, NULLIF(e.name, ''), e.storey_global_id, e.storey_name,
+			    e.psets::jsonb, e.quantities::jsonb, e.placement::jsonb, e.classifications::jsonb
+			FROM unnest($2::bigint[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[],
+			    $8::text[], $9::text[], $10::text[], $11::text[])
+			    AS e(step_id, global_id, type, name, storey_global_id, storey_name,
+			         psets, quantities, placement, classifications)`,
+			fileVersionID, pq.Array(stepIDs), pq.Array(globalIDs), pq.Array(types), pq.Array(names),
+			pq.Array(storeyIDs), pq.Array(storeyNames), pq.Array(psets), pq.Array(quantities),
+			pq.Array(placements), pq.Array(classifications))
+		if err != nil {
+			return fmt.Errorf("insert elements: %w", err)
+		}
+	}
+	return nil
+}
+
+// jsonColumn encodes v for a nullable jsonb column.
+func jsonColumn(v interface{}, null bool) (sql.NullString, error) {
+	if null {
+		return sql.NullString{}, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(raw), Valid: true}, nil
+}
+
+// saveTree replaces the stored spatial tree of a version.
+func saveTree(ctx context.Context, tx *sql.Tx, fileVersionID string, tree []TreeNode) error {
+	if tree == nil {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM arca_ifc_spatial_tree WHERE file_version_id = $1`, fileVersionID)
+		if err != nil {
+			return fmt.Errorf("clear spatial tree: %w", err)
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("encode spatial tree: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO arca_ifc_spatial_tree (file_version_id, tree, computed_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (file_version_id) DO UPDATE SET tree = EXCLUDED.tree, computed_at = EXCLUDED.computed_at`,
+		fileVersionID, raw)
+	if err != nil {
+		return fmt.Errorf("save spatial tree: %w", err)
+	}
+	return nil
+}
+
+// SpatialTree returns the stored spatial tree of a version as JSON, and the
+// time it was computed for use as a validator.
+func (s *Service) SpatialTree(ctx context.Context, fileVersionID string) ([]byte, time.Time, error) {
+	if err := s.requireIndexed(ctx, fileVersionID); err != nil {
+		return nil, time.Time{}, err
+	}
+	var raw []byte
+	var computedAt time.Time
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT tree, computed_at FROM arca_ifc_spatial_tree WHERE file_version_id = $1`,
+		fileVersionID).Scan(&raw, &computedAt)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, fmt.Errorf("%w: file version %s", ErrNotIndexed, fileVersionID)
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("get spatial tree: %w", err)
+	}
+	return raw, computedAt, nil
+}
+
+// GetElement returns one element of a version with its property sets,
+// quantities, placement and classifications. For duplicated GlobalIds the
+// first instance in the file is returned.
+func (s *Service) GetElement(ctx context.Context, fileVersionID, globalID string) (*Element, error) {
+	if err := s.requireIndexed(ctx, fileVersionID); err != nil {
+		return nil, err
+	}
+
+	var el Element
+	var stepID int64
+	var psets, quantities, placement, classifications []byte
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT global_id, type, COALESCE(name, ''), step_id, storey_global_id, storey_name,
+		       psets, quantities, placement, classifications
+		FROM arca_ifc_element
+		WHERE file_version_id = $1 AND global_id = $2
+		ORDER BY step_id
+		LIMIT 1`, fileVersionID, globalID).Scan(&el.GlobalID, &el.Type, &el.Name, &stepID,
+		&el.StoreyGlobalID, &el.StoreyName, &psets, &quantities, &placement, &classifications)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: element %s", ErrNotFound, globalID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get element: %w", err)
+	}
+	el.StepID = uint64(stepID)
+
+	for _, col := range []struct {
+		raw []byte
+		dst interface{}
+	}{
+		{psets, &el.Psets}, {quantities, &el.Quantities},
+		{placement, &el.Placement}, {classifications, &el.Classifications},
+	} {
+		if col.raw == nil {
+			continue
+		}
+		if err := json.Unmarshal(col.raw, col.dst); err != nil {
+			return nil, fmt.Errorf("decode element %s: %w", globalID, err)
+		}
+	}
+	return &el, nil
+}
+
+// ListElements returns indexed elements of a version in STEP id order.
+func (s *Service) ListElements(ctx context.Context, fileVersionID string, f ElementFilter) ([]Element, error) {
+	if err := s.requireIndexed(ctx, fileVersionID); err != nil {
+		return nil, err
+	}
+
+	types := make([]string, 0, len(f.Types))
+	for _, t := range f.Types {
+		types = append(types, strings.ToUpper(t))
+	}
+	globalIDs := f.GlobalIDs
+	if globalIDs == nil {
+		globalIDs = []string{}
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT global_id, type, COALESCE(name, ''), step_id, storey_global_id, storey_name
+		FROM arca_ifc_element
+		WHERE file_version_id = $1
+		  AND (cardinality($2::text[]) = 0 OR type = ANY($2))
+		  AND ($3 = '' OR storey_name = $3 OR storey_global_id = $3)
+		  AND (cardinality($4::text[]) = 0 OR global_id = ANY($4))
+		  AND step_id > $5
+		ORDER BY step_id
+		LIMIT $6`,
+		fileVersionID, pq.Array(types), f.Storey, pq.Array(globalIDs), int64(f.After), f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("query elements: %w", err)
+	}
+	defer rows.Close()
+
+	elements := []Element{}
+	for rows.Next() {
+		var el Element
+		var stepID int64
+		if err := rows.Scan(&el.GlobalID, &el.Type, &el.Name, &stepID, &el.StoreyGlobalID, &el.StoreyName); err != nil {
+			return nil, fmt.Errorf("scan element: %w", err)
+		}
+		el.StepID = uint64(stepID)
+		elements = append(elements, el)
+	}
+	return elements, rows.Err()
+}
+
+// requireIndexed checks that the version exists and that its element index
+// has been built at the current indexVersion.
+func (s *Service) requireIndexed(ctx context.Context, fileVersionID string) error {
+	var indexed bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT h.indexed_at IS NOT NULL AND h.index_version >= $2
+		FROM arca_file_version fv
+		LEFT JOIN arca_ifc_header h ON h.file_version_id = fv.id
+		WHERE fv.id = $1`, fileVersionID, indexVersion).Scan(&indexed)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return fmt.Errorf("get file version: %w", err)
+	}
+	if !indexed {
+		return fmt.Errorf("%w: file version %s", ErrNotIndexed, fileVersionID)
+	}
+	return nil
+}
+
+// VersionProjectID returns the project a file version belongs to.
+func (s *Service) VersionProjectID(ctx context.Context, fileVersionID string) (string, error) {
+	var projectID string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT fo.project_id
+		FROM arca_file_version fv
+		JOIN arca_folder_file ff ON ff.file_id = fv.file_id
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		WHERE fv.id = $1
+		LIMIT 1`, fileVersionID).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("get file version project: %w", err)
+	}
+	return projectID, nil
+}
@@ -0,0 +1,506 @@
+package ifc
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxSpatialDepth stops the storey and placement lookups on cyclic or
+// absurdly deep chains.
+const maxSpatialDepth = 64
+
+// indexer collects IfcRoot instances and everything needed to resolve their
+// storey, property sets and placement while the DATA section streams past.
+// STEP allows forward references, so resolution waits until the end.
+type indexer struct {
+	roots map[uint64]*Element
+	order []uint64
+
+	container map[uint64]uint64 // element -> spatial structure it is contained in
+	parent    map[uint64]uint64 // part -> whole, from IfcRelAggregates and IfcRelNests
+
+	placementOf map[uint64]uint64   // product -> IfcLocalPlacement
+	psetsOf     map[uint64][]uint64 // object or type -> property set definitions
+	typeOf      map[uint64]uint64   // occurrence -> type object, from IfcRelDefinesByType
+
+	psets      map[uint64]psetDef
+	properties map[uint64]*Entity
+	qsets      map[uint64]psetDef
+	quantities map[uint64]*Entity
+
+	classesOf    map[uint64][]uint64 // object or type -> classification references
+	classRefs    map[uint64]classRef
+	classSystems map[uint64]string // IfcClassification -> Name
+
+	localPlacements map[uint64][2]uint64 // IfcLocalPlacement -> PlacementRelTo, RelativePlacement
+	axisPlacements  map[uint64][3]uint64 // IfcAxis2Placement -> Location, Axis, RefDirection
+	coords          map[uint64][3]float64
+	wantCoords      map[uint64]bool
+}
+
+// classRef is an IfcClassificationReference. source is the IfcClassification
+// or, for nested references, the parent reference.
+type classRef struct {
+	identification string
+	name           string
+	source         uint64
+}
+
+// psetDef is an IfcPropertySet or IfcElementQuantity: its name and property
+// or quantity instances.
+type psetDef struct {
+	name  string
+	props []uint64
+}
+
+func newIndexer() *indexer {
+	return &indexer{
+		roots:           make(map[uint64]*Element),
+		container:       make(map[uint64]uint64),
+		parent:          make(map[uint64]uint64),
+		placementOf:     make(map[uint64]uint64),
+		psetsOf:         make(map[uint64][]uint64),
+		typeOf:          make(map[uint64]uint64),
+		psets:           make(map[uint64]psetDef),
+		properties:      make(map[uint64]*Entity),
+		qsets:           make(map[uint64]psetDef),
+		quantities:      make(map[uint64]*Entity),
+		classesOf:       make(map[uint64][]uint64),
+		classRefs:       make(map[uint64]classRef),
+		classSystems:    make(map[uint64]string),
+		localPlacements: make(map[uint64][2]uint64),
+		axisPlacements:  make(map[uint64][3]uint64),
+		coords:          make(map[uint64][3]float64),
+		wantCoords:      make(map[uint64]bool),
+	}
+}
+
+// isRoot reports whether raw parameters start with a GlobalId, which every
+// IfcRoot subtype has as its first attribute. Checking the bytes first avoids
+// parsing the many geometry instances that cannot be roots.
+//
+// A GlobalId is a 128-bit number in 22 characters of the IFC base64 alphabet,
+// so its first character only carries two bits and is one of 0-3. That keeps
+// names of other entities that happen to be 22 characters long out.
+func isRoot(raw []byte) bool {
+	if len(raw) <= 26 || raw[0] != '(' || raw[1] != '\'' || raw[24] != '\'' || raw[25] != ',' {
+		return false
+	}
+	if raw[2] < '0' || raw[2] > '3' {
+		return false
+	}
+	for _, c := range raw[3:24] {
+		if !isGlobalIDChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isGlobalIDChar reports whether c is in the IFC base64 alphabet
+// 0-9, A-Z, a-z, _ and $.
+func isGlobalIDChar(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || c == '_' || c == '$'
+}
+
+// isProperty reports whether an entity type is an IfcProperty kept for
+// property set values.
+func isProperty(typ string) bool {
+	switch typ {
+	case "IFCPROPERTYSINGLEVALUE", "IFCPROPERTYENUMERATEDVALUE", "IFCPROPERTYLISTVALUE",
+		"IFCPROPERTYBOUNDEDVALUE", "IFCPROPERTYTABLEVALUE", "IFCCOMPLEXPROPERTY":
+		return true
+	}
+	return false
+}
+
+// isTypeObject reports whether an entity type is an IfcTypeObject subtype,
+// going by the schema's naming (IfcWallType, IfcDoorStyle, ...).
+func isTypeObject(typ string) bool {
+	return strings.HasSuffix(typ, "TYPE") || strings.HasSuffix(typ, "STYLE")
+}
+
+func (x *indexer) add(e *Entity) error {
+	// Properties and quantities start with a name, which must not be taken
+	// for a GlobalId
+	switch {
+	case isProperty(e.Type):
+		x.properties[e.ID] = e
+		return nil
+	case quantityKinds[e.Type] != "":
+		x.quantities[e.ID] = e
+		return nil
+	case isRoot(e.raw):
+		return x.addRoot(e)
+	}
+
+	switch e.Type {
+	case "IFCLOCALPLACEMENT":
+		args, err := e.Args()
+		if err != nil {
+			return err
+		}
+		relTo, _ := RefOf(arg(args, 0))
+		rel, _ := RefOf(arg(args, 1))
+		x.localPlacements[e.ID] = [2]uint64{uint64(relTo), uint64(rel)}
+	case "IFCAXIS2PLACEMENT3D", "IFCAXIS2PLACEMENT2D":
+		args, err := e.Args()
+		if err != nil {
+			return err
+		}
+		loc, _ := RefOf(arg(args, 0))
+		var axis, ref Ref
+		if e.Type == "IFCAXIS2PLACEMENT3D" {
+			axis, _ = RefOf(arg(args, 1))
+			ref, _ = RefOf(arg(args, 2))
+		} else {
+			ref, _ = RefOf(arg(args, 1))
+		}
+		x.axisPlacements[e.ID] = [3]uint64{uint64(loc), uint64(axis), uint64(ref)}
+		for _, id := range []Ref{loc, axis, ref} {
+			if _, ok := x.coords[uint64(id)]; id != 0 && !ok {
+				x.wantCoords[uint64(id)] = true
+			}
+		}
+	case "IFCCARTESIANPOINT", "IFCDIRECTION":
+		return x.addCoords(e)
+	case "IFCCLASSIFICATIONREFERENCE":
+		args, err := e.Args()
+		if err != nil {
+			return err
+		}
+		source, _ := RefOf(arg(args, 3))
+		x.classRefs[e.ID] = classRef{
+			identification: String(arg(args, 1)),
+			name:           String(arg(args, 2)),
+			source:         uint64(source),
+		}
+	case "IFCCLASSIFICATION":
+		args, err := e.Args()
+		if err != nil {
+			return err
+		}
+		x.classSystems[e.ID] = String(arg(args, 3))
+	}
+	return nil
+}
+
+// addCoords keeps a point or direction that a placement refers to. Geometry
+// has far more of them than placements do, so unreferenced ones are skipped;
+// those defined before their placement are picked up by a second pass.
+func (x *indexer) addCoords(e *Entity) error {
+	if !x.wantCoords[e.ID] || (e.Type != "IFCCARTESIANPOINT" && e.Type != "IFCDIRECTION") {
+		return nil
+	}
+	args, err := e.Args()
+	if err != nil {
+		return err
+	}
+	var c [3]float64
+	for i, v := range first(args) {
+		if i < 3 {
+			c[i], _ = Number(v)
+		}
+	}
+	x.coords[e.ID] = c
+	delete(x.wantCoords, e.ID)
+	return nil
+}
+
+func first(args []Value) []Value {
+	list, _ := arg(args, 0).([]Value)
+	return list
+}
+
+// missingCoords reports whether a second pass is needed.
+func (x *indexer) missingCoords() bool {
+	return len(x.wantCoords) > 0
+}
+
+func (x *indexer) addRoot(e *Entity) error {
+	args, err := e.Args()
+	if err != nil {
+		return err
+	}
+
+	x.roots[e.ID] = &Element{
+		GlobalID: String(args[0]),
+		Type:     e.Type,
+		Name:     String(arg(args, 2)),
+		StepID:   e.ID,
+	}
+	x.order = append(x.order, e.ID)
+
+	// IfcProduct.ObjectPlacement and IfcTypeObject.HasPropertySets share
+	// position 5; other roots have neither there.
+	switch v := arg(args, 5).(type) {
+	case Ref:
+		x.placementOf[e.ID] = uint64(v)
+	case []Value:
+		if isTypeObject(e.Type) {
+			x.psetsOf[e.ID] = append(x.psetsOf[e.ID], refIDs(v)...)
+		}
+	}
+
+	switch e.Type {
+	case "IFCRELCONTAINEDINSPATIALSTRUCTURE":
+		if structure, ok := RefOf(arg(args, 5)); ok {
+			for _, el := range Refs(arg(args, 4)) {
+				x.container[uint64(el)] = uint64(structure)
+			}
+		}
+	case "IFCRELAGGREGATES", "IFCRELNESTS":
+		if whole, ok := RefOf(arg(args, 4)); ok {
+			for _, part := range Refs(arg(args, 5)) {
+				x.parent[uint64(part)] = uint64(whole)
+			}
+		}
+	case "IFCRELDEFINESBYPROPERTIES":
+		if def, ok := RefOf(arg(args, 5)); ok {
+			for _, obj := range Refs(arg(args, 4)) {
+				x.psetsOf[uint64(obj)] = append(x.psetsOf[uint64(obj)], uint64(def))
+			}
+		}
+	case "IFCRELASSOCIATESCLASSIFICATION":
+		if ref, ok := RefOf(arg(args, 5)); ok {
+			for _, obj := range Refs(arg(args, 4)) {
+				x.classesOf[uint64(obj)] = append(x.classesOf[uint64(obj)], uint64(ref))
+			}
+		}
+	case "IFCRELDEFINESBYTYPE":
+		if typ, ok := RefOf(arg(args, 5)); ok {
+			for _, obj := range Refs(arg(args, 4)) {
+				x.typeOf[uint64(obj)] = uint64(typ)
+			}
+		}
+	case "IFCPROPERTYSET":
+		x.psets[e.ID] = psetDef{name: String(arg(args, 2)), props: refIDs(arg(args, 4))}
+	case "IFCELEMENTQUANTITY":
+		x.qsets[e.ID] = psetDef{name: String(arg(args, 2)), props: refIDs(arg(args, 5))}
+	}
+	return nil
+}
+
+func refIDs(v Value) []uint64 {
+	refs := Refs(v)
+	out := make([]uint64, len(refs))
+	for i, r := range refs {
+		out[i] = uint64(r)
+	}
+	return out
+}
+
+// elements resolves storeys, property sets and placements and returns the
+// roots in file order.
+func (x *indexer) elements() []Element {
+	out := make([]Element, 0, len(x.order))
+	for _, id := range x.order {
+		el := *x.roots[id]
+		if storey := x.storeyOf(id); storey != nil {
+			el.StoreyGlobalID = &storey.GlobalID
+			el.StoreyName = &storey.Name
+		}
+		el.Psets = x.propertySets(id)
+		el.Quantities = x.quantitySets(id)
+		el.Classifications = x.classifications(id)
+		if ref, ok := x.placementOf[id]; ok {
+			if f, ok := x.frameOf(ref, 0); ok {
+				el.Placement = f.placement()
+			}
+		}
+		out = append(out, el)
+	}
+	return out
+}
+
+// storeyOf walks up from id through containment and aggregation until it
+// reaches a storey. A storey is not its own container.
+func (x *indexer) storeyOf(id uint64) *Element {
+	cur := id
+	for range maxSpatialDepth {
+		next, ok := x.container[cur]
+		if !ok {
+			if next, ok = x.parent[cur]; !ok {
+				return nil
+			}
+		}
+		if el := x.roots[next]; el != nil && el.Type == "IFCBUILDINGSTOREY" {
+			return el
+		}
+		cur = next
+	}
+	return nil
+}
+
+// definitions returns the property definitions of an object, those of its
+// type object first so that occurrence values override them.
+func (x *indexer) definitions(id uint64) []uint64 {
+	var defs []uint64
+	if typ, ok := x.typeOf[id]; ok {
+		defs = append(defs, x.psetsOf[typ]...)
+	}
+	return append(defs, x.psetsOf[id]...)
+}
+
+// propertySets returns an object's property sets by name.
+func (x *indexer) propertySets(id uint64) map[string]map[string]interface{} {
+	var out map[string]map[string]interface{}
+	for _, def := range x.definitions(id) {
+		pset, ok := x.psets[def]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]map[string]interface{})
+		}
+		props := out[pset.name]
+		if props == nil {
+			props = make(map[string]interface{})
+			out[pset.name] = props
+		}
+		for _, p := range pset.props {
+			if e := x.properties[p]; e != nil {
+				if name, value, ok := x.property(e, 0); ok {
+					props[name] = value
+				}
+			}
+		}
+	}
+	return out
+}
+
+// quantitySets returns an object's IfcElementQuantity sets by name.
+func (x *indexer) quantitySets(id uint64) map[string]map[string]Quantity {
+	var out map[string]map[string]Quantity
+	for _, def := range x.definitions(id) {
+		qset, ok := x.qsets[def]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]map[string]Quantity)
+		}
+		qs := out[qset.name]
+		if qs == nil {
+			qs = make(map[string]Quantity)
+			out[qset.name] = qs
+		}
+		for _, q := range qset.props {
+			if e := x.quantities[q]; e != nil {
+				if name, value, ok := quantity(e); ok {
+					qs[name] = value
+				}
+			}
+		}
+	}
+	return out
+}
+
+// classifications returns the classification references of an object and
+// its type object, resolved to their classification system.
+func (x *indexer) classifications(id uint64) []Classification {
+	refs := x.classesOf[id]
+	if typ, ok := x.typeOf[id]; ok {
+		refs = append(slices.Clone(refs), x.classesOf[typ]...)
+	}
+
+	var out []Classification
+	for _, r := range refs {
+		ref, ok := x.classRefs[r]
+		if !ok {
+			continue
+		}
+		c := Classification{Identification: ref.identification, Name: ref.name}
+		source := ref.source
+		for range maxSpatialDepth {
+			if system, ok := x.classSystems[source]; ok {
+				c.System = system
+				break
+			}
+			parent, ok := x.classRefs[source]
+			if !ok {
+				break
+			}
+			source = parent.source
+		}
+		if !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// spatialTree returns the decomposition of each IfcProject: spatial
+// structure elements through IfcRelAggregates, with the elements contained
+// in them and their parts as children.
+func (x *indexer) spatialTree() []TreeNode {
+	children := make(map[uint64][]uint64)
+	for child, parent := range x.parent {
+		if x.roots[child] != nil {
+			children[parent] = append(children[parent], child)
+		}
+	}
+	for child, container := range x.container {
+		if _, aggregated := x.parent[child]; !aggregated && x.roots[child] != nil {
+			children[container] = append(children[container], child)
+		}
+	}
+	for _, ids := range children {
+		slices.Sort(ids)
+	}
+
+	visited := make(map[uint64]bool)
+	var build func(id uint64, depth int) TreeNode
+	build = func(id uint64, depth int) TreeNode {
+		visited[id] = true
+		el := x.roots[id]
+		node := TreeNode{GlobalID: el.GlobalID, Type: el.Type, Name: el.Name, StepID: el.StepID}
+		if depth >= maxSpatialDepth {
+			return node
+		}
+		for _, c := range children[id] {
+			if !visited[c] {
+				node.Children = append(node.Children, build(c, depth+1))
+			}
+		}
+		return node
+	}
+
+	tree := []TreeNode{}
+	for _, id := range x.order {
+		if x.roots[id].Type == "IFCPROJECT" {
+			tree = append(tree, build(id, 0))
+		}
+	}
+	return tree
+}
+
+// frameOf resolves an IfcLocalPlacement to model coordinates.
+func (x *indexer) frameOf(id uint64, depth int) (frame, bool) {
+	lp, ok := x.localPlacements[id]
+	if !ok || depth > maxSpatialDepth {
+		return frame{}, false
+	}
+	parent := identity
+	if lp[0] != 0 {
+		if parent, ok = x.frameOf(lp[0], depth+1); !ok {
+			return frame{}, false
+		}
+	}
+	ap, ok := x.axisPlacements[lp[1]]
+	if !ok {
+		return frame{}, false
+	}
+	loc, ok := x.coords[ap[0]]
+	if !ok {
+		return frame{}, false
+	}
+	var axis, ref *[3]float64
+	if c, ok := x.coords[ap[1]]; ok {
+		axis = &c
+	}
+	if c, ok := x.coords[ap[2]]; ok {
+		ref = &c
+	}
+	return parent.compose(axisFrame(loc, axis, ref)), true
+}
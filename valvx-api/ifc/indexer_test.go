@@ -0,0 +1,85 @@
+package ifc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsRoot(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{`('2O2Fr$t4X7Zf8NOew3FLOH',#2,'Wall',$)`, true},
+		{`('0_______________$$$$$$',$)`, true},
+		// 22 characters, but the first one carries more than two bits
+		{`('LoadBearingCapacityKN1',$,IFCREAL(5.),$)`, false},
+		{`('2O2Fr$t4X7Zf8NOew3FL-H',#2)`, false},
+		{`('2O2Fr$t4X7Zf8NOew3FLOHX',#2)`, false},
+		{`('2O2Fr$t4X7Zf8NOew3FLOH')`, false},
+		{`(#1,'2O2Fr$t4X7Zf8NOew3FLOH',#2)`, false},
+		{`($,#21)`, false},
+	}
+	for _, tt := range tests {
+		if got := isRoot([]byte(tt.raw)); got != tt.want {
+			t.Errorf("isRoot(%s) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestIndexerElements(t *testing.T) {
+	// The property and quantity names look like GlobalIds; they must still
+	// be read as a property and a quantity
+	src := testHeader + `DATA;
+#1=IFCBUILDINGSTOREY('0aaaaaaaaaaaaaaaaaaaaa',$,'Plan 1',$,$,$,$,$,.ELEMENT.,0.);
+#2=IFCWALL('1bbbbbbbbbbbbbbbbbbbbb',$,'W1',$,$,#20,$,$,$);
+#3=IFCRELCONTAINEDINSPATIALSTRUCTURE('2ccccccccccccccccccccc',$,$,$,(#2),#1);
+#4=IFCPROPERTYSINGLEVALUE('0123456789ABCDEFGHIJKL',$,IFCLABEL('x'),$);
+#5=IFCQUANTITYAREA('3NetSideAreaOfTheWall_',$,$,12.5,$);
+#6=IFCPROPERTYSET('3ddddddddddddddddddddd',$,'Pset_Test',$,(#4));
+#7=IFCELEMENTQUANTITY('3eeeeeeeeeeeeeeeeeeeee',$,'Qto_Test',$,$,(#5));
+#8=IFCRELDEFINESBYPROPERTIES('3fffffffffffffffffffff',$,$,$,(#2),#6);
+#9=IFCRELDEFINESBYPROPERTIES('3ggggggggggggggggggggg',$,$,$,(#2),#7);
+#20=IFCLOCALPLACEMENT($,#21);
+#21=IFCAXIS2PLACEMENT3D(#22,$,$);
+#22=IFCCARTESIANPOINT((1000.,2000.,0.));
+ENDSEC;
+END-ISO-10303-21;
+`
+	x := newIndexer()
+	if _, err := readEntities(strings.NewReader(src), x.add); err != nil {
+		t.Fatalf("readEntities: %v", err)
+	}
+	if x.missingCoords() {
+		if _, err := readEntities(strings.NewReader(src), x.addCoords); err != nil {
+			t.Fatalf("second pass: %v", err)
+		}
+	}
+
+	var wall *Element
+	for _, el := range x.elements() {
+		switch el.GlobalID {
+		case "1bbbbbbbbbbbbbbbbbbbbb":
+			wall = &el
+		case "0123456789ABCDEFGHIJKL", "3NetSideAreaOfTheWall_":
+			t.Errorf("%s %s indexed as a root", el.Type, el.GlobalID)
+		}
+	}
+	if wall == nil {
+		t.Fatal("wall not indexed")
+	}
+
+	if wall.StoreyName == nil || *wall.StoreyName != "Plan 1" {
+		t.Errorf("storey = %v, want Plan 1", wall.StoreyName)
+	}
+	if want := map[string]map[string]interface{}{"Pset_Test": {"0123456789ABCDEFGHIJKL": "x"}}; !reflect.DeepEqual(wall.Psets, want) {
+		t.Errorf("Psets = %v, want %v", wall.Psets, want)
+	}
+	if want := map[string]map[string]Quantity{"Qto_Test": {"3NetSideAreaOfTheWall_": {Kind: QuantityArea, Value: 12.5}}}; !reflect.DeepEqual(wall.Quantities, want) {
+		t.Errorf("Quantities = %v, want %v", wall.Quantities, want)
+	}
+	if wall.Placement == nil || wall.Placement.Location != [3]float64{1000, 2000, 0} {
+		t.Errorf("Placement = %+v, want location (1000, 2000, 0)", wall.Placement)
+	}
+}
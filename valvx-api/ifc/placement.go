@@ -0,0 +1,93 @@
+package ifc
+
+import "math"
+
+// Placement is an element's ObjectPlacement resolved to model coordinates
+// (in the model's length unit): the origin and the X and Z axes of its local
+// coordinate system.
+type Placement struct {
+	Location [3]float64 `json:"location"`
+	XAxis    [3]float64 `json:"xAxis"`
+	ZAxis    [3]float64 `json:"zAxis"`
+}
+
+// placementTolerance is the relative difference below which two placement
+// components count as equal; exporters round differently between runs.
+const placementTolerance = 1e-6
+
+// Equal reports whether two placements match within placementTolerance.
+func (p *Placement) Equal(o *Placement) bool {
+	if p == nil || o == nil {
+		return p == o
+	}
+	return vecEqual(p.Location, o.Location) && vecEqual(p.XAxis, o.XAxis) && vecEqual(p.ZAxis, o.ZAxis)
+}
+
+func vecEqual(a, b [3]float64) bool {
+	for i := range a {
+		scale := max(1, math.Abs(a[i]), math.Abs(b[i]))
+		if math.Abs(a[i]-b[i]) > placementTolerance*scale {
+			return false
+		}
+	}
+	return true
+}
+
+// frame is a rigid transform: the columns of a rotation and an origin.
+type frame struct {
+	x, y, z, o [3]float64
+}
+
+var identity = frame{x: [3]float64{1, 0, 0}, y: [3]float64{0, 1, 0}, z: [3]float64{0, 0, 1}}
+
+// apply maps a direction from f's local coordinates to its parent's.
+func (f frame) apply(v [3]float64) [3]float64 {
+	var out [3]float64
+	for i := range out {
+		out[i] = f.x[i]*v[0] + f.y[i]*v[1] + f.z[i]*v[2]
+	}
+	return out
+}
+
+// compose returns the transform of a frame local to f.
+func (f frame) compose(local frame) frame {
+	o := f.apply(local.o)
+	for i := range o {
+		o[i] += f.o[i]
+	}
+	return frame{x: f.apply(local.x), y: f.apply(local.y), z: f.apply(local.z), o: o}
+}
+
+// axisFrame builds the frame of an IfcAxis2Placement from its location,
+// optional Axis and optional RefDirection, as the IFC schema defines it.
+func axisFrame(loc [3]float64, axis, ref *[3]float64) frame {
+	z := [3]float64{0, 0, 1}
+	if axis != nil {
+		z = normalize(*axis)
+	}
+	xRef := [3]float64{1, 0, 0}
+	if ref != nil {
+		xRef = *ref
+	}
+	d := dot(xRef, z)
+	x := normalize([3]float64{xRef[0] - d*z[0], xRef[1] - d*z[1], xRef[2] - d*z[2]})
+	return frame{x: x, y: cross(z, x), z: z, o: loc}
+}
+
+func (f frame) placement() *Placement {
+	return &Placement{Location: f.o, XAxis: f.x, ZAxis: f.z}
+}
+
+func dot(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(v [3]float64) [3]float64 {
+	n := math.Sqrt(dot(v, v))
+	if n == 0 {
+		return v
+	}
+	return [3]float64{v[0] / n, v[1] / n, v[2] / n}
+}
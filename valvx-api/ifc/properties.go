@@ -0,0 +1,102 @@
+package ifc
+
+// maxPropertyDepth bounds nesting of IfcComplexProperty.
+const maxPropertyDepth = 8
+
+// property converts an IfcProperty instance to its name and a JSON value:
+//   - IfcPropertySingleValue: the nominal value
+//   - IfcPropertyEnumeratedValue, IfcPropertyListValue: an array
+//   - IfcPropertyBoundedValue: {"lower": ..., "upper": ...}
+//   - IfcPropertyTableValue: {"defining": [...], "defined": [...]}
+//   - IfcComplexProperty: an object of its member properties
+func (x *indexer) property(e *Entity, depth int) (string, interface{}, bool) {
+	args, err := e.Args()
+	if err != nil || depth > maxPropertyDepth {
+		return "", nil, false
+	}
+	name := String(arg(args, 0))
+	if name == "" {
+		return "", nil, false
+	}
+
+	switch e.Type {
+	case "IFCPROPERTYSINGLEVALUE", "IFCPROPERTYENUMERATEDVALUE", "IFCPROPERTYLISTVALUE":
+		return name, jsonValue(arg(args, 2)), true
+	case "IFCPROPERTYBOUNDEDVALUE":
+		return name, map[string]interface{}{
+			"upper": jsonValue(arg(args, 2)),
+			"lower": jsonValue(arg(args, 3)),
+		}, true
+	case "IFCPROPERTYTABLEVALUE":
+		return name, map[string]interface{}{
+			"defining": jsonValue(arg(args, 2)),
+			"defined":  jsonValue(arg(args, 3)),
+		}, true
+	case "IFCCOMPLEXPROPERTY":
+		members := make(map[string]interface{})
+		for _, ref := range Refs(arg(args, 3)) {
+			if m := x.properties[uint64(ref)]; m != nil {
+				if n, v, ok := x.property(m, depth+1); ok {
+					members[n] = v
+				}
+			}
+		}
+		return name, members, true
+	}
+	return "", nil, false
+}
+
+// quantityKinds maps IfcPhysicalSimpleQuantity subtypes to Quantity.Kind.
+var quantityKinds = map[string]string{
+	"IFCQUANTITYLENGTH": QuantityLength,
+	"IFCQUANTITYAREA":   QuantityArea,
+	"IFCQUANTITYVOLUME": QuantityVolume,
+	"IFCQUANTITYCOUNT":  QuantityCount,
+	"IFCQUANTITYWEIGHT": QuantityWeight,
+	"IFCQUANTITYTIME":   QuantityTime,
+}
+
+// quantity converts an IfcPhysicalSimpleQuantity to its name and value.
+func quantity(e *Entity) (string, Quantity, bool) {
+	args, err := e.Args()
+	if err != nil {
+		return "", Quantity{}, false
+	}
+	name := String(arg(args, 0))
+	value, ok := Number(arg(args, 3))
+	if name == "" || !ok {
+		return "", Quantity{}, false
+	}
+	return name, Quantity{Kind: quantityKinds[e.Type], Value: value}, true
+}
+
+// jsonValue converts a STEP value to plain JSON: defined types are
+// unwrapped, .T./.F. become booleans and .U. (unknown) null. References
+// have no meaning outside the file and become null.
+func jsonValue(v Value) interface{} {
+	switch x := v.(type) {
+	case Typed:
+		return jsonValue(x.Value)
+	case Enum:
+		switch x {
+		case "T":
+			return true
+		case "F":
+			return false
+		case "U":
+			return nil
+		}
+		return string(x)
+	case string, int64, float64:
+		return x
+	case Binary:
+		return string(x)
+	case []Value:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = jsonValue(item)
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package ifc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// rulePriorities are the BCF priorities a rule's topics can be raised with.
+var rulePriorities = []string{"Critical", "Major", "Normal", "Minor"}
+
+const ruleColumns = `id, project_id, name, kind, types, COALESCE(pset, ''), properties,
+	COALESCE(pattern, ''), priority, enabled, creator_id, created_at, updated_at`
+
+func scanRule(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	var r Rule
+	err := row.Scan(&r.ID, &r.ProjectID, &r.Name, &r.Kind, pq.Array(&r.Types), &r.Pset,
+		pq.Array(&r.Properties), &r.Pattern, &r.Priority, &r.Enabled, &r.CreatorID,
+		&r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Types = nonNil(r.Types)
+	r.Properties = nonNil(r.Properties)
+	return &r, nil
+}
+
+// ListRules returns a project's validation rules.
+func (s *Service) ListRules(ctx context.Context, projectID string) ([]Rule, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+ruleColumns+`
+		FROM arca_ifc_rule
+		WHERE project_id = $1
+		ORDER BY created_at, id`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("query rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan rule: %w", err)
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// GetRule returns one rule of a project.
+func (s *Service) GetRule(ctx context.Context, projectID, ruleID string) (*Rule, error) {
+	r, err := scanRule(s.DB.QueryRowContext(ctx, `
+		SELECT `+ruleColumns+`
+		FROM arca_ifc_rule
+		WHERE id = $1 AND project_id = $2`, ruleID, projectID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: rule %s", ErrNotFound, ruleID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get rule: %w", err)
+	}
+	return r, nil
+}
+
+// CreateRule adds a validation rule to a project.
+func (s *Service) CreateRule(ctx context.Context, projectID, creatorID string, req CreateRuleRequest) (*Rule, error) {
+	r := &Rule{
+		ProjectID:  projectID,
+		Name:       req.Name,
+		Kind:       req.Kind,
+		Types:      req.Types,
+		Pset:       req.Pset,
+		Properties: req.Properties,
+		Pattern:    req.Pattern,
+		Priority:   req.Priority,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+	}
+	if r.Priority == "" {
+		r.Priority = "Normal"
+	}
+	if err := checkRule(r); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO arca_ifc_rule (id, project_id, name, kind, types, pset, properties,
+		    pattern, priority, enabled, creator_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), $9, $10, $11, $12, $12)`,
+		id, projectID, r.Name, r.Kind, pq.Array(r.Types), r.Pset, pq.Array(r.Properties),
+		r.Pattern, r.Priority, r.Enabled, creatorID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("insert rule: %w", err)
+	}
+	return s.GetRule(ctx, projectID, id)
+}
+
+// UpdateRule changes the given fields of a rule. Results of earlier checks
+// are kept; they describe the rule as it was when they ran.
+func (s *Service) UpdateRule(ctx context.Context, projectID, ruleID string, req UpdateRuleRequest) (*Rule, error) {
+	r, err := s.GetRule(ctx, projectID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		r.Name = *req.Name
+	}
+	if req.Types != nil {
+		r.Types = *req.Types
+	}
+	if req.Pset != nil {
+		r.Pset = *req.Pset
+	}
+	if req.Properties != nil {
+		r.Properties = *req.Properties
+	}
+	if req.Pattern != nil {
+		r.Pattern = *req.Pattern
+	}
+	if req.Priority != nil {
+		r.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+	if err := checkRule(r); err != nil {
+		return nil, err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE arca_ifc_rule
+		SET name = $2, types = $3, pset = NULLIF($4, ''), properties = $5,
+		    pattern = NULLIF($6, ''), priority = $7, enabled = $8, updated_at = $9
+		WHERE id = $1`,
+		ruleID, r.Name, pq.Array(r.Types), r.Pset, pq.Array(r.Properties),
+		r.Pattern, r.Priority, r.Enabled, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("update rule: %w", err)
+	}
+	return s.GetRule(ctx, projectID, ruleID)
+}
+
+// DeleteRule removes a rule and its results. Topics it raised stay open.
+func (s *Service) DeleteRule(ctx context.Context, projectID, ruleID string) error {
+	res, err := s.DB.ExecContext(ctx,
+		`DELETE FROM arca_ifc_rule WHERE id = $1 AND project_id = $2`, ruleID, projectID)
+	if err != nil {
+		return fmt.Errorf("delete rule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: rule %s", ErrNotFound, ruleID)
+	}
+	return nil
+}
+
+// checkRule validates a rule and normalizes its type names to the upper
+// case STEP entity names stored in the index.
+func checkRule(r *Rule) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	i := slices.IndexFunc(rulePriorities, func(p string) bool { return strings.EqualFold(p, r.Priority) })
+	if i < 0 {
+		return fmt.Errorf("%w: priority must be one of %s", ErrInvalid, strings.Join(rulePriorities, ", "))
+	}
+	r.Priority = rulePriorities[i]
+
+	types := make([]string, 0, len(r.Types))
+	for _, t := range r.Types {
+		if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	r.Types = types
+	r.Properties = nonNil(r.Properties)
+
+	switch r.Kind {
+	case RuleRequiredPset:
+		r.Pset = strings.TrimSpace(r.Pset)
+		if r.Pset == "" {
+			return fmt.Errorf("%w: %s rules need a pset", ErrInvalid, r.Kind)
+		}
+	case RuleNaming:
+		if r.Pattern == "" {
+			return fmt.Errorf("%w: %s rules need a pattern", ErrInvalid, r.Kind)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%w: pattern: %v", ErrInvalid, err)
+		}
+	case RuleUniqueGlobalID, RuleStoreyAssigned:
+	default:
+		return fmt.Errorf("%w: unknown rule kind %q", ErrInvalid, r.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package ifc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/lib/pq"
+
+	"github.com/nsssthlm/valvx-api/collab"
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
+)
+
+// indexVersion identifies what ProcessVersion stores. Bump it when the index
+// gains data, so `backfill-ifc` reprocesses older versions.
+//
+//	1: header and elements with storeys
+//	2: property sets and placements
+//	3: base quantities and the spatial tree
+//	4: classification references
+const indexVersion = 4
+
+// Service extracts IFC metadata and element indexes from stored file versions
+// and checks them against the project's validation rules.
+type Service struct {
+	DB    *sql.DB
+	Blobs *blobstor.Store
+
+	// Topics receives the BCF topics raised by failed validation rules.
+	Topics *collab.Service
+}
+
+// NewService creates a new IFC service.
+func NewService(db *sql.DB, blobs *blobstor.Store, topics *collab.Service) *Service {
+	return &Service{DB: db, Blobs: blobs, Topics: topics}
+}
+
+// readErrors remembers failures of the underlying object stream, so they can
+// be told apart from a malformed file.
+type readErrors struct {
+	r   io.Reader
+	err error
+}
+
+func (t *readErrors) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// ProcessVersion reads an .ifc file version and stores its STEP header in
+// arca_ifc_header, its IfcRoot instances in arca_ifc_element and its spatial
+// tree in arca_ifc_spatial_tree, replacing any earlier result. Versions of
+// other file types are ignored. A file that cannot be parsed is recorded
+// with its error and is not an error to the caller; storage and database
+// failures are, so the upload worker retries them.
+func (s *Service) ProcessVersion(ctx context.Context, fileVersionID string) error {
+	var ext, key string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT lower(COALESCE(f.ext, '')), COALESCE(fv.storage_key, fv.id::text)
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE fv.id = $1`, fileVersionID).Scan(&ext, &key)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return fmt.Errorf("get file version: %w", err)
+	}
+	if ext != "ifc" {
+		return nil
+	}
+
+	// Placements may refer to points written before them; those need a
+	// second pass over the object once the first has named them.
+	x := newIndexer()
+	h, parseErr, err := s.scan(ctx, key, x.add)
+	if err != nil {
+		return err
+	}
+	if parseErr == nil && x.missingCoords() {
+		if _, parseErr, err = s.scan(ctx, key, x.addCoords); err != nil {
+			return err
+		}
+	}
+
+	var elements []Element
+	var tree []TreeNode
+	if parseErr != nil {
+		log.Printf("IFC version %s could not be parsed: %v", fileVersionID, parseErr)
+	} else {
+		elements = x.elements()
+		tree = x.spatialTree()
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := saveHeader(ctx, tx, fileVersionID, h, len(elements), parseErr); err != nil {
+		return err
+	}
+	if err := saveElements(ctx, tx, fileVersionID, elements); err != nil {
+		return err
+	}
+	if err := saveTree(ctx, tx, fileVersionID, tree); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// scan streams the object at key through fn, one DATA entity at a time. It
+// returns the header, a parse error for a malformed file (with whatever
+// header was read), and an error when the object could not be read.
+func (s *Service) scan(ctx context.Context, key string, fn func(*Entity) error) (*Header, error, error) {
+	body, err := s.Blobs.Get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	defer body.Close()
+
+	src := &readErrors{r: body}
+	h, parseErr := readEntities(src, fn)
+	if src.err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", key, src.err)
+	}
+	return h, parseErr, nil
+}
+
+func readEntities(src io.Reader, fn func(*Entity) error) (*Header, error) {
+	r := NewReader(src)
+	h, err := r.Header()
+	if err != nil {
+		return &Header{}, err
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return h, nil
+		}
+		if err == nil {
+			err = fn(e)
+		}
+		if err != nil {
+			return h, err
+		}
+	}
+}
+
+func saveHeader(ctx context.Context, tx *sql.Tx, fileVersionID string, h *Header, elementCount int, parseErr error) error {
+	var errText sql.NullString
+	if parseErr != nil {
+		errText = sql.NullString{String: parseErr.Error(), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO arca_ifc_header (file_version_id, schema, schemas, description, implementation_level,
+		    view_definition, file_name, time_stamp, authors, organizations, preprocessor_version,
+		    originating_system, file_authorization, error, parsed_at, element_count, indexed_at, index_version)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''),
+		    $9, $10, NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), $14, now(),
+		    $15, CASE WHEN $14::text IS NULL THEN now() END, $16)
+		ON CONFLICT (file_version_id) DO UPDATE SET
+		    schema = EXCLUDED.schema, schemas = EXCLUDED.schemas, description = EXCLUDED.description,
+		    implementation_level = EXCLUDED.implementation_level, view_definition = EXCLUDED.view_definition,
+		    file_name = EXCLUDED.file_name, time_stamp = EXCLUDED.time_stamp, authors = EXCLUDED.authors,
+		    organizations = EXCLUDED.organizations, preprocessor_version = EXCLUDED.preprocessor_version,
+		    originating_system = EXCLUDED.originating_system, file_authorization = EXCLUDED.file_authorization,
+		    error = EXCLUDED.error, parsed_at = EXCLUDED.parsed_at,
+		    element_count = EXCLUDED.element_count, indexed_at = EXCLUDED.indexed_at,
+		    index_version = EXCLUDED.index_version`,
+		fileVersionID, h.Schema(), pq.Array(nonNil(h.Schemas)), pq.Array(nonNil(h.Description)),
+		h.ImplementationLevel, h.ViewDefinition, h.FileName, h.TimeStamp,
+		pq.Array(nonNil(h.Authors)), pq.Array(nonNil(h.Organizations)), h.PreprocessorVersion,
+		h.OriginatingSystem, h.Authorization, errText, elementCount, indexVersion)
+	if err != nil {
+		return fmt.Errorf("save ifc header: %w", err)
+	}
+	return nil
+}
+
+// Backfill processes every .ifc version not yet indexed at indexVersion.
+func (s *Service) Backfill(ctx context.Context) (int, error) {
+	versionIDs, err := s.pendingVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, id := range versionIDs {
+		err := s.ProcessVersion(ctx, id)
+		if blobstor.IsNotFound(err) {
+			log.Printf("Backfill: version %s has no object", id)
+			continue
+		}
+		if err != nil {
+			return done, err
+		}
+		done++
+	}
+
+	log.Printf("Backfill: indexed %d of %d IFC file versions", done, len(versionIDs))
+	return done, nil
+}
+
+func (s *Service) pendingVersions(ctx context.Context) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT fv.id::text
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE lower(COALESCE(f.ext, '')) = 'ifc'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM arca_ifc_header h
+		      WHERE h.file_version_id = fv.id AND h.index_version >= $1
+		  )
+		ORDER BY fv.created_at`, indexVersion)
+	if err != nil {
+		return nil, fmt.Errorf("query versions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan version: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,620 @@
+// Package ifc reads IFC models stored as ISO 10303-21 (STEP physical file)
+// text and keeps per-version metadata derived from them.
+//
+// The reader streams: statements are read one at a time from the object in
+// blob storage, so memory use is bounded by the largest single statement
+// rather than by the size of the model.
+package ifc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxStatementSize bounds one STEP statement. Real models have statements of
+// a few MB at most (large point lists); anything bigger is treated as corrupt.
+const maxStatementSize = 64 << 20
+
+// ErrNotSTEP is returned when the input does not start like a STEP file.
+var ErrNotSTEP = errors.New("ifc: not an ISO 10303-21 file")
+
+// --- Values ---
+
+// Value is one STEP parameter: nil ($), Derived (*), Ref (#n), string,
+// Enum (.X.), Binary ("..."), int64, float64, []Value or Typed.
+type Value interface{}
+
+// Ref is an entity instance reference, #n.
+type Ref uint64
+
+// Enum is an enumeration value such as .T. or .ELEMENT., without the dots.
+type Enum string
+
+// Binary is a STEP binary literal, kept as its hex digits.
+type Binary string
+
+// Derived is the * placeholder for attributes derived in a subtype.
+type Derived struct{}
+
+// Typed is a value wrapped in its defined type, e.g. IFCLABEL('x').
+type Typed struct {
+	Type  string
+	Value Value
+}
+
+// String returns v as text, unwrapping a Typed value. Null and non-string
+// values yield "".
+func String(v Value) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case Typed:
+		return String(x.Value)
+	case Enum:
+		return string(x)
+	}
+	return ""
+}
+
+// Strings returns the string elements of a list value.
+func Strings(v Value) []string {
+	list, _ := v.([]Value)
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if s := String(item); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// arg returns parameter i, or nil when the instance has fewer parameters.
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// RefOf returns v as an entity reference.
+func RefOf(v Value) (Ref, bool) {
+	r, ok := v.(Ref)
+	return r, ok
+}
+
+// Refs returns the references in a list value.
+func Refs(v Value) []Ref {
+	list, _ := v.([]Value)
+	out := make([]Ref, 0, len(list))
+	for _, item := range list {
+		if r, ok := item.(Ref); ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Number returns v as a float, unwrapping a Typed value.
+func Number(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int64:
+		return float64(x), true
+	case Typed:
+		return Number(x.Value)
+	}
+	return 0, false
+}
+
+// --- Entities ---
+
+// Entity is one instance from the DATA section. Its parameters are parsed
+// on demand with Args, so callers that only need the type pay nothing more.
+type Entity struct {
+	ID   uint64
+	Type string // upper-case, e.g. IFCWALL; the first part of a complex instance
+	raw  []byte
+}
+
+// Args parses the entity's parameter list.
+func (e *Entity) Args() ([]Value, error) {
+	p := parser{buf: e.raw}
+	v, err := p.value()
+	if err != nil {
+		return nil, fmt.Errorf("ifc: #%d %s: %w", e.ID, e.Type, err)
+	}
+	list, ok := v.([]Value)
+	if !ok {
+		return nil, fmt.Errorf("ifc: #%d %s: parameters are not a list", e.ID, e.Type)
+	}
+	return list, nil
+}
+
+// --- Reader ---
+
+// Reader reads a STEP file statement by statement.
+type Reader struct {
+	r          *bufio.Reader
+	buf        []byte
+	header     *Header
+	headerErr  error
+	inData     bool
+	done       bool
+	statements int
+}
+
+// NewReader returns a Reader over src.
+func NewReader(src io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(src, 1<<16)}
+}
+
+// Header reads and returns the HEADER section. Calling it again returns the
+// same result; Next calls it implicitly.
+func (r *Reader) Header() (*Header, error) {
+	if r.header != nil || r.headerErr != nil {
+		return r.header, r.headerErr
+	}
+	r.header, r.headerErr = r.readHeader()
+	return r.header, r.headerErr
+}
+
+func (r *Reader) readHeader() (*Header, error) {
+	stmt, err := r.statement()
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrNotSTEP
+		}
+		return nil, err
+	}
+	if string(stmt) != "ISO-10303-21" {
+		return nil, ErrNotSTEP
+	}
+
+	h := &Header{}
+	inHeader := false
+	for {
+		stmt, err := r.statement()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case string(stmt) == "HEADER":
+			inHeader = true
+		case string(stmt) == "ENDSEC":
+			if inHeader {
+				return h, nil
+			}
+		case inHeader:
+			typ, args, err := splitHeaderEntity(stmt)
+			if err != nil {
+				return nil, err
+			}
+			h.apply(typ, args)
+		}
+	}
+}
+
+// Next returns the next DATA section entity, or io.EOF after the last one.
+func (r *Reader) Next() (*Entity, error) {
+	if _, err := r.Header(); err != nil {
+		return nil, err
+	}
+	for !r.done {
+		stmt, err := r.statement()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !r.inData {
+			if string(stmt) == "DATA" || bytes.HasPrefix(stmt, []byte("DATA(")) {
+				r.inData = true
+			} else if string(stmt) == "END-ISO-10303-21" {
+				r.done = true
+			}
+			continue
+		}
+		if string(stmt) == "ENDSEC" {
+			// A file may contain several DATA sections (rare in IFC)
+			r.inData = false
+			continue
+		}
+
+		e, err := splitInstance(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return nil, io.EOF
+}
+
+// statement reads up to the next ';' outside strings and comments, with
+// whitespace outside strings removed. The result is only valid until the
+// next call.
+func (r *Reader) statement() ([]byte, error) {
+	buf := r.buf[:0]
+	inString := false
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(bytes.TrimSpace(buf)) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if inString {
+			buf = append(buf, c)
+			if c == '\'' {
+				// '' is an escaped quote inside the string
+				if next, err := r.r.Peek(1); err == nil && next[0] == '\'' {
+					r.r.ReadByte()
+					buf = append(buf, '\'')
+					continue
+				}
+				inString = false
+			}
+		} else {
+			switch c {
+			case ';':
+				r.buf = buf
+				r.statements++
+				return buf, nil
+			case '\'':
+				inString = true
+				buf = append(buf, c)
+			case ' ', '\t', '\r', '\n':
+			case '/':
+				if next, err := r.r.Peek(1); err == nil && next[0] == '*' {
+					r.r.ReadByte()
+					if err := r.skipComment(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				buf = append(buf, c)
+			default:
+				buf = append(buf, c)
+			}
+		}
+
+		if len(buf) > maxStatementSize {
+			return nil, fmt.Errorf("ifc: statement %d exceeds %d bytes", r.statements+1, maxStatementSize)
+		}
+	}
+}
+
+func (r *Reader) skipComment() error {
+	prev := byte(0)
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if prev == '*' && c == '/' {
+			return nil
+		}
+		prev = c
+	}
+}
+
+// splitInstance parses "#id=TYPE(...)" or "#id=(A(...)B(...))".
+func splitInstance(stmt []byte) (*Entity, error) {
+	eq := bytes.IndexByte(stmt, '=')
+	if len(stmt) < 2 || stmt[0] != '#' || eq < 2 {
+		return nil, fmt.Errorf("ifc: malformed instance %.40q", stmt)
+	}
+	id, err := strconv.ParseUint(string(stmt[1:eq]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ifc: malformed instance id %.40q", stmt)
+	}
+
+	body := stmt[eq+1:]
+	complex := len(body) > 0 && body[0] == '('
+	if complex {
+		body = body[1:]
+	}
+	open := bytes.IndexByte(body, '(')
+	if open <= 0 {
+		return nil, fmt.Errorf("ifc: #%d: missing parameter list", id)
+	}
+
+	e := &Entity{ID: id, Type: strings.ToUpper(string(body[:open]))}
+	if complex {
+		// Complex instances keep the first part's parameters only
+		end, err := matchParen(body, open)
+		if err != nil {
+			return nil, fmt.Errorf("ifc: #%d: %w", id, err)
+		}
+		e.raw = append([]byte(nil), body[open:end+1]...)
+	} else {
+		e.raw = append([]byte(nil), body[open:]...)
+	}
+	return e, nil
+}
+
+// matchParen returns the index of the ')' closing the '(' at open.
+func matchParen(b []byte, open int) (int, error) {
+	depth := 0
+	inString := false
+	for i := open; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			if c == '\'' {
+				if i+1 < len(b) && b[i+1] == '\'' {
+					i++
+					continue
+				}
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '\'':
+			inString = true
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New("unbalanced parentheses")
+}
+
+func splitHeaderEntity(stmt []byte) (string, []Value, error) {
+	open := bytes.IndexByte(stmt, '(')
+	if open <= 0 {
+		return "", nil, fmt.Errorf("ifc: malformed header entity %.40q", stmt)
+	}
+	e := Entity{Type: strings.ToUpper(string(stmt[:open])), raw: stmt[open:]}
+	args, err := e.Args()
+	return e.Type, args, err
+}
+
+// --- Parameter parser ---
+
+type parser struct {
+	buf []byte
+	pos int
+}
+
+func (p *parser) value() (Value, error) {
+	if p.pos >= len(p.buf) {
+		return nil, errors.New("unexpected end of parameters")
+	}
+	switch c := p.buf[p.pos]; {
+	case c == '$':
+		p.pos++
+		return nil, nil
+	case c == '*':
+		p.pos++
+		return Derived{}, nil
+	case c == '#':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.buf) && isDigit(p.buf[p.pos]) {
+			p.pos++
+		}
+		n, err := strconv.ParseUint(string(p.buf[start:p.pos]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad reference at %d", start)
+		}
+		return Ref(n), nil
+	case c == '\'':
+		return p.str()
+	case c == '.':
+		end := bytes.IndexByte(p.buf[p.pos+1:], '.')
+		if end < 0 {
+			return nil, errors.New("unterminated enumeration")
+		}
+		v := Enum(p.buf[p.pos+1 : p.pos+1+end])
+		p.pos += end + 2
+		return v, nil
+	case c == '"':
+		end := bytes.IndexByte(p.buf[p.pos+1:], '"')
+		if end < 0 {
+			return nil, errors.New("unterminated binary")
+		}
+		v := Binary(p.buf[p.pos+1 : p.pos+1+end])
+		p.pos += end + 2
+		return v, nil
+	case c == '(':
+		return p.list()
+	case c == '+' || c == '-' || isDigit(c):
+		return p.number()
+	case isIdentStart(c):
+		start := p.pos
+		for p.pos < len(p.buf) && isIdent(p.buf[p.pos]) {
+			p.pos++
+		}
+		typ := strings.ToUpper(string(p.buf[start:p.pos]))
+		if p.pos >= len(p.buf) || p.buf[p.pos] != '(' {
+			return nil, fmt.Errorf("expected ( after %s", typ)
+		}
+		p.pos++
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.buf) || p.buf[p.pos] != ')' {
+			return nil, fmt.Errorf("expected ) after %s value", typ)
+		}
+		p.pos++
+		return Typed{Type: typ, Value: v}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+	}
+}
+
+func (p *parser) list() (Value, error) {
+	p.pos++ // (
+	list := []Value{}
+	if p.pos < len(p.buf) && p.buf[p.pos] == ')' {
+		p.pos++
+		return list, nil
+	}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if p.pos >= len(p.buf) {
+			return nil, errors.New("unterminated list")
+		}
+		switch p.buf[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return list, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q in list at %d", p.buf[p.pos], p.pos)
+		}
+	}
+}
+
+func (p *parser) number() (Value, error) {
+	start := p.pos
+	isFloat := false
+	p.pos++
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		if c == '.' || c == 'E' || c == 'e' {
+			isFloat = true
+		} else if !isDigit(c) && !((c == '+' || c == '-') && (p.buf[p.pos-1] == 'E' || p.buf[p.pos-1] == 'e')) {
+			break
+		}
+		p.pos++
+	}
+	s := string(p.buf[start:p.pos])
+	if !isFloat {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	// STEP allows "1." for 1.0, which ParseFloat accepts
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad number %q", s)
+	}
+	return f, nil
+}
+
+func (p *parser) str() (Value, error) {
+	p.pos++ // opening quote
+	var raw []byte
+	for {
+		if p.pos >= len(p.buf) {
+			return nil, errors.New("unterminated string")
+		}
+		c := p.buf[p.pos]
+		p.pos++
+		if c == '\'' {
+			if p.pos < len(p.buf) && p.buf[p.pos] == '\'' {
+				raw = append(raw, '\'')
+				p.pos++
+				continue
+			}
+			return decodeString(raw), nil
+		}
+		raw = append(raw, c)
+	}
+}
+
+// decodeString resolves the STEP control directives in a string literal:
+// \\ for a backslash, \S\c for ISO 8859 upper-half characters, \X\hh for a
+// single byte, \X2\...\X0\ for UTF-16 and \X4\...\X0\ for UTF-32 code
+// points. Code page switches (\P?\) are ignored. Bytes that are not valid
+// UTF-8 are read as Latin-1, which is what older exporters write.
+func decodeString(raw []byte) string {
+	if bytes.IndexByte(raw, '\\') < 0 && utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(raw); {
+		if raw[i] != '\\' {
+			r, size := utf8.DecodeRune(raw[i:])
+			if r == utf8.RuneError && size == 1 {
+				r = rune(raw[i])
+			}
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+		rest := raw[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte(`\\`)):
+			b.WriteByte('\\')
+			i += 2
+		case bytes.HasPrefix(rest, []byte(`\S\`)) && len(rest) >= 4:
+			b.WriteRune(rune(rest[3]) + 128)
+			i += 4
+		case bytes.HasPrefix(rest, []byte(`\X\`)) && len(rest) >= 5:
+			if n, err := strconv.ParseUint(string(rest[3:5]), 16, 8); err == nil {
+				b.WriteRune(rune(n))
+			}
+			i += 5
+		case bytes.HasPrefix(rest, []byte(`\X2\`)), bytes.HasPrefix(rest, []byte(`\X4\`)):
+			width := 4
+			if rest[2] == '4' {
+				width = 8
+			}
+			end := bytes.Index(rest[4:], []byte(`\X0\`))
+			if end < 0 {
+				b.Write(rest)
+				return b.String()
+			}
+			hex := rest[4 : 4+end]
+			var units []uint16
+			for j := 0; j+width <= len(hex); j += width {
+				n, err := strconv.ParseUint(string(hex[j:j+width]), 16, 32)
+				if err != nil {
+					break
+				}
+				if width == 8 {
+					b.WriteRune(rune(n))
+				} else {
+					units = append(units, uint16(n))
+				}
+			}
+			if len(units) > 0 {
+				b.WriteString(string(utf16.Decode(units)))
+			}
+			i += 4 + end + 4
+		case bytes.HasPrefix(rest, []byte(`\P`)) && len(rest) >= 4 && rest[3] == '\\':
+			i += 4
+		default:
+			b.WriteByte('\\')
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool { return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_' }
+
+func isIdent(c byte) bool { return isIdentStart(c) || isDigit(c) }
@@ -0,0 +1,208 @@
+package ifc
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHeader = `ISO-10303-21;
+HEADER;
+/* written by a test */
+FILE_DESCRIPTION(('ViewDefinition [CoordinationView_V2.0]','Option [Drawing]'),'2;1');
+FILE_NAME('Building A.ifc','2024-03-01T10:00:00',('Anna','Bo'),('ValvX AB'),'IfcOpenShell 0.7','Revit 2024','none');
+FILE_SCHEMA(('IFC4'));
+ENDSEC;
+`
+
+func TestReadHeader(t *testing.T) {
+	h, err := ReadHeader(strings.NewReader(testHeader + "DATA;\nENDSEC;\nEND-ISO-10303-21;\n"))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	want := &Header{
+		Description:         []string{"ViewDefinition [CoordinationView_V2.0]", "Option [Drawing]"},
+		ImplementationLevel: "2;1",
+		ViewDefinition:      "CoordinationView_V2.0",
+		FileName:            "Building A.ifc",
+		TimeStamp:           "2024-03-01T10:00:00",
+		Authors:             []string{"Anna", "Bo"},
+		Organizations:       []string{"ValvX AB"},
+		PreprocessorVersion: "IfcOpenShell 0.7",
+		OriginatingSystem:   "Revit 2024",
+		Authorization:       "none",
+		Schemas:             []string{"IFC4"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("ReadHeader =\n%+v\nwant\n%+v", h, want)
+	}
+	if got := h.Schema(); got != "IFC4" {
+		t.Errorf("Schema() = %q", got)
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want error
+	}{
+		{"empty", "", ErrNotSTEP},
+		{"not STEP", "PK\x03\x04 zipped;", ErrNotSTEP},
+		{"truncated header", "ISO-10303-21;\nHEADER;\nFILE_SCHEMA(('IFC2X3'));\n", io.ErrUnexpectedEOF},
+		{"unterminated statement", "ISO-10303-21;\nHEADER;\nFILE_SCHEMA(('IFC2X3'))", io.ErrUnexpectedEOF},
+		{"unterminated comment", "ISO-10303-21;\nHEADER;\n/* never closed", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHeader(strings.NewReader(tt.src)); !errors.Is(err, tt.want) {
+				t.Errorf("ReadHeader error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	src := testHeader + `DATA;
+#1= IFCWALL ( '2O2Fr$t4X7Zf8NOew3FLOH' , #2 , 'Wall; with ''quotes''' , $ ) ;
+/* a comment; with a semicolon */
+#2=IFCOWNERHISTORY(#3,#4,$,.ADDED.,$,$,$,1700000000);
+#10=(IFCREPRESENTATIONCONTEXT('a',$)IFCGEOMETRICREPRESENTATIONCONTEXT($,$,3,1.E-05,#11,$));
+ENDSEC;
+END-ISO-10303-21;
+`
+	r := NewReader(strings.NewReader(src))
+	type entity struct {
+		ID   uint64
+		Type string
+		Raw  string
+	}
+	var got []entity
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, entity{e.ID, e.Type, string(e.raw)})
+	}
+	want := []entity{
+		{1, "IFCWALL", `('2O2Fr$t4X7Zf8NOew3FLOH',#2,'Wall; with ''quotes''',$)`},
+		{2, "IFCOWNERHISTORY", `(#3,#4,$,.ADDED.,$,$,$,1700000000)`},
+		{10, "IFCREPRESENTATIONCONTEXT", `('a',$)`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entities =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestReaderNextTruncated(t *testing.T) {
+	r := NewReader(strings.NewReader(testHeader + "DATA;\n#1=IFCWALL('x',$);\n"))
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("first Next: %v", err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next past the end of a truncated file = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestEntityArgs(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []Value
+	}{
+		{`()`, []Value{}},
+		{`($,*,#12,.T.,.NOTDEFINED.)`, []Value{nil, Derived{}, Ref(12), Enum("T"), Enum("NOTDEFINED")}},
+		{`(1,-2,+3,1.5,-0.25,1.,2.E-3,1.5E+2)`, []Value{int64(1), int64(-2), int64(3), 1.5, -0.25, 1.0, 0.002, 150.0}},
+		{`('a','it''s','')`, []Value{"a", "it's", ""}},
+		{`((#1,#2),(),((1.,2.),(3.,4.)))`, []Value{
+			[]Value{Ref(1), Ref(2)},
+			[]Value{},
+			[]Value{[]Value{1.0, 2.0}, []Value{3.0, 4.0}},
+		}},
+		{`(IFCLABEL('x'),IFCBOOLEAN(.F.),IfcReal(2.5))`, []Value{
+			Typed{Type: "IFCLABEL", Value: "x"},
+			Typed{Type: "IFCBOOLEAN", Value: Enum("F")},
+			Typed{Type: "IFCREAL", Value: 2.5},
+		}},
+		{`("0FF")`, []Value{Binary("0FF")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			e := Entity{ID: 1, Type: "TEST", raw: []byte(tt.raw)}
+			got, err := e.Args()
+			if err != nil {
+				t.Fatalf("Args: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Args = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntityArgsErrors(t *testing.T) {
+	for _, raw := range []string{
+		``,
+		`'not a list'`,
+		`(1,2`,
+		`('open`,
+		`(.ENUM)`,
+		`(#x)`,
+		`(IFCLABEL'x')`,
+		`(1;2)`,
+		`(1.2.3)`,
+	} {
+		e := Entity{ID: 7, Type: "TEST", raw: []byte(raw)}
+		if args, err := e.Args(); err == nil {
+			t.Errorf("Args(%q) = %#v, want an error", raw, args)
+		}
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{`plain`, "plain"},
+		{`back\\slash`, `back\slash`},
+		{`V\S\dgatan`, "Vägatan"},
+		{`\X\E4`, "ä"},
+		{`\X2\00E500E400F6\X0\`, "åäö"},
+		{`\X2\D83DDE00\X0\`, "😀"},
+		{`\X4\0001F600\X0\`, "😀"},
+		{`\PA\text`, "text"},
+		{"caf\xe9", "café"},
+		{`\X2\00E5`, `\X2\00E5`},
+	}
+	for _, tt := range tests {
+		if got := decodeString([]byte(tt.raw)); got != tt.want {
+			t.Errorf("decodeString(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestValueHelpers(t *testing.T) {
+	if got := String(Typed{Type: "IFCLABEL", Value: "x"}); got != "x" {
+		t.Errorf("String(typed) = %q", got)
+	}
+	if got := String(Ref(1)); got != "" {
+		t.Errorf("String(ref) = %q", got)
+	}
+	if got := Strings([]Value{"a", nil, Typed{Value: "b"}, ""}); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Strings = %q", got)
+	}
+	if got := Refs([]Value{Ref(1), "x", Ref(3)}); !reflect.DeepEqual(got, []Ref{1, 3}) {
+		t.Errorf("Refs = %v", got)
+	}
+	if n, ok := Number(Typed{Value: int64(4)}); !ok || n != 4 {
+		t.Errorf("Number(typed int) = %v, %v", n, ok)
+	}
+	if _, ok := Number("4"); ok {
+		t.Error("Number(string) succeeded")
+	}
+}
@@ -0,0 +1,273 @@
+package ifc
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Takeoff grouping dimensions.
+const (
+	GroupType           = "type"
+	GroupStorey         = "storey"
+	GroupClassification = "classification"
+	GroupProperty       = "property"
+)
+
+// TakeoffOptions selects how quantities are grouped.
+type TakeoffOptions struct {
+	GroupBy []string
+
+	// Classification is the system to group by, e.g. CoClass; empty uses
+	// each element's first classification.
+	Classification string
+
+	// Property is "Pset.Property", required when grouping by property.
+	Property string
+}
+
+// Takeoff is a quantity takeoff of one file version.
+type Takeoff struct {
+	GroupBy        []string       `json:"groupBy"`
+	Classification string         `json:"classification,omitempty"`
+	Property       string         `json:"property,omitempty"`
+	Groups         []TakeoffGroup `json:"groups"`
+}
+
+// TakeoffGroup sums the base quantities of the elements sharing Key, which
+// maps each grouping dimension to its value ("" when the element has none).
+type TakeoffGroup struct {
+	Key        map[string]string `json:"key"`
+	Elements   int               `json:"elements"`
+	Quantities []TakeoffQuantity `json:"quantities"`
+}
+
+// TakeoffQuantity is the total of one quantity across a group.
+type TakeoffQuantity struct {
+	Set   string  `json:"set"`
+	Name  string  `json:"name"`
+	Kind  string  `json:"kind"`
+	Total float64 `json:"total"`
+}
+
+// QuantityTakeoff aggregates the IfcElementQuantity values of a version's
+// elements. Type objects are skipped: their quantities are already counted
+// on each occurrence that inherits them.
+func (s *Service) QuantityTakeoff(ctx context.Context, fileVersionID string, opts TakeoffOptions) (*Takeoff, error) {
+	if len(opts.GroupBy) == 0 {
+		opts.GroupBy = []string{GroupType, GroupStorey}
+	}
+	var pset, prop string
+	for _, g := range opts.GroupBy {
+		switch g {
+		case GroupType, GroupStorey, GroupClassification:
+		case GroupProperty:
+			var ok bool
+			if pset, prop, ok = strings.Cut(opts.Property, "."); !ok || pset == "" || prop == "" {
+				return nil, fmt.Errorf("%w: property must be given as Pset.Property", ErrInvalid)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown grouping %q", ErrInvalid, g)
+		}
+	}
+	if err := s.requireIndexed(ctx, fileVersionID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT type, COALESCE(storey_name, ''), quantities, classifications,
+		       CASE WHEN $2::text <> '' THEN psets -> $2::text -> $3::text END
+		FROM arca_ifc_element
+		WHERE file_version_id = $1 AND quantities IS NOT NULL`,
+		fileVersionID, pset, prop)
+	if err != nil {
+		return nil, fmt.Errorf("query elements: %w", err)
+	}
+	defer rows.Close()
+
+	b := newTakeoffBuilder(opts)
+	for rows.Next() {
+		var typ, storey string
+		var rawQuantities, rawClasses, rawProp []byte
+		if err := rows.Scan(&typ, &storey, &rawQuantities, &rawClasses, &rawProp); err != nil {
+			return nil, fmt.Errorf("scan element: %w", err)
+		}
+		if isTypeObject(typ) {
+			continue
+		}
+
+		var quantities map[string]map[string]Quantity
+		if err := json.Unmarshal(rawQuantities, &quantities); err != nil {
+			return nil, fmt.Errorf("decode quantities: %w", err)
+		}
+		var classes []Classification
+		if rawClasses != nil {
+			if err := json.Unmarshal(rawClasses, &classes); err != nil {
+				return nil, fmt.Errorf("decode classifications: %w", err)
+			}
+		}
+		b.add(typ, storey, quantities, classes, rawProp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return b.takeoff(), nil
+}
+
+// takeoffBuilder sums element quantities into the groups of a takeoff.
+type takeoffBuilder struct {
+	opts   TakeoffOptions
+	groups map[string]*takeoffTotals
+}
+
+type takeoffTotals struct {
+	group  TakeoffGroup
+	values map[[2]string]*TakeoffQuantity
+}
+
+func newTakeoffBuilder(opts TakeoffOptions) *takeoffBuilder {
+	return &takeoffBuilder{opts: opts, groups: make(map[string]*takeoffTotals)}
+}
+
+// add counts one element, given its type, storey name, base quantities,
+// classifications and the stored value of the grouping property.
+func (b *takeoffBuilder) add(typ, storey string, quantities map[string]map[string]Quantity, classes []Classification, rawProp []byte) {
+	key := make(map[string]string, len(b.opts.GroupBy))
+	parts := make([]string, len(b.opts.GroupBy))
+	for i, g := range b.opts.GroupBy {
+		switch g {
+		case GroupType:
+			key[g] = typ
+		case GroupStorey:
+			key[g] = storey
+		case GroupClassification:
+			key[g] = classificationCode(classes, b.opts.Classification)
+		case GroupProperty:
+			key[g] = propertyText(rawProp)
+		}
+		parts[i] = key[g]
+	}
+
+	id := strings.Join(parts, "\x00")
+	t := b.groups[id]
+	if t == nil {
+		t = &takeoffTotals{group: TakeoffGroup{Key: key}, values: make(map[[2]string]*TakeoffQuantity)}
+		b.groups[id] = t
+	}
+	t.group.Elements++
+	for set, qs := range quantities {
+		for name, q := range qs {
+			v := t.values[[2]string{set, name}]
+			if v == nil {
+				v = &TakeoffQuantity{Set: set, Name: name, Kind: q.Kind}
+				t.values[[2]string{set, name}] = v
+			}
+			v.Total += q.Value
+		}
+	}
+}
+
+// takeoff returns the groups sorted by key, with each group's quantities
+// sorted by set and name.
+func (b *takeoffBuilder) takeoff() *Takeoff {
+	takeoff := &Takeoff{
+		GroupBy:        b.opts.GroupBy,
+		Classification: b.opts.Classification,
+		Property:       b.opts.Property,
+		Groups:         make([]TakeoffGroup, 0, len(b.groups)),
+	}
+	for _, t := range b.groups {
+		t.group.Quantities = make([]TakeoffQuantity, 0, len(t.values))
+		for _, v := range t.values {
+			t.group.Quantities = append(t.group.Quantities, *v)
+		}
+		sort.Slice(t.group.Quantities, func(i, j int) bool {
+			a, b := t.group.Quantities[i], t.group.Quantities[j]
+			return a.Set < b.Set || a.Set == b.Set && a.Name < b.Name
+		})
+		takeoff.Groups = append(takeoff.Groups, t.group)
+	}
+	sort.Slice(takeoff.Groups, func(i, j int) bool {
+		for _, g := range b.opts.GroupBy {
+			x, y := takeoff.Groups[i].Key[g], takeoff.Groups[j].Key[g]
+			if x != y {
+				return x < y
+			}
+		}
+		return false
+	})
+	return takeoff
+}
+
+// classificationCode returns the identification (or name, when there is
+// none) of the first classification in system, or of the first one at all
+// when system is empty.
+func classificationCode(classes []Classification, system string) string {
+	i := slices.IndexFunc(classes, func(c Classification) bool {
+		return system == "" || strings.EqualFold(c.System, system)
+	})
+	if i < 0 {
+		return ""
+	}
+	if classes[i].Identification != "" {
+		return classes[i].Identification
+	}
+	return classes[i].Name
+}
+
+// propertyText renders a stored property value as a grouping key.
+func propertyText(raw []byte) string {
+	var v interface{}
+	if raw == nil || json.Unmarshal(raw, &v) != nil {
+		return ""
+	}
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return string(raw)
+}
+
+// WriteCSV writes the takeoff as one row per group and quantity, with the
+// grouping dimensions as leading columns. Groups without quantities get a
+// single row with the quantity columns empty.
+func (t *Takeoff) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := append(slices.Clone(t.GroupBy), "elements", "quantity_set", "quantity", "kind", "total")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, g := range t.Groups {
+		lead := make([]string, 0, len(header))
+		for _, dim := range t.GroupBy {
+			lead = append(lead, g.Key[dim])
+		}
+		lead = append(lead, strconv.Itoa(g.Elements))
+		if len(g.Quantities) == 0 {
+			if err := cw.Write(append(lead, "", "", "", "")); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, q := range g.Quantities {
+			row := append(slices.Clone(lead), q.Set, q.Name, q.Kind, strconv.FormatFloat(q.Total, 'f', -1, 64))
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,154 @@
+package ifc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTakeoffBuilder(t *testing.T) {
+	wall := map[string]map[string]Quantity{
+		"Qto_WallBaseQuantities": {
+			"NetSideArea": {Kind: QuantityArea, Value: 10},
+			"Length":      {Kind: QuantityLength, Value: 4},
+		},
+	}
+	slab := map[string]map[string]Quantity{
+		"Qto_SlabBaseQuantities": {"NetVolume": {Kind: QuantityVolume, Value: 2.5}},
+	}
+	coclass := []Classification{
+		{System: "BSAB 96", Identification: "27.B"},
+		{System: "CoClass", Identification: "", Name: "Vägg"},
+	}
+
+	b := newTakeoffBuilder(TakeoffOptions{GroupBy: []string{GroupType, GroupStorey}})
+	b.add("IFCWALL", "Plan 2", wall, nil, nil)
+	b.add("IFCWALL", "Plan 1", wall, nil, nil)
+	b.add("IFCWALL", "Plan 1", wall, nil, nil)
+	b.add("IFCSLAB", "Plan 1", slab, nil, nil)
+	b.add("IFCSLAB", "", nil, nil, nil)
+
+	got := b.takeoff()
+	want := []TakeoffGroup{
+		{Key: map[string]string{GroupType: "IFCSLAB", GroupStorey: ""}, Elements: 1, Quantities: []TakeoffQuantity{}},
+		{Key: map[string]string{GroupType: "IFCSLAB", GroupStorey: "Plan 1"}, Elements: 1, Quantities: []TakeoffQuantity{
+			{Set: "Qto_SlabBaseQuantities", Name: "NetVolume", Kind: QuantityVolume, Total: 2.5},
+		}},
+		{Key: map[string]string{GroupType: "IFCWALL", GroupStorey: "Plan 1"}, Elements: 2, Quantities: []TakeoffQuantity{
+			{Set: "Qto_WallBaseQuantities", Name: "Length", Kind: QuantityLength, Total: 8},
+			{Set: "Qto_WallBaseQuantities", Name: "NetSideArea", Kind: QuantityArea, Total: 20},
+		}},
+		{Key: map[string]string{GroupType: "IFCWALL", GroupStorey: "Plan 2"}, Elements: 1, Quantities: []TakeoffQuantity{
+			{Set: "Qto_WallBaseQuantities", Name: "Length", Kind: QuantityLength, Total: 4},
+			{Set: "Qto_WallBaseQuantities", Name: "NetSideArea", Kind: QuantityArea, Total: 10},
+		}},
+	}
+	if !reflect.DeepEqual(got.Groups, want) {
+		t.Errorf("Groups =\n%+v\nwant\n%+v", got.Groups, want)
+	}
+
+	b = newTakeoffBuilder(TakeoffOptions{GroupBy: []string{GroupClassification, GroupProperty}, Classification: "coclass", Property: "Pset_WallCommon.IsExternal"})
+	b.add("IFCWALL", "", wall, coclass, []byte(`true`))
+	b.add("IFCWALL", "", wall, coclass, []byte(`false`))
+	b.add("IFCWALL", "", wall, coclass, []byte(`true`))
+	got = b.takeoff()
+	keys := make([]map[string]string, len(got.Groups))
+	for i, g := range got.Groups {
+		keys[i] = g.Key
+	}
+	wantKeys := []map[string]string{
+		{GroupClassification: "Vägg", GroupProperty: "false"},
+		{GroupClassification: "Vägg", GroupProperty: "true"},
+	}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("keys = %v, want %v", keys, wantKeys)
+	}
+	if got.Groups[1].Elements != 2 {
+		t.Errorf("elements in the external group = %d, want 2", got.Groups[1].Elements)
+	}
+}
+
+func TestQuantityTakeoffOptions(t *testing.T) {
+	s := &Service{}
+	for _, opts := range []TakeoffOptions{
+		{GroupBy: []string{"colour"}},
+		{GroupBy: []string{GroupProperty}},
+		{GroupBy: []string{GroupProperty}, Property: "IsExternal"},
+		{GroupBy: []string{GroupProperty}, Property: "Pset_WallCommon."},
+	} {
+		if _, err := s.QuantityTakeoff(context.Background(), "v1", opts); !errors.Is(err, ErrInvalid) {
+			t.Errorf("QuantityTakeoff(%+v) error = %v, want ErrInvalid", opts, err)
+		}
+	}
+}
+
+func TestClassificationCode(t *testing.T) {
+	classes := []Classification{
+		{System: "BSAB 96", Identification: "27.B", Name: "Väggar"},
+		{System: "CoClass", Name: "Innervägg"},
+	}
+	tests := []struct {
+		system, want string
+	}{
+		{"", "27.B"},
+		{"bsab 96", "27.B"},
+		{"CoClass", "Innervägg"},
+		{"Uniclass", ""},
+	}
+	for _, tt := range tests {
+		if got := classificationCode(classes, tt.system); got != tt.want {
+			t.Errorf("classificationCode(%q) = %q, want %q", tt.system, got, tt.want)
+		}
+	}
+	if got := classificationCode(nil, ""); got != "" {
+		t.Errorf("classificationCode(nil) = %q", got)
+	}
+}
+
+func TestPropertyText(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		want string
+	}{
+		{nil, ""},
+		{[]byte(`null`), ""},
+		{[]byte(`"EI60"`), "EI60"},
+		{[]byte(`2.5`), "2.5"},
+		{[]byte(`100000000`), "100000000"},
+		{[]byte(`false`), "false"},
+		{[]byte(`[1,2]`), "[1,2]"},
+		{[]byte(`{`), ""},
+	}
+	for _, tt := range tests {
+		if got := propertyText(tt.raw); got != tt.want {
+			t.Errorf("propertyText(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestTakeoffWriteCSV(t *testing.T) {
+	takeoff := &Takeoff{
+		GroupBy: []string{GroupType, GroupStorey},
+		Groups: []TakeoffGroup{
+			{Key: map[string]string{GroupType: "IFCSLAB", GroupStorey: "Plan 1, norr"}, Elements: 1},
+			{Key: map[string]string{GroupType: "IFCWALL", GroupStorey: "Plan 1"}, Elements: 2, Quantities: []TakeoffQuantity{
+				{Set: "Qto_WallBaseQuantities", Name: "Length", Kind: QuantityLength, Total: 8},
+				{Set: "Qto_WallBaseQuantities", Name: "NetSideArea", Kind: QuantityArea, Total: 20.25},
+			}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := takeoff.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	want := `type,storey,elements,quantity_set,quantity,kind,total
+IFCSLAB,"Plan 1, norr",1,,,,
+IFCWALL,Plan 1,2,Qto_WallBaseQuantities,Length,length,8
+IFCWALL,Plan 1,2,Qto_WallBaseQuantities,NetSideArea,area,20.25
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCSV =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package ifc
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a file version does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrNotIndexed is returned when a version has not been processed yet,
+	// or is not an IFC file.
+	ErrNotIndexed = errors.New("model not indexed")
+	// ErrInvalid is returned for requests that can never succeed.
+	ErrInvalid = errors.New("invalid request")
+)
+
+// Element is one IfcRoot instance of a file version: an object, a
+// relationship or a property definition, keyed by its GlobalId.
+type Element struct {
+	GlobalID string `json:"globalId"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	StepID   uint64 `json:"stepId"`
+
+	// Storey is the IfcBuildingStorey the element is contained in, directly
+	// or through a space or an aggregating element.
+	StoreyGlobalID *string `json:"storeyGlobalId"`
+	StoreyName     *string `json:"storeyName"`
+
+	// Psets maps property set name to property name to value, and
+	// Quantities IfcElementQuantity name to quantity name to value, both
+	// including sets inherited from the element's type object. Placement is
+	// nil for elements without a resolvable IfcLocalPlacement. Element
+	// listings leave these details out.
+	Psets      map[string]map[string]interface{} `json:"psets,omitempty"`
+	Quantities map[string]map[string]Quantity    `json:"quantities,omitempty"`
+	Placement  *Placement                        `json:"placement,omitempty"`
+
+	// Classifications are the element's IfcClassificationReferences,
+	// followed by those of its type object.
+	Classifications []Classification `json:"classifications,omitempty"`
+}
+
+// Classification is a classification reference, e.g. CoClass or Uniclass.
+type Classification struct {
+	System         string `json:"system"`
+	Identification string `json:"identification"`
+	Name           string `json:"name"`
+}
+
+// Quantity kinds, from the IfcPhysicalSimpleQuantity subtype.
+const (
+	QuantityLength = "length"
+	QuantityArea   = "area"
+	QuantityVolume = "volume"
+	QuantityCount  = "count"
+	QuantityWeight = "weight"
+	QuantityTime   = "time"
+)
+
+// Quantity is one base quantity value, in the model's units.
+type Quantity struct {
+	Kind  string  `json:"kind"`
+	Value float64 `json:"value"`
+}
+
+// TreeNode is an object in the spatial structure tree.
+type TreeNode struct {
+	GlobalID string     `json:"globalId"`
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	StepID   uint64     `json:"stepId"`
+	Children []TreeNode `json:"children,omitempty"`
+}
+
+// ElementFilter selects elements of a version. Empty fields match anything.
+type ElementFilter struct {
+	Types     []string // STEP entity names, matched case-insensitively
+	Storey    string   // storey name or GlobalId
+	GlobalIDs []string
+	After     uint64 // STEP id to continue after
+	Limit     int
+}
+
+// Validation rule kinds.
+const (
+	// RuleRequiredPset requires Pset, and each of Properties within it, on
+	// every element of Types.
+	RuleRequiredPset = "required_pset"
+	// RuleUniqueGlobalID reports GlobalIds used by more than one instance.
+	RuleUniqueGlobalID = "unique_globalid"
+	// RuleStoreyAssigned requires elements of Types to be contained in a
+	// storey.
+	RuleStoreyAssigned = "storey_assigned"
+	// RuleNaming requires the Name of elements of Types to match Pattern.
+	RuleNaming = "naming"
+)
+
+// Rule is a project's model check, run against every IFC version uploaded
+// to the project. Types defaults to all placed products when empty.
+type Rule struct {
+	ID         string    `json:"id"`
+	ProjectID  string    `json:"projectId"`
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Types      []string  `json:"types"`
+	Pset       string    `json:"pset,omitempty"`
+	Properties []string  `json:"properties,omitempty"`
+	Pattern    string    `json:"pattern,omitempty"`
+	Priority   string    `json:"priority"`
+	Enabled    bool      `json:"enabled"`
+	CreatorID  string    `json:"creatorId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// CreateRuleRequest is the request body for creating a rule.
+type CreateRuleRequest struct {
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"`
+	Types      []string `json:"types,omitempty"`
+	Pset       string   `json:"pset,omitempty"`
+	Properties []string `json:"properties,omitempty"`
+	Pattern    string   `json:"pattern,omitempty"`
+	Priority   string   `json:"priority,omitempty"`
+	Enabled    *bool    `json:"enabled,omitempty"`
+}
+
+// UpdateRuleRequest is the request body for changing a rule. Absent fields
+// are left as they are; the kind cannot change.
+type UpdateRuleRequest struct {
+	Name       *string   `json:"name,omitempty"`
+	Types      *[]string `json:"types,omitempty"`
+	Pset       *string   `json:"pset,omitempty"`
+	Properties *[]string `json:"properties,omitempty"`
+	Pattern    *string   `json:"pattern,omitempty"`
+	Priority   *string   `json:"priority,omitempty"`
+	Enabled    *bool     `json:"enabled,omitempty"`
+}
+
+// RuleResult is the outcome of one rule on one file version. TopicID is the
+// BCF topic raised for the failures, if any.
+type RuleResult struct {
+	RuleID    string    `json:"ruleId"`
+	RuleName  string    `json:"ruleName"`
+	Failures  int       `json:"failures"`
+	TopicID   *string   `json:"topicId"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
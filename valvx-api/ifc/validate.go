@@ -0,0 +1,397 @@
+package ifc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nsssthlm/valvx-api/collab"
+)
+
+const (
+	// maxTopicComponents caps the GlobalIds put in a topic's viewpoint, so
+	// a rule that fails on a whole model still gives a viewpoint the viewer
+	// can load.
+	maxTopicComponents = 10000
+	// listedFailures is how many offending elements a topic description
+	// names.
+	listedFailures = 20
+)
+
+// spatialTypes are never checked for storey assignment: they make up the
+// structure storeys live in, or are voids owned by another element.
+var spatialTypes = []string{"IFCPROJECT", "IFCSITE", "IFCBUILDING", "IFCBUILDINGSTOREY", "IFCOPENINGELEMENT"}
+
+// ruleElement is the part of an indexed element the rules look at.
+type ruleElement struct {
+	GlobalID  string
+	Type      string
+	Name      string
+	InStorey  bool
+	Psets     map[string]map[string]interface{}
+	Placement *Placement
+}
+
+// ValidateUpload runs the project's rules against a freshly uploaded
+// version on behalf of its uploader. Versions that are not indexed IFC
+// models are skipped.
+func (s *Service) ValidateUpload(ctx context.Context, fileVersionID string) error {
+	var creatorID string
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT creator_id FROM arca_file_version WHERE id = $1`, fileVersionID).Scan(&creatorID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return fmt.Errorf("get file version: %w", err)
+	}
+	_, err = s.ValidateVersion(ctx, fileVersionID, creatorID)
+	if errors.Is(err, ErrNotIndexed) {
+		return nil
+	}
+	return err
+}
+
+// ValidateVersion runs the enabled rules of the version's project against
+// its element index and records one result per rule. A rule with failures
+// opens a BCF topic, created by creatorID, whose viewpoint selects the
+// offending elements; checking the same version again updates the counts
+// but does not open a second topic for a rule.
+func (s *Service) ValidateVersion(ctx context.Context, fileVersionID, creatorID string) ([]RuleResult, error) {
+	if err := s.requireIndexed(ctx, fileVersionID); err != nil {
+		return nil, err
+	}
+	projectID, err := s.VersionProjectID(ctx, fileVersionID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.ListRules(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	rules = slices.DeleteFunc(rules, func(r Rule) bool { return !r.Enabled })
+	if len(rules) == 0 {
+		return []RuleResult{}, nil
+	}
+
+	needPsets := slices.ContainsFunc(rules, func(r Rule) bool { return r.Kind == RuleRequiredPset })
+	elements, err := s.ruleElements(ctx, fileVersionID, needPsets)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileName string
+	var number int
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT f.name, fv.number
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE fv.id = $1`, fileVersionID).Scan(&fileName, &number)
+	if err != nil {
+		return nil, fmt.Errorf("get file name: %w", err)
+	}
+
+	results := make([]RuleResult, 0, len(rules))
+	for _, rule := range rules {
+		failed, err := evaluateRule(&rule, elements)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.recordResult(ctx, projectID, fileVersionID, creatorID, &rule, failed,
+			fmt.Sprintf("%s (version %d)", fileName, number))
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		results = append(results, *res)
+	}
+	return results, nil
+}
+
+// ValidationResults returns the last recorded outcome of each rule that was
+// run against a version.
+func (s *Service) ValidationResults(ctx context.Context, fileVersionID string) ([]RuleResult, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT r.id, r.name, res.failures, res.topic_id, res.checked_at
+		FROM arca_ifc_rule_result res
+		JOIN arca_ifc_rule r ON r.id = res.rule_id
+		WHERE res.file_version_id = $1
+		ORDER BY r.created_at, r.id`, fileVersionID)
+	if err != nil {
+		return nil, fmt.Errorf("query rule results: %w", err)
+	}
+	defer rows.Close()
+
+	results := []RuleResult{}
+	for rows.Next() {
+		var res RuleResult
+		if err := rows.Scan(&res.RuleID, &res.RuleName, &res.Failures, &res.TopicID, &res.CheckedAt); err != nil {
+			return nil, fmt.Errorf("scan rule result: %w", err)
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// ruleElements loads every indexed instance of a version in file order.
+func (s *Service) ruleElements(ctx context.Context, fileVersionID string, psets bool) ([]ruleElement, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT global_id, type, COALESCE(name, ''), storey_global_id IS NOT NULL,
+		       CASE WHEN $2::boolean THEN psets END, placement
+		FROM arca_ifc_element
+		WHERE file_version_id = $1
+		ORDER BY step_id`, fileVersionID, psets)
+	if err != nil {
+		return nil, fmt.Errorf("query elements: %w", err)
+	}
+	defer rows.Close()
+
+	var elements []ruleElement
+	for rows.Next() {
+		var el ruleElement
+		var rawPsets, rawPlacement []byte
+		if err := rows.Scan(&el.GlobalID, &el.Type, &el.Name, &el.InStorey, &rawPsets, &rawPlacement); err != nil {
+			return nil, fmt.Errorf("scan element: %w", err)
+		}
+		if rawPsets != nil {
+			if err := json.Unmarshal(rawPsets, &el.Psets); err != nil {
+				return nil, fmt.Errorf("decode psets of %s: %w", el.GlobalID, err)
+			}
+		}
+		if rawPlacement != nil {
+			if err := json.Unmarshal(rawPlacement, &el.Placement); err != nil {
+				return nil, fmt.Errorf("decode placement of %s: %w", el.GlobalID, err)
+			}
+		}
+		elements = append(elements, el)
+	}
+	return elements, rows.Err()
+}
+
+// evaluateRule returns the elements failing a rule, at most one per
+// GlobalId.
+func evaluateRule(rule *Rule, elements []ruleElement) ([]*ruleElement, error) {
+	var pattern *regexp.Regexp
+	if rule.Kind == RuleNaming {
+		var err error
+		if pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("%w: rule %s: pattern: %v", ErrInvalid, rule.Name, err)
+		}
+	}
+
+	var failed []*ruleElement
+	seen := make(map[string]int)
+	reported := make(map[string]bool)
+	for i := range elements {
+		el := &elements[i]
+		if !ruleApplies(rule, el) {
+			continue
+		}
+		var fails bool
+		switch rule.Kind {
+		case RuleRequiredPset:
+			fails = !hasProperties(el.Psets[rule.Pset], rule.Properties)
+		case RuleUniqueGlobalID:
+			seen[el.GlobalID]++
+			fails = seen[el.GlobalID] == 2
+		case RuleStoreyAssigned:
+			fails = !el.InStorey
+		case RuleNaming:
+			fails = !pattern.MatchString(el.Name)
+		}
+		if fails && !reported[el.GlobalID] {
+			reported[el.GlobalID] = true
+			failed = append(failed, el)
+		}
+	}
+	return failed, nil
+}
+
+// ruleApplies reports whether a rule checks an element. Rules without types
+// check placed products, leaving out type objects, relationships and
+// property definitions; GlobalId uniqueness covers every instance.
+func ruleApplies(rule *Rule, el *ruleElement) bool {
+	if len(rule.Types) > 0 {
+		return slices.Contains(rule.Types, el.Type)
+	}
+	switch rule.Kind {
+	case RuleUniqueGlobalID:
+		return true
+	case RuleStoreyAssigned:
+		if slices.Contains(spatialTypes, el.Type) {
+			return false
+		}
+	}
+	return el.Placement != nil && !isTypeObject(el.Type)
+}
+
+// hasProperties reports whether a property set exists and holds a value
+// for each of the named properties.
+func hasProperties(pset map[string]interface{}, names []string) bool {
+	if pset == nil {
+		return false
+	}
+	for _, name := range names {
+		if pset[name] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// recordResult stores a rule's outcome and opens its topic when the rule
+// failed and none is open for this version yet. The result row stays
+// locked until the topic is linked, so concurrent checks of the same
+// version wait instead of raising a duplicate. A topic opened for a result
+// that ends up rolled back is deleted again, so the next run starts clean.
+func (s *Service) recordResult(ctx context.Context, projectID, fileVersionID, creatorID string, rule *Rule, failed []*ruleElement, source string) (*RuleResult, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var createdTopic string
+	committed := false
+	defer func() {
+		if createdTopic != "" && !committed {
+			if err := s.Topics.DeleteTopic(context.WithoutCancel(ctx), createdTopic); err != nil {
+				log.Printf("Warning: could not delete unlinked topic %s: %v", createdTopic, err)
+			}
+		}
+	}()
+
+	res := RuleResult{RuleID: rule.ID, RuleName: rule.Name, Failures: len(failed), CheckedAt: time.Now().UTC()}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO arca_ifc_rule_result (file_version_id, rule_id, failures, checked_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (file_version_id, rule_id)
+		DO UPDATE SET failures = EXCLUDED.failures, checked_at = EXCLUDED.checked_at
+		RETURNING topic_id`,
+		fileVersionID, rule.ID, res.Failures, res.CheckedAt).Scan(&res.TopicID)
+	if err != nil {
+		return nil, fmt.Errorf("store result: %w", err)
+	}
+
+	if res.Failures > 0 && res.TopicID == nil {
+		topic, err := s.Topics.CreateTopic(ctx, projectID, creatorID, failureTopic(fileVersionID, rule, failed, source))
+		if err != nil {
+			return nil, fmt.Errorf("create topic: %w", err)
+		}
+		createdTopic = topic.ID
+		res.TopicID = &topic.ID
+		_, err = tx.ExecContext(ctx, `
+			UPDATE arca_ifc_rule_result SET topic_id = $3
+			WHERE file_version_id = $1 AND rule_id = $2`,
+			fileVersionID, rule.ID, topic.ID)
+		if err != nil {
+			return nil, fmt.Errorf("link topic: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	committed = true
+	return &res, nil
+}
+
+// failureTopic describes a failed rule as a BCF topic with a viewpoint that
+// selects the offending elements.
+func failureTopic(fileVersionID string, rule *Rule, failed []*ruleElement, source string) collab.CreateTopicRequest {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d elements in %s fail the %s check %q.\n", len(failed), source, rule.Kind, rule.Name)
+	switch rule.Kind {
+	case RuleRequiredPset:
+		if len(rule.Properties) > 0 {
+			fmt.Fprintf(&b, "Required: %s with %s.\n", rule.Pset, strings.Join(rule.Properties, ", "))
+		} else {
+			fmt.Fprintf(&b, "Required: %s.\n", rule.Pset)
+		}
+	case RuleNaming:
+		fmt.Fprintf(&b, "Names must match %s.\n", rule.Pattern)
+	}
+	b.WriteString("\n")
+	for _, el := range failed[:min(len(failed), listedFailures)] {
+		fmt.Fprintf(&b, "- %s %s %q\n", el.GlobalID, el.Type, el.Name)
+	}
+	if len(failed) > listedFailures {
+		fmt.Fprintf(&b, "- and %d more\n", len(failed)-listedFailures)
+	}
+	if len(failed) > maxTopicComponents {
+		fmt.Fprintf(&b, "\nThe viewpoint selects the first %d.\n", maxTopicComponents)
+	}
+
+	selected := failed[:min(len(failed), maxTopicComponents)]
+	components := collab.Components{Selection: make([]collab.Component, len(selected))}
+	for i, el := range selected {
+		components.Selection[i] = collab.Component{IfcGuid: el.GlobalID}
+	}
+	raw, _ := json.Marshal(components)
+	rawComponents := json.RawMessage(raw)
+
+	viewpoint := overviewCamera(selected)
+	viewpoint.Components = &rawComponents
+
+	title := fmt.Sprintf("%s: %d elements", rule.Name, len(failed))
+	description := b.String()
+	topicType := "Issue"
+	priority := rule.Priority
+	return collab.CreateTopicRequest{
+		Title:          title,
+		Description:    &description,
+		Priority:       &priority,
+		TopicType:      &topicType,
+		Labels:         []string{"validation"},
+		FileVersionIDs: []string{fileVersionID},
+		Viewpoint:      &viewpoint,
+	}
+}
+
+// overviewCamera looks down at the placed elements from above one corner of
+// their bounding box. Without placements it falls back to a view of the
+// origin.
+func overviewCamera(elements []*ruleElement) collab.CreateViewpointRequest {
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	placed := false
+	for _, el := range elements {
+		if el.Placement == nil {
+			continue
+		}
+		placed = true
+		for i, v := range el.Placement.Location {
+			lo[i] = min(lo[i], v)
+			hi[i] = max(hi[i], v)
+		}
+	}
+	var center [3]float64
+	extent := 10.0
+	if placed {
+		for i := range center {
+			center[i] = (lo[i] + hi[i]) / 2
+			extent = max(extent, hi[i]-lo[i])
+		}
+	}
+
+	// Back off along (1,1,1) far enough for the box to fit the field of view
+	fov := 60.0
+	distance := extent / math.Tan(fov/2*math.Pi/180)
+	offset := distance / math.Sqrt(3)
+	dir := -1 / math.Sqrt(3)
+	up := 1 / math.Sqrt(6)
+	return collab.CreateViewpointRequest{
+		CameraType:      "perspective",
+		CameraPosition:  collab.Vector3{X: center[0] + offset, Y: center[1] + offset, Z: center[2] + offset},
+		CameraDirection: collab.Vector3{X: dir, Y: dir, Z: dir},
+		CameraUp:        collab.Vector3{X: -up, Y: -up, Z: 2 * up},
+		FieldOfView:     &fov,
+	}
+}
@@ -1,9 +1,10 @@
 // Package auth implements session-based authentication using the existing
 // iam_session table. Sessions are stored as Go gob-encoded bytea in PostgreSQL.
 //
-// The session token is read from the cookie named "session" and looked up
-// in the iam_session table. The account_id is extracted from the gob-encoded
-// data field and used to identify the user.
+// The session token is read from the cookie named "session", or from an
+// "Authorization: Bearer" header for API clients that do not keep cookies,
+// and looked up in the iam_session table. The account_id is extracted from
+// the gob-encoded data field and used to identify the user.
 package auth
 
 import (
@@ -12,6 +13,7 @@ import (
 	"database/sql"
 	"encoding/gob"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -48,20 +50,18 @@ func NewSessionStore(db *sql.DB) *SessionStore {
 	return &SessionStore{DB: db}
 }
 
-// Authenticate reads the session cookie, looks up the token in iam_session,
+// Authenticate reads the session token, looks up the token in iam_session,
 // decodes the gob data, and returns the account_id. If the session is invalid
 // or expired, it returns an empty string.
 func (s *SessionStore) Authenticate(r *http.Request) string {
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil || cookie.Value == "" {
+	token := sessionToken(r)
+	if token == "" {
 		return ""
 	}
 
-	token := cookie.Value
-
 	var data []byte
 	var expiry time.Time
-	err = s.DB.QueryRowContext(r.Context(),
+	err := s.DB.QueryRowContext(r.Context(),
 		"SELECT data, expiry FROM iam_session WHERE token = $1", token,
 	).Scan(&data, &expiry)
 	if err != nil {
@@ -94,6 +94,18 @@ func (s *SessionStore) Authenticate(r *http.Request) string {
 	return accountIDStr
 }
 
+// sessionToken returns the token from the session cookie or, failing that,
+// from a bearer Authorization header.
+func sessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
 // GetProfileForProject finds the iam_profile for the given account in a project.
 func (s *SessionStore) GetProfileForProject(ctx context.Context, accountID, projectID string) (string, error) {
 	var profileID string
@@ -107,6 +119,22 @@ func (s *SessionStore) GetProfileForProject(ctx context.Context, accountID, proj
 	return profileID, err
 }
 
+// GetWritableProfileForProject finds the iam_profile for the given account in
+// a project, but only if the membership has been accepted by both sides.
+// Pending invitations can browse but not add content.
+func (s *SessionStore) GetWritableProfileForProject(ctx context.Context, accountID, projectID string) (string, error) {
+	var profileID string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT p.id FROM iam_profile p
+		JOIN iam_ident i ON i.id = p.ident_id
+		WHERE i.account_id = $1 AND p.project_id = $2
+			AND p.active = true AND p.removed = false
+			AND p.project_accepted = true AND p.account_accepted = true`,
+		accountID, projectID,
+	).Scan(&profileID)
+	return profileID, err
+}
+
 // AccountIDFromContext returns the account ID from the request context.
 func AccountIDFromContext(ctx context.Context) string {
 	v, _ := ctx.Value(ContextKeyAccountID).(string)
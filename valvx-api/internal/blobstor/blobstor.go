@@ -0,0 +1,341 @@
+// Package blobstor wraps the S3/MinIO bucket that holds uploaded file content.
+//
+// The TUS s3store writes objects into the same bucket; this package is used
+// for everything that happens after the upload — downloads, presigned URLs,
+// server-side copies and deletes.
+package blobstor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awscreds "github.com/aws/aws-sdk-go-v2/credentials"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Config holds blob storage connection settings.
+type Config struct {
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// Store provides access to objects in the configured bucket.
+type Store struct {
+	Client  *s3v2.Client
+	Bucket  string
+	presign *s3v2.PresignClient
+}
+
+// New creates a Store backed by an S3-compatible endpoint (MinIO in production).
+func New(ctx context.Context, cfg Config) (*Store, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion("us-east-1"),
+		awsconfig.WithCredentialsProvider(awscreds.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+
+	client := s3v2.NewFromConfig(awsCfg, func(o *s3v2.Options) {
+		o.BaseEndpoint = &cfg.Endpoint
+		o.UsePathStyle = true
+	})
+
+	return &Store{
+		Client:  client,
+		Bucket:  cfg.Bucket,
+		presign: s3v2.NewPresignClient(client),
+	}, nil
+}
+
+// IsNotFound reports whether err means the object does not exist.
+func IsNotFound(err error) bool {
+	var nf *types.NotFound
+	var nsk *types.NoSuchKey
+	return errors.As(err, &nf) || errors.As(err, &nsk)
+}
+
+// PresignGet returns a time-limited URL for downloading key directly from the
+// bucket. The disposition and content type are baked into the signed response
+// headers so the browser sees the same result as a proxied download.
+func (s *Store) PresignGet(ctx context.Context, key, contentDisposition, contentType string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3v2.GetObjectInput{
+		Bucket:                     aws.String(s.Bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(contentDisposition),
+		ResponseContentType:        aws.String(contentType),
+	}, s3v2.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// Object is a seekable reader over a stored object. Each Read after a Seek
+// issues a ranged GetObject, so it can be handed to http.ServeContent to get
+// Range and conditional request handling without buffering the object.
+type Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+
+	store  *Store
+	ctx    context.Context
+	offset int64
+	body   io.ReadCloser
+}
+
+// Open looks up key and returns a reader positioned at the start of the object.
+func (s *Store) Open(ctx context.Context, key string) (*Object, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		Key:          key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		store:        s,
+		ctx:          ctx,
+	}, nil
+}
+
+// Read reads from the current offset, opening a ranged request if needed.
+func (o *Object) Read(p []byte) (int, error) {
+	if o.offset >= o.Size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		out, err := o.store.Client.GetObject(o.ctx, &s3v2.GetObjectInput{
+			Bucket: aws.String(o.store.Bucket),
+			Key:    aws.String(o.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("get %s: %w", o.Key, err)
+		}
+		o.body = out.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+// Seek moves the read offset. The open response body, if any, is discarded
+// and a new ranged request is made on the next Read.
+func (o *Object) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.Size + offset
+	default:
+		return 0, errors.New("blobstor: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("blobstor: negative position")
+	}
+
+	if abs != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = abs
+	return abs, nil
+}
+
+// Close releases the underlying response body.
+func (o *Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// ObjectInfo describes a stored object without its content.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// Stat returns size and ETag for key.
+func (s *Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return s.stat(ctx, s.Bucket, key)
+}
+
+func (s *Store) stat(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	head, err := s.Client.HeadObject(ctx, &s3v2.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("head %s: %w", key, err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(head.ContentLength),
+		ETag:         aws.ToString(head.ETag),
+		LastModified: aws.ToTime(head.LastModified),
+	}, nil
+}
+
+// Get returns the full content of key as a stream. The caller must close it.
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.Client.GetObject(ctx, &s3v2.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	return out.Body, nil
+}
+
+// Hash streams key and returns the hex-encoded SHA-256 of its content.
+func (s *Store) Hash(ctx context.Context, key string) (string, error) {
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", fmt.Errorf("hash %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Walk calls fn for every object in the bucket, page by page.
+func (s *Store) Walk(ctx context.Context, fn func(ObjectInfo) error) error {
+	p := s3v2.NewListObjectsV2Paginator(s.Client, &s3v2.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", s.Bucket, err)
+		}
+		for _, obj := range page.Contents {
+			err := fn(ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Delete removes key. Deleting a missing object is not an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3v2.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// maxCopyObjectSize is the largest object S3 copies in a single CopyObject.
+// Larger objects are copied part by part with UploadPartCopy.
+const maxCopyObjectSize = 5 << 30
+
+// copyPartSize is the part size used for multipart copies.
+const copyPartSize = 512 << 20
+
+// Copy duplicates src in srcBucket to dst in the store's bucket without
+// downloading it and returns the new object's ETag.
+func (s *Store) Copy(ctx context.Context, srcBucket, src, dst string) (string, error) {
+	info, err := s.stat(ctx, srcBucket, src)
+	if err != nil {
+		return "", err
+	}
+	source := srcBucket + "/" + url.PathEscape(src)
+
+	if info.Size <= maxCopyObjectSize {
+		out, err := s.Client.CopyObject(ctx, &s3v2.CopyObjectInput{
+			Bucket:     aws.String(s.Bucket),
+			Key:        aws.String(dst),
+			CopySource: aws.String(source),
+		})
+		if err != nil {
+			return "", fmt.Errorf("copy %s to %s: %w", src, dst, err)
+		}
+		return aws.ToString(out.CopyObjectResult.ETag), nil
+	}
+
+	created, err := s.Client.CreateMultipartUpload(ctx, &s3v2.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(dst),
+	})
+	if err != nil {
+		return "", fmt.Errorf("start copy %s to %s: %w", src, dst, err)
+	}
+	abort := func(cause error) (string, error) {
+		s.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3v2.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(dst),
+			UploadId: created.UploadId,
+		})
+		return "", fmt.Errorf("copy %s to %s: %w", src, dst, cause)
+	}
+
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < info.Size; offset, number = offset+copyPartSize, number+1 {
+		end := min(offset+copyPartSize, info.Size) - 1
+		out, err := s.Client.UploadPartCopy(ctx, &s3v2.UploadPartCopyInput{
+			Bucket:          aws.String(s.Bucket),
+			Key:             aws.String(dst),
+			UploadId:        created.UploadId,
+			PartNumber:      aws.Int32(number),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int32(number),
+		})
+	}
+
+	out, err := s.Client.CompleteMultipartUpload(ctx, &s3v2.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(dst),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return aws.ToString(out.ETag), nil
+}
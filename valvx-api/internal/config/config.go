@@ -5,6 +5,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all API configuration from environment variables.
@@ -29,6 +31,10 @@ type Config struct {
 	AWSAccessKeyID    string
 	AWSSecretAccessKey string
 
+	// File downloads: redirect to presigned URLs instead of proxying bytes
+	BlobstorPresignDownloads bool
+	BlobstorPresignExpiry    time.Duration
+
 	// Speckle integration
 	SpeckleURL         string
 	SpeckleInternalURL string
@@ -36,13 +42,33 @@ type Config struct {
 	SpeckleAPIToken    string
 	SpeckleProxyEnabled bool
 
+	// Speckle conversion of uploaded IFC models; runs when the API token
+	// and project ID are set
+	SpecklePollInterval      time.Duration
+	SpeckleConversionTimeout time.Duration
+
 	// TUS upload
 	TUSEnabled  bool
 	TUSMaxSize  int64
 	TUSChunkSize int64
 
+	// Post-upload processing queue
+	UploadWorkers        int
+	UploadMaxAttempts    int
+	UploadRetryBaseDelay time.Duration
+
+	// Trash: how long deleted files and folders are kept before purging
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// Storage quotas in bytes; 0 means unlimited. Per-project and per-tenant
+	// overrides live in core_project/core_tenant.storage_quota.
+	ProjectStorageQuota int64
+	TenantStorageQuota  int64
+
 	// Security
-	PasswordPepper string
+	PasswordPepper  string
+	AdminAccountIDs []string
 
 	// Mailgun
 	MailgunAPIKey string
@@ -70,17 +96,34 @@ func Load() *Config {
 		AWSAccessKeyID:     env("AWS_ACCESS_KEY_ID", ""),
 		AWSSecretAccessKey: env("AWS_SECRET_ACCESS_KEY", ""),
 
+		BlobstorPresignDownloads: envBool("VALVX_API_BLOBSTOR_PRESIGN_DOWNLOADS", false),
+		BlobstorPresignExpiry:    envDuration("VALVX_API_BLOBSTOR_PRESIGN_EXPIRY", 15*time.Minute),
+
 		SpeckleURL:          env("VALVX_API_SPECKLE_URL", "https://speckle.valvx.se"),
 		SpeckleInternalURL:  env("VALVX_API_SPECKLE_INTERNAL_URL", "http://127.0.0.1:8080"),
 		SpeckleProjectID:    env("VALVX_API_SPECKLE_PROJECT_ID", ""),
 		SpeckleAPIToken:     env("VALVX_API_SPECKLE_API_TOKEN", ""),
 		SpeckleProxyEnabled: envBool("VALVX_API_SPECKLE_PROXY_ENABLED", false),
 
+		SpecklePollInterval:      envDuration("VALVX_API_SPECKLE_POLL_INTERVAL", 5*time.Second),
+		SpeckleConversionTimeout: envDuration("VALVX_API_SPECKLE_CONVERSION_TIMEOUT", time.Hour),
+
 		TUSEnabled:   envBool("VALVX_API_TUS_ENABLED", true),
 		TUSMaxSize:   envInt64("VALVX_API_TUS_MAX_SIZE", 5*1024*1024*1024),    // 5 GB
 		TUSChunkSize: envInt64("VALVX_API_TUS_CHUNK_SIZE", 5*1024*1024),         // 5 MB
 
-		PasswordPepper: env("VALVX_API_PASSWORD_PEPPER", ""),
+		UploadWorkers:        envInt("VALVX_API_UPLOAD_WORKERS", 4),
+		UploadMaxAttempts:    envInt("VALVX_API_UPLOAD_MAX_ATTEMPTS", 8),
+		UploadRetryBaseDelay: envDuration("VALVX_API_UPLOAD_RETRY_BASE_DELAY", 5*time.Second),
+
+		TrashRetention:     envDuration("VALVX_API_TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: envDuration("VALVX_API_TRASH_PURGE_INTERVAL", time.Hour),
+
+		ProjectStorageQuota: envInt64("VALVX_API_PROJECT_STORAGE_QUOTA", 0),
+		TenantStorageQuota:  envInt64("VALVX_API_TENANT_STORAGE_QUOTA", 0),
+
+		PasswordPepper:  env("VALVX_API_PASSWORD_PEPPER", ""),
+		AdminAccountIDs: envList("VALVX_API_ADMIN_ACCOUNT_IDS"),
 		MailgunAPIKey:  env("VALVX_API_MAILGUN_API_KEY", ""),
 
 		MigrationsDir: env("VALVX_API_MIGRATIONS_DIR", "/app/migrations"),
@@ -137,3 +180,22 @@ func envBool(key string, fallback bool) bool {
 	}
 	return fallback
 }
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
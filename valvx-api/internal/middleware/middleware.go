@@ -24,6 +24,10 @@ func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					// Deliberate abort of a response already in flight
+					panic(err)
+				}
 				log.Printf("PANIC: %v\n%s", err, debug.Stack())
 				http.Error(w, "internal server error", http.StatusInternalServerError)
 			}
@@ -52,6 +56,11 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // CORS adds Cross-Origin Resource Sharing headers.
 func CORS(allowedOrigins string) func(http.Handler) http.Handler {
 	origins := strings.Split(allowedOrigins, ",")
@@ -74,8 +83,8 @@ func CORS(allowedOrigins string) func(http.Handler) http.Handler {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Concat")
-				w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-None-Match, If-Range, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Concat")
+				w.Header().Set("Access-Control-Expose-Headers", "Location, X-Next-Cursor, Content-Range, Content-Disposition, Accept-Ranges, ETag, Upload-Offset, Upload-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension, X-Quota-Scope, X-Quota-Remaining")
 				w.Header().Set("Access-Control-Max-Age", "86400")
 			}
 
@@ -89,7 +98,7 @@ func CORS(allowedOrigins string) func(http.Handler) http.Handler {
 	}
 }
 
-// Session extracts the authenticated user from the session cookie
+// Session extracts the authenticated user from the session cookie or token
 // and puts the account_id into the request context. Does NOT block
 // unauthenticated requests — endpoints check auth individually.
 func Session(store *auth.SessionStore) func(http.Handler) http.Handler {
@@ -3,19 +3,27 @@
 // This binary serves the BCF module, TUS upload engine, file download,
 // and all existing ValvX API endpoints. It connects to PostgreSQL and MinIO.
 //
-// IFC files are parsed client-side via web-ifc WASM — no server-side
-// conversion or Speckle infrastructure needed.
+// IFC geometry is parsed client-side via web-ifc WASM; the server only reads
+// STEP header metadata and an element index from uploaded models, and checks
+// them against each project's validation rules. When a Speckle API token and
+// project are configured, uploaded IFC models are also converted in Speckle.
 //
 // Usage:
 //
 //	valvx-api              — start the HTTP server
 //	valvx-api migrate      — run database migrations and exit
+//	valvx-api backfill-storage — link existing file versions to MinIO objects and exit
+//	valvx-api backfill-hashes  — hash stored versions, share duplicate blobs and exit
+//	valvx-api backfill-ifc     — parse and index IFC models of existing versions and exit
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -26,10 +34,14 @@ import (
 
 	_ "github.com/lib/pq"
 
+	"github.com/nsssthlm/valvx-api/arca"
 	"github.com/nsssthlm/valvx-api/collab"
+	"github.com/nsssthlm/valvx-api/ifc"
 	"github.com/nsssthlm/valvx-api/internal/auth"
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
 	"github.com/nsssthlm/valvx-api/internal/config"
 	"github.com/nsssthlm/valvx-api/internal/middleware"
+	"github.com/nsssthlm/valvx-api/speckle"
 	"github.com/nsssthlm/valvx-api/upload"
 )
 
@@ -66,14 +78,84 @@ func main() {
 	sessionStore := auth.NewSessionStore(db)
 	collabHandler := collab.NewHandler(collabSvc, sessionStore)
 
-	uploadHandler := upload.NewHandler(db, upload.Config{
-		MinioEndpoint:  cfg.BlobstorServer,
-		MinioBucket:    cfg.BlobstorBucket,
-		MinioAccessKey: cfg.AWSAccessKeyID,
-		MinioSecretKey: cfg.AWSSecretAccessKey,
-		MaxUploadSize:  cfg.TUSMaxSize,
-		ChunkSize:      cfg.TUSChunkSize,
+	blobStore, err := blobstor.New(context.Background(), blobstor.Config{
+		Endpoint:  cfg.BlobstorServer,
+		Bucket:    cfg.BlobstorBucket,
+		AccessKey: cfg.AWSAccessKeyID,
+		SecretKey: cfg.AWSSecretAccessKey,
 	})
+	if err != nil {
+		log.Printf("Warning: could not initialize blob storage: %v", err)
+	}
+
+	// Handle "backfill-storage" subcommand
+	if len(os.Args) > 1 && os.Args[1] == "backfill-storage" {
+		if blobStore == nil {
+			log.Fatalf("Backfill failed: blob storage unavailable")
+		}
+		if _, err := upload.BackfillStorage(context.Background(), db, blobStore); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	// Handle "backfill-hashes" subcommand
+	if len(os.Args) > 1 && os.Args[1] == "backfill-hashes" {
+		if blobStore == nil {
+			log.Fatalf("Backfill failed: blob storage unavailable")
+		}
+		if _, err := upload.BackfillHashes(context.Background(), db, blobStore); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	ifcSvc := ifc.NewService(db, blobStore, collabSvc)
+	ifcHandler := ifc.NewHandler(ifcSvc, sessionStore)
+
+	// Handle "backfill-ifc" subcommand
+	if len(os.Args) > 1 && os.Args[1] == "backfill-ifc" {
+		if blobStore == nil {
+			log.Fatalf("Backfill failed: blob storage unavailable")
+		}
+		if _, err := ifcSvc.Backfill(context.Background()); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	storageQuotas := arca.Quotas{Project: cfg.ProjectStorageQuota, Tenant: cfg.TenantStorageQuota}
+	arcaSvc := arca.NewService(db, blobStore, arca.Config{
+		TrashRetention: cfg.TrashRetention,
+		PurgeInterval:  cfg.TrashPurgeInterval,
+		Quotas:         storageQuotas,
+	})
+	arcaHandler := arca.NewHandler(arcaSvc, sessionStore)
+	go arcaSvc.RunPurger(context.Background())
+
+	uploadHandler := upload.NewHandler(db, blobStore, sessionStore, upload.Config{
+		MaxUploadSize:   cfg.TUSMaxSize,
+		ChunkSize:       cfg.TUSChunkSize,
+		Workers:         cfg.UploadWorkers,
+		MaxAttempts:     cfg.UploadMaxAttempts,
+		RetryBaseDelay:  cfg.UploadRetryBaseDelay,
+		AdminAccountIDs: cfg.AdminAccountIDs,
+		Quotas:          storageQuotas,
+	})
+	uploadHandler.Processors = append(uploadHandler.Processors, ifcSvc.ProcessVersion, ifcSvc.ValidateUpload)
+
+	speckleSvc := speckle.NewService(db, blobStore, speckle.NewClient(cfg.SpeckleInternalURL, cfg.SpeckleAPIToken), speckle.Config{
+		ProjectID:    cfg.SpeckleProjectID,
+		PollInterval: cfg.SpecklePollInterval,
+		Timeout:      cfg.SpeckleConversionTimeout,
+	})
+	speckleHandler := speckle.NewHandler(speckleSvc, sessionStore)
+	if cfg.SpeckleAPIToken != "" && cfg.SpeckleProjectID != "" && blobStore != nil {
+		uploadHandler.Processors = append(uploadHandler.Processors, speckleSvc.Enqueue)
+		go speckleSvc.Run(context.Background())
+	} else {
+		log.Printf("Speckle conversion disabled (no API token, project ID or blob storage)")
+	}
 
 	// Build router
 	mux := http.NewServeMux()
@@ -87,6 +169,16 @@ func main() {
 	// Register module routes
 	collabHandler.RegisterRoutes(mux)
 	uploadHandler.RegisterRoutes(mux)
+	arcaHandler.RegisterRoutes(mux)
+	ifcHandler.RegisterRoutes(mux)
+	speckleHandler.RegisterRoutes(mux)
+	if cfg.SpeckleProxyEnabled {
+		speckleProxy, err := speckle.NewProxy(db, sessionStore, cfg.SpeckleInternalURL, cfg.SpeckleAPIToken, cfg.SpeckleProjectID)
+		if err != nil {
+			log.Fatalf("Speckle proxy: %v", err)
+		}
+		speckleProxy.RegisterRoutes(mux)
+	}
 
 	// Project and file browsing
 	mux.HandleFunc("GET /api/projects", func(w http.ResponseWriter, r *http.Request) {
@@ -98,8 +190,18 @@ func main() {
 	mux.HandleFunc("GET /api/projects/{projectId}/folders", func(w http.ResponseWriter, r *http.Request) {
 		handleListFolders(w, r, db)
 	})
+	mux.HandleFunc("GET /api/projects/{projectId}/folders/tree", func(w http.ResponseWriter, r *http.Request) {
+		handleFolderTree(w, r, db, sessionStore)
+	})
 	mux.HandleFunc("GET /api/projects/{projectId}/folders/{folderId}/files", func(w http.ResponseWriter, r *http.Request) {
-		handleListFiles(w, r, db)
+		handleListFiles(w, r, db, sessionStore)
+	})
+	mux.HandleFunc("GET /api/projects/{projectId}/folders/{folderId}/archive", func(w http.ResponseWriter, r *http.Request) {
+		handleFolderArchive(w, r, db, sessionStore, blobStore)
+	})
+
+	mux.HandleFunc("GET /api/files/{fileId}/versions", func(w http.ResponseWriter, r *http.Request) {
+		handleListFileVersions(w, r, db, sessionStore)
 	})
 
 	// Model listing — returns files for client-side IFC loading
@@ -109,7 +211,7 @@ func main() {
 
 	// File download — serves IFC files from MinIO for client-side parsing
 	mux.HandleFunc("GET /api/files/{fileVersionId}/download", func(w http.ResponseWriter, r *http.Request) {
-		handleFileDownload(w, r, db, cfg)
+		handleFileDownload(w, r, db, sessionStore, cfg, blobStore)
 	})
 
 	// Apply middleware stack
@@ -137,35 +239,129 @@ func main() {
 	}
 }
 
-// handleFileDownload serves a file from MinIO/S3 by redirecting to a presigned URL.
-func handleFileDownload(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config) {
+// handleFileDownload streams a file version from MinIO/S3, or redirects to a
+// presigned URL when VALVX_API_BLOBSTOR_PRESIGN_DOWNLOADS is enabled.
+// Range, If-Range and If-None-Match are handled by http.ServeContent so large
+// IFC models can be fetched in parts and resumed.
+func handleFileDownload(w http.ResponseWriter, r *http.Request, db *sql.DB, sessions *auth.SessionStore, cfg *config.Config, store *blobstor.Store) {
 	fileVersionID := r.PathValue("fileVersionId")
 	if fileVersionID == "" {
 		http.Error(w, "missing fileVersionId", http.StatusBadRequest)
 		return
 	}
+	if !requireAccount(w, r) {
+		return
+	}
+	if store == nil {
+		http.Error(w, "blob storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
 
-	// Look up the S3 key for this file version (stored as TUS upload ID in S3)
-	var fileName, ext string
+	// Legacy uploads without a recorded key are stored under the file version ID
+	var projectID, fileName, ext, key string
 	err := db.QueryRowContext(r.Context(),
-		`SELECT f.name, f.ext FROM arca_file_version fv
+		`SELECT fo.project_id, f.name, COALESCE(f.ext, ''), COALESCE(fv.storage_key, fv.id::text) FROM arca_file_version fv
 		 JOIN arca_file f ON f.id = fv.file_id
-		 WHERE fv.id = $1`, fileVersionID).Scan(&fileName, &ext)
+		 JOIN arca_folder_file ff ON ff.file_id = f.id
+		 JOIN arca_folder fo ON fo.id = ff.folder_id
+		 WHERE fv.id = $1 AND f.deleted_at IS NULL AND fo.deleted_at IS NULL
+		 LIMIT 1`, fileVersionID).Scan(&projectID, &fileName, &ext, &key)
 	if err != nil {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
+	if !requireProjectMember(w, r, sessions, projectID) {
+		return
+	}
+
+	fullName := fileName
+	if ext != "" {
+		fullName += "." + ext
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": fullName})
+	contentType := contentTypeForExt(ext)
+
+	if cfg.BlobstorPresignDownloads {
+		url, err := store.PresignGet(r.Context(), key, disposition, contentType, cfg.BlobstorPresignExpiry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+
+	obj, err := store.Open(r.Context(), key)
+	if blobstor.IsNotFound(err) {
+		http.Error(w, "file content not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer obj.Close()
 
-	// Redirect to MinIO presigned URL or proxy the content
-	// For now, set the download headers and proxy from MinIO
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, fileName, ext))
-	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if obj.ETag != "" {
+		w.Header().Set("ETag", obj.ETag)
+	}
+
+	http.ServeContent(w, r, fullName, obj.LastModified, obj)
+}
+
+// requireAccount checks that the request carries a valid session.
+func requireAccount(w http.ResponseWriter, r *http.Request) bool {
+	if auth.AccountIDFromContext(r.Context()) == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
 
-	// The actual S3 key is the TUS upload ID — stored in the MinIO bucket
-	// In production, generate a presigned URL and redirect:
-	// http.Redirect(w, r, presignedURL, http.StatusTemporaryRedirect)
-	http.Error(w, "file download proxy not yet implemented — use presigned URLs", http.StatusNotImplemented)
+// requireProjectMember checks that the caller has a profile in the project.
+func requireProjectMember(w http.ResponseWriter, r *http.Request, sessions *auth.SessionStore, projectID string) bool {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	_, err := sessions.GetProfileForProject(r.Context(), accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// contentTypeForExt maps the file extensions used in ValvX projects to MIME
+// types, falling back to the system table and then application/octet-stream.
+func contentTypeForExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case "ifc":
+		return "application/x-step"
+	case "ifczip":
+		return "application/zip"
+	case "ifcxml":
+		return "application/xml"
+	case "pdf":
+		return "application/pdf"
+	case "dwg":
+		return "image/vnd.dwg"
+	case "dxf":
+		return "image/vnd.dxf"
+	}
+	if ct := mime.TypeByExtension("." + ext); ext != "" && ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
 }
 
 // runMigrations reads SQL files from the migrations directory and applies them.
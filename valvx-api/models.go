@@ -4,6 +4,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+
+	"github.com/lib/pq"
+
+	"github.com/nsssthlm/valvx-api/ifc"
 )
 
 // ProjectFile represents a file available for client-side viewing.
@@ -13,10 +17,24 @@ type ProjectFile struct {
 	FileExt       string `json:"fileExt"`
 	FileSize      int64  `json:"fileSize"`
 	CreatedAt     string `json:"createdAt"`
+
+	// IFC is the STEP header of .ifc versions, once the upload worker has
+	// parsed it.
+	IFC *ModelHeader `json:"ifc,omitempty"`
+}
+
+// ModelHeader is the stored IFC header of a file version.
+type ModelHeader struct {
+	Schema string `json:"schema"`
+	ifc.Header
+	Error        *string `json:"error,omitempty"`
+	ParsedAt     string  `json:"parsedAt"`
+	ElementCount *int    `json:"elementCount"`
 }
 
 // handleListModels returns files for a project that can be loaded in the 3D viewer.
-// IFC files are parsed client-side via web-ifc WASM — no server mapping needed.
+// IFC files are parsed client-side via web-ifc WASM — no server mapping needed;
+// the STEP header metadata extracted after upload is included per version.
 func handleListModels(w http.ResponseWriter, r *http.Request, db *sql.DB, _ string) {
 	projectID := r.PathValue("projectId")
 	if projectID == "" {
@@ -25,12 +43,18 @@ func handleListModels(w http.ResponseWriter, r *http.Request, db *sql.DB, _ stri
 	}
 
 	rows, err := db.QueryContext(r.Context(), `
-		SELECT fv.id, f.name, f.ext, fv.size, fv.created_at
+		SELECT fv.id, f.name, f.ext, fv.size, fv.created_at,
+		       h.file_version_id IS NOT NULL, COALESCE(h.schema, ''), h.schemas, h.description,
+		       COALESCE(h.implementation_level, ''), COALESCE(h.view_definition, ''),
+		       COALESCE(h.file_name, ''), COALESCE(h.time_stamp, ''), h.authors, h.organizations,
+		       COALESCE(h.preprocessor_version, ''), COALESCE(h.originating_system, ''),
+		       COALESCE(h.file_authorization, ''), h.error, h.parsed_at, h.element_count
 		FROM arca_file_version fv
 		JOIN arca_file f ON f.id = fv.file_id
+		LEFT JOIN arca_ifc_header h ON h.file_version_id = fv.id
 		JOIN arca_folder_file ff ON ff.file_id = f.id
 		JOIN arca_folder fo ON fo.id = ff.folder_id
-		WHERE fo.project_id = $1
+		WHERE fo.project_id = $1 AND f.deleted_at IS NULL AND fo.deleted_at IS NULL
 		ORDER BY fv.created_at DESC`, projectID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -41,10 +65,22 @@ func handleListModels(w http.ResponseWriter, r *http.Request, db *sql.DB, _ stri
 	var files []ProjectFile
 	for rows.Next() {
 		var f ProjectFile
-		if err := rows.Scan(&f.FileVersionID, &f.FileName, &f.FileExt, &f.FileSize, &f.CreatedAt); err != nil {
+		var hasHeader bool
+		var parsedAt sql.NullString
+		var m ModelHeader
+		if err := rows.Scan(&f.FileVersionID, &f.FileName, &f.FileExt, &f.FileSize, &f.CreatedAt,
+			&hasHeader, &m.Schema, pq.Array(&m.Schemas), pq.Array(&m.Description),
+			&m.ImplementationLevel, &m.ViewDefinition,
+			&m.FileName, &m.TimeStamp, pq.Array(&m.Authors), pq.Array(&m.Organizations),
+			&m.PreprocessorVersion, &m.OriginatingSystem,
+			&m.Authorization, &m.Error, &parsedAt, &m.ElementCount); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if hasHeader {
+			m.ParsedAt = parsedAt.String
+			f.IFC = &m
+		}
 		files = append(files, f)
 	}
 
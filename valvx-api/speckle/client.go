@@ -0,0 +1,208 @@
+package speckle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Final conversion states of a file upload (FileUpload.convertedStatus);
+// 0 and 1 mean queued and converting.
+const (
+	convertedCompleted = 2
+	convertedError     = 3
+)
+
+// Client talks to a Speckle server's file import API: files are posted to
+// /api/file/{type}/{projectId}/{modelName} and their conversion is followed
+// through GraphQL. Any server implementing those two endpoints works,
+// including a stub in tests.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// NewClient creates a client for the server at baseURL.
+func NewClient(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token, HTTP: http.DefaultClient}
+}
+
+// FileUpload is the server's view of an uploaded file.
+type FileUpload struct {
+	ID               string  `json:"id"`
+	ConvertedStatus  int     `json:"convertedStatus"`
+	ConvertedMessage *string `json:"convertedMessage"`
+	ConvertedVersion *string `json:"convertedCommitId"`
+}
+
+// Version is a converted model version.
+type Version struct {
+	ModelID  string
+	ObjectID string
+}
+
+// HTTPError is returned for non-2xx responses.
+type HTTPError struct {
+	Code int
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("speckle: HTTP %d: %s", e.Code, e.Body)
+}
+
+// Temporary reports whether the request may succeed when retried.
+func (e *HTTPError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// Upload streams a file into a model of a project and returns the id of the
+// file upload the server queued for conversion. The model is created if it
+// does not exist.
+func (c *Client) Upload(ctx context.Context, projectID, modelName, fileType, fileName string, body io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", fileName)
+		if err == nil {
+			_, err = io.Copy(part, body)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	u := fmt.Sprintf("%s/api/file/%s/%s/%s", c.BaseURL,
+		url.PathEscape(fileType), url.PathEscape(projectID), url.PathEscape(modelName))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, pr)
+	if err != nil {
+		pr.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var out struct {
+		UploadResults []struct {
+			BlobID      string `json:"blobId"`
+			UploadError string `json:"uploadError"`
+		} `json:"uploadResults"`
+	}
+	if err := c.do(req, &out); err != nil {
+		return "", err
+	}
+	if len(out.UploadResults) == 0 {
+		return "", fmt.Errorf("speckle: upload returned no results")
+	}
+	res := out.UploadResults[0]
+	if res.UploadError != "" {
+		return "", fmt.Errorf("%w: %s", ErrRejected, res.UploadError)
+	}
+	if res.BlobID == "" {
+		return "", fmt.Errorf("speckle: upload returned no id")
+	}
+	return res.BlobID, nil
+}
+
+// FileUpload returns the conversion state of an upload.
+func (c *Client) FileUpload(ctx context.Context, projectID, uploadID string) (*FileUpload, error) {
+	var out struct {
+		Stream *struct {
+			FileUpload *FileUpload `json:"fileUpload"`
+		} `json:"stream"`
+	}
+	err := c.graphql(ctx, `
+		query($projectId: String!, $id: String!) {
+			stream(id: $projectId) {
+				fileUpload(id: $id) { id convertedStatus convertedMessage convertedCommitId }
+			}
+		}`, map[string]interface{}{"projectId": projectID, "id": uploadID}, &out)
+	if err != nil {
+		return nil, err
+	}
+	if out.Stream == nil || out.Stream.FileUpload == nil {
+		return nil, fmt.Errorf("speckle: file upload %s not found", uploadID)
+	}
+	return out.Stream.FileUpload, nil
+}
+
+// Version returns the model and root object of a project version.
+func (c *Client) Version(ctx context.Context, projectID, versionID string) (*Version, error) {
+	var out struct {
+		Project *struct {
+			Version *struct {
+				ReferencedObject string `json:"referencedObject"`
+				Model            struct {
+					ID string `json:"id"`
+				} `json:"model"`
+			} `json:"version"`
+		} `json:"project"`
+	}
+	err := c.graphql(ctx, `
+		query($projectId: String!, $id: String!) {
+			project(id: $projectId) {
+				version(id: $id) { referencedObject model { id } }
+			}
+		}`, map[string]interface{}{"projectId": projectID, "id": versionID}, &out)
+	if err != nil {
+		return nil, err
+	}
+	if out.Project == nil || out.Project.Version == nil {
+		return nil, fmt.Errorf("speckle: version %s not found", versionID)
+	}
+	v := out.Project.Version
+	return &Version{ModelID: v.Model.ID, ObjectID: v.ReferencedObject}, nil
+}
+
+func (c *Client) graphql(ctx context.Context, query string, vars map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("speckle: graphql: %s", resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+func (c *Client) do(req *http.Request, out interface{}) error {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &HTTPError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("speckle: decode response: %w", err)
+	}
+	return nil
+}
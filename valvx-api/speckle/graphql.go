@@ -0,0 +1,336 @@
+package speckle
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errQuery is wrapped by checkQuery's errors.
+var errQuery = errors.New("query not allowed")
+
+// speckleID matches the ids Speckle generates: 10 hex digits for projects,
+// models and versions, 32 for objects.
+var speckleID = regexp.MustCompile(`^(?:[0-9a-f]{10}|[0-9a-f]{32})$`)
+
+var (
+	// rootFields may be selected at the top of a query.
+	rootFields = []string{"project", "stream", "serverInfo", "__typename"}
+
+	// projectFields may be selected on the project: single models, versions
+	// and objects looked up by id, never listings of the whole project.
+	projectFields = []string{
+		"id", "name", "description", "role", "visibility", "createdAt", "updatedAt",
+		"model", "version", "object", "commit", "__typename",
+	}
+
+	// deniedFields lead from a model or object back to the project or to
+	// other users' data, and may not be selected below the top.
+	deniedFields = []string{
+		"project", "projects", "stream", "streams", "models", "branches", "branch",
+		"commits", "workspace", "activeUser", "otherUser", "user", "users",
+		"activity", "timeline",
+	}
+)
+
+// checkQuery reports whether a GraphQL document may be forwarded. Only
+// queries are allowed (no mutations, subscriptions or named fragments),
+// selections are limited to looking up one model, version or object of the
+// project at a time, and every id-shaped string or number literal must be
+// allowed. GraphQL coerces numbers to ID arguments.
+//
+// This is a lexer-level check, not a full GraphQL parser: it only needs to
+// understand enough structure to know which field each selection set
+// belongs to.
+func checkQuery(query string, allowed func(string) bool) error {
+	toks, err := lexGraphQL(query)
+	if err != nil {
+		return err
+	}
+
+	var path []string
+	expectOp := true
+	for i, t := range toks {
+		if len(path) == 0 && t.kind == tokName && expectOp {
+			// Operation definition at the top level
+			switch t.text {
+			case "query":
+			case "mutation", "subscription":
+				return fmt.Errorf("%w: only queries may be sent", errQuery)
+			case "fragment":
+				return fmt.Errorf("%w: named fragments are not supported", errQuery)
+			default:
+				return fmt.Errorf("%w: unexpected %q", errQuery, t.text)
+			}
+			expectOp = false
+		}
+
+		switch {
+		case t.kind == tokString || t.kind == tokOther:
+			if id := strings.TrimSpace(t.text); speckleID.MatchString(id) && !allowed(id) {
+				return fmt.Errorf("%w: id %s is not shared with this project", errQuery, id)
+			}
+		case t.selection && t.text == "{":
+			if t.field == "" && len(path) > 0 {
+				return fmt.Errorf("%w: selection without a field", errQuery)
+			}
+			path = append(path, t.field)
+		case t.selection && t.text == "}":
+			if len(path) == 0 {
+				return fmt.Errorf("%w: unbalanced braces", errQuery)
+			}
+			path = path[:len(path)-1]
+			expectOp = len(path) == 0
+		case t.isField:
+			if err := checkField(slices.DeleteFunc(slices.Clone(path), isInline), t.text); err != nil {
+				return err
+			}
+		case t.kind == tokPunct && t.text == "...":
+			// An inline fragment has a type condition, directives or its
+			// selection set next; a name is a named fragment spread
+			if i+1 < len(toks) && toks[i+1].kind == tokName && toks[i+1].text != "on" {
+				return fmt.Errorf("%w: fragment spreads are not supported", errQuery)
+			}
+		}
+	}
+	if len(path) != 0 {
+		return fmt.Errorf("%w: unbalanced braces", errQuery)
+	}
+	return nil
+}
+
+// inlineFragment stands for the selection set of an inline fragment in a
+// query path; it selects on the same object as its parent.
+const inlineFragment = "..."
+
+func isInline(field string) bool { return field == inlineFragment }
+
+// checkField checks a field selected below path, which lists the fields of
+// the enclosing selection sets ("" for the operation itself).
+func checkField(path []string, field string) error {
+	depth := len(path) - 1
+	switch {
+	case depth == 0:
+		if !slices.Contains(rootFields, field) {
+			return fmt.Errorf("%w: field %s", errQuery, field)
+		}
+	case depth == 1 && (path[1] == "project" || path[1] == "stream"):
+		if !slices.Contains(projectFields, field) {
+			return fmt.Errorf("%w: field %s.%s", errQuery, path[1], field)
+		}
+	case slices.Contains(deniedFields, field):
+		return fmt.Errorf("%w: field %s", errQuery, field)
+	}
+	return nil
+}
+
+// checkVariables checks every id-shaped string or number in the request
+// variables.
+func checkVariables(v interface{}, allowed func(string) bool) error {
+	switch x := v.(type) {
+	case float64:
+		return checkVariables(strconv.FormatFloat(x, 'f', -1, 64), allowed)
+	case string:
+		if id := strings.TrimSpace(x); speckleID.MatchString(id) && !allowed(id) {
+			return fmt.Errorf("%w: id %s is not shared with this project", errQuery, id)
+		}
+	case []interface{}:
+		for _, e := range x {
+			if err := checkVariables(e, allowed); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range x {
+			if err := checkVariables(e, allowed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	tokName = iota
+	tokString
+	tokPunct
+	tokOther
+)
+
+type gqlToken struct {
+	kind int
+	text string
+
+	// isField marks names that are selected fields (not aliases, arguments,
+	// types or keywords) and selection the braces of selection sets; field
+	// is set on "{" to the field it opens, or to inlineFragment.
+	isField   bool
+	selection bool
+	field     string
+}
+
+// lexGraphQL splits a document into tokens and marks field names. Strings
+// are decoded, so an id cannot hide behind escapes.
+func lexGraphQL(src string) ([]gqlToken, error) {
+	var toks []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			if len(src) >= i+3 && src[i:i+3] == `"""` {
+				end := i + 3
+				for ; end+3 <= len(src) && src[end:end+3] != `"""`; end++ {
+					if src[end] == '\\' {
+						end++
+					}
+				}
+				if end+3 > len(src) {
+					return nil, fmt.Errorf("%w: unterminated string", errQuery)
+				}
+				toks = append(toks, gqlToken{kind: tokString, text: src[i+3 : end]})
+				i = end + 3
+				continue
+			}
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] != '\\' {
+					b.WriteByte(src[j])
+					continue
+				}
+				if j+1 >= len(src) {
+					return nil, fmt.Errorf("%w: unterminated string", errQuery)
+				}
+				j++
+				switch src[j] {
+				case 'u':
+					if j+4 >= len(src) {
+						return nil, fmt.Errorf("%w: bad escape", errQuery)
+					}
+					r, err := strconv.ParseUint(src[j+1:j+5], 16, 32)
+					if err != nil {
+						return nil, fmt.Errorf("%w: bad escape", errQuery)
+					}
+					b.WriteRune(rune(r))
+					j += 4
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				case 'b':
+					b.WriteByte('\b')
+				case 'f':
+					b.WriteByte('\f')
+				default:
+					b.WriteByte(src[j])
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("%w: unterminated string", errQuery)
+			}
+			toks = append(toks, gqlToken{kind: tokString, text: b.String()})
+			i = j + 1
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, gqlToken{kind: tokName, text: src[i:j]})
+			i = j
+		case c == '.':
+			if len(src) < i+3 || src[i:i+3] != "..." {
+				return nil, fmt.Errorf("%w: unexpected '.'", errQuery)
+			}
+			toks = append(toks, gqlToken{kind: tokPunct, text: "..."})
+			i += 3
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' || src[j] == '+' || src[j] == '-') {
+				j++
+			}
+			toks = append(toks, gqlToken{kind: tokOther, text: src[i:j]})
+			i = j
+		case slices.Contains([]byte("!$&():=@[]{|}"), c):
+			toks = append(toks, gqlToken{kind: tokPunct, text: src[i : i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("%w: unexpected character %q", errQuery, c)
+		}
+	}
+	markFields(toks)
+	return toks, nil
+}
+
+// markFields walks the tokens and marks which names are fields. Inside a
+// selection set a field is a name not preceded by "$", "@" or "..." (a
+// variable, directive or fragment), not the type condition after "... on"
+// and not followed by ":" (an alias), outside any argument list.
+//
+// A "{" opens the selection set of the last field or inline fragment
+// before it; directives in between do not change which.
+func markFields(toks []gqlToken) {
+	depth := 0  // selection set nesting
+	parens := 0 // argument or variable definition nesting
+	lastField := ""
+	for i := range toks {
+		t := &toks[i]
+		if t.kind == tokPunct {
+			switch t.text {
+			case "(":
+				parens++
+				continue
+			case ")":
+				parens--
+				continue
+			}
+		}
+		if parens > 0 || t.kind != tokPunct && t.kind != tokName {
+			continue
+		}
+		switch t.text {
+		case "{":
+			t.selection = true
+			if depth > 0 {
+				t.field = lastField
+			}
+			depth++
+			lastField = ""
+			continue
+		case "}":
+			t.selection = true
+			depth--
+			continue
+		case "...":
+			lastField = inlineFragment
+			continue
+		}
+		if t.kind != tokName || depth == 0 {
+			continue
+		}
+		if i > 0 && toks[i-1].kind == tokPunct {
+			switch toks[i-1].text {
+			case "$", "@", "...":
+				continue
+			}
+		}
+		if i > 1 && toks[i-1].text == "on" && toks[i-2].kind == tokPunct && toks[i-2].text == "..." {
+			continue
+		}
+		if i+1 < len(toks) && toks[i+1].text == ":" {
+			continue
+		}
+		t.isField = true
+		lastField = t.text
+	}
+}
@@ -0,0 +1,127 @@
+package speckle
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Ids shared with the test project. Any other id, such as bbbbbbbbbb,
+// belongs to another project.
+const (
+	sharedProject = "0a1b2c3d4e"
+	sharedModel   = "aaaaaaaaaa"
+	sharedObject  = "0123456789abcdef0123456789abcdef"
+)
+
+func testAllowed(id string) bool {
+	return id == sharedProject || id == sharedModel || id == sharedObject
+}
+
+func TestCheckQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		// Lookups of single models, versions and objects
+		{"model", `query { project(id: "0a1b2c3d4e") { model(id: "aaaaaaaaaa") { id name } } }`, true},
+		{"shorthand", `{ project(id: "0a1b2c3d4e") { id name } }`, true},
+		{"object", `query Obj { project(id: "0a1b2c3d4e") { object(id: "0123456789abcdef0123456789abcdef") { id data } } }`, true},
+		{"version walk", `{ project(id: "0a1b2c3d4e") { version(id: "aaaaaaaaaa") { id referencedObject model { id name } } } }`, true},
+		{"stream alias", `{ stream(id: "0a1b2c3d4e") { commit(id: "aaaaaaaaaa") { id } } }`, true},
+		{"typename", `{ __typename serverInfo { version } }`, true},
+		{"comment", "{ project(id: \"0a1b2c3d4e\") { id } } # models { id }", true},
+		{"inline fragment", `{ project(id: "0a1b2c3d4e") { ... on Project { id name } } }`, true},
+		{"spread without type", `{ project(id: "0a1b2c3d4e") { ... @include(if: true) { id } } }`, true},
+		{"field directive", `{ project(id: "0a1b2c3d4e") @include(if: true) { model(id: "aaaaaaaaaa") @skip(if: false) { id } } }`, true},
+		{"alias of allowed field", `{ p: project(id: "0a1b2c3d4e") { m: model(id: "aaaaaaaaaa") { id } } }`, true},
+		{"variable", `query($id: String!) { project(id: $id) { id } }`, true},
+
+		// Operations other than queries
+		{"mutation", `mutation { projectMutations { delete(id: "0a1b2c3d4e") } }`, false},
+		{"subscription", `subscription { projectModelsUpdated(id: "0a1b2c3d4e") { id } }`, false},
+		{"second operation", `{ serverInfo { version } } mutation { x }`, false},
+		{"named fragment", `query { project(id: "0a1b2c3d4e") { ...F } } fragment F on Project { models { items { id } } }`, false},
+		{"named spread", `{ project(id: "0a1b2c3d4e") { ...F } }`, false},
+
+		// Listings and walks back up the tree
+		{"projects", `{ projects { items { id } } }`, false},
+		{"activeUser", `{ activeUser { projects { items { id } } } }`, false},
+		{"project listing", `{ project(id: "0a1b2c3d4e") { models { items { id } } } }`, false},
+		{"project versions", `{ project(id: "0a1b2c3d4e") { versions { items { id } } } }`, false},
+		{"model to project", `{ project(id: "0a1b2c3d4e") { model(id: "aaaaaaaaaa") { project { models { items { id } } } } } }`, false},
+		{"version to project", `{ project(id: "0a1b2c3d4e") { version(id: "aaaaaaaaaa") { model { project { id } } } } }`, false},
+		{"version to author", `{ project(id: "0a1b2c3d4e") { version(id: "aaaaaaaaaa") { authorUser { activity { items { info } } } } } }`, false},
+		{"inline fragment listing", `{ project(id: "0a1b2c3d4e") { ... on Project { models { items { id } } } } }`, false},
+		{"root inline fragment", `{ ... on Query { projects { items { id } } } }`, false},
+		{"fragment after a sibling", `{ project(id: "0a1b2c3d4e") { id ... on Project @include(if: true) { versions { items { id } } } } }`, false},
+		{"spread after a sibling", `{ project(id: "0a1b2c3d4e") { id ... @include(if: true) { versions { items { id } } } } }`, false},
+
+		// Aliases hiding a denied field
+		{"alias at root", `{ x: projects { items { id } } }`, false},
+		{"alias on project", `{ project(id: "0a1b2c3d4e") { x: models { items { id } } } }`, false},
+		{"after a field named on", `{ project(id: "0a1b2c3d4e") { model(id: "aaaaaaaaaa") { on project { id } } } }`, false},
+		{"alias below", `{ project(id: "0a1b2c3d4e") { model(id: "aaaaaaaaaa") { up: project { id } } } }`, false},
+
+		// Ids of other projects, however they are written
+		{"other id", `{ project(id: "0a1b2c3d4e") { model(id: "bbbbbbbbbb") { id } } }`, false},
+		{"padded id", `{ project(id: "0a1b2c3d4e") { model(id: " bbbbbbbbbb ") { id } } }`, false},
+		{"escaped id", `{ project(id: "0a1b2c3d4e") { model(id: "\u0062bbbbbbbbb") { id } } }`, false},
+		{"block string id", "{ project(id: \"0a1b2c3d4e\") { model(id: \"\"\"\n    bbbbbbbbbb\n\"\"\") { id } } }", false},
+		{"variable default", `query($id: String = "bbbbbbbbbb") { project(id: "0a1b2c3d4e") { model(id: $id) { id } } }`, false},
+		{"numeric id", `{ project(id: "0a1b2c3d4e") { model(id: 1234567890) { id } } }`, false},
+		{"other project", `{ project(id: "cccccccccc") { id } }`, false},
+
+		// Malformed documents
+		{"unbalanced", `{ project(id: "0a1b2c3d4e") { id }`, false},
+		{"extra brace", `{ serverInfo { version } } }`, false},
+		{"unterminated string", `{ project(id: "0a1b2c3d4e) { id } }`, false},
+		{"unterminated block string", `{ project(id: """0a1b2c3d4e) { id } }`, false},
+		{"bad escape", `{ project(id: "\u00zz") { id } }`, false},
+		{"stray dot", `{ project(id: "0a1b2c3d4e") { .. on Project { id } } }`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuery(tt.query, testAllowed)
+			if tt.ok && err != nil {
+				t.Errorf("checkQuery rejected an allowed query: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, errQuery) {
+				t.Errorf("checkQuery = %v, want errQuery", err)
+			}
+		})
+	}
+}
+
+func TestCheckVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables string
+		ok        bool
+	}{
+		{"none", `null`, true},
+		{"shared ids", `{"projectId": "0a1b2c3d4e", "ids": ["aaaaaaaaaa", "0123456789abcdef0123456789abcdef"]}`, true},
+		{"other values", `{"limit": 10, "name": "Model 1", "flag": true}`, true},
+		{"other id", `{"id": "bbbbbbbbbb"}`, false},
+		{"padded id", `{"id": "\tbbbbbbbbbb"}`, false},
+		{"id in a list", `{"ids": ["aaaaaaaaaa", "bbbbbbbbbb"]}`, false},
+		{"id in an input object", `{"input": {"filter": {"modelIds": ["bbbbbbbbbb"]}}}`, false},
+		{"numeric id", `{"id": 1234567890}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tt.variables), &v); err != nil {
+				t.Fatal(err)
+			}
+			err := checkVariables(v, testAllowed)
+			if tt.ok && err != nil {
+				t.Errorf("checkVariables rejected allowed variables: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, errQuery) {
+				t.Errorf("checkVariables = %v, want errQuery", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package speckle
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+// Handler holds the Speckle HTTP handler dependencies.
+type Handler struct {
+	Service      *Service
+	SessionStore *auth.SessionStore
+}
+
+// NewHandler creates a new Speckle handler.
+func NewHandler(svc *Service, sessionStore *auth.SessionStore) *Handler {
+	return &Handler{Service: svc, SessionStore: sessionStore}
+}
+
+// RegisterRoutes registers Speckle conversion routes on the given mux.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/files/{fileVersionId}/conversion", h.GetConversion)
+}
+
+// GetConversion returns the conversion status of a file version:
+// pending, processing, ready (with the Speckle model, version and object
+// ids) or error.
+func (h *Handler) GetConversion(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	fileVersionID := r.PathValue("fileVersionId")
+	var projectID string
+	err := h.Service.DB.QueryRowContext(r.Context(), `
+		SELECT fo.project_id
+		FROM arca_file_version fv
+		JOIN arca_folder_file ff ON ff.file_id = fv.file_id
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		WHERE fv.id = $1
+		LIMIT 1`, fileVersionID).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "file version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = h.SessionStore.GetProfileForProject(r.Context(), accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c, err := h.Service.GetConversion(r.Context(), fileVersionID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,210 @@
+package speckle
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+// maxQuerySize bounds GraphQL request bodies read by the proxy.
+const maxQuerySize = 1 << 20
+
+// Proxy forwards Speckle viewer requests for a ValvX project to the Speckle
+// server, so users need no Speckle account of their own. Callers must be
+// members of the project; requests carry the server's token instead of the
+// caller's credentials and may only reach the models converted from the
+// project's files.
+type Proxy struct {
+	DB           *sql.DB
+	SessionStore *auth.SessionStore
+
+	// ProjectID is the Speckle project holding the converted models, and
+	// Token the server token sent in place of the caller's credentials.
+	ProjectID string
+	Token     string
+
+	forward *httputil.ReverseProxy
+}
+
+// NewProxy creates a proxy to the Speckle server at target.
+func NewProxy(db *sql.DB, sessionStore *auth.SessionStore, target, token, projectID string) (*Proxy, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parse speckle url: %w", err)
+	}
+	p := &Proxy{DB: db, SessionStore: sessionStore, ProjectID: projectID, Token: token}
+	p.forward = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			// The caller's ValvX session must not reach Speckle
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Set("Authorization", "Bearer "+p.Token)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			for k := range resp.Header {
+				if strings.HasPrefix(k, "Access-Control-") {
+					resp.Header.Del(k)
+				}
+			}
+			return nil
+		},
+	}
+	return p, nil
+}
+
+// RegisterRoutes registers the proxy routes on the given mux.
+func (p *Proxy) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/speckle/{projectId}/graphql", p.GraphQL)
+	mux.HandleFunc("GET /api/speckle/{projectId}/objects/{objectId}", p.Object)
+	mux.HandleFunc("GET /api/speckle/{projectId}/objects/{objectId}/single", p.Object)
+}
+
+// shared holds the Speckle ids a ValvX project may reach.
+type shared struct {
+	ids     map[string]bool // Speckle project, models, versions and objects
+	objects map[string]bool // root objects of converted versions
+}
+
+// sharedIDs returns the ids of the converted models of a project's files,
+// leaving out files in the trash.
+func (p *Proxy) sharedIDs(ctx context.Context, projectID string) (*shared, error) {
+	rows, err := p.DB.QueryContext(ctx, `
+		SELECT DISTINCT m.speckle_model_id, m.speckle_version_id, m.speckle_object_id
+		FROM arca_speckle_mapping m
+		JOIN arca_file_version fv ON fv.id = m.file_version_id
+		JOIN arca_file f ON f.id = fv.file_id
+		JOIN arca_folder_file ff ON ff.file_id = f.id
+		JOIN arca_folder fo ON fo.id = ff.folder_id
+		WHERE fo.project_id = $1 AND m.status = $2 AND f.deleted_at IS NULL`,
+		projectID, StatusReady)
+	if err != nil {
+		return nil, fmt.Errorf("query mappings: %w", err)
+	}
+	defer rows.Close()
+
+	s := &shared{ids: map[string]bool{p.ProjectID: true}, objects: map[string]bool{}}
+	for rows.Next() {
+		var model string
+		var version, object sql.NullString
+		if err := rows.Scan(&model, &version, &object); err != nil {
+			return nil, fmt.Errorf("scan mapping: %w", err)
+		}
+		s.ids[model] = true
+		if version.Valid {
+			s.ids[version.String] = true
+		}
+		if object.Valid {
+			s.ids[object.String] = true
+			s.objects[object.String] = true
+		}
+	}
+	return s, rows.Err()
+}
+
+// authorize checks that the caller is a member of the path project and
+// returns what the project may reach.
+func (p *Proxy) authorize(w http.ResponseWriter, r *http.Request) (*shared, bool) {
+	accountID := auth.AccountIDFromContext(r.Context())
+	if accountID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	projectID := r.PathValue("projectId")
+	_, err := p.SessionStore.GetProfileForProject(r.Context(), accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	s, err := p.sharedIDs(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return s, true
+}
+
+// GraphQL forwards a read-only GraphQL query. The projectId and streamId
+// variables are set to the Speckle project, and the query may only look up
+// models, versions and objects shared with the ValvX project.
+func (p *Proxy) GraphQL(w http.ResponseWriter, r *http.Request) {
+	s, ok := p.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName,omitempty"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxQuerySize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, name := range []string{"projectId", "streamId"} {
+		if _, ok := req.Variables[name]; ok {
+			req.Variables[name] = p.ProjectID
+		}
+	}
+
+	allowed := func(id string) bool { return s.ids[id] }
+	if err := checkQuery(req.Query, allowed); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := checkVariables(req.Variables, allowed); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := r.Clone(r.Context())
+	out.URL.Path, out.URL.RawPath = "/graphql", ""
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	out.Header.Set("Content-Type", "application/json")
+	out.Header.Del("Content-Encoding")
+	p.forward.ServeHTTP(w, out)
+}
+
+// Object streams a converted model's root object, with its children, or
+// only the object itself for the /single variant.
+func (p *Proxy) Object(w http.ResponseWriter, r *http.Request) {
+	s, ok := p.authorize(w, r)
+	if !ok {
+		return
+	}
+	objectID := r.PathValue("objectId")
+	if !s.objects[objectID] {
+		http.Error(w, "object is not shared with this project", http.StatusForbidden)
+		return
+	}
+
+	path := "/objects/" + url.PathEscape(p.ProjectID) + "/" + url.PathEscape(objectID)
+	if strings.HasSuffix(r.URL.Path, "/single") {
+		path += "/single"
+	}
+	out := r.Clone(r.Context())
+	out.URL.Path, out.URL.RawPath = path, ""
+	p.forward.ServeHTTP(w, out)
+}
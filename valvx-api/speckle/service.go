@@ -0,0 +1,367 @@
+// Package speckle converts uploaded IFC models into a Speckle server and
+// tracks the result in arca_speckle_mapping.
+//
+// Uploaded versions are queued by Enqueue, which runs as an upload
+// Processor. Run submits queued files to the server's file import endpoint
+// and polls each upload until the server reports a converted version or an
+// error. Every ValvX file maps to one Speckle model, so new versions of a
+// file become new versions of the same model.
+package speckle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultTimeout      = time.Hour
+	defaultMaxAttempts  = 5
+	maxRetryDelay       = time.Hour
+
+	// submitStaleAfter reclaims files claimed for submission by a worker
+	// that stopped before the server acknowledged the upload.
+	submitStaleAfter = 15 * time.Minute
+)
+
+// Config holds conversion worker configuration.
+type Config struct {
+	// ProjectID is the Speckle project the models are created in.
+	ProjectID string
+
+	// PollInterval is how often queued files are submitted and running
+	// conversions are checked.
+	PollInterval time.Duration
+
+	// Timeout fails conversions the server has not finished after this long.
+	Timeout time.Duration
+
+	// MaxAttempts bounds submissions of a file when the server is
+	// unreachable or overloaded.
+	MaxAttempts int
+}
+
+// Service runs Speckle conversions of uploaded file versions.
+type Service struct {
+	DB     *sql.DB
+	Blobs  *blobstor.Store
+	Client *Client
+	Config Config
+
+	wake chan struct{}
+}
+
+// NewService creates a new conversion service.
+func NewService(db *sql.DB, blobs *blobstor.Store, client *Client, cfg Config) *Service {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	return &Service{DB: db, Blobs: blobs, Client: client, Config: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue queues an .ifc file version for conversion. Other file types are
+// ignored, and queuing a version twice is a no-op.
+func (s *Service) Enqueue(ctx context.Context, fileVersionID string) error {
+	var fileID, ext string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT fv.file_id, lower(COALESCE(f.ext, ''))
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE fv.id = $1`, fileVersionID).Scan(&fileID, &ext)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return fmt.Errorf("get file version: %w", err)
+	}
+	if ext != "ifc" {
+		return nil
+	}
+
+	// The model is named after the file until the server reports its id
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO arca_speckle_mapping (file_version_id, speckle_model_id, status, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, now(), now(), now())
+		ON CONFLICT (file_version_id) DO NOTHING`,
+		fileVersionID, fileID, StatusPending)
+	if err != nil {
+		return fmt.Errorf("insert mapping: %w", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// GetConversion returns the conversion of a file version.
+func (s *Service) GetConversion(ctx context.Context, fileVersionID string) (*Conversion, error) {
+	var c Conversion
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT file_version_id, status,
+		       CASE WHEN status = $2 THEN speckle_model_id END,
+		       speckle_version_id, speckle_object_id, error_message,
+		       attempts, submitted_at, created_at, updated_at
+		FROM arca_speckle_mapping
+		WHERE file_version_id = $1`, fileVersionID, StatusReady).Scan(
+		&c.FileVersionID, &c.Status, &c.SpeckleModelID, &c.SpeckleVersionID, &c.SpeckleObjectID,
+		&c.Error, &c.Attempts, &c.SubmittedAt, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: no conversion for file version %s", ErrNotFound, fileVersionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get mapping: %w", err)
+	}
+	return &c, nil
+}
+
+// Run submits queued files and follows running conversions until ctx ends.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			ok, err := s.submitNext(ctx)
+			if err != nil {
+				log.Printf("Speckle conversion error: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+		}
+		if err := s.poll(ctx); err != nil {
+			log.Printf("Speckle conversion error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// submitNext claims one due file and uploads it to the server. It reports
+// false when there was nothing to do.
+func (s *Service) submitNext(ctx context.Context) (bool, error) {
+	var fileVersionID, modelName string
+	var attempts int
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE arca_speckle_mapping SET status = $1, attempts = attempts + 1,
+		    speckle_upload_id = NULL, updated_at = now()
+		WHERE file_version_id = (
+			SELECT file_version_id FROM arca_speckle_mapping
+			WHERE (status = $2 AND run_at <= now())
+			   OR (status = $1 AND speckle_upload_id IS NULL AND updated_at < now() - $3::interval)
+			ORDER BY run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING file_version_id, speckle_model_id, attempts`,
+		StatusProcessing, StatusPending, fmt.Sprintf("%d seconds", int(submitStaleAfter.Seconds())),
+	).Scan(&fileVersionID, &modelName, &attempts)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim conversion: %w", err)
+	}
+
+	uploadID, err := s.submit(ctx, fileVersionID, modelName)
+	if err != nil {
+		log.Printf("Speckle conversion of %s attempt %d failed: %v", fileVersionID, attempts, err)
+		return true, s.retry(ctx, fileVersionID, attempts, err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE arca_speckle_mapping SET speckle_upload_id = $2, submitted_at = now(),
+		    error_message = NULL, updated_at = now()
+		WHERE file_version_id = $1`, fileVersionID, uploadID)
+	if err != nil {
+		return true, fmt.Errorf("store upload of %s: %w", fileVersionID, err)
+	}
+	return true, nil
+}
+
+func (s *Service) submit(ctx context.Context, fileVersionID, modelName string) (string, error) {
+	var name, ext, key string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT f.name, lower(COALESCE(f.ext, '')), COALESCE(fv.storage_key, fv.id::text)
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE fv.id = $1`, fileVersionID).Scan(&name, &ext, &key)
+	if err != nil {
+		return "", fmt.Errorf("get file version: %w", err)
+	}
+
+	body, err := s.Blobs.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("get %s: %w", key, err)
+	}
+	defer body.Close()
+
+	return s.Client.Upload(ctx, s.Config.ProjectID, modelName, ext, name+"."+ext, body)
+}
+
+// retry reschedules a failed submission with exponential backoff, or fails
+// the conversion when the error is permanent or attempts are used up.
+func (s *Service) retry(ctx context.Context, fileVersionID string, attempts int, cause error) error {
+	status, delay := s.nextAttempt(attempts, cause)
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE arca_speckle_mapping SET status = $2, error_message = $3,
+		    run_at = now() + $4::interval, updated_at = now()
+		WHERE file_version_id = $1`,
+		fileVersionID, status, cause.Error(), fmt.Sprintf("%d milliseconds", delay.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("reschedule conversion of %s: %w", fileVersionID, err)
+	}
+	return nil
+}
+
+// nextAttempt returns the state a failed submission moves to and how long
+// to wait before the next attempt: the poll interval, doubled per attempt
+// up to maxRetryDelay.
+func (s *Service) nextAttempt(attempts int, cause error) (string, time.Duration) {
+	status := StatusPending
+	if !temporary(cause) || attempts >= s.Config.MaxAttempts {
+		status = StatusError
+	}
+	delay := s.Config.PollInterval
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return status, min(delay, maxRetryDelay)
+}
+
+// temporary reports whether a submission may succeed when retried: network
+// failures and server overload do, rejected files do not.
+func temporary(err error) bool {
+	if errors.Is(err, ErrRejected) {
+		return false
+	}
+	var se *HTTPError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	return true
+}
+
+// poll checks every submitted conversion once.
+func (s *Service) poll(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT file_version_id, speckle_upload_id, submitted_at
+		FROM arca_speckle_mapping
+		WHERE status = $1 AND speckle_upload_id IS NOT NULL`, StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("query conversions: %w", err)
+	}
+	type running struct {
+		fileVersionID, uploadID string
+		submittedAt             time.Time
+	}
+	var pending []running
+	for rows.Next() {
+		var r running
+		if err := rows.Scan(&r.fileVersionID, &r.uploadID, &r.submittedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan conversion: %w", err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range pending {
+		if err := s.check(ctx, r.fileVersionID, r.uploadID, r.submittedAt); err != nil {
+			log.Printf("Speckle conversion of %s: %v", r.fileVersionID, err)
+		}
+	}
+	return nil
+}
+
+// check updates one conversion from the server's state. Errors talking to
+// the server leave it running, to be checked again on the next poll.
+func (s *Service) check(ctx context.Context, fileVersionID, uploadID string, submittedAt time.Time) error {
+	upload, err := s.Client.FileUpload(ctx, s.Config.ProjectID, uploadID)
+	status, cause := s.conversionState(upload, err, time.Since(submittedAt))
+	switch status {
+	case StatusError:
+		return s.fail(ctx, fileVersionID, cause)
+	case StatusProcessing:
+		return cause
+	}
+
+	v, err := s.Client.Version(ctx, s.Config.ProjectID, *upload.ConvertedVersion)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE arca_speckle_mapping SET status = $2, speckle_model_id = $3,
+		    speckle_version_id = $4, speckle_object_id = $5, error_message = NULL, updated_at = now()
+		WHERE file_version_id = $1`,
+		fileVersionID, StatusReady, v.ModelID, *upload.ConvertedVersion, v.ObjectID)
+	if err != nil {
+		return fmt.Errorf("store version: %w", err)
+	}
+	return nil
+}
+
+// conversionState maps the server's view of an upload, fetched elapsed
+// after submission, to the state the conversion moves to. A ready upload
+// has a converted version. For StatusError the error is the cause to
+// record; for StatusProcessing it is the error fetching the upload, if any.
+func (s *Service) conversionState(upload *FileUpload, fetchErr error, elapsed time.Duration) (string, error) {
+	if fetchErr != nil {
+		if elapsed > s.Config.Timeout {
+			return StatusError, fmt.Errorf("conversion timed out: %w", fetchErr)
+		}
+		return StatusProcessing, fetchErr
+	}
+
+	switch upload.ConvertedStatus {
+	case convertedCompleted:
+		if upload.ConvertedVersion == nil {
+			return StatusError, errors.New("conversion completed without a version")
+		}
+		return StatusReady, nil
+	case convertedError:
+		msg := "conversion failed"
+		if upload.ConvertedMessage != nil && *upload.ConvertedMessage != "" {
+			msg = *upload.ConvertedMessage
+		}
+		return StatusError, errors.New(msg)
+	}
+
+	if elapsed > s.Config.Timeout {
+		return StatusError, fmt.Errorf("conversion timed out after %s", s.Config.Timeout)
+	}
+	return StatusProcessing, nil
+}
+
+func (s *Service) fail(ctx context.Context, fileVersionID string, cause error) error {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE arca_speckle_mapping SET status = $2, error_message = $3, updated_at = now()
+		WHERE file_version_id = $1`, fileVersionID, StatusError, cause.Error())
+	if err != nil {
+		return fmt.Errorf("store failure: %w", err)
+	}
+	return nil
+}
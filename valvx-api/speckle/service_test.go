@@ -0,0 +1,264 @@
+package speckle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer is a minimal Speckle server: it accepts file imports and
+// reports each upload as queued, converting and then converted, one step
+// per poll.
+type stubServer struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	uploads  map[string]string // upload id -> file content
+	polls    map[string]int
+	failNext int    // respond 503 to this many requests
+	reject   string // uploadError to return for the next upload
+	final    int    // convertedStatus reported once conversion ends
+}
+
+func newStubServer(t *testing.T) (*stubServer, *Client) {
+	stub := &stubServer{t: t, uploads: map[string]string{}, polls: map[string]int{}, final: convertedCompleted}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, NewClient(srv.URL+"/", "token")
+}
+
+func (s *stubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.failNext > 0 {
+		s.failNext--
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/file/ifc/proj/Model 1":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "model.ifc" {
+			s.t.Errorf("uploaded file name = %q, want model.ifc", header.Filename)
+		}
+		result := map[string]string{"blobId": "", "uploadError": s.reject}
+		if s.reject == "" {
+			id := "upload-" + string(rune('a'+len(s.uploads)))
+			s.uploads[id] = string(data)
+			result["blobId"] = id
+		}
+		s.reject = ""
+		json.NewEncoder(w).Encode(map[string]interface{}{"uploadResults": []interface{}{result}})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/graphql":
+		var req struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := req.Variables["id"]
+		var data interface{}
+		switch {
+		case strings.Contains(req.Query, "fileUpload"):
+			if _, ok := s.uploads[id]; !ok {
+				data = map[string]interface{}{"stream": map[string]interface{}{"fileUpload": nil}}
+				break
+			}
+			s.polls[id]++
+			upload := map[string]interface{}{"id": id, "convertedStatus": min(s.polls[id]-1, 1)}
+			if s.polls[id] > 2 {
+				upload["convertedStatus"] = s.final
+				if s.final == convertedCompleted {
+					upload["convertedCommitId"] = "version-" + id
+				} else {
+					upload["convertedMessage"] = "unsupported schema"
+				}
+			}
+			data = map[string]interface{}{"stream": map[string]interface{}{"fileUpload": upload}}
+		case strings.Contains(req.Query, "version"):
+			data = map[string]interface{}{"project": map[string]interface{}{"version": map[string]interface{}{
+				"referencedObject": "object-" + id,
+				"model":            map[string]string{"id": "model-1"},
+			}}}
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": "unknown query"}}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestClientConversion(t *testing.T) {
+	stub, client := newStubServer(t)
+	ctx := context.Background()
+	svc := NewService(nil, nil, client, Config{ProjectID: "proj", Timeout: time.Hour})
+
+	id, err := client.Upload(ctx, "proj", "Model 1", "ifc", "model.ifc", strings.NewReader("ISO-10303-21;"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := stub.uploads[id]; got != "ISO-10303-21;" {
+		t.Fatalf("uploaded content = %q", got)
+	}
+
+	// Queued, converting, converted
+	wantStates := []string{StatusProcessing, StatusProcessing, StatusReady}
+	var upload *FileUpload
+	for i, want := range wantStates {
+		upload, err = client.FileUpload(ctx, "proj", id)
+		status, cause := svc.conversionState(upload, err, time.Minute)
+		if status != want || cause != nil {
+			t.Fatalf("poll %d: state = %s, %v; want %s", i+1, status, cause, want)
+		}
+	}
+
+	v, err := client.Version(ctx, "proj", *upload.ConvertedVersion)
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if v.ModelID != "model-1" || v.ObjectID != "object-version-"+id {
+		t.Errorf("Version = %+v", v)
+	}
+
+	if _, err := client.FileUpload(ctx, "proj", "missing"); err == nil {
+		t.Error("FileUpload of an unknown upload succeeded")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	stub, client := newStubServer(t)
+	ctx := context.Background()
+	upload := func() error {
+		_, err := client.Upload(ctx, "proj", "Model 1", "ifc", "model.ifc", strings.NewReader("x"))
+		return err
+	}
+
+	stub.failNext = 1
+	err := upload()
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusServiceUnavailable || !temporary(err) {
+		t.Errorf("overloaded server: err = %v, want temporary HTTP 503", err)
+	}
+
+	stub.reject = "file too large"
+	if err := upload(); !errors.Is(err, ErrRejected) || temporary(err) {
+		t.Errorf("rejected upload: err = %v, want permanent ErrRejected", err)
+	}
+
+	client.Token = "wrong"
+	if err := upload(); temporary(err) {
+		t.Errorf("unauthorized: err = %v, want permanent", err)
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	svc := NewService(nil, nil, nil, Config{PollInterval: 10 * time.Minute, MaxAttempts: 5})
+	temp := &HTTPError{Code: http.StatusBadGateway}
+
+	tests := []struct {
+		name      string
+		attempts  int
+		cause     error
+		status    string
+		wantDelay time.Duration
+	}{
+		{"first failure", 1, temp, StatusPending, 10 * time.Minute},
+		{"second failure", 2, temp, StatusPending, 20 * time.Minute},
+		{"backoff is capped", 4, temp, StatusPending, maxRetryDelay},
+		{"network error", 2, io.ErrUnexpectedEOF, StatusPending, 20 * time.Minute},
+		{"attempts used up", 5, temp, StatusError, maxRetryDelay},
+		{"rejected", 1, ErrRejected, StatusError, 10 * time.Minute},
+		{"client error", 1, &HTTPError{Code: http.StatusBadRequest}, StatusError, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, delay := svc.nextAttempt(tt.attempts, tt.cause)
+			if status != tt.status || delay != tt.wantDelay {
+				t.Errorf("nextAttempt(%d) = %s, %s; want %s, %s", tt.attempts, status, delay, tt.status, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestConversionState(t *testing.T) {
+	svc := NewService(nil, nil, nil, Config{Timeout: time.Hour})
+	version := "v1"
+	message := "unsupported schema"
+	fetchErr := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		upload   *FileUpload
+		fetchErr error
+		elapsed  time.Duration
+		status   string
+		cause    string
+	}{
+		{"queued", &FileUpload{ConvertedStatus: 0}, nil, time.Minute, StatusProcessing, ""},
+		{"converting", &FileUpload{ConvertedStatus: 1}, nil, time.Minute, StatusProcessing, ""},
+		{"converted", &FileUpload{ConvertedStatus: convertedCompleted, ConvertedVersion: &version}, nil, time.Minute, StatusReady, ""},
+		{"converted without version", &FileUpload{ConvertedStatus: convertedCompleted}, nil, time.Minute, StatusError, "conversion completed without a version"},
+		{"failed", &FileUpload{ConvertedStatus: convertedError, ConvertedMessage: &message}, nil, time.Minute, StatusError, message},
+		{"failed without message", &FileUpload{ConvertedStatus: convertedError}, nil, time.Minute, StatusError, "conversion failed"},
+		{"timed out", &FileUpload{ConvertedStatus: 1}, nil, 2 * time.Hour, StatusError, "conversion timed out after 1h0m0s"},
+		{"unreachable", nil, fetchErr, time.Minute, StatusProcessing, "connection refused"},
+		{"unreachable past timeout", nil, fetchErr, 2 * time.Hour, StatusError, "conversion timed out: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, cause := svc.conversionState(tt.upload, tt.fetchErr, tt.elapsed)
+			got := ""
+			if cause != nil {
+				got = cause.Error()
+			}
+			if status != tt.status || got != tt.cause {
+				t.Errorf("conversionState = %s, %q; want %s, %q", status, got, tt.status, tt.cause)
+			}
+		})
+	}
+}
+
+func TestConversionFailureFromServer(t *testing.T) {
+	stub, client := newStubServer(t)
+	stub.final = convertedError
+	ctx := context.Background()
+	svc := NewService(nil, nil, client, Config{ProjectID: "proj", Timeout: time.Hour})
+
+	id, err := client.Upload(ctx, "proj", "Model 1", "ifc", "model.ifc", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	var status string
+	var cause error
+	for range 3 {
+		upload, err := client.FileUpload(ctx, "proj", id)
+		status, cause = svc.conversionState(upload, err, time.Minute)
+	}
+	if status != StatusError || cause == nil || cause.Error() != "unsupported schema" {
+		t.Errorf("state = %s, %v; want error with the server's message", status, cause)
+	}
+}
@@ -0,0 +1,37 @@
+package speckle
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a file version has no conversion.
+	ErrNotFound = errors.New("not found")
+	// ErrRejected is returned when the server refuses a file; resubmitting
+	// it will not help.
+	ErrRejected = errors.New("speckle: upload rejected")
+)
+
+// Conversion states in arca_speckle_mapping.status.
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusError      = "error"
+)
+
+// Conversion is the Speckle conversion of one file version. The Speckle
+// ids are set once the server has converted the file.
+type Conversion struct {
+	FileVersionID    string     `json:"fileVersionId"`
+	Status           string     `json:"status"`
+	SpeckleModelID   *string    `json:"speckleModelId"`
+	SpeckleVersionID *string    `json:"speckleVersionId"`
+	SpeckleObjectID  *string    `json:"speckleObjectId"`
+	Error            *string    `json:"error"`
+	Attempts         int        `json:"attempts"`
+	SubmittedAt      *time.Time `json:"submittedAt"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tus/tusd/v2/pkg/handler"
+
+	"github.com/nsssthlm/valvx-api/arca"
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+var (
+	errUnauthorized   = handler.NewError("ERR_UNAUTHORIZED", "login required", http.StatusUnauthorized)
+	errMissingTarget  = handler.NewError("ERR_MISSING_TARGET", "folderId or fileId metadata is required", http.StatusBadRequest)
+	errTargetNotFound = handler.NewError("ERR_TARGET_NOT_FOUND", "target folder or file not found", http.StatusNotFound)
+	errForbidden      = handler.NewError("ERR_FORBIDDEN", "no write access to target project", http.StatusForbidden)
+	errLengthRequired = handler.NewError("ERR_UPLOAD_LENGTH_REQUIRED", "Upload-Length is required", http.StatusLengthRequired)
+)
+
+// quotaExceeded builds the 413 response for an upload that does not fit.
+// The remaining space is repeated in headers so clients need not parse the body.
+func quotaExceeded(qe *arca.QuotaError) handler.Error {
+	err := handler.NewError("ERR_QUOTA_EXCEEDED",
+		fmt.Sprintf("%s storage quota exceeded, %d bytes remaining", qe.Scope, qe.Remaining),
+		http.StatusRequestEntityTooLarge)
+	err.HTTPResponse.Header["X-Quota-Scope"] = qe.Scope
+	err.HTTPResponse.Header["X-Quota-Remaining"] = strconv.FormatInt(qe.Remaining, 10)
+	return err
+}
+
+// preUploadCreate authenticates the caller from the session, resolves the
+// project that owns the target folder (or file, for new versions) and checks
+// that the caller may write to it and that the declared Upload-Length fits
+// the project and tenant storage quotas. The resolved profile, project and
+// quota reservation replace any client-supplied values in the upload
+// metadata, so onUploadComplete can trust them.
+func (h *Handler) preUploadCreate(event handler.HookEvent) (handler.HTTPResponse, handler.FileInfoChanges, error) {
+	ctx := event.Context
+	accountID := auth.AccountIDFromContext(ctx)
+	if accountID == "" {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, errUnauthorized
+	}
+
+	metadata := event.Upload.MetaData
+	folderID := metadata["folderId"]
+	if folderID == "root" {
+		folderID = ""
+	}
+	fileID := metadata["fileId"]
+
+	var projectID string
+	var err error
+	switch {
+	case fileID != "":
+		err = h.DB.QueryRowContext(ctx, `
+			SELECT fo.project_id FROM arca_folder_file ff
+			JOIN arca_folder fo ON fo.id = ff.folder_id
+			JOIN arca_file f ON f.id = ff.file_id
+			WHERE ff.file_id = $1 AND f.deleted_at IS NULL AND fo.deleted_at IS NULL
+			LIMIT 1`, fileID).Scan(&projectID)
+	case folderID != "":
+		err = h.DB.QueryRowContext(ctx,
+			`SELECT project_id FROM arca_folder WHERE id = $1 AND deleted_at IS NULL`, folderID).Scan(&projectID)
+	default:
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, errMissingTarget
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, errTargetNotFound
+	}
+	if err != nil {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, err
+	}
+
+	profileID, err := h.Sessions.GetWritableProfileForProject(ctx, accountID, projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, errForbidden
+	}
+	if err != nil {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, err
+	}
+
+	if event.Upload.SizeIsDeferred {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, errLengthRequired
+	}
+	reservationID, err := arca.ReserveStorage(ctx, h.DB, projectID, event.Upload.Size, h.Config.Quotas)
+	var qe *arca.QuotaError
+	if errors.As(err, &qe) {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, quotaExceeded(qe)
+	}
+	if err != nil {
+		return handler.HTTPResponse{}, handler.FileInfoChanges{}, err
+	}
+
+	stamped := handler.MetaData{}
+	for k, v := range metadata {
+		stamped[k] = v
+	}
+	delete(stamped, "creator_id")
+	stamped["creatorId"] = profileID
+	stamped["projectId"] = projectID
+	stamped["reservationId"] = reservationID
+
+	return handler.HTTPResponse{}, handler.FileInfoChanges{MetaData: stamped}, nil
+}
+
+// requireAccount rejects TUS requests without a valid session. Creation is
+// additionally checked per target in preUploadCreate.
+func requireAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.AccountIDFromContext(r.Context()) == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireUploadOwner rejects requests on an existing upload (HEAD, PATCH and
+// DELETE) unless the caller is the account that created it: the caller's
+// writable profile in the stamped project must be the stamped creator.
+// tusd v2.6 has no pre-terminate hook, so this also guards termination.
+func (h *Handler) requireUploadOwner(next http.Handler) http.Handler {
+	return requireAccount(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(r.URL.Path, "/")
+		if r.Method == http.MethodPost || id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		upload, err := h.tusStore.GetUpload(ctx, id)
+		var info handler.FileInfo
+		if err == nil {
+			info, err = upload.GetInfo(ctx)
+		}
+		if errors.Is(err, handler.ErrNotFound) {
+			// Let tusd answer with its own 404
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		projectID, creatorID := info.MetaData["projectId"], info.MetaData["creatorId"]
+		if projectID == "" || creatorID == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		profileID, err := h.Sessions.GetWritableProfileForProject(ctx, auth.AccountIDFromContext(ctx), projectID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && profileID != creatorID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,257 @@
+package upload
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tus/tusd/v2/pkg/handler"
+
+	"github.com/nsssthlm/valvx-api/arca"
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
+)
+
+// BackfillStorage fills in storage_bucket/storage_key/storage_etag for file
+// versions created before the columns existed, by matching them against the
+// objects actually present in the bucket.
+//
+// Two layouts exist in production:
+//   - the legacy app stored each version under its own id
+//   - TUS uploads stored the content under the s3store object id, with a
+//     sidecar "<id>.info" object holding the upload metadata
+//
+// Legacy objects are matched on key and size. TUS objects are matched on
+// filename and size, and only when exactly one unclaimed upload fits.
+func BackfillStorage(ctx context.Context, db *sql.DB, blobs *blobstor.Store) (int, error) {
+	objects := make(map[string]blobstor.ObjectInfo)
+	var infoKeys []string
+	err := blobs.Walk(ctx, func(obj blobstor.ObjectInfo) error {
+		switch {
+		case strings.HasSuffix(obj.Key, ".info"):
+			infoKeys = append(infoKeys, obj.Key)
+		case strings.HasSuffix(obj.Key, ".part"):
+		default:
+			objects[obj.Key] = obj
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	claimed := make(map[string]bool)
+	rows, err := db.QueryContext(ctx,
+		`SELECT storage_key FROM arca_file_version WHERE storage_key IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("query claimed keys: %w", err)
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan claimed key: %w", err)
+		}
+		claimed[key] = true
+	}
+	rows.Close()
+
+	// Index finished TUS uploads by filename and size
+	type tusCandidate struct {
+		uploadID string
+		key      string
+	}
+	tusUploads := make(map[string][]tusCandidate)
+	for _, infoKey := range infoKeys {
+		info, err := readUploadInfo(ctx, blobs, infoKey)
+		if err != nil {
+			log.Printf("Backfill: skipping %s: %v", infoKey, err)
+			continue
+		}
+		key := info.Storage["Key"]
+		obj, ok := objects[key]
+		if !ok || claimed[key] || obj.Size != info.Size {
+			continue
+		}
+		match := fmt.Sprintf("%s|%d", info.MetaData["filename"], info.Size)
+		tusUploads[match] = append(tusUploads[match], tusCandidate{uploadID: info.ID, key: key})
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT fv.id::text, fv.size, f.name, COALESCE(f.ext, '')
+		FROM arca_file_version fv
+		JOIN arca_file f ON f.id = fv.file_id
+		WHERE fv.storage_key IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("query versions: %w", err)
+	}
+	type pending struct {
+		id, name, ext string
+		size          int64
+	}
+	var versions []pending
+	for rows.Next() {
+		var v pending
+		if err := rows.Scan(&v.id, &v.size, &v.name, &v.ext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+
+	updated := 0
+	for _, v := range versions {
+		var key, uploadID string
+		if obj, ok := objects[v.id]; ok && obj.Size == v.size {
+			key = v.id
+		} else {
+			filename := v.name
+			if v.ext != "" {
+				filename += "." + v.ext
+			}
+			candidates := tusUploads[fmt.Sprintf("%s|%d", filename, v.size)]
+			var free []tusCandidate
+			for _, c := range candidates {
+				if !claimed[c.key] {
+					free = append(free, c)
+				}
+			}
+			if len(free) != 1 {
+				continue
+			}
+			key, uploadID = free[0].key, free[0].uploadID
+		}
+
+		_, err := db.ExecContext(ctx, `
+			UPDATE arca_file_version
+			SET storage_bucket = $2, storage_key = $3, storage_etag = $4, upload_id = NULLIF($5, '')
+			WHERE id = $1 AND storage_key IS NULL`,
+			v.id, blobs.Bucket, key, objects[key].ETag, uploadID)
+		if err != nil {
+			return updated, fmt.Errorf("update version %s: %w", v.id, err)
+		}
+		claimed[key] = true
+		updated++
+	}
+
+	log.Printf("Backfill: %d of %d file versions linked to stored objects", updated, len(versions))
+	return updated, nil
+}
+
+func readUploadInfo(ctx context.Context, blobs *blobstor.Store, key string) (handler.FileInfo, error) {
+	var info handler.FileInfo
+	body, err := blobs.Get(ctx, key)
+	if err != nil {
+		return info, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return info, fmt.Errorf("decode upload info: %w", err)
+	}
+	return info, nil
+}
+
+// BackfillHashes computes the SHA-256 of every version stored before content
+// hashing existed and moves it onto the shared arca_blob scheme. A version
+// whose content is already known is repointed at the existing blob and its
+// own object deleted, which is where most of the space in the bucket goes.
+func BackfillHashes(ctx context.Context, db *sql.DB, blobs *blobstor.Store) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id::text, COALESCE(storage_key, id::text), COALESCE(storage_bucket, $1),
+		       COALESCE(storage_etag, ''), size
+		FROM arca_file_version
+		WHERE content_hash IS NULL
+		ORDER BY created_at`, blobs.Bucket)
+	if err != nil {
+		return 0, fmt.Errorf("query versions: %w", err)
+	}
+	type pending struct {
+		id, key, bucket, etag string
+		size                  int64
+	}
+	var versions []pending
+	for rows.Next() {
+		var v pending
+		if err := rows.Scan(&v.id, &v.key, &v.bucket, &v.etag, &v.size); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+
+	hashed, freed := 0, 0
+	for _, v := range versions {
+		hash, err := blobs.Hash(ctx, v.key)
+		if blobstor.IsNotFound(err) {
+			log.Printf("Backfill: version %s has no object at %s", v.id, v.key)
+			continue
+		}
+		if err != nil {
+			return hashed, err
+		}
+
+		drop, err := linkVersionBlob(ctx, db, v.id, v.key, v.bucket, v.etag, hash, v.size)
+		if err != nil {
+			return hashed, err
+		}
+		hashed++
+		if drop {
+			if err := blobs.Delete(ctx, v.key); err != nil {
+				log.Printf("Warning: could not delete duplicate %s: %v", v.key, err)
+				continue
+			}
+			freed++
+		}
+	}
+
+	log.Printf("Backfill: hashed %d of %d file versions, removed %d duplicate objects", hashed, len(versions), freed)
+	return hashed, nil
+}
+
+// linkVersionBlob points one version at the blob for hash. It reports whether
+// the version's previous object is now unused and can be deleted.
+func linkVersionBlob(ctx context.Context, db *sql.DB, versionID, key, bucket, etag, hash string, size int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	sharedKey, sharedETag, err := arca.AcquireBlob(ctx, tx, hash, bucket, key, etag, size)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE arca_file_version
+		SET content_hash = $2, storage_bucket = $3, storage_key = $4, storage_etag = NULLIF($5, '')
+		WHERE id = $1 AND content_hash IS NULL`,
+		versionID, hash, bucket, sharedKey, sharedETag)
+	if err != nil {
+		return false, fmt.Errorf("update version %s: %w", versionID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Hashed concurrently, e.g. by a second backfill run
+		return false, nil
+	}
+
+	drop := false
+	if sharedKey != key {
+		var users int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM arca_file_version
+			WHERE COALESCE(storage_key, id::text) = $1`, key).Scan(&users)
+		if err != nil {
+			return false, fmt.Errorf("count key users: %w", err)
+		}
+		drop = users == 0
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return drop, nil
+}
@@ -3,8 +3,13 @@
 // Uses tusd v2 with S3 store backend for streaming directly to MinIO.
 // No temp files on disk — chunks go straight to S3 multipart upload.
 //
-// IFC files are stored as-is — client-side web-ifc WASM handles parsing.
-// No server-side conversion pipeline needed.
+// Completed uploads are recorded in arca_upload_job before the client gets
+// its final response; a worker pool turns each job into arca_file rows,
+// retrying with backoff and parking failures in a dead-letter state.
+//
+// IFC files are stored as-is — client-side web-ifc WASM handles geometry.
+// Server-side steps such as IFC header extraction run as Processors once the
+// file version exists.
 package upload
 
 import (
@@ -18,63 +23,75 @@ import (
 	"strings"
 	"time"
 
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	awscreds "github.com/aws/aws-sdk-go-v2/credentials"
-	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 	"github.com/tus/tusd/v2/pkg/handler"
 	"github.com/tus/tusd/v2/pkg/s3store"
+
+	"github.com/nsssthlm/valvx-api/arca"
+	"github.com/nsssthlm/valvx-api/internal/auth"
+	"github.com/nsssthlm/valvx-api/internal/blobstor"
 )
 
 // Config holds upload engine configuration.
 type Config struct {
-	MinioEndpoint  string
-	MinioBucket    string
-	MinioAccessKey string
-	MinioSecretKey string
-	MaxUploadSize  int64
-	ChunkSize      int64
+	MaxUploadSize int64
+	ChunkSize     int64
+
+	// Post-upload job queue
+	Workers         int
+	MaxAttempts     int
+	RetryBaseDelay  time.Duration
+	AdminAccountIDs []string
+
+	// Default storage quotas checked at upload creation
+	Quotas arca.Quotas
 }
 
+// Processor is a post-upload step run on each new file version. It must be
+// safe to run more than once for the same version: a returned error retries
+// the whole job.
+type Processor func(ctx context.Context, fileVersionID string) error
+
 // Handler manages TUS uploads and post-upload processing.
 type Handler struct {
 	DB         *sql.DB
+	Blobs      *blobstor.Store
+	Sessions   *auth.SessionStore
 	Config     Config
+	Processors []Processor
 	tusHandler *handler.Handler
+	tusStore   s3store.S3Store
+	wake       chan struct{}
 }
 
 // NewHandler creates a new upload handler with a real TUS server backed by S3/MinIO.
-func NewHandler(db *sql.DB, cfg Config) *Handler {
+func NewHandler(db *sql.DB, blobs *blobstor.Store, sessions *auth.SessionStore, cfg Config) *Handler {
 	h := &Handler{
-		DB:     db,
-		Config: cfg,
+		DB:       db,
+		Blobs:    blobs,
+		Sessions: sessions,
+		Config:   cfg,
 	}
 
-	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(),
-		awsconfig.WithRegion("us-east-1"),
-		awsconfig.WithCredentialsProvider(awscreds.NewStaticCredentialsProvider(cfg.MinioAccessKey, cfg.MinioSecretKey, "")),
-	)
-	if err != nil {
-		log.Printf("Warning: could not load AWS config for TUS: %v", err)
+	if blobs == nil {
+		log.Printf("Warning: TUS uploads disabled, blob storage unavailable")
 		return h
 	}
 
-	s3Client := s3v2.NewFromConfig(awsCfg, func(o *s3v2.Options) {
-		o.BaseEndpoint = &cfg.MinioEndpoint
-		o.UsePathStyle = true
-	})
-	store := s3store.New(cfg.MinioBucket, s3Client)
+	store := s3store.New(blobs.Bucket, blobs.Client)
+	h.tusStore = store
 
 	composer := handler.NewStoreComposer()
 	store.UseIn(composer)
 
 	tusHandler, err := handler.NewHandler(handler.Config{
-		BasePath:                "/api/uploads/",
-		StoreComposer:           composer,
-		MaxSize:                 cfg.MaxUploadSize,
-		NotifyCompleteUploads:   true,
-		NotifyCreatedUploads:    true,
-		RespectForwardedHeaders: true,
+		BasePath:                  "/api/uploads/",
+		StoreComposer:             composer,
+		MaxSize:                   cfg.MaxUploadSize,
+		NotifyCreatedUploads:      true,
+		RespectForwardedHeaders:   true,
+		PreUploadCreateCallback:   h.preUploadCreate,
+		PreFinishResponseCallback: h.preFinishResponse,
 	})
 	if err != nil {
 		log.Printf("Warning: could not create TUS handler: %v", err)
@@ -82,19 +99,24 @@ func NewHandler(db *sql.DB, cfg Config) *Handler {
 	}
 
 	h.tusHandler = tusHandler
-	go h.processCompletedUploads()
+	go h.processCreatedUploads()
+	h.startWorkers(context.Background())
 
 	return h
 }
 
-// RegisterRoutes sets up the TUS upload endpoint.
+// RegisterRoutes sets up the TUS upload endpoint and the job admin API.
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/admin/upload-jobs", h.requireAdmin(h.ListJobs))
+	mux.HandleFunc("POST /api/admin/upload-jobs/{jobId}/retry", h.requireAdmin(h.RetryJob))
+
 	if h.tusHandler != nil {
-		mux.Handle("POST /api/uploads/", http.StripPrefix("/api/uploads/", h.tusHandler))
-		mux.Handle("HEAD /api/uploads/", http.StripPrefix("/api/uploads/", h.tusHandler))
-		mux.Handle("PATCH /api/uploads/", http.StripPrefix("/api/uploads/", h.tusHandler))
-		mux.Handle("DELETE /api/uploads/", http.StripPrefix("/api/uploads/", h.tusHandler))
-		mux.Handle("POST /api/uploads", http.StripPrefix("/api/uploads", h.tusHandler))
+		tus := h.requireUploadOwner(http.StripPrefix("/api/uploads/", h.tusHandler))
+		mux.Handle("POST /api/uploads/", tus)
+		mux.Handle("HEAD /api/uploads/", tus)
+		mux.Handle("PATCH /api/uploads/", tus)
+		mux.Handle("DELETE /api/uploads/", tus)
+		mux.Handle("POST /api/uploads", requireAccount(http.StripPrefix("/api/uploads", h.tusHandler)))
 
 		optionsHandler := func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Tus-Resumable", "1.0.0")
@@ -114,22 +136,36 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	}
 }
 
-func (h *Handler) processCompletedUploads() {
+func (h *Handler) processCreatedUploads() {
 	if h.tusHandler == nil {
 		return
 	}
-	for {
-		select {
-		case event := <-h.tusHandler.CompleteUploads:
-			go h.onUploadComplete(event)
-		case event := <-h.tusHandler.CreatedUploads:
-			log.Printf("Upload created: %s (%d bytes)", event.Upload.ID, event.Upload.Size)
-		}
+	for event := range h.tusHandler.CreatedUploads {
+		log.Printf("Upload created: %s (%d bytes)", event.Upload.ID, event.Upload.Size)
 	}
 }
 
-func (h *Handler) onUploadComplete(event handler.HookEvent) {
+// preFinishResponse records the completed upload in the job queue before the
+// client receives the final response. If the insert fails the client sees an
+// error instead of a silently orphaned object.
+func (h *Handler) preFinishResponse(event handler.HookEvent) (handler.HTTPResponse, error) {
 	info := event.Upload
+	log.Printf("Upload complete: %s (%s, %d bytes, folder=%s)",
+		info.MetaData["filename"], info.ID, info.Size, info.MetaData["folderId"])
+
+	if err := h.enqueueUpload(event.Context, info); err != nil {
+		log.Printf("Upload enqueue error (%s): %v", info.ID, err)
+		return handler.HTTPResponse{}, handler.NewError("ERR_ENQUEUE_FAILED",
+			"upload stored but could not be registered, please retry", http.StatusInternalServerError)
+	}
+	return handler.HTTPResponse{}, nil
+}
+
+// createFileRecord inserts the arca_file / arca_file_version rows for a
+// completed upload and returns the new file version ID. It is idempotent on
+// the TUS upload ID: if a version already references the upload, that
+// version is returned and nothing is written.
+func (h *Handler) createFileRecord(ctx context.Context, info handler.FileInfo) (string, error) {
 	metadata := info.MetaData
 
 	filename := metadata["filename"]
@@ -139,55 +175,133 @@ func (h *Handler) onUploadComplete(event handler.HookEvent) {
 		ext = metadata["ext"]
 	}
 
+	// Stamped by preUploadCreate from the caller's session
 	creatorID := metadata["creatorId"]
-	if creatorID == "" {
-		creatorID = metadata["creator_id"]
+	reservationID := metadata["reservationId"]
+
+	var existing string
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT id FROM arca_file_version WHERE upload_id = $1`, info.ID).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("check existing version: %w", err)
 	}
 
-	log.Printf("Upload complete: %s (%s, %d bytes, folder=%s)", filename, ext, info.Size, folderId)
+	bucket := info.Storage["Bucket"]
+	uploadKey := info.Storage["Key"]
+	var etag, hash string
+	if uploadKey != "" {
+		obj, err := h.Blobs.Stat(ctx, uploadKey)
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", uploadKey, err)
+		}
+		etag = obj.ETag
+		if hash, err = h.Blobs.Hash(ctx, uploadKey); err != nil {
+			return "", err
+		}
+	}
 
-	ctx := context.Background()
-	fileID := uuid.New().String()
+	// A fileId in the metadata appends a new version to an existing file
+	fileID := metadata["fileId"]
+	isNewVersion := fileID != ""
+	if !isNewVersion {
+		fileID = uuid.New().String()
+	}
 	fileVersionID := uuid.New().String()
 	now := time.Now().UTC()
 
 	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Upload post-processing error (begin tx): %v", err)
-		return
+		return "", fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	cleanName := strings.TrimSuffix(filename, "."+ext)
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO arca_file (id, created_at, updated_at, name, ext) VALUES ($1, $2, $3, $4, $5)`,
-		fileID, now, now, cleanName, ext)
-	if err != nil {
-		log.Printf("Upload post-processing error (insert file): %v", err)
-		return
+	number := int64(1)
+	if isNewVersion {
+		// Lock the file row so concurrent uploads get distinct version numbers
+		var locked string
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM arca_file WHERE id = $1 FOR UPDATE`, fileID).Scan(&locked)
+		if err != nil {
+			return "", fmt.Errorf("lock file %s: %w", fileID, err)
+		}
+		err = tx.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(number), 0) + 1 FROM arca_file_version WHERE file_id = $1`,
+			fileID).Scan(&number)
+		if err != nil {
+			return "", fmt.Errorf("next version number: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE arca_file SET updated_at = $2 WHERE id = $1`, fileID, now)
+		if err != nil {
+			return "", fmt.Errorf("touch file: %w", err)
+		}
+	} else {
+		cleanName := strings.TrimSuffix(filename, "."+ext)
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO arca_file (id, created_at, updated_at, name, ext) VALUES ($1, $2, $3, $4, $5)`,
+			fileID, now, now, cleanName, ext)
+		if err != nil {
+			return "", fmt.Errorf("insert file: %w", err)
+		}
+	}
+
+	// Identical content already stored is shared instead of kept twice
+	key := uploadKey
+	if hash != "" {
+		if key, etag, err = arca.AcquireBlob(ctx, tx, hash, bucket, uploadKey, etag, info.Size); err != nil {
+			return "", err
+		}
 	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO arca_file_version (id, created_at, updated_at, number, size, file_id, creator_id)
-		 VALUES ($1, $2, $3, 1, $4, $5, $6)`,
-		fileVersionID, now, now, info.Size, fileID, creatorID)
+		`INSERT INTO arca_file_version (id, created_at, updated_at, number, size, file_id, creator_id,
+		     storage_bucket, storage_key, storage_etag, upload_id, content_hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, NULLIF($12, ''))`,
+		fileVersionID, now, now, number, info.Size, fileID, creatorID,
+		bucket, key, etag, info.ID, hash)
 	if err != nil {
-		log.Printf("Upload post-processing error (insert file_version): %v", err)
-		return
+		return "", fmt.Errorf("insert file_version: %w", err)
 	}
 
-	if folderId != "" && folderId != "root" {
-		tx.ExecContext(ctx,
+	if err := arca.ReleaseStorage(ctx, tx, reservationID); err != nil {
+		return "", err
+	}
+
+	if !isNewVersion && folderId != "" && folderId != "root" {
+		_, err = tx.ExecContext(ctx,
 			`INSERT INTO arca_folder_file (folder_id, file_id) VALUES ($1, $2)`,
 			folderId, fileID)
+		if err != nil {
+			return "", fmt.Errorf("insert folder_file: %w", err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Printf("Upload post-processing error (commit): %v", err)
-		return
+		return "", fmt.Errorf("commit: %w", err)
+	}
+
+	if key != uploadKey {
+		log.Printf("Upload %s duplicates blob %s, removing its copy", info.ID, hash)
+		if err := h.terminateUpload(ctx, info.ID); err != nil {
+			log.Printf("Warning: could not delete duplicate upload %s: %v", info.ID, err)
+		}
 	}
 
-	log.Printf("Created file record: %s (version %s)", fileID, fileVersionID)
+	log.Printf("Created file record: %s (version %s, #%d)", fileID, fileVersionID, number)
+	return fileVersionID, nil
+}
+
+// terminateUpload removes a finished upload through the TUS store, taking
+// its .info and .part objects along with the content.
+func (h *Handler) terminateUpload(ctx context.Context, uploadID string) error {
+	upload, err := h.tusStore.GetUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	return h.tusStore.AsTerminatableUpload(upload).Terminate(ctx)
 }
 
 func parseTUSMetadata(header string) map[string]string {
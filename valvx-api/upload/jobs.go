@@ -0,0 +1,327 @@
+package upload
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tus/tusd/v2/pkg/handler"
+
+	"github.com/nsssthlm/valvx-api/internal/auth"
+)
+
+// Job states in arca_upload_job.
+const (
+	JobPending    = "pending"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobDead       = "dead"
+)
+
+const (
+	jobPollInterval = 2 * time.Second
+	jobStaleAfter   = 15 * time.Minute
+	jobHeartbeat    = time.Minute
+	jobMaxDelay     = time.Hour
+)
+
+// Job is a completed upload waiting for (or done with) post-processing.
+type Job struct {
+	ID            string    `json:"id"`
+	UploadID      string    `json:"uploadId"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     *string   `json:"lastError,omitempty"`
+	FileVersionID *string   `json:"fileVersionId,omitempty"`
+	Filename      string    `json:"filename"`
+	Size          int64     `json:"size"`
+	RunAt         time.Time `json:"runAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// enqueueUpload records a completed upload. Enqueuing the same upload twice
+// is a no-op, so a client retrying the final PATCH cannot create duplicates.
+func (h *Handler) enqueueUpload(ctx context.Context, info handler.FileInfo) error {
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("encode upload info: %w", err)
+	}
+
+	_, err = h.DB.ExecContext(ctx, `
+		INSERT INTO arca_upload_job (id, upload_id, status, payload, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, now(), now(), now())
+		ON CONFLICT (upload_id) DO NOTHING`,
+		uuid.New().String(), info.ID, JobPending, payload)
+	if err != nil {
+		return fmt.Errorf("insert job: %w", err)
+	}
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// startWorkers launches the worker pool that drains arca_upload_job.
+func (h *Handler) startWorkers(ctx context.Context) {
+	h.wake = make(chan struct{}, 1)
+	workers := h.Config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go h.runWorker(ctx)
+	}
+}
+
+func (h *Handler) runWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain everything that is due before sleeping again
+		for {
+			ok, err := h.runNextJob(ctx)
+			if err != nil {
+				log.Printf("Upload worker error: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims one due job and processes it. It reports false when there
+// was nothing to do. A running job refreshes its lock every jobHeartbeat, so
+// only jobs whose worker is gone (e.g. the API restarted mid-job) are
+// reclaimed after jobStaleAfter.
+func (h *Handler) runNextJob(ctx context.Context) (bool, error) {
+	var jobID, uploadID string
+	var attempts int
+	var payload []byte
+	err := h.DB.QueryRowContext(ctx, `
+		UPDATE arca_upload_job SET status = $1, attempts = attempts + 1, locked_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM arca_upload_job
+			WHERE (status = $2 AND run_at <= now())
+			   OR (status = $1 AND locked_at < now() - $3::interval)
+			ORDER BY run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, upload_id, attempts, payload`,
+		JobProcessing, JobPending, fmt.Sprintf("%d seconds", int(jobStaleAfter.Seconds())),
+	).Scan(&jobID, &uploadID, &attempts, &payload)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim job: %w", err)
+	}
+	defer h.heartbeat(ctx, jobID)()
+
+	var info handler.FileInfo
+	if err := json.Unmarshal(payload, &info); err != nil {
+		// A payload we cannot decode will never succeed
+		return true, h.failJob(ctx, jobID, attempts, fmt.Errorf("decode payload: %w", err), true)
+	}
+
+	fileVersionID, err := h.createFileRecord(ctx, info)
+	if err != nil {
+		log.Printf("Upload job %s (upload %s) attempt %d failed: %v", jobID, uploadID, attempts, err)
+		return true, h.failJob(ctx, jobID, attempts, err, false)
+	}
+
+	for _, process := range h.Processors {
+		if err := process(ctx, fileVersionID); err != nil {
+			log.Printf("Upload job %s (upload %s) attempt %d failed processing version %s: %v",
+				jobID, uploadID, attempts, fileVersionID, err)
+			return true, h.failJob(ctx, jobID, attempts, err, false)
+		}
+	}
+
+	_, err = h.DB.ExecContext(ctx, `
+		UPDATE arca_upload_job SET status = $2, file_version_id = $3, last_error = NULL,
+		    locked_at = NULL, updated_at = now()
+		WHERE id = $1`, jobID, JobDone, fileVersionID)
+	if err != nil {
+		return true, fmt.Errorf("complete job %s: %w", jobID, err)
+	}
+	return true, nil
+}
+
+// heartbeat refreshes locked_at of a processing job until the returned stop
+// function is called, keeping long post-upload steps from being reclaimed
+// and run a second time by another worker.
+func (h *Handler) heartbeat(ctx context.Context, jobID string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			_, err := h.DB.ExecContext(ctx, `
+				UPDATE arca_upload_job SET locked_at = now()
+				WHERE id = $1 AND status = $2`, jobID, JobProcessing)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Upload job %s heartbeat failed: %v", jobID, err)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// failJob reschedules a job with exponential backoff, or moves it to the
+// dead-letter state once it has used all attempts.
+func (h *Handler) failJob(ctx context.Context, jobID string, attempts int, cause error, permanent bool) error {
+	maxAttempts := h.Config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	status := JobPending
+	if permanent || attempts >= maxAttempts {
+		status = JobDead
+		log.Printf("Upload job %s moved to dead-letter after %d attempts: %v", jobID, attempts, cause)
+	}
+
+	_, err := h.DB.ExecContext(ctx, `
+		UPDATE arca_upload_job SET status = $2, last_error = $3, run_at = now() + $4::interval,
+		    locked_at = NULL, updated_at = now()
+		WHERE id = $1`,
+		jobID, status, cause.Error(), fmt.Sprintf("%d milliseconds", h.backoff(attempts).Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("reschedule job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// backoff returns the delay before the next attempt: base * 2^(attempts-1),
+// capped at jobMaxDelay, with up to 20% jitter so failed jobs spread out.
+func (h *Handler) backoff(attempts int) time.Duration {
+	delay := h.Config.RetryBaseDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := 1; i < attempts && delay < jobMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > jobMaxDelay {
+		delay = jobMaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// --- Admin endpoints ---
+
+func (h *Handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID := auth.AccountIDFromContext(r.Context())
+		if accountID == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(h.Config.AdminAccountIDs, accountID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ListJobs returns upload jobs, by default the dead-letter queue.
+// Query parameters: status (pending|processing|done|dead), limit.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = JobDead
+	}
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, upload_id, status, attempts, last_error, file_version_id,
+		       COALESCE(payload->'MetaData'->>'filename', ''), COALESCE((payload->>'Size')::bigint, 0),
+		       run_at, created_at, updated_at
+		FROM arca_upload_job
+		WHERE status = $1
+		ORDER BY updated_at DESC
+		LIMIT $2`, status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.UploadID, &j.Status, &j.Attempts, &j.LastError, &j.FileVersionID,
+			&j.Filename, &j.Size, &j.RunAt, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, j)
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// RetryJob moves a dead job back to pending with a fresh attempt budget.
+func (h *Handler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID := r.PathValue("jobId")
+
+	res, err := h.DB.ExecContext(r.Context(), `
+		UPDATE arca_upload_job SET status = $2, attempts = 0, run_at = now(), updated_at = now()
+		WHERE id = $1 AND status = $3`, jobID, JobPending, JobDead)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "dead job not found", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
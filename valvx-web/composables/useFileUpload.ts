@@ -74,7 +74,11 @@ export function useFileUpload() {
     return parts.length > 1 ? parts[parts.length - 1].toLowerCase() : ''
   }
 
-  function addFiles(rawFiles: File[], folderId: string): UploadFile[] {
+  /**
+   * Queue files for upload into a folder. Pass fileId to upload a single
+   * file as a new version of an existing file instead of a new file.
+   */
+  function addFiles(rawFiles: File[], folderId: string, fileId?: string): UploadFile[] {
     const newFiles: UploadFile[] = rawFiles.map((file) => ({
       id: generateId(),
       file,
@@ -82,6 +86,7 @@ export function useFileUpload() {
       size: file.size,
       ext: getFileExt(file.name),
       folderId,
+      fileId,
       status: 'queued' as UploadStatus,
       progress: 0,
       bytesUploaded: 0,
@@ -117,6 +122,12 @@ export function useFileUpload() {
         filetype: uploadFile.file.type || 'application/octet-stream',
         folderId: uploadFile.folderId,
         ext: uploadFile.ext,
+        ...(uploadFile.fileId ? { fileId: uploadFile.fileId } : {}),
+      },
+      // The API authenticates uploads from the session cookie
+      onBeforeRequest: (req) => {
+        const xhr = req.getUnderlyingObject() as XMLHttpRequest
+        xhr.withCredentials = true
       },
       onProgress: (bytesUploaded: number, bytesTotal: number) => {
         uploadFile.bytesUploaded = bytesUploaded
@@ -1,4 +1,4 @@
-/** BCF 2.1 compliant types for BIM Collaboration Format */
+/** BCF 2.1 and 3.0 compliant types for BIM Collaboration Format */
 
 export interface BcfViewpoint {
   id: string
@@ -10,6 +10,7 @@ export interface BcfViewpoint {
   cameraUp: { x: number; y: number; z: number }
   fieldOfView?: number
   viewWorldScale?: number
+  aspectRatio?: number
   snapshotBase64?: string
   components?: BcfComponents
   clippingPlanes?: BcfClippingPlane[]
@@ -56,14 +57,23 @@ export interface BcfTopic {
   stage?: string
   assignedTo?: string
   assignedToName?: string
+  /** BCF AssignedTo: the assignee's email, or the text from an imported file */
+  assignedToEmail?: string
   dueDate?: string
   labels?: string[]
+  referenceLinks?: string[]
   projectId: string
   creatorId: string
   creatorName?: string
+  /** BCF CreationAuthor: the creator's email, or the text from an imported file */
+  creationAuthor?: string
   modifiedBy?: string
+  /** BCF ModifiedAuthor: the editor's email, or the text from an imported file */
+  modifiedAuthor?: string
   viewpoints?: BcfViewpoint[]
   comments?: BcfComment[]
+  documentReferences?: BcfDocumentReference[]
+  files?: BcfTopicFile[]
   fileVersionIds?: string[]
   createdAt: string
   updatedAt: string
@@ -71,23 +81,48 @@ export interface BcfTopic {
 
 export interface BcfComment {
   id: string
+  guid: string
   body: string
   viewpointId?: string
+  viewpointGuid?: string
   topicId: string
   authorId: string
   authorName?: string
+  /** BCF Author: the author's email, or the text from an imported file */
+  author?: string
+  modifiedBy?: string
+  /** BCF ModifiedAuthor: the editor's email, or the text from an imported file */
+  modifiedAuthor?: string
   createdAt: string
   updatedAt: string
 }
 
+export interface BcfDocumentReference {
+  guid: string
+  /** A document of the project, shipped in the Documents folder of a BCF file */
+  documentGuid?: string
+  url?: string
+  description?: string
+}
+
+export interface BcfTopicFile {
+  fileVersionId: string
+  filename: string
+  date?: string
+  ifcProject?: string
+}
+
 export interface BcfCreateTopicRequest {
   title: string
   description?: string
   priority?: BcfTopic['priority']
   topicType?: BcfTopic['topicType']
+  topicStatus?: BcfTopic['topicStatus']
+  stage?: string
   assignedTo?: string
   dueDate?: string
   labels?: string[]
+  referenceLinks?: string[]
   fileVersionIds?: string[]
   viewpoint?: BcfCreateViewpointRequest
 }
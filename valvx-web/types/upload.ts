@@ -7,6 +7,8 @@ export interface UploadFile {
   size: number
   ext: string
   folderId: string
+  /** Existing file to append a new version to */
+  fileId?: string
   status: UploadStatus
   progress: number
   bytesUploaded: number